@@ -14,6 +14,7 @@ import (
 	"github.com/volatiletech/sqlboiler/v4/boil"
 
 	"github.com/dennis-tra/nebula-crawler/config"
+	"github.com/dennis-tra/nebula-crawler/stream"
 	"github.com/dennis-tra/nebula-crawler/tele"
 )
 
@@ -44,11 +45,18 @@ var rootConfig = &config.Root{
 	MetricsPort:     6666,
 	TracesHost:      "", // disabled
 	TracesPort:      0,  // disabled
+	StreamAddr:      "", // disabled
 	Database: &config.Database{
 		DryRun:                           false,
 		JSONOut:                          "",
+		JSONCompression:                  "none",
+		JSONRotateSize:                   0,
+		JSONRotateCount:                  0,
+		ParquetOut:                       "",
+		ParquetRowGroupSize:              100_000,
 		DatabaseEngine:                   "postgres",
 		DatabaseHost:                     "localhost",
+		DatabasePath:                     "nebula.db",
 		DatabasePort:                     0,
 		DatabaseName:                     "nebula_local",
 		DatabasePassword:                 "password_local",
@@ -60,6 +68,9 @@ var rootConfig = &config.Root{
 		ClickHouseBatchInterval:          2 * time.Second,
 		ClickHouseBatchSize:              10_000,
 		ClickHouseReplicatedTableEngines: false,
+		PostgresBatchSize:                0,
+		PostgresBatchInterval:            2 * time.Second,
+		PostgresNeighborsBufferSize:      50_000,
 		AgentVersionsCacheSize:           200,
 		ProtocolsCacheSize:               100,
 		ProtocolsSetCacheSize:            200,
@@ -164,6 +175,14 @@ func main() {
 				Destination: &rootConfig.TracesPort,
 				Category:    flagCategoryDebugging,
 			},
+			&cli.StringFlag{
+				Name:        "stream-addr",
+				Usage:       "If set, streams all crawl and dial results as JSON Server-Sent Events on `ADDR` under /events",
+				EnvVars:     []string{"NEBULA_STREAM_ADDR"},
+				Value:       rootConfig.StreamAddr,
+				Destination: &rootConfig.StreamAddr,
+				Category:    flagCategorySystem,
+			},
 			&cli.BoolFlag{
 				Name:        "dry-run",
 				Usage:       "Don't write anything to disk",
@@ -180,6 +199,54 @@ func main() {
 				Destination: &rootConfig.Database.JSONOut,
 				Category:    flagCategoryDatabase,
 			},
+			&cli.StringFlag{
+				Name:        "json-compression",
+				Usage:       "The compression of the JSON visits and neighbors files (none, gzip, zstd)",
+				EnvVars:     []string{"NEBULA_JSON_COMPRESSION"},
+				Value:       rootConfig.Database.JSONCompression,
+				Destination: &rootConfig.Database.JSONCompression,
+				Category:    flagCategoryDatabase,
+			},
+			&cli.Int64Flag{
+				Name:        "json-rotate-size",
+				Usage:       "Start a new JSON visits or neighbors file after this many uncompressed `BYTES` (0 disables rotation by size)",
+				EnvVars:     []string{"NEBULA_JSON_ROTATE_SIZE"},
+				Value:       rootConfig.Database.JSONRotateSize,
+				Destination: &rootConfig.Database.JSONRotateSize,
+				Category:    flagCategoryDatabase,
+			},
+			&cli.IntFlag{
+				Name:        "json-rotate-count",
+				Usage:       "Start a new JSON visits or neighbors file after this many records (0 disables rotation by count)",
+				EnvVars:     []string{"NEBULA_JSON_ROTATE_COUNT"},
+				Value:       rootConfig.Database.JSONRotateCount,
+				Destination: &rootConfig.Database.JSONRotateCount,
+				Category:    flagCategoryDatabase,
+			},
+			&cli.StringFlag{
+				Name:        "parquet-out",
+				Usage:       "If set, stores results as Parquet files at `DIR` (takes precedence over database settings).",
+				EnvVars:     []string{"NEBULA_PARQUET_OUT"},
+				Value:       rootConfig.Database.ParquetOut,
+				Destination: &rootConfig.Database.ParquetOut,
+				Category:    flagCategoryDatabase,
+			},
+			&cli.IntFlag{
+				Name:        "parquet-row-group-size",
+				Usage:       "The maximum number of rows in a single Parquet row group",
+				EnvVars:     []string{"NEBULA_PARQUET_ROW_GROUP_SIZE"},
+				Value:       rootConfig.Database.ParquetRowGroupSize,
+				Destination: &rootConfig.Database.ParquetRowGroupSize,
+				Category:    flagCategoryDatabase,
+			},
+			&cli.BoolFlag{
+				Name:        "parquet-partition",
+				Usage:       "Whether to write the Parquet files of each crawl into a separate crawl_id=<id> directory",
+				EnvVars:     []string{"NEBULA_PARQUET_PARTITION"},
+				Value:       rootConfig.Database.ParquetPartition,
+				Destination: &rootConfig.Database.ParquetPartition,
+				Category:    flagCategoryDatabase,
+			},
 			&cli.BoolFlag{
 				Name:        "db-apply-migrations",
 				Usage:       "Whether to apply the database migrations on startup",
@@ -190,12 +257,20 @@ func main() {
 			},
 			&cli.StringFlag{
 				Name:        "db-engine",
-				Usage:       "Which DB Engine to use (postgres, clickhouse)",
+				Usage:       "Which DB Engine to use (postgres, clickhouse, sqlite). Separate multiple engines by commas to write to all of them (also accepts json and parquet).",
 				EnvVars:     []string{"NEBULA_DATABASE_ENGINE"},
 				Value:       rootConfig.Database.DatabaseEngine,
 				Destination: &rootConfig.Database.DatabaseEngine,
 				Category:    flagCategoryDatabase,
 			},
+			&cli.StringFlag{
+				Name:        "db-path",
+				Usage:       "The path to the database file (only used with sqlite)",
+				EnvVars:     []string{"NEBULA_DATABASE_PATH"},
+				Value:       rootConfig.Database.DatabasePath,
+				Destination: &rootConfig.Database.DatabasePath,
+				Category:    flagCategoryDatabase,
+			},
 			&cli.StringFlag{
 				Name:        "db-host",
 				Usage:       "On which host address can nebula reach the database",
@@ -279,6 +354,30 @@ func main() {
 				Destination: &rootConfig.Database.ProtocolsSetCacheSize,
 				Category:    flagCategoryCache,
 			},
+			&cli.IntFlag{
+				Name:        "postgres-batch-size",
+				Usage:       "The maximum number of visits to hold in memory before inserting them into postgres in a single batch (0 disables batching)",
+				EnvVars:     []string{"NEBULA_POSTGRES_BATCH_SIZE"},
+				Value:       rootConfig.Database.PostgresBatchSize,
+				Destination: &rootConfig.Database.PostgresBatchSize,
+				Category:    flagCategoryDatabase,
+			},
+			&cli.DurationFlag{
+				Name:        "postgres-batch-timeout",
+				Usage:       "The maximum time to hold visits in memory before inserting them into postgres",
+				EnvVars:     []string{"NEBULA_POSTGRES_BATCH_TIMEOUT"},
+				Value:       rootConfig.Database.PostgresBatchInterval,
+				Destination: &rootConfig.Database.PostgresBatchInterval,
+				Category:    flagCategoryDatabase,
+			},
+			&cli.IntFlag{
+				Name:        "postgres-neighbors-buffer-size",
+				Usage:       "The maximum number of routing tables to hold in memory until all their peers were inserted into postgres",
+				EnvVars:     []string{"NEBULA_POSTGRES_NEIGHBORS_BUFFER_SIZE"},
+				Value:       rootConfig.Database.PostgresNeighborsBufferSize,
+				Destination: &rootConfig.Database.PostgresNeighborsBufferSize,
+				Category:    flagCategoryDatabase,
+			},
 			&cli.StringFlag{
 				Name:        "clickhouse-cluster-name",
 				Usage:       "Name of the cluster for creating the migrations table cluster wide",
@@ -325,6 +424,11 @@ func main() {
 			CrawlCommand,
 			MonitorCommand,
 			ResolveCommand,
+			PruneCommand,
+			MigrateCommand,
+			DiffCommand,
+			GraphCommand,
+			ImportCommand,
 			NetworksCommand,
 			HealthCommand,
 		},
@@ -398,3 +502,19 @@ func Before(c *cli.Context) error {
 
 	return nil
 }
+
+// startStreamServer starts serving all peer processing results as
+// Server-Sent Events on the given address. The returned server must be
+// closed after the engine has stopped.
+func startStreamServer(addr string) (*stream.Server, error) {
+	cfg := stream.DefaultConfig()
+	cfg.ListenAddr = addr
+
+	srv, err := stream.NewServer(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("new stream server: %w", err)
+	}
+	go srv.ListenAndServe()
+
+	return srv, nil
+}
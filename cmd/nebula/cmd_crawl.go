@@ -5,9 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/dennis-tra/nebula-crawler/bitcoin"
 	"github.com/dennis-tra/nebula-crawler/config"
@@ -23,24 +27,46 @@ import (
 	"github.com/dennis-tra/nebula-crawler/discv4"
 	"github.com/dennis-tra/nebula-crawler/discv5"
 	"github.com/dennis-tra/nebula-crawler/libp2p"
+	"github.com/dennis-tra/nebula-crawler/maxmind"
+	"github.com/dennis-tra/nebula-crawler/shard"
 	"github.com/dennis-tra/nebula-crawler/utils"
 )
 
 var crawlConfig = &config.Crawl{
-	Root:              rootConfig,
-	CrawlWorkerCount:  1000,
-	WriteWorkerCount:  10,
-	CrawlLimit:        0,
-	PersistNeighbors:  false,
-	FilePathUdgerDB:   "",
-	Network:           string(config.NetworkIPFS),
-	BootstrapPeers:    cli.NewStringSlice(),
-	Protocols:         cli.NewStringSlice(string(kaddht.ProtocolDHT)),
-	AddrDialTypeStr:   "public",
-	KeepENR:           false,
-	CheckExposed:      false,
-	UDPRespTimeout:    3 * time.Second,
-	EnableGossipSubPX: false,
+	Root:                rootConfig,
+	CrawlWorkerCount:    1000,
+	WriteWorkerCount:    10,
+	CrawlLimit:          0,
+	PersistNeighbors:    false,
+	FilePathUdgerDB:     "",
+	Network:             string(config.NetworkIPFS),
+	BootstrapPeers:      cli.NewStringSlice(),
+	Protocols:           cli.NewStringSlice(string(kaddht.ProtocolDHT)),
+	AddrDialTypeStr:     "public",
+	KeepENR:             false,
+	CheckExposed:        false,
+	UDPRespTimeout:      3 * time.Second,
+	EnableGossipSubPX:   false,
+	CheckpointPath:      "",
+	CheckpointInterval:  time.Minute,
+	ResumePath:          "",
+	DialLimitPerPrefix:  0,
+	DialPrefixLenV4:     24,
+	DialPrefixLenV6:     48,
+	DialLimitPerASN:     0,
+	FilePathMaxmindASN:  "",
+	AdaptiveWorkers:     false,
+	MinWorkerCount:      10,
+	MaxWorkerCount:      0,
+	ShardCount:          0,
+	ShardListen:         "0.0.0.0:0",
+	ShardInterval:       time.Second,
+	ShardTimeout:        time.Minute,
+	WriteQueueLimit:     10_000,
+	SpillDir:            "",
+	SpillReplayInterval: 30 * time.Second,
+	RetryPasses:         0,
+	RetryDialTimeout:    0,
 }
 
 // CrawlCommand contains the crawl sub-command configuration.
@@ -49,8 +75,38 @@ var CrawlCommand = &cli.Command{
 	Usage:  "Crawls the entire network starting with a set of bootstrap nodes.",
 	Action: CrawlAction,
 	Before: func(c *cli.Context) error {
+		networks := crawlConfig.Networks()
+		if len(networks) == 0 {
+			return fmt.Errorf("no network given")
+		}
+
+		// when crawling multiple networks, each network uses its default
+		// bootstrap peers and protocols. Features that keep state in a single
+		// location aren't supported either. Checkpoints are written to one
+		// file per network (see networkCheckpointPath).
+		if len(networks) > 1 {
+			for _, flag := range []string{"bootstrap-peers", "protocols", "shard-coordinator", "shard-join"} {
+				if c.IsSet(flag) {
+					return fmt.Errorf("--%s is not supported when crawling multiple networks", flag)
+				}
+			}
+
+			// a checkpoint only holds the state of a single network
+			if crawlConfig.ResumePath != "" {
+				if _, err := os.Stat(crawlConfig.ResumePath); err == nil {
+					return fmt.Errorf("--resume must be the path that was given to --checkpoint when crawling multiple networks, not the checkpoint of a single network (%s)", crawlConfig.ResumePath)
+				}
+			}
+
+			for _, network := range networks[1:] {
+				if _, _, err := config.ConfigureNetwork(network); err != nil {
+					return err
+				}
+			}
+		}
+
 		// based on the network setting, return the default bootstrap peers and protocols
-		bootstrapPeers, protocols, err := config.ConfigureNetwork(crawlConfig.Network)
+		bootstrapPeers, protocols, err := config.ConfigureNetwork(networks[0])
 		if err != nil {
 			return err
 		}
@@ -126,6 +182,27 @@ var CrawlCommand = &cli.Command{
 			Destination: &crawlConfig.WriteWorkerCount,
 			Hidden:      true,
 		},
+		&cli.BoolFlag{
+			Name:        "adaptive-workers",
+			Usage:       "Adapt the number of concurrently active workers based on connection errors, latencies, and open file descriptors",
+			EnvVars:     []string{"NEBULA_CRAWL_ADAPTIVE_WORKERS"},
+			Value:       crawlConfig.AdaptiveWorkers,
+			Destination: &crawlConfig.AdaptiveWorkers,
+		},
+		&cli.IntFlag{
+			Name:        "min-workers",
+			Usage:       "The minimum number of concurrently active workers if --adaptive-workers is set",
+			EnvVars:     []string{"NEBULA_CRAWL_MIN_WORKER_COUNT"},
+			Value:       crawlConfig.MinWorkerCount,
+			Destination: &crawlConfig.MinWorkerCount,
+		},
+		&cli.IntFlag{
+			Name:        "max-workers",
+			Usage:       "The maximum number of concurrently active workers if --adaptive-workers is set (0 means the value of --workers)",
+			EnvVars:     []string{"NEBULA_CRAWL_MAX_WORKER_COUNT"},
+			Value:       crawlConfig.MaxWorkerCount,
+			Destination: &crawlConfig.MaxWorkerCount,
+		},
 		&cli.IntFlag{
 			Name:        "limit",
 			Usage:       "Only crawl the specified amount of peers (0 for unlimited)",
@@ -142,7 +219,7 @@ var CrawlCommand = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:        "network",
-			Usage:       "Which network should be crawled. Presets default bootstrap peers and protocol. Run: `nebula networks` for more information.",
+			Usage:       "Which network should be crawled. Presets default bootstrap peers and protocol. Accepts a comma-separated list of networks that are crawled concurrently. Run: `nebula networks` for more information.",
 			EnvVars:     []string{"NEBULA_CRAWL_NETWORK"},
 			Value:       crawlConfig.Network,
 			Destination: &crawlConfig.Network,
@@ -186,6 +263,146 @@ var CrawlCommand = &cli.Command{
 			Destination: &crawlConfig.UDPRespTimeout,
 			Category:    flagCategoryNetwork,
 		},
+		&cli.StringFlag{
+			Name:        "checkpoint",
+			Usage:       "If set, periodically writes the crawl state to `FILE`, so that an interrupted crawl can be resumed with --resume. When crawling multiple networks, each network writes to FILE.<network>",
+			EnvVars:     []string{"NEBULA_CRAWL_CHECKPOINT"},
+			Value:       crawlConfig.CheckpointPath,
+			Destination: &crawlConfig.CheckpointPath,
+		},
+		&cli.DurationFlag{
+			Name:        "checkpoint-interval",
+			Usage:       "How often the crawl state should be written to the checkpoint file",
+			EnvVars:     []string{"NEBULA_CRAWL_CHECKPOINT_INTERVAL"},
+			Value:       crawlConfig.CheckpointInterval,
+			Destination: &crawlConfig.CheckpointInterval,
+		},
+		&cli.StringFlag{
+			Name:        "resume",
+			Usage:       "Resume an interrupted crawl from the checkpoint at `FILE`. Results are written to the same crawl. When crawling multiple networks, each network resumes from FILE.<network>",
+			EnvVars:     []string{"NEBULA_CRAWL_RESUME"},
+			Value:       crawlConfig.ResumePath,
+			Destination: &crawlConfig.ResumePath,
+		},
+		&cli.IntFlag{
+			Name:        "dial-limit-prefix",
+			Usage:       "The maximum number of peers in the same IP prefix that are crawled concurrently (0 disables the limit)",
+			EnvVars:     []string{"NEBULA_CRAWL_DIAL_LIMIT_PREFIX"},
+			Value:       crawlConfig.DialLimitPerPrefix,
+			Destination: &crawlConfig.DialLimitPerPrefix,
+		},
+		&cli.IntFlag{
+			Name:        "dial-prefix-v4",
+			Usage:       "The prefix length by which IPv4 addresses are grouped for --dial-limit-prefix",
+			EnvVars:     []string{"NEBULA_CRAWL_DIAL_PREFIX_V4"},
+			Value:       crawlConfig.DialPrefixLenV4,
+			Destination: &crawlConfig.DialPrefixLenV4,
+		},
+		&cli.IntFlag{
+			Name:        "dial-prefix-v6",
+			Usage:       "The prefix length by which IPv6 addresses are grouped for --dial-limit-prefix",
+			EnvVars:     []string{"NEBULA_CRAWL_DIAL_PREFIX_V6"},
+			Value:       crawlConfig.DialPrefixLenV6,
+			Destination: &crawlConfig.DialPrefixLenV6,
+		},
+		&cli.IntFlag{
+			Name:        "dial-limit-asn",
+			Usage:       "The maximum number of peers in the same autonomous system that are crawled concurrently (0 disables the limit, requires --maxmind-asn)",
+			EnvVars:     []string{"NEBULA_CRAWL_DIAL_LIMIT_ASN"},
+			Value:       crawlConfig.DialLimitPerASN,
+			Destination: &crawlConfig.DialLimitPerASN,
+		},
+		&cli.StringFlag{
+			Name:        "maxmind-asn",
+			Usage:       "Location of the Maxmind ASN database that's used for --dial-limit-asn",
+			EnvVars:     []string{"NEBULA_CRAWL_MAXMIND_ASN"},
+			Value:       crawlConfig.FilePathMaxmindASN,
+			Destination: &crawlConfig.FilePathMaxmindASN,
+		},
+		&cli.StringFlag{
+			Name:        "shard-coordinator",
+			Usage:       "Coordinate a distributed crawl across --shard-count workers and listen for them on `ADDR`. The coordinator doesn't crawl itself. Requires a postgres or clickhouse database.",
+			EnvVars:     []string{"NEBULA_CRAWL_SHARD_COORDINATOR"},
+			Value:       crawlConfig.ShardCoordinatorListen,
+			Destination: &crawlConfig.ShardCoordinatorListen,
+		},
+		&cli.IntFlag{
+			Name:        "shard-count",
+			Usage:       "The number of workers that take part in the distributed crawl (requires --shard-coordinator)",
+			EnvVars:     []string{"NEBULA_CRAWL_SHARD_COUNT"},
+			Value:       crawlConfig.ShardCount,
+			Destination: &crawlConfig.ShardCount,
+		},
+		&cli.StringFlag{
+			Name:        "shard-join",
+			Usage:       "Take part in a distributed crawl by registering with the coordinator at `URL`. Only supported for libp2p networks and postgres or clickhouse databases.",
+			EnvVars:     []string{"NEBULA_CRAWL_SHARD_JOIN"},
+			Value:       crawlConfig.ShardCoordinatorURL,
+			Destination: &crawlConfig.ShardCoordinatorURL,
+		},
+		&cli.StringFlag{
+			Name:        "shard-listen",
+			Usage:       "The address on which this worker listens for peers that other workers forward (requires --shard-join)",
+			EnvVars:     []string{"NEBULA_CRAWL_SHARD_LISTEN"},
+			Value:       crawlConfig.ShardListen,
+			Destination: &crawlConfig.ShardListen,
+		},
+		&cli.StringFlag{
+			Name:        "shard-advertise",
+			Usage:       "The `URL` under which the coordinator and other workers can reach this worker (default: derived from --shard-listen)",
+			EnvVars:     []string{"NEBULA_CRAWL_SHARD_ADVERTISE"},
+			Value:       crawlConfig.ShardAdvertiseURL,
+			Destination: &crawlConfig.ShardAdvertiseURL,
+		},
+		&cli.DurationFlag{
+			Name:        "shard-interval",
+			Usage:       "How often workers forward peers to each other and the coordinator checks whether the crawl has finished",
+			EnvVars:     []string{"NEBULA_CRAWL_SHARD_INTERVAL"},
+			Value:       crawlConfig.ShardInterval,
+			Destination: &crawlConfig.ShardInterval,
+		},
+		&cli.DurationFlag{
+			Name:        "shard-timeout",
+			Usage:       "How long a worker of a distributed crawl may be unresponsive before the coordinator fails the crawl",
+			EnvVars:     []string{"NEBULA_CRAWL_SHARD_TIMEOUT"},
+			Value:       crawlConfig.ShardTimeout,
+			Destination: &crawlConfig.ShardTimeout,
+		},
+		&cli.IntFlag{
+			Name:        "write-queue-limit",
+			Usage:       "The maximum number of crawl results that wait to be written before no new peers are crawled (0 disables the limit)",
+			EnvVars:     []string{"NEBULA_CRAWL_WRITE_QUEUE_LIMIT"},
+			Value:       crawlConfig.WriteQueueLimit,
+			Destination: &crawlConfig.WriteQueueLimit,
+		},
+		&cli.StringFlag{
+			Name:        "spill-dir",
+			Usage:       "If set, visits that can't be written to the database are spilled to `DIR` and replayed when the database recovers or at the next start (not supported with clickhouse, parquet or multiple engines)",
+			EnvVars:     []string{"NEBULA_CRAWL_SPILL_DIR"},
+			Value:       crawlConfig.SpillDir,
+			Destination: &crawlConfig.SpillDir,
+		},
+		&cli.DurationFlag{
+			Name:        "spill-replay-interval",
+			Usage:       "How often spilled visits are replayed to the database (requires --spill-dir)",
+			EnvVars:     []string{"NEBULA_CRAWL_SPILL_REPLAY_INTERVAL"},
+			Value:       crawlConfig.SpillReplayInterval,
+			Destination: &crawlConfig.SpillReplayInterval,
+		},
+		&cli.IntFlag{
+			Name:        "retry-passes",
+			Usage:       "How often peers that failed with transient errors are crawled again after all other peers were crawled (0 disables retrying)",
+			EnvVars:     []string{"NEBULA_CRAWL_RETRY_PASSES"},
+			Value:       crawlConfig.RetryPasses,
+			Destination: &crawlConfig.RetryPasses,
+		},
+		&cli.DurationFlag{
+			Name:        "retry-dial-timeout",
+			Usage:       "The dial timeout for retried peers. Only supported for libp2p networks (0 means the regular dial timeout)",
+			EnvVars:     []string{"NEBULA_CRAWL_RETRY_DIAL_TIMEOUT"},
+			Value:       crawlConfig.RetryDialTimeout,
+			Destination: &crawlConfig.RetryDialTimeout,
+		},
 		&cli.IntFlag{
 			Name:        "waku-cluster-id",
 			Usage:       "WAKU/WAKU_TWN: The cluster ID for the Waku network",
@@ -212,23 +429,194 @@ func CrawlAction(c *cli.Context) error {
 	log.Infoln("Starting Nebula crawler...")
 	defer log.Infoln("Stopped Nebula crawler.")
 
-	// init convenience variables
-	ctx := c.Context
-	cfg := crawlConfig
-	start := time.Now()
+	// if configured, stream all results to interested subscribers
+	var observers []core.Observer
+	if rootConfig.StreamAddr != "" {
+		srv, err := startStreamServer(rootConfig.StreamAddr)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := srv.Close(); err != nil {
+				log.WithError(err).Warnln("Failed closing stream server")
+			}
+		}()
+		observers = append(observers, srv)
+	}
+
+	// if multiple networks were given, crawl all of them concurrently
+	if networks := crawlConfig.Networks(); len(networks) > 1 {
+		return crawlNetworks(c, networks, observers)
+	}
 
 	// initialize a new database client based on the given configuration.
 	// Options are Postgres, JSON, and noop (dry-run).
-	dbc, err := rootConfig.Database.NewClient(ctx)
+	dbc, err := rootConfig.Database.NewClient(c.Context)
 	if err != nil {
 		return fmt.Errorf("new database client: %w", err)
 	}
+	defer closeDBClient(dbc)
+
+	return crawlNetwork(c, crawlConfig, dbc, observers)
+}
+
+// crawlNetworks crawls all given networks concurrently. Each network is
+// crawled by its own engine and gets its own crawl in the database. If the
+// database client supports it, all networks share the same connection.
+func crawlNetworks(c *cli.Context, networks []string, observers []core.Observer) error {
+	dbcs, err := rootConfig.Database.NewClients(c.Context, networks)
+	if err != nil {
+		return fmt.Errorf("new database clients: %w", err)
+	}
 	defer func() {
-		if err := dbc.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) && !strings.Contains(err.Error(), "use of closed network connection") {
-			log.WithError(err).Warnln("Failed closing database handle")
+		for _, dbc := range dbcs {
+			closeDBClient(dbc)
 		}
 	}()
 
+	errs := make([]error, len(networks))
+
+	var wg sync.WaitGroup
+	for i, network := range networks {
+		// derive the configuration of this network from the command line
+		// configuration.
+		netCfg := *crawlConfig
+		netCfg.Network = network
+
+		bootstrapPeers, protocols, err := config.ConfigureNetwork(network)
+		if err != nil {
+			return err
+		}
+		netCfg.BootstrapPeers = bootstrapPeers
+		netCfg.Protocols = protocols
+
+		// don't replay the spilled visits of one network into another
+		if netCfg.SpillDir != "" {
+			netCfg.SpillDir = filepath.Join(netCfg.SpillDir, network)
+		}
+
+		// each network writes and resumes from its own checkpoint
+		netCfg.CheckpointPath = networkCheckpointPath(netCfg.CheckpointPath, network)
+		netCfg.ResumePath = networkCheckpointPath(netCfg.ResumePath, network)
+
+		wg.Add(1)
+		go func(i int, cfg *config.Crawl) {
+			defer wg.Done()
+			if err := crawlNetwork(c, cfg, dbcs[i], observers); err != nil {
+				errs[i] = fmt.Errorf("crawl %s: %w", cfg.Network, err)
+			}
+		}(i, &netCfg)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// networkCheckpointPath returns the path of the checkpoint of the given network
+// when crawling multiple networks. It returns an empty path if no path was
+// given.
+func networkCheckpointPath(path string, network string) string {
+	if path == "" {
+		return ""
+	}
+	return path + "." + network
+}
+
+// closeDBClient closes the given database client and logs any unexpected
+// errors.
+func closeDBClient(dbc db.Client) {
+	if err := dbc.Close(); err != nil && !errors.Is(err, sql.ErrConnDone) && !strings.Contains(err.Error(), "use of closed network connection") {
+		log.WithError(err).Warnln("Failed closing database handle")
+	}
+}
+
+// crawlNetwork crawls the network that's configured in cfg and writes the
+// results with the given database client.
+func crawlNetwork(c *cli.Context, cfg *config.Crawl, dbc db.Client, observers []core.Observer) error {
+	// init convenience variables
+	ctx := c.Context
+	start := time.Now()
+
+	// if we should resume an interrupted crawl, load the engine state that
+	// was persisted in the checkpoint file.
+	var resume *core.Checkpoint
+	if cfg.ResumePath != "" {
+		cp, err := core.LoadCheckpoint(cfg.ResumePath)
+		if err != nil {
+			return fmt.Errorf("load checkpoint: %w", err)
+		} else if cp.Network != cfg.Network {
+			return fmt.Errorf("checkpoint network mismatch (expected %s, got %s)", cfg.Network, cp.Network)
+		}
+
+		log.WithFields(log.Fields{
+			"crawlID":   cp.CrawlID,
+			"queued":    len(cp.Queued),
+			"processed": len(cp.Processed),
+			"createdAt": cp.CreatedAt,
+		}).Infoln("Resuming crawl from checkpoint")
+
+		resume = cp
+	}
+
+	if cfg.ShardCoordinatorListen != "" && cfg.ShardCoordinatorURL != "" {
+		return fmt.Errorf("--shard-coordinator and --shard-join are mutually exclusive")
+	} else if cfg.ShardCoordinatorURL != "" && resume != nil {
+		return fmt.Errorf("--resume is not supported in distributed crawls")
+	} else if (cfg.ShardCoordinatorListen != "" || cfg.ShardCoordinatorURL != "") && !db.Shared(dbc) {
+		return fmt.Errorf("distributed crawls require a postgres or clickhouse database")
+	}
+
+	// if we're the coordinator of a distributed crawl, we only create the
+	// crawl and wait for the workers to do the actual work.
+	if cfg.ShardCoordinatorListen != "" {
+		return coordinateCrawl(c, cfg, dbc, start)
+	}
+
+	// if we're a worker of a distributed crawl, register with the coordinator
+	// to learn which slice of the keyspace we're responsible for and which
+	// crawl we should write our results to.
+	var (
+		transport  *shard.Transport[libp2p.PeerInfo]
+		assignment *shard.Assignment
+		err        error
+	)
+	if cfg.ShardCoordinatorURL != "" {
+		// forwarded peers are exchanged as libp2p address information
+		switch cfg.Network {
+		case string(config.NetworkEthExec),
+			string(config.NetworkBitcoin),
+			string(config.NetworkEthCons),
+			string(config.NetworkHolesky),
+			string(config.NetworkPortal),
+			string(config.NetworkWakuStatus),
+			string(config.NetworkWakuTWN),
+			string(config.NetworkGnosis):
+			return fmt.Errorf("distributed crawls are not supported for network %s", cfg.Network)
+		}
+
+		transport, err = shard.NewTransport[libp2p.PeerInfo](&shard.TransportConfig{
+			ListenAddr:     cfg.ShardListen,
+			AdvertiseURL:   cfg.ShardAdvertiseURL,
+			CoordinatorURL: cfg.ShardCoordinatorURL,
+			FlushInterval:  cfg.ShardInterval,
+		}, func(p core.CheckpointPeer) (libp2p.PeerInfo, error) {
+			return libp2p.PeerInfo{AddrInfo: p.AddrInfo()}, nil
+		})
+		if err != nil {
+			return fmt.Errorf("new shard transport: %w", err)
+		}
+		defer func() {
+			if err := transport.Close(); err != nil {
+				log.WithError(err).Warnln("Failed closing shard transport")
+			}
+		}()
+
+		assignment, err = transport.Start(ctx)
+		if err != nil {
+			return fmt.Errorf("start shard transport: %w", err)
+		}
+	}
+
 	// Query some additional bootstrap peers from the database.
 	// This is optional, and we only log a warning if that doesn't work.
 	bpAddrInfos, err := dbc.QueryBootstrapPeers(ctx, 10)
@@ -237,13 +625,54 @@ func CrawlAction(c *cli.Context) error {
 	}
 	log.WithField("limit", 10).Infof("Queried %d bootstrap peers\n", len(bpAddrInfos))
 
-	// Inserting a crawl row into the db so that we
-	// can associate results with this crawl via
-	// its DB identifier
-	if err := dbc.InitCrawl(ctx, c.App.Version); err != nil {
+	if resume != nil {
+		// Continue writing results to the interrupted crawl and schedule all
+		// peers that were still queued as if they were bootstrap peers.
+		if err := dbc.ResumeCrawl(ctx, resume.CrawlID); err != nil {
+			return fmt.Errorf("resuming crawl in db: %w", err)
+		}
+		bpAddrInfos = append(bpAddrInfos, resume.AddrInfos()...)
+	} else if assignment != nil {
+		// All workers of a distributed crawl write to the crawl that the
+		// coordinator has created.
+		if err := dbc.ResumeCrawl(ctx, assignment.CrawlID); err != nil {
+			return fmt.Errorf("joining crawl in db: %w", err)
+		}
+	} else if err := dbc.InitCrawl(ctx, c.App.Version); err != nil {
+		// Inserting a crawl row into the db so that we
+		// can associate results with this crawl via
+		// its DB identifier
 		return fmt.Errorf("creating crawl in db: %w", err)
 	}
 
+	// if configured, spill visits to disk that can't be written to the
+	// database. The crawl writers use the wrapped client.
+	var writeDBC db.Client = dbc
+	var spill *db.SpillClient
+	if _, ok := dbc.(*db.NoopClient); !ok && cfg.SpillDir != "" {
+		if !db.Spillable(dbc) {
+			return fmt.Errorf("spilling visits to disk is not supported with database engine %q", cfg.Root.Database.DatabaseEngine)
+		}
+
+		spillCfg := db.DefaultSpillConfig()
+		spillCfg.Dir = cfg.SpillDir
+		spillCfg.ReplayInterval = cfg.SpillReplayInterval
+		spillCfg.MeterProvider = cfg.Root.MeterProvider
+
+		var err error
+		spill, err = db.NewSpillClient(dbc, spillCfg)
+		if err != nil {
+			return fmt.Errorf("new spill client: %w", err)
+		}
+		defer func() {
+			if err := spill.Close(); err != nil {
+				log.WithError(err).Warnln("Failed closing spill client")
+			}
+		}()
+
+		writeDBC = spill
+	}
+
 	// Set the timeout for dialing peers
 	ctx = network.WithDialPeerTimeout(ctx, cfg.Root.DialTimeout)
 
@@ -255,6 +684,9 @@ func CrawlAction(c *cli.Context) error {
 	ctx = network.WithDisableBackoff(ctx, "prevent backoff")
 
 	handlerCfg := &core.CrawlHandlerConfig{}
+	if cfg.RetryPasses > 0 {
+		handlerCfg.RetryErrors = core.DefaultRetryErrors
+	}
 
 	engineCfg := &core.EngineConfig{
 		WorkerCount:         cfg.CrawlWorkerCount,
@@ -264,6 +696,84 @@ func CrawlAction(c *cli.Context) error {
 		DuplicateProcessing: false,
 		TracerProvider:      cfg.Root.TracerProvider,
 		MeterProvider:       cfg.Root.MeterProvider,
+		MetricAttributes:    []attribute.KeyValue{attribute.String("network", cfg.Network)},
+		MaxWriteQueue:       cfg.WriteQueueLimit,
+		RetryPasses:         cfg.RetryPasses,
+		Observers:           observers,
+		Network:             cfg.Network,
+		Resume:              resume,
+	}
+
+	// if configured, adapt the number of active workers between the given
+	// bounds. The engine initializes as many workers as the upper bound.
+	if cfg.AdaptiveWorkers {
+		if cfg.MaxWorkerCount > 0 {
+			engineCfg.WorkerCount = cfg.MaxWorkerCount
+		}
+
+		engineCfg.Concurrency = core.DefaultConcurrencyConfig()
+		engineCfg.Concurrency.MinWorkers = cfg.MinWorkerCount
+	}
+
+	// if configured, limit the number of peers that share an IP prefix or
+	// autonomous system that we crawl concurrently.
+	if cfg.DialLimitPerPrefix > 0 || cfg.DialLimitPerASN > 0 {
+		policyCfg := &core.PrefixPolicyConfig{
+			MaxPerPrefix:  cfg.DialLimitPerPrefix,
+			IPv4PrefixLen: cfg.DialPrefixLenV4,
+			IPv6PrefixLen: cfg.DialPrefixLenV6,
+			MaxPerASN:     cfg.DialLimitPerASN,
+		}
+
+		if cfg.DialLimitPerASN > 0 {
+			if cfg.FilePathMaxmindASN == "" {
+				return fmt.Errorf("--dial-limit-asn requires --maxmind-asn")
+			}
+
+			mmc, err := maxmind.NewASNClient(cfg.FilePathMaxmindASN)
+			if err != nil {
+				return fmt.Errorf("new maxmind asn client: %w", err)
+			}
+			defer func() {
+				if err := mmc.Close(); err != nil {
+					log.WithError(err).Warnln("Failed closing maxmind client")
+				}
+			}()
+
+			policyCfg.ASNLookup = func(ip net.IP) (uint, error) {
+				asn, _, err := mmc.AddrAS(ip.String())
+				return asn, err
+			}
+		}
+
+		policy, err := core.NewPrefixPolicy(policyCfg)
+		if err != nil {
+			return fmt.Errorf("new prefix scheduling policy: %w", err)
+		}
+		engineCfg.SchedulingPolicy = policy
+	}
+
+	// by default, keep writing checkpoints to the file we have resumed from
+	checkpointPath := cfg.CheckpointPath
+	if checkpointPath == "" {
+		checkpointPath = cfg.ResumePath
+	}
+
+	if checkpointPath != "" {
+		engineCfg.Checkpoint = &core.CheckpointConfig{
+			Path:     checkpointPath,
+			Interval: cfg.CheckpointInterval,
+			CrawlID:  dbc.CrawlID(),
+			Network:  cfg.Network,
+		}
+	}
+
+	if assignment != nil {
+		engineCfg.Shard = &core.ShardConfig{
+			Index:     assignment.Index,
+			Count:     assignment.Count,
+			Transport: transport,
+		}
 	}
 
 	var (
@@ -309,7 +819,7 @@ func CrawlAction(c *cli.Context) error {
 		}
 
 		// init the crawl driver
-		driver, err := discv4.NewCrawlDriver(dbc, driverCfg)
+		driver, err := discv4.NewCrawlDriver(writeDBC, driverCfg)
 		if err != nil {
 			return fmt.Errorf("new discv4 driver: %w", err)
 		}
@@ -346,7 +856,7 @@ func CrawlAction(c *cli.Context) error {
 		}
 
 		// init the crawl driver
-		driver, err := bitcoin.NewCrawlDriver(dbc, driverCfg)
+		driver, err := bitcoin.NewCrawlDriver(writeDBC, driverCfg)
 		if err != nil {
 			return fmt.Errorf("new bitcoin driver: %w", err)
 		}
@@ -405,7 +915,7 @@ func CrawlAction(c *cli.Context) error {
 			BootstrapPeers:    bpEnodes,
 			CrawlWorkerCount:  cfg.CrawlWorkerCount,
 			AddrDialType:      cfg.AddrDialType(),
-			KeepENR:           crawlConfig.KeepENR,
+			KeepENR:           cfg.KeepENR,
 			TracerProvider:    cfg.Root.TracerProvider,
 			MeterProvider:     cfg.Root.MeterProvider,
 			LogErrors:         cfg.Root.LogErrors,
@@ -417,7 +927,7 @@ func CrawlAction(c *cli.Context) error {
 		}
 
 		// init the crawl driver
-		driver, err := discv5.NewCrawlDriver(dbc, driverCfg)
+		driver, err := discv5.NewCrawlDriver(writeDBC, driverCfg)
 		if err != nil {
 			return fmt.Errorf("new discv5 driver: %w", err)
 		}
@@ -445,22 +955,23 @@ func CrawlAction(c *cli.Context) error {
 
 		// configure the crawl driver
 		driverCfg := &libp2p.CrawlDriverConfig{
-			Version:        cfg.Root.Version(),
-			WorkerCount:    cfg.CrawlWorkerCount,
-			Network:        config.Network(cfg.Network),
-			Protocols:      cfg.Protocols.Value(),
-			DialTimeout:    cfg.Root.DialTimeout,
-			CheckExposed:   cfg.CheckExposed,
-			BootstrapPeers: bpAddrInfos,
-			AddrDialType:   cfg.AddrDialType(),
-			TracerProvider: cfg.Root.TracerProvider,
-			MeterProvider:  cfg.Root.MeterProvider,
-			GossipSubPX:    cfg.EnableGossipSubPX,
-			LogErrors:      cfg.Root.LogErrors,
+			Version:          cfg.Root.Version(),
+			WorkerCount:      cfg.CrawlWorkerCount,
+			Network:          config.Network(cfg.Network),
+			Protocols:        cfg.Protocols.Value(),
+			DialTimeout:      cfg.Root.DialTimeout,
+			RetryDialTimeout: cfg.RetryDialTimeout,
+			CheckExposed:     cfg.CheckExposed,
+			BootstrapPeers:   bpAddrInfos,
+			AddrDialType:     cfg.AddrDialType(),
+			TracerProvider:   cfg.Root.TracerProvider,
+			MeterProvider:    cfg.Root.MeterProvider,
+			GossipSubPX:      cfg.EnableGossipSubPX,
+			LogErrors:        cfg.Root.LogErrors,
 		}
 
 		// init the crawl driver
-		driver, err := libp2p.NewCrawlDriver(dbc, driverCfg)
+		driver, err := libp2p.NewCrawlDriver(writeDBC, driverCfg)
 		if err != nil {
 			return fmt.Errorf("new driver: %w", err)
 		}
@@ -478,12 +989,92 @@ func CrawlAction(c *cli.Context) error {
 		summary, runErr = eng.Run(ctx)
 	}
 
+	// try to write all spilled visits before the crawl is sealed. Visits
+	// that still can't be written stay on disk and are replayed during the
+	// next run.
+	if spill != nil {
+		replaySpill(spill)
+	}
+
+	// in a distributed crawl, the coordinator seals the crawl after all
+	// workers have reported their results.
+	if transport != nil {
+		return reportShardSummary(cfg, dbc, transport, summary, runErr, start)
+	}
+
 	// we're done with the crawl so seal the crawl and store aggregate information
 	if err := persistCrawlInformation(dbc, summary, runErr); err != nil {
 		return fmt.Errorf("persist crawl information: %w", err)
 	}
 
-	logSummary(summary, time.Since(start))
+	logSummary(cfg.Network, summary, time.Since(start))
+
+	return nil
+}
+
+// replaySpill replays the visits that were spilled to disk during the crawl
+// and inserts the visits that the database client still holds in memory.
+// Visits of batches that fail now are spilled again.
+func replaySpill(spill *db.SpillClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := spill.Flush(ctx); err != nil {
+		log.WithError(err).Warnln("Not all spilled visits could be replayed, they'll be replayed during the next run")
+	}
+}
+
+// coordinateCrawl creates a new crawl and waits for the workers of a
+// distributed crawl to finish. Afterward, it seals the crawl with the merged
+// results of all workers.
+func coordinateCrawl(c *cli.Context, cfg *config.Crawl, dbc db.Client, start time.Time) error {
+	// Inserting a crawl row into the db so that all
+	// workers can associate their results with this
+	// crawl via its DB identifier
+	if err := dbc.InitCrawl(c.Context, c.App.Version); err != nil {
+		return fmt.Errorf("creating crawl in db: %w", err)
+	}
+
+	coordinator, err := shard.NewCoordinator(&shard.CoordinatorConfig{
+		ListenAddr:    cfg.ShardCoordinatorListen,
+		Count:         cfg.ShardCount,
+		CrawlID:       dbc.CrawlID(),
+		PollInterval:  cfg.ShardInterval,
+		WorkerTimeout: cfg.ShardTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("new shard coordinator: %w", err)
+	}
+
+	summary, runErr := coordinator.Run(c.Context)
+	if summary == nil {
+		summary = &core.Summary{}
+	}
+
+	if err := persistCrawlInformation(dbc, summary, runErr); err != nil {
+		return fmt.Errorf("persist crawl information: %w", err)
+	}
+
+	logSummary(cfg.Network, summary, time.Since(start))
+
+	return runErr
+}
+
+// reportShardSummary flushes the results of a distributed crawl worker and
+// reports its summary to the coordinator.
+func reportShardSummary(cfg *config.Crawl, dbc db.Client, transport *shard.Transport[libp2p.PeerInfo], summary *core.Summary, runErr error, start time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shard.RequestTimeout)
+	defer cancel()
+
+	if err := dbc.Flush(ctx); err != nil {
+		log.WithError(err).Warnln("Failed flushing information to database")
+	}
+
+	if err := transport.ReportSummary(ctx, summary, runErr); err != nil {
+		return fmt.Errorf("report summary to coordinator: %w", err)
+	}
+
+	logSummary(cfg.Network, summary, time.Since(start))
 
 	return nil
 }
@@ -533,7 +1124,11 @@ func updateCrawl(ctx context.Context, dbc db.Client, runErr error, summary *core
 		Dialable:   summary.PeersDialable,
 		Undialable: summary.PeersUndialable,
 		Remaining:  summary.PeersRemaining,
+
+		Retried:        summary.PeersRetried,
+		RetrySucceeded: summary.PeersRetrySucceeded,
 	}
+	args.NetworkSize, args.NetworkSizeLower, args.NetworkSizeUpper = summary.NetworkSize.Estimate()
 
 	if runErr == nil {
 		args.State = db.CrawlStateSucceeded
@@ -550,8 +1145,6 @@ func updateCrawl(ctx context.Context, dbc db.Client, runErr error, summary *core
 func persistCrawlProperties(ctx context.Context, dbc db.Client, summary *core.Summary) error {
 	if _, ok := dbc.(*db.NoopClient); ok {
 		return nil
-	} else if _, ok := dbc.(*db.ClickHouseClient); ok {
-		return nil
 	}
 
 	log.Infoln("Persisting crawl properties...")
@@ -569,10 +1162,10 @@ func persistCrawlProperties(ctx context.Context, dbc db.Client, summary *core.Su
 }
 
 // logSummary logs the final results of the crawl.
-func logSummary(summary *core.Summary, crawlDuration time.Duration) {
+func logSummary(network string, summary *core.Summary, crawlDuration time.Duration) {
 	log.Infoln("")
 	log.Infoln("")
-	log.Infoln("Crawl summary:")
+	log.WithField("network", network).Infoln("Crawl summary:")
 
 	log.Infoln("")
 	for err, count := range summary.ConnErrs {
@@ -593,11 +1186,18 @@ func logSummary(summary *core.Summary, crawlDuration time.Duration) {
 		log.WithField("count", count).WithField("value", protocol).Infoln("Protocol")
 	}
 	log.Infoln("")
+	networkSize, networkSizeLower, networkSizeUpper := summary.NetworkSize.Estimate()
 	log.WithFields(log.Fields{
-		"crawledPeers":    summary.PeersCrawled,
-		"crawlDuration":   crawlDuration.String(),
-		"dialablePeers":   summary.PeersDialable,
-		"undialablePeers": summary.PeersUndialable,
-		"remainingPeers":  summary.PeersRemaining,
+		"network":          network,
+		"crawledPeers":     summary.PeersCrawled,
+		"crawlDuration":    crawlDuration.String(),
+		"dialablePeers":    summary.PeersDialable,
+		"undialablePeers":  summary.PeersUndialable,
+		"remainingPeers":   summary.PeersRemaining,
+		"networkSize":      int(networkSize),
+		"networkSizeLower": int(networkSizeLower),
+		"networkSizeUpper": int(networkSizeUpper),
+		"retriedPeers":     summary.PeersRetried,
+		"retrySucceeded":   summary.PeersRetrySucceeded,
 	}).Infoln("Finished crawl")
 }
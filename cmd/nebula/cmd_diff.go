@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/nebula-crawler/config"
+	"github.com/dennis-tra/nebula-crawler/db"
+)
+
+var diffConfig = &config.Diff{
+	Root:   rootConfig,
+	Crawls: cli.NewStringSlice(),
+	Out:    "",
+	Top:    10,
+}
+
+// DiffCommand contains the diff sub-command configuration.
+var DiffCommand = &cli.Command{
+	Name:   "diff",
+	Usage:  "Compares the peers of two crawls",
+	Action: DiffAction,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "crawl",
+			Usage:       "The ID of a crawl in the configured database or the output directory or file prefix of a JSON crawl. Pass twice, older crawl first",
+			EnvVars:     []string{"NEBULA_DIFF_CRAWL"},
+			Destination: diffConfig.Crawls,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Usage:       "Write the diff as JSON to this file (- for stdout)",
+			EnvVars:     []string{"NEBULA_DIFF_OUT"},
+			Value:       diffConfig.Out,
+			Destination: &diffConfig.Out,
+		},
+		&cli.IntFlag{
+			Name:        "top",
+			Usage:       "The number of agent version and protocol changes to show in the summary",
+			EnvVars:     []string{"NEBULA_DIFF_TOP"},
+			Value:       diffConfig.Top,
+			Destination: &diffConfig.Top,
+		},
+	},
+	Before: func(c *cli.Context) error {
+		if len(diffConfig.Crawls.Value()) != 2 {
+			return fmt.Errorf("exactly two crawls must be given with --crawl")
+		} else if diffConfig.Top < 0 {
+			return fmt.Errorf("number of summary entries must not be negative")
+		}
+		return nil
+	},
+}
+
+// DiffAction is the function that is called when running `nebula diff`.
+func DiffAction(c *cli.Context) error {
+	crawls := diffConfig.Crawls.Value()
+
+	// the database client is only initialized if a crawl isn't read from
+	// JSON files.
+	var dbc db.Client
+	defer func() {
+		if dbc == nil {
+			return
+		}
+		if err := dbc.Close(); err != nil {
+			log.WithError(err).Warnln("Failed closing database handle")
+		}
+	}()
+
+	snapshots := make([]*db.CrawlSnapshot, 0, len(crawls))
+	for _, crawl := range crawls {
+		var (
+			snapshot *db.CrawlSnapshot
+			err      error
+		)
+		if jsonCrawl, ok := jsonCrawlPath(crawl, diffConfig.Root.Database.JSONOut); ok {
+			snapshot, err = db.LoadJSONCrawl(jsonCrawl)
+		} else {
+			if dbc == nil {
+				if dbc, err = diffConfig.Root.Database.NewClient(c.Context); err != nil {
+					return err
+				}
+			}
+			snapshot, err = loadCrawl(c.Context, dbc, crawl)
+		}
+		if err != nil {
+			return fmt.Errorf("load crawl %s: %w", crawl, err)
+		}
+
+		log.WithFields(log.Fields{
+			"crawl": snapshot.CrawlID,
+			"peers": len(snapshot.Peers),
+		}).Infoln("Loaded crawl")
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	diff := db.DiffCrawls(snapshots[0], snapshots[1])
+
+	logDiffSummary(diff, diffConfig.Top)
+
+	if diffConfig.Out == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal diff: %w", err)
+	}
+
+	if diffConfig.Out == "-" {
+		_, err = fmt.Println(string(data))
+		return err
+	}
+
+	if err = os.WriteFile(diffConfig.Out, data, 0o644); err != nil {
+		return fmt.Errorf("write diff: %w", err)
+	}
+
+	log.WithField("file", diffConfig.Out).Infoln("Wrote diff")
+
+	return nil
+}
+
+// jsonCrawlPath returns the path to the crawl if it refers to the files of a
+// crawl that the JSON client wrote. This is the case if it is an existing
+// directory or file prefix or if a JSON output directory is configured.
+func jsonCrawlPath(crawl string, jsonOut string) (string, bool) {
+	if _, err := os.Stat(crawl); err == nil {
+		return crawl, true
+	}
+
+	if _, err := os.Stat(crawl + "_crawl.json"); err == nil {
+		return crawl, true
+	}
+
+	if jsonOut != "" {
+		return filepath.Join(jsonOut, crawl), true
+	}
+
+	return "", false
+}
+
+// loadCrawl loads the crawl with the given ID from the database.
+func loadCrawl(ctx context.Context, dbc db.Client, crawlID string) (*db.CrawlSnapshot, error) {
+	loader, ok := dbc.(db.CrawlLoader)
+	if !ok {
+		return nil, fmt.Errorf("diff is only supported for the postgres, clickhouse, sqlite, and json database engines")
+	}
+
+	return loader.LoadCrawl(ctx, crawlID)
+}
+
+// logDiffSummary logs a human-readable summary of the diff with at most top
+// entries for the agent version and protocol changes.
+func logDiffSummary(diff *db.CrawlDiff, top int) {
+	log.WithFields(log.Fields{
+		"crawlA":    diff.CrawlA,
+		"crawlB":    diff.CrawlB,
+		"dialableA": diff.DialablePeersA,
+		"dialableB": diff.DialablePeersB,
+	}).Infoln("Compared crawls")
+
+	log.Infof("New peers: %d", len(diff.NewPeers))
+	log.Infof("Re-appeared peers: %d", len(diff.ReappearedPeers))
+	log.Infof("Disappeared peers: %d", len(diff.DisappearedPeers))
+	log.Infof("Peers with address changes: %d", len(diff.AddressChanges))
+
+	type transition struct {
+		from  string
+		to    string
+		kind  db.AgentVersionChangeKind
+		count int
+	}
+
+	kinds := map[db.AgentVersionChangeKind]int{}
+	transitions := map[[2]string]*transition{}
+	for _, change := range diff.AgentVersionChanges {
+		kinds[change.Kind] += 1
+
+		key := [2]string{change.From, change.To}
+		if _, found := transitions[key]; !found {
+			transitions[key] = &transition{from: change.From, to: change.To, kind: change.Kind}
+		}
+		transitions[key].count += 1
+	}
+
+	log.WithFields(log.Fields{
+		"upgrades":   kinds[db.AgentVersionUpgrade],
+		"downgrades": kinds[db.AgentVersionDowngrade],
+		"other":      kinds[db.AgentVersionSwitch],
+	}).Infof("Agent version changes: %d", len(diff.AgentVersionChanges))
+
+	sorted := make([]*transition, 0, len(transitions))
+	for _, t := range transitions {
+		sorted = append(sorted, t)
+	}
+	slices.SortFunc(sorted, func(a, b *transition) int {
+		if a.count != b.count {
+			return b.count - a.count
+		}
+		return strings.Compare(a.from+a.to, b.from+b.to)
+	})
+	for _, t := range sorted[:min(top, len(sorted))] {
+		log.Infof("  %s -> %s (%s): %d", t.from, t.to, t.kind, t.count)
+	}
+
+	protocols := slices.Clone(diff.ProtocolChanges)
+	slices.SortStableFunc(protocols, func(a, b *db.ProtocolChange) int {
+		return absInt(b.PeersB-b.PeersA) - absInt(a.PeersB-a.PeersA)
+	})
+
+	log.Infof("Protocol adoption changes: %d", len(protocols))
+	for _, p := range protocols[:min(top, len(protocols))] {
+		log.Infof("  %s: %d -> %d (%+d)", p.Protocol, p.PeersA, p.PeersB, p.PeersB-p.PeersA)
+	}
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
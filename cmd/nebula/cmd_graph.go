@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/nebula-crawler/config"
+	"github.com/dennis-tra/nebula-crawler/db"
+)
+
+var graphConfig = &config.Graph{
+	Root:    rootConfig,
+	CrawlID: "",
+	Format:  "",
+	Out:     "",
+}
+
+// GraphCommand contains the graph sub-command configuration.
+var GraphCommand = &cli.Command{
+	Name:  "graph",
+	Usage: "Works with the graph of the routing tables of a crawl",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "export",
+			Usage:  "Exports the directed neighbor graph of a crawl for Gephi, networkx, or Graphviz",
+			Action: GraphExportAction,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "crawl",
+					Usage:       "The ID of a crawl in the configured database or the output directory or file prefix of a JSON crawl",
+					EnvVars:     []string{"NEBULA_GRAPH_CRAWL"},
+					Value:       graphConfig.CrawlID,
+					Destination: &graphConfig.CrawlID,
+					Required:    true,
+				},
+				&cli.StringFlag{
+					Name:        "out",
+					Usage:       "The file to which the graph should be written (- for stdout)",
+					EnvVars:     []string{"NEBULA_GRAPH_OUT"},
+					Value:       graphConfig.Out,
+					Destination: &graphConfig.Out,
+					Required:    true,
+				},
+				&cli.StringFlag{
+					Name:        "format",
+					Usage:       fmt.Sprintf("The file format of the graph %v (default derived from the file extension)", db.GraphFormats()),
+					EnvVars:     []string{"NEBULA_GRAPH_FORMAT"},
+					Value:       graphConfig.Format,
+					Destination: &graphConfig.Format,
+				},
+			},
+		},
+	},
+}
+
+// GraphExportAction is the function that is called when running `nebula graph export`.
+func GraphExportAction(c *cli.Context) error {
+	format, err := graphFormat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		g   *db.NeighborGraph
+		dbc db.Client
+	)
+	if jsonCrawl, ok := jsonCrawlPath(graphConfig.CrawlID, graphConfig.Root.Database.JSONOut); ok {
+		g, err = db.LoadJSONNeighborGraph(jsonCrawl)
+	} else {
+		dbc, err = graphConfig.Root.Database.NewClient(c.Context)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := dbc.Close(); err != nil {
+				log.WithError(err).Warnln("Failed closing database handle")
+			}
+		}()
+
+		loader, ok := dbc.(db.GraphLoader)
+		if !ok {
+			return fmt.Errorf("graph export is only supported for the postgres, clickhouse, sqlite, and json database engines")
+		}
+
+		g, err = loader.LoadNeighborGraph(c.Context, graphConfig.CrawlID)
+	}
+	if err != nil {
+		return fmt.Errorf("load neighbor graph: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"crawl": g.CrawlID,
+		"nodes": len(g.Nodes),
+		"edges": len(g.Edges),
+	}).Infoln("Loaded neighbor graph")
+
+	if graphConfig.Out == "-" {
+		return writeGraph(os.Stdout, g, format)
+	}
+
+	f, err := os.Create(graphConfig.Out)
+	if err != nil {
+		return fmt.Errorf("create graph file: %w", err)
+	}
+
+	if err = writeGraph(f, g, format); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("close graph file: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"file":   graphConfig.Out,
+		"format": format,
+	}).Infoln("Wrote neighbor graph")
+
+	return nil
+}
+
+// graphFormat returns the configured graph format or derives it from the
+// extension of the output file.
+func graphFormat() (db.GraphFormat, error) {
+	if graphConfig.Format == "" {
+		if graphConfig.Out == "-" {
+			return "", fmt.Errorf("--format is required when writing to stdout")
+		}
+		return db.GraphFormatFromPath(graphConfig.Out)
+	}
+
+	for _, format := range db.GraphFormats() {
+		if strings.EqualFold(graphConfig.Format, string(format)) {
+			return format, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown graph format %q", graphConfig.Format)
+}
+
+// writeGraph writes the graph in the given format through a buffer.
+func writeGraph(w io.Writer, g *db.NeighborGraph, format db.GraphFormat) error {
+	bw := bufio.NewWriter(w)
+	if err := g.Write(bw, format); err != nil {
+		return fmt.Errorf("write graph: %w", err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("flush graph: %w", err)
+	}
+
+	return nil
+}
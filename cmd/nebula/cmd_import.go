@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/nebula-crawler/config"
+	"github.com/dennis-tra/nebula-crawler/db"
+)
+
+var importConfig = &config.Import{
+	Root:             rootConfig,
+	Network:          string(config.NetworkIPFS),
+	PersistNeighbors: true,
+}
+
+// ImportCommand contains the import sub-command configuration.
+var ImportCommand = &cli.Command{
+	Name:      "import",
+	Usage:     "Imports crawls that were written with --json-out into the configured database",
+	ArgsUsage: "DIR|PREFIX...",
+	Action:    ImportAction,
+	Before: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("no JSON output directory or crawl given (flags must precede arguments)")
+		}
+
+		if _, _, err := config.ConfigureNetwork(importConfig.Network); err != nil {
+			return err
+		}
+
+		// set the network ID on the database object because the JSON files
+		// don't contain it.
+		rootConfig.Database.NetworkID = importConfig.Network
+
+		// set the persist neighbors flag on the database object
+		rootConfig.Database.PersistNeighbors = importConfig.PersistNeighbors
+
+		return nil
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "network",
+			Usage:       "The network in which the imported crawls were performed. Run: nebula networks for more information.",
+			EnvVars:     []string{"NEBULA_IMPORT_NETWORK"},
+			Value:       importConfig.Network,
+			Destination: &importConfig.Network,
+		},
+		&cli.BoolFlag{
+			Name:        "neighbors",
+			Usage:       "Whether to import the routing tables of the crawled peers",
+			EnvVars:     []string{"NEBULA_IMPORT_NEIGHBORS"},
+			Value:       importConfig.PersistNeighbors,
+			Destination: &importConfig.PersistNeighbors,
+		},
+	},
+}
+
+// ImportAction is the function that is called when running `nebula import`.
+// Each argument is either an output directory of the JSON client, in which
+// case all of its crawls are imported, or the file name prefix of a single
+// crawl.
+func ImportAction(c *cli.Context) error {
+	var crawls []string
+	for _, arg := range c.Args().Slice() {
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() {
+			crawls = append(crawls, arg)
+			continue
+		}
+
+		prefixes, err := db.ListJSONCrawls(arg)
+		if err != nil {
+			return err
+		} else if len(prefixes) == 0 {
+			return fmt.Errorf("no crawl found in %s", arg)
+		}
+		crawls = append(crawls, prefixes...)
+	}
+
+	// a client tracks a single crawl, so each crawl is imported with its
+	// own client. If supported, they share the connection of the first.
+	first, err := importConfig.Root.Database.NewClient(c.Context)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := first.Close(); err != nil {
+			log.WithError(err).Warnln("Failed closing database handle")
+		}
+	}()
+
+	for i, crawl := range crawls {
+		dbc := first
+		if i > 0 {
+			if forker, ok := first.(db.Forker); ok {
+				dbc, err = forker.Fork(importConfig.Network)
+			} else {
+				dbc, err = importConfig.Root.Database.NewClient(c.Context)
+			}
+			if err != nil {
+				return fmt.Errorf("new db client for %s: %w", crawl, err)
+			}
+		}
+
+		result, err := db.ImportJSONCrawl(c.Context, dbc, crawl)
+
+		if i > 0 {
+			if err := dbc.Close(); err != nil {
+				log.WithError(err).Warnln("Failed closing database handle")
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("import crawl %s: %w", crawl, err)
+		}
+
+		log.WithFields(log.Fields{
+			"crawl":     result.Crawl,
+			"crawlID":   result.CrawlID,
+			"visits":    result.Visits,
+			"neighbors": result.Neighbors,
+			"sealed":    result.Sealed,
+		}).Infoln("Imported crawl")
+	}
+
+	return nil
+}
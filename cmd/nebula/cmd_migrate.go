@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/nebula-crawler/config"
+	"github.com/dennis-tra/nebula-crawler/db"
+)
+
+var migrateConfig = &config.Migrate{
+	Root:   rootConfig,
+	DryRun: false,
+}
+
+// MigrateCommand contains the migrate sub-command configuration.
+var MigrateCommand = &cli.Command{
+	Name:  "migrate",
+	Usage: "Manages the schema migrations of the configured database engine",
+	Before: func(c *cli.Context) error {
+		// the global dry-run flag would not connect to a database at all.
+		// For this command it means that no migration is applied.
+		if migrateConfig.Root.Database.DryRun {
+			migrateConfig.DryRun = true
+			migrateConfig.Root.Database.DryRun = false
+		}
+
+		return nil
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:   "up",
+			Usage:  "Applies all pending migrations",
+			Flags:  []cli.Flag{migrateDryRunFlag()},
+			Action: MigrateUpAction,
+		},
+		{
+			Name:      "down",
+			Usage:     "Rolls back the given number of migrations",
+			ArgsUsage: "N",
+			Flags:     []cli.Flag{migrateDryRunFlag()},
+			Action:    MigrateDownAction,
+		},
+		{
+			Name:   "status",
+			Usage:  "Prints the current version and all available migrations",
+			Action: MigrateStatusAction,
+		},
+		{
+			Name:      "force",
+			Usage:     "Sets the migration version without running migrations and clears the dirty flag (pass `-- -1` for no version)",
+			ArgsUsage: "VERSION",
+			Action:    MigrateForceAction,
+		},
+		{
+			Name:      "goto",
+			Usage:     "Migrates up or down to the given version",
+			ArgsUsage: "VERSION",
+			Flags:     []cli.Flag{migrateDryRunFlag()},
+			Action:    MigrateGotoAction,
+		},
+	},
+}
+
+// migrateDryRunFlag returns a new dry-run flag for the sub-commands that
+// apply migrations.
+func migrateDryRunFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:        "dry-run",
+		Usage:       "Only print the SQL of the migrations that would be applied",
+		EnvVars:     []string{"NEBULA_MIGRATE_DRY_RUN"},
+		Value:       migrateConfig.DryRun,
+		Destination: &migrateConfig.DryRun,
+	}
+}
+
+// MigrateUpAction is the function that is called when running `nebula migrate up`.
+func MigrateUpAction(c *cli.Context) error {
+	if err := migrateArgs(c, 0); err != nil {
+		return err
+	}
+
+	return withMigrator(func(m *db.Migrator) error {
+		if migrateConfig.DryRun {
+			return printMigrationSteps(m.PlanUp())
+		}
+
+		return m.Up()
+	})
+}
+
+// MigrateDownAction is the function that is called when running `nebula migrate down N`.
+func MigrateDownAction(c *cli.Context) error {
+	if err := migrateArgs(c, 1); err != nil {
+		return err
+	}
+
+	n, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("parse number of migrations %q: %w", c.Args().First(), err)
+	}
+
+	return withMigrator(func(m *db.Migrator) error {
+		if migrateConfig.DryRun {
+			return printMigrationSteps(m.PlanDown(n))
+		}
+
+		return m.Down(n)
+	})
+}
+
+// MigrateStatusAction is the function that is called when running `nebula migrate status`.
+func MigrateStatusAction(c *cli.Context) error {
+	return withMigrator(func(m *db.Migrator) error {
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		if status.Version == nil {
+			log.Infoln("No migration applied")
+		} else {
+			log.WithField("dirty", status.Dirty).Infof("Version %d", *status.Version)
+		}
+
+		for _, migration := range status.Migrations {
+			mark := " "
+			if migration.Applied {
+				mark = "x"
+			}
+			log.Infof("[%s] %d %s", mark, migration.Version, migration.Identifier)
+		}
+
+		return nil
+	})
+}
+
+// MigrateForceAction is the function that is called when running `nebula migrate force VERSION`.
+func MigrateForceAction(c *cli.Context) error {
+	if err := migrateArgs(c, 1); err != nil {
+		return err
+	}
+
+	version, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("parse version %q: %w", c.Args().First(), err)
+	}
+
+	return withMigrator(func(m *db.Migrator) error {
+		return m.Force(version)
+	})
+}
+
+// MigrateGotoAction is the function that is called when running `nebula migrate goto VERSION`.
+func MigrateGotoAction(c *cli.Context) error {
+	if err := migrateArgs(c, 1); err != nil {
+		return err
+	}
+
+	version, err := strconv.ParseUint(c.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse version %q: %w", c.Args().First(), err)
+	}
+
+	return withMigrator(func(m *db.Migrator) error {
+		if migrateConfig.DryRun {
+			return printMigrationSteps(m.PlanGoto(uint(version)))
+		}
+
+		return m.Goto(uint(version))
+	})
+}
+
+// migrateArgs checks that the sub-command received the given number of
+// arguments. Flags after the arguments aren't parsed, so that, e.g., a
+// trailing --dry-run would otherwise be silently ignored.
+func migrateArgs(c *cli.Context, n int) error {
+	if c.NArg() != n {
+		return fmt.Errorf("expected %d argument(s) but got %d (flags must precede arguments): %v", n, c.NArg(), c.Args().Slice())
+	}
+	return nil
+}
+
+// withMigrator initializes a migrator for the configured database engine,
+// calls the given function with it, and closes it afterward.
+func withMigrator(fn func(m *db.Migrator) error) error {
+	m, err := migrateConfig.Root.Database.NewMigrator()
+	if err != nil {
+		return fmt.Errorf("init migrator: %w", err)
+	}
+	defer func() {
+		if err := m.Close(); err != nil {
+			log.WithError(err).Warnln("Failed closing migrator")
+		}
+	}()
+
+	return fn(m)
+}
+
+// printMigrationSteps prints the SQL of the given migrations to stdout.
+func printMigrationSteps(steps []*db.MigrationStep, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
+		log.Infoln("No migrations to apply")
+		return nil
+	}
+
+	for _, step := range steps {
+		direction := "down"
+		if step.Up {
+			direction = "up"
+		}
+		fmt.Printf("-- %d_%s.%s.sql\n%s\n\n", step.Version, step.Identifier, direction, step.SQL)
+	}
+
+	return nil
+}
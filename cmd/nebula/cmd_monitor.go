@@ -109,6 +109,21 @@ func MonitorAction(c *cli.Context) error {
 		AddrDialType:        config.AddrTypeAny,
 		TracerProvider:      monitorConfig.Root.TracerProvider,
 		MeterProvider:       monitorConfig.Root.MeterProvider,
+		Network:             monitorConfig.Network,
+	}
+
+	// if configured, stream all results to interested subscribers
+	if rootConfig.StreamAddr != "" {
+		srv, err := startStreamServer(rootConfig.StreamAddr)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := srv.Close(); err != nil {
+				log.WithError(err).Warnln("Failed closing stream server")
+			}
+		}()
+		engineCfg.Observers = append(engineCfg.Observers, srv)
 	}
 
 	switch monitorConfig.Network {
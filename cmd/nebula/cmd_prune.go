@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/dennis-tra/nebula-crawler/config"
+	"github.com/dennis-tra/nebula-crawler/db"
+)
+
+var pruneConfig = &config.Prune{
+	Root:              rootConfig,
+	VisitsRetention:   0,
+	SessionsRetention: 0,
+	PeerLogsRetention: 0,
+	NeighborsCrawls:   0,
+	ExportDir:         "",
+	DryRun:            false,
+}
+
+// PruneCommand contains the prune sub-command configuration.
+var PruneCommand = &cli.Command{
+	Name:   "prune",
+	Usage:  "Deletes data that exceeds the configured retention policies",
+	Action: PruneAction,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:        "visits",
+			Usage:       "How long to keep visits, e.g., 2160h for 90 days (0 keeps all visits)",
+			EnvVars:     []string{"NEBULA_PRUNE_VISITS"},
+			Value:       pruneConfig.VisitsRetention,
+			Destination: &pruneConfig.VisitsRetention,
+		},
+		&cli.DurationFlag{
+			Name:        "sessions",
+			Usage:       "How long to keep closed sessions (0 keeps all sessions)",
+			EnvVars:     []string{"NEBULA_PRUNE_SESSIONS"},
+			Value:       pruneConfig.SessionsRetention,
+			Destination: &pruneConfig.SessionsRetention,
+		},
+		&cli.DurationFlag{
+			Name:        "peer-logs",
+			Usage:       "How long to keep peer logs (0 keeps all peer logs, postgres only)",
+			EnvVars:     []string{"NEBULA_PRUNE_PEER_LOGS"},
+			Value:       pruneConfig.PeerLogsRetention,
+			Destination: &pruneConfig.PeerLogsRetention,
+		},
+		&cli.IntFlag{
+			Name:        "neighbors-crawls",
+			Usage:       "Keep the neighbors of this many most recent crawls (0 keeps all neighbors)",
+			EnvVars:     []string{"NEBULA_PRUNE_NEIGHBORS_CRAWLS"},
+			Value:       pruneConfig.NeighborsCrawls,
+			Destination: &pruneConfig.NeighborsCrawls,
+		},
+		&cli.StringFlag{
+			Name:        "export",
+			Usage:       "Export the data as gzip compressed newline delimited JSON files to this directory before deleting it",
+			EnvVars:     []string{"NEBULA_PRUNE_EXPORT"},
+			Value:       pruneConfig.ExportDir,
+			Destination: &pruneConfig.ExportDir,
+		},
+		&cli.BoolFlag{
+			Name:        "dry-run",
+			Usage:       "Only report what would be deleted",
+			EnvVars:     []string{"NEBULA_PRUNE_DRY_RUN"},
+			Value:       pruneConfig.DryRun,
+			Destination: &pruneConfig.DryRun,
+		},
+	},
+	Before: func(c *cli.Context) error {
+		// the global dry-run flag would not connect to a database at all.
+		// For this command it means that nothing is deleted.
+		if pruneConfig.Root.Database.DryRun {
+			pruneConfig.DryRun = true
+			pruneConfig.Root.Database.DryRun = false
+		}
+
+		return pruneConfig.PruneConfig().Validate()
+	},
+}
+
+// PruneAction is the function that is called when running `nebula prune`.
+func PruneAction(c *cli.Context) error {
+	log.Infoln("Starting Nebula pruner...")
+	defer log.Infoln("Stopped Nebula pruner.")
+
+	// Initialize the database client
+	dbc, err := pruneConfig.Root.Database.NewClient(c.Context)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := dbc.Close(); err != nil {
+			log.WithError(err).Warnln("Failed closing database handle")
+		}
+	}()
+
+	pruner, ok := dbc.(db.Pruner)
+	if !ok {
+		return fmt.Errorf("prune is only supported for the postgres and clickhouse database engines")
+	}
+
+	results, err := pruner.Prune(c.Context, pruneConfig.PruneConfig())
+	for _, result := range results {
+		log.WithFields(log.Fields{
+			"table":     result.Table,
+			"partition": result.Partition,
+			"rows":      result.Rows,
+			"export":    result.ExportFile,
+		}).Infoln("Pruned data")
+	}
+	if err != nil {
+		return fmt.Errorf("prune: %w", err)
+	}
+
+	log.WithField("count", len(results)).Infoln("Finished pruning")
+
+	return nil
+}
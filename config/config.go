@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -125,6 +127,9 @@ type Root struct {
 	// Port of the trace collector
 	TracesPort int
 
+	// The address on which peer processing results are streamed as Server-Sent Events (disabled if empty)
+	StreamAddr string
+
 	// Contains all configuration parameters for interacting with the database
 	Database *Database
 
@@ -215,6 +220,24 @@ type Database struct {
 	// File path to the JSON output directory
 	JSONOut string
 
+	// The compression of the JSON visits and neighbors files (none, gzip, zstd)
+	JSONCompression string
+
+	// The number of uncompressed bytes after which a new JSON file is started
+	JSONRotateSize int64
+
+	// The number of records after which a new JSON file is started
+	JSONRotateCount int
+
+	// File path to the Parquet output directory
+	ParquetOut string
+
+	// The maximum number of rows in a single Parquet row group
+	ParquetRowGroupSize int
+
+	// Whether to write the Parquet files of each crawl into a separate directory
+	ParquetPartition bool
+
 	// Determines the database engine to which the data should be written
 	DatabaseEngine string
 
@@ -233,6 +256,9 @@ type Database struct {
 	// Determines the username with which we access the database.
 	DatabaseUser string
 
+	// File path to the SQLite database file
+	DatabasePath string
+
 	// The database SSL configuration. For Postgres SSL mode should be
 	// one of the supported values here: https://www.postgresql.org/docs/current/libpq-ssl.html)
 	// For clickhouse only a yes or no value is supported
@@ -256,6 +282,17 @@ type Database struct {
 	// Whether to use the replicated merge tree engine variants for migrations
 	ClickHouseReplicatedTableEngines bool
 
+	// The maximum number of visits to hold in memory before inserting them
+	// into postgres in a single batch. Zero disables batching.
+	PostgresBatchSize int
+
+	// The maximum time to hold visits in memory before inserting them into postgres
+	PostgresBatchInterval time.Duration
+
+	// The maximum number of routing tables to hold in memory until the
+	// database IDs of all their peers are known
+	PostgresNeighborsBufferSize int
+
 	// The cache size to hold agent versions in memory to skip database queries.
 	AgentVersionsCacheSize int
 
@@ -300,6 +337,10 @@ func (cfg *Database) PostgresClientConfig() *db.PostgresClientConfig {
 		ProtocolsSetCacheSize:  cfg.ProtocolsSetCacheSize,
 		MaxIdleConns:           cfg.MaxIdleConns,
 		PersistNeighbors:       cfg.PersistNeighbors,
+		BatchSize:              cfg.PostgresBatchSize,
+		BatchTimeout:           cfg.PostgresBatchInterval,
+		NeighborsBufferSize:    cfg.PostgresNeighborsBufferSize,
+		NetworkID:              cfg.NetworkID,
 		MeterProvider:          cfg.MeterProvider,
 		TracerProvider:         cfg.TracerProvider,
 	}
@@ -336,36 +377,67 @@ func (cfg *Database) ClickHouseClientConfig() *db.ClickHouseClientConfig {
 	}
 }
 
+func (cfg *Database) SQLiteClientConfig() *db.SQLiteClientConfig {
+	if cfg.DatabasePath == "" {
+		cfg.DatabasePath = "nebula.db"
+	}
+
+	return &db.SQLiteClientConfig{
+		DatabasePath:     cfg.DatabasePath,
+		ApplyMigrations:  cfg.ApplyMigrations,
+		NetworkID:        cfg.NetworkID,
+		PersistNeighbors: cfg.PersistNeighbors,
+	}
+}
+
+func (cfg *Database) JSONClientConfig() *db.JSONClientConfig {
+	return &db.JSONClientConfig{
+		Out:         cfg.JSONOut,
+		Compression: cfg.JSONCompression,
+		RotateSize:  cfg.JSONRotateSize,
+		RotateCount: cfg.JSONRotateCount,
+	}
+}
+
+func (cfg *Database) ParquetClientConfig() *db.ParquetClientConfig {
+	return &db.ParquetClientConfig{
+		Out:              cfg.ParquetOut,
+		RowGroupSize:     cfg.ParquetRowGroupSize,
+		PartitionByCrawl: cfg.ParquetPartition,
+	}
+}
+
 // NewClient will initialize the right database client based on the given
-// configuration. This can either be a Postgres, ClickHouse, JSON, or noop
+// configuration. This can either be a Postgres, ClickHouse, SQLite, JSON, Parquet, or noop
 // client. The noop client is a dummy implementation of the [Client] interface
 // that does nothing when the methods are called. That's the one used if the
 // user specifies `--dry-run` on the command line. The JSON client is used when
 // the user specifies a JSON output directory. Then JSON files with crawl
-// information are written to that directory. In any other case, the Postgres
-// or ClickHouse client is used based on the configured database engine.
+// information are written to that directory. The same applies to the Parquet
+// client and a Parquet output directory. In any other case, the Postgres,
+// ClickHouse, or SQLite client is used based on the configured database engine.
+// If multiple comma separated engines are configured, a [db.FanOutClient]
+// writes to all of them.
 func (cfg *Database) NewClient(ctx context.Context) (db.Client, error) {
 	var (
 		dbc db.Client
 		err error
 	)
 
-	// dry run has precedence. Then, if a JSON output directory is given, use
-	// the JSON client. In any other case, use the one configured via the engine
-	// command line flag client.
+	// dry run has precedence. Then, if multiple database engines are
+	// configured, use a client that writes to all of them. Then, if a JSON or
+	// Parquet output directory is given, use the respective client. In any
+	// other case, use the one configured via the engine command line flag.
 	if cfg.DryRun {
 		dbc = db.NewNoopClient()
+	} else if engines := cfg.DatabaseEngines(); len(engines) > 1 {
+		dbc, err = cfg.newFanOutClient(ctx, engines)
 	} else if cfg.JSONOut != "" {
-		dbc, err = db.NewJSONClient(cfg.JSONOut)
+		dbc, err = db.NewJSONClient(cfg.JSONClientConfig())
+	} else if cfg.ParquetOut != "" {
+		dbc, err = db.NewParquetClient(cfg.ParquetClientConfig())
 	} else {
-		switch strings.ToLower(cfg.DatabaseEngine) {
-		case "postgres", "pg":
-			dbc, err = db.NewPostgresClient(ctx, cfg.PostgresClientConfig())
-		case "clickhouse", "ch":
-			dbc, err = db.NewClickHouseClient(ctx, cfg.ClickHouseClientConfig())
-		default:
-			return nil, fmt.Errorf("unknown database engine: %s", cfg.DatabaseEngine)
-		}
+		dbc, err = cfg.newEngineClient(ctx, cfg.DatabaseEngine)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("init db client: %w", err)
@@ -374,6 +446,137 @@ func (cfg *Database) NewClient(ctx context.Context) (db.Client, error) {
 	return dbc, nil
 }
 
+// DatabaseEngines returns the list of configured database engines. Multiple
+// engines are separated by commas.
+func (cfg *Database) DatabaseEngines() []string {
+	var engines []string
+	for _, engine := range strings.Split(cfg.DatabaseEngine, ",") {
+		if engine = strings.TrimSpace(engine); engine != "" {
+			engines = append(engines, engine)
+		}
+	}
+	return engines
+}
+
+// newEngineClient initializes the client for the given database engine.
+// Besides the database engines, "json" and "parquet" are accepted to combine
+// them with other engines. They write to the configured output directories.
+func (cfg *Database) newEngineClient(ctx context.Context, engine string) (db.Client, error) {
+	switch strings.ToLower(engine) {
+	case "postgres", "pg":
+		return db.NewPostgresClient(ctx, cfg.PostgresClientConfig())
+	case "clickhouse", "ch":
+		return db.NewClickHouseClient(ctx, cfg.ClickHouseClientConfig())
+	case "sqlite", "sqlite3":
+		return db.NewSQLiteClient(ctx, cfg.SQLiteClientConfig())
+	case "json":
+		if cfg.JSONOut == "" {
+			return nil, fmt.Errorf("json engine requires a JSON output directory")
+		}
+		return db.NewJSONClient(cfg.JSONClientConfig())
+	case "parquet":
+		if cfg.ParquetOut == "" {
+			return nil, fmt.Errorf("parquet engine requires a Parquet output directory")
+		}
+		return db.NewParquetClient(cfg.ParquetClientConfig())
+	default:
+		return nil, fmt.Errorf("unknown database engine: %s", engine)
+	}
+}
+
+// NewMigrator initializes a migrator for the migrations of the configured
+// database engine.
+func (cfg *Database) NewMigrator() (*db.Migrator, error) {
+	engines := cfg.DatabaseEngines()
+	if len(engines) != 1 {
+		return nil, fmt.Errorf("migrations require exactly one database engine, got %d", len(engines))
+	}
+
+	switch strings.ToLower(engines[0]) {
+	case "postgres", "pg":
+		return db.NewPostgresMigrator(cfg.PostgresClientConfig())
+	case "clickhouse", "ch":
+		return db.NewClickHouseMigrator(cfg.ClickHouseClientConfig())
+	case "sqlite", "sqlite3":
+		return db.NewSQLiteMigrator(cfg.SQLiteClientConfig())
+	default:
+		return nil, fmt.Errorf("database engine %s has no migrations", engines[0])
+	}
+}
+
+// newFanOutClient initializes a client for each of the given engines and
+// returns a client that writes to all of them. All database engines use the
+// same connection settings, except for the default ports.
+func (cfg *Database) newFanOutClient(ctx context.Context, engines []string) (db.Client, error) {
+	clients := make([]db.Client, 0, len(engines))
+	for _, engine := range engines {
+		// the client configs set engine specific defaults
+		engineCfg := *cfg
+
+		dbc, err := engineCfg.newEngineClient(ctx, engine)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("init %s client: %w", engine, err)
+		}
+		clients = append(clients, dbc)
+	}
+
+	return db.NewFanOutClient(engines, clients)
+}
+
+// NewClients initializes one database client for each of the given networks.
+// If the database client supports it (see [db.Forker]), all clients share
+// the same database connection. JSON and Parquet clients write into a
+// separate subdirectory for each network. Postgres doesn't store the network
+// of a crawl and is therefore rejected (see [db.SeparatesNetworks]).
+func (cfg *Database) NewClients(ctx context.Context, networks []string) ([]db.Client, error) {
+	clients := make([]db.Client, 0, len(networks))
+	for i, network := range networks {
+		if i == 1 && !db.SeparatesNetworks(clients[0]) {
+			closeClients(clients)
+			return nil, fmt.Errorf("postgres doesn't support crawling multiple networks")
+		}
+
+		if i > 0 {
+			if forker, ok := clients[0].(db.Forker); ok {
+				dbc, err := forker.Fork(network)
+				if err != nil {
+					closeClients(clients)
+					return nil, fmt.Errorf("fork db client for %s: %w", network, err)
+				}
+				clients = append(clients, dbc)
+				continue
+			}
+		}
+
+		netCfg := *cfg
+		netCfg.NetworkID = network
+		if netCfg.JSONOut != "" {
+			netCfg.JSONOut = filepath.Join(cfg.JSONOut, strings.ToLower(network))
+		}
+		if netCfg.ParquetOut != "" {
+			netCfg.ParquetOut = filepath.Join(cfg.ParquetOut, strings.ToLower(network))
+		}
+
+		dbc, err := netCfg.NewClient(ctx)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("new db client for %s: %w", network, err)
+		}
+		clients = append(clients, dbc)
+	}
+
+	return clients, nil
+}
+
+// closeClients closes all given clients and ignores any errors. It's used to
+// clean up after initializing a set of clients has failed.
+func closeClients(clients []db.Client) {
+	for _, dbc := range clients {
+		_ = dbc.Close()
+	}
+}
+
 // Crawl contains general user configuration.
 type Crawl struct {
 	Root *Root
@@ -399,7 +602,8 @@ type Crawl struct {
 	// File path to the udger datbase
 	FilePathUdgerDB string
 
-	// The network to crawl
+	// The network to crawl. This can be a comma-separated list of networks
+	// that are crawled concurrently.
 	Network string
 
 	// Which type of addresses should Nebula try to dial (private, public, both)
@@ -422,6 +626,88 @@ type Crawl struct {
 
 	// EnabledGossipSub defines whether to activate gossipsub PX crawling
 	EnableGossipSubPX bool
+
+	// File path to which the crawl engine periodically writes its state
+	CheckpointPath string
+
+	// How often the crawl engine should write its state to disk
+	CheckpointInterval time.Duration
+
+	// File path to a checkpoint from which an interrupted crawl should be resumed
+	ResumePath string
+
+	// The maximum number of peers in the same IP prefix that are crawled concurrently
+	DialLimitPerPrefix int
+
+	// The prefix length by which IPv4 addresses are grouped for DialLimitPerPrefix
+	DialPrefixLenV4 int
+
+	// The prefix length by which IPv6 addresses are grouped for DialLimitPerPrefix
+	DialPrefixLenV6 int
+
+	// The maximum number of peers in the same autonomous system that are crawled concurrently
+	DialLimitPerASN int
+
+	// File path to the Maxmind ASN database that's used for DialLimitPerASN
+	FilePathMaxmindASN string
+
+	// Whether to adapt the number of concurrently active crawl workers
+	AdaptiveWorkers bool
+
+	// The minimum number of active crawl workers in adaptive mode
+	MinWorkerCount int
+
+	// The maximum number of active crawl workers in adaptive mode (0 means CrawlWorkerCount)
+	MaxWorkerCount int
+
+	// The address on which the coordinator of a distributed crawl listens for workers
+	ShardCoordinatorListen string
+
+	// The number of workers that take part in a distributed crawl
+	ShardCount int
+
+	// The URL of the coordinator of a distributed crawl that this worker should register with
+	ShardCoordinatorURL string
+
+	// The address on which a worker of a distributed crawl listens for forwarded peers
+	ShardListen string
+
+	// The URL under which other participants of a distributed crawl can reach this worker
+	ShardAdvertiseURL string
+
+	// How often forwarded peers are sent to other workers and the coordinator polls the workers' status
+	ShardInterval time.Duration
+
+	// How long a worker of a distributed crawl may be unresponsive before the coordinator fails the crawl
+	ShardTimeout time.Duration
+
+	// The maximum number of crawl results that wait to be written before no new peers are crawled (0 means no limit)
+	WriteQueueLimit int
+
+	// The directory to which visits are spilled that couldn't be written to the database
+	SpillDir string
+
+	// How often spilled visits are replayed to the database
+	SpillReplayInterval time.Duration
+
+	// How often peers that failed with transient errors are crawled again at the end of the crawl (0 disables retrying)
+	RetryPasses int
+
+	// The dial timeout for retried peers (0 means the regular dial timeout)
+	RetryDialTimeout time.Duration
+}
+
+// Networks returns the list of networks that should be crawled.
+func (c *Crawl) Networks() []string {
+	var networks []string
+	for _, network := range strings.Split(c.Network, ",") {
+		network = strings.TrimSpace(network)
+		if network == "" || slices.Contains(networks, network) {
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
 }
 
 func (c *Crawl) AddrDialType() AddrType {
@@ -731,3 +1017,110 @@ type Resolve struct {
 	FilePathMaxmindCountry string
 	FilePathMaxmindASN     string
 }
+
+type Prune struct {
+	Root *Root
+
+	// How long to keep visits
+	VisitsRetention time.Duration
+
+	// How long to keep sessions after they were closed
+	SessionsRetention time.Duration
+
+	// How long to keep peer logs
+	PeerLogsRetention time.Duration
+
+	// Keep the neighbors of this many most recent crawls
+	NeighborsCrawls int
+
+	// The directory to which data should be exported before it's deleted
+	ExportDir string
+
+	// Only report what would be deleted
+	DryRun bool
+}
+
+// PruneConfig returns the configuration of the retention policies.
+func (p *Prune) PruneConfig() *db.PruneConfig {
+	return &db.PruneConfig{
+		VisitsRetention:   p.VisitsRetention,
+		SessionsRetention: p.SessionsRetention,
+		PeerLogsRetention: p.PeerLogsRetention,
+		NeighborsCrawls:   p.NeighborsCrawls,
+		ExportDir:         p.ExportDir,
+		DryRun:            p.DryRun,
+	}
+}
+
+// String prints the configuration as a json string
+func (p *Prune) String() string {
+	data, _ := json.MarshalIndent(p, "", "  ")
+	return string(data)
+}
+
+type Migrate struct {
+	Root *Root
+
+	// Only print the SQL of the migrations that would be applied
+	DryRun bool
+}
+
+// String prints the configuration as a json string
+func (m *Migrate) String() string {
+	data, _ := json.MarshalIndent(m, "", "  ")
+	return string(data)
+}
+
+type Diff struct {
+	Root *Root
+
+	// The two crawls to compare
+	Crawls *cli.StringSlice
+
+	// The file to which the diff should be written as JSON ("-" for stdout)
+	Out string
+
+	// The number of entries to show per category in the summary
+	Top int
+}
+
+// String prints the configuration as a json string
+func (d *Diff) String() string {
+	data, _ := json.MarshalIndent(d, "", "  ")
+	return string(data)
+}
+
+type Graph struct {
+	Root *Root
+
+	// The crawl whose routing tables should be exported
+	CrawlID string
+
+	// The file format of the graph
+	Format string
+
+	// The file to which the graph should be written ("-" for stdout)
+	Out string
+}
+
+// String prints the configuration as a json string
+func (g *Graph) String() string {
+	data, _ := json.MarshalIndent(g, "", "  ")
+	return string(data)
+}
+
+type Import struct {
+	Root *Root
+
+	// The network in which the imported crawls were performed
+	Network string
+
+	// Whether to import the routing tables of the crawled peers
+	PersistNeighbors bool
+}
+
+// String prints the configuration as a json string
+func (i *Import) String() string {
+	data, _ := json.MarshalIndent(i, "", "  ")
+	return string(data)
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+// CheckpointConfig configures the periodic snapshots of the engine state.
+type CheckpointConfig struct {
+	// Path is the file path the checkpoint is written to. Each snapshot
+	// overwrites the previous one.
+	Path string
+
+	// Interval determines how often the engine writes a snapshot of its
+	// internal state to disk.
+	Interval time.Duration
+
+	// CrawlID is the database identifier of the crawl that the engine is
+	// working on. It is stored alongside the engine state, so that a resumed
+	// crawl can continue writing its results to the same crawl.
+	CrawlID string
+
+	// Network is the network that is being crawled. When resuming from a
+	// checkpoint, the network must match.
+	Network string
+}
+
+// Checkpoint is a snapshot of the internal [Engine] state. It contains
+// everything that's necessary to continue an interrupted crawl. Because the
+// engine is generic on the [PeerInfo] type, the queued peers are stored in
+// their most basic form (peer ID + multi addresses). The drivers can
+// construct their specific peer info types from that information the same
+// way they do for bootstrap peers.
+type Checkpoint struct {
+	// CrawlID is the database identifier of the interrupted crawl.
+	CrawlID string
+
+	// Network is the network that was crawled.
+	Network string
+
+	// CreatedAt is the time when this checkpoint was taken.
+	CreatedAt time.Time
+
+	// Queued contains all peers that were queued or inflight at the time of
+	// the checkpoint. Inflight peers haven't produced a result yet, so they
+	// will be processed again. The tasks that the driver has emitted are
+	// moved into the peer queue immediately, so they are included here too.
+	Queued []CheckpointPeer
+
+	// Processed contains the deduplication keys of all peers that were
+	// already processed.
+	Processed []string
+
+	// Summary is the aggregate crawl information up until the checkpoint.
+	Summary *Summary
+}
+
+// CheckpointPeer is the serializable representation of a queued peer. The
+// ID holds the raw peer ID bytes because not all networks use valid libp2p
+// peer IDs (e.g., bitcoin) which would fail to decode.
+type CheckpointPeer struct {
+	ID    []byte
+	Addrs []string
+}
+
+// AddrInfo returns the peer information in the [peer.AddrInfo] format.
+// Multi addresses that cannot be parsed are skipped.
+func (p CheckpointPeer) AddrInfo() peer.AddrInfo {
+	maddrs := make([]ma.Multiaddr, 0, len(p.Addrs))
+	for _, addr := range p.Addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			continue
+		}
+		maddrs = append(maddrs, maddr)
+	}
+
+	return peer.AddrInfo{
+		ID:    peer.ID(p.ID),
+		Addrs: maddrs,
+	}
+}
+
+// AddrInfos returns the queued peers in the [peer.AddrInfo] format.
+func (c *Checkpoint) AddrInfos() []peer.AddrInfo {
+	addrInfos := make([]peer.AddrInfo, len(c.Queued))
+	for i, p := range c.Queued {
+		addrInfos[i] = p.AddrInfo()
+	}
+	return addrInfos
+}
+
+// LoadCheckpoint reads and decodes the checkpoint at the given path.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoint file: %w", err)
+	}
+
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("unmarshal checkpoint: %w", err)
+	}
+
+	return cp, nil
+}
+
+// Save writes the checkpoint to the given path. It first writes the data to
+// a temporary file in the same directory and then renames it to the final
+// path. This prevents a corrupt checkpoint if the process dies while writing.
+func (c *Checkpoint) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary checkpoint file: %w", err)
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("close temporary checkpoint file: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("rename checkpoint file: %w", err)
+	}
+
+	return nil
+}
+
+// Merge adds the aggregate information of the other summary to this summary
+// and returns the result. This is used to combine the summary of a resumed
+// crawl with the one of the interrupted crawl. The number of remaining peers
+// is taken from the receiver.
+func (s *Summary) Merge(other *Summary) *Summary {
+	if other == nil {
+		return s
+	}
+
+	mergeMaps := func(a, b map[string]int) map[string]int {
+		out := make(map[string]int, len(a))
+		for k, v := range a {
+			out[k] += v
+		}
+		for k, v := range b {
+			out[k] += v
+		}
+		return out
+	}
+
+	return &Summary{
+		PeersCrawled:    s.PeersCrawled + other.PeersCrawled,
+		PeersDialable:   s.PeersDialable + other.PeersDialable,
+		PeersUndialable: s.PeersUndialable + other.PeersUndialable,
+		PeersRemaining:  s.PeersRemaining,
+		AgentVersion:    mergeMaps(s.AgentVersion, other.AgentVersion),
+		Protocols:       mergeMaps(s.Protocols, other.Protocols),
+		ConnErrs:        mergeMaps(s.ConnErrs, other.ConnErrs),
+		CrawlErrs:       mergeMaps(s.CrawlErrs, other.CrawlErrs),
+		NetworkSize:     s.NetworkSize.Merge(other.NetworkSize),
+
+		PeersRetried:        s.PeersRetried + other.PeersRetried,
+		PeersRetrySucceeded: s.PeersRetrySucceeded + other.PeersRetrySucceeded,
+	}
+}
+
+// checkpoint takes a snapshot of the internal engine state.
+func (e *Engine[I, R]) checkpoint() *Checkpoint {
+	queued := make([]CheckpointPeer, 0, e.queuedCount()+len(e.inflight)+e.writeQueue.Len())
+	for _, task := range e.peerQueue.All() {
+		queued = append(queued, CheckpointPeer{
+			ID:    []byte(task.ID()),
+			Addrs: utils.MaddrsToAddrs(task.Addrs()),
+		})
+	}
+
+	for _, tasks := range e.deferred {
+		for _, task := range tasks {
+			queued = append(queued, CheckpointPeer{
+				ID:    []byte(task.ID()),
+				Addrs: utils.MaddrsToAddrs(task.Addrs()),
+			})
+		}
+	}
+
+	for _, task := range e.inflight {
+		queued = append(queued, CheckpointPeer{
+			ID:    []byte(task.ID()),
+			Addrs: utils.MaddrsToAddrs(task.Addrs()),
+		})
+	}
+
+	// peers whose results haven't been written yet are considered
+	// unprocessed. Otherwise, we would lose their results when resuming.
+	unwritten := e.writeQueue.All()
+	for _, result := range unwritten {
+		task := result.PeerInfo()
+		queued = append(queued, CheckpointPeer{
+			ID:    []byte(task.ID()),
+			Addrs: utils.MaddrsToAddrs(task.Addrs()),
+		})
+	}
+
+	processed := make([]string, 0, len(e.processed))
+	for key := range e.processed {
+		if _, found := e.writeQueue.Find(key); found {
+			continue
+		}
+		processed = append(processed, key)
+	}
+
+	cp := &Checkpoint{
+		CreatedAt: time.Now(),
+		Queued:    queued,
+		Processed: processed,
+		Summary:   e.checkpointSummary(slices.Collect(maps.Values(unwritten))),
+	}
+
+	if e.cfg.Checkpoint != nil {
+		cp.CrawlID = e.cfg.Checkpoint.CrawlID
+		cp.Network = e.cfg.Checkpoint.Network
+	}
+
+	return cp
+}
+
+// checkpointSummary returns the summary without the given unwritten results.
+// Their peers are processed again after resuming and would be counted twice
+// otherwise. Handlers that can't forget results don't count any results (see
+// [ForgetHandler]).
+func (e *Engine[I, R]) checkpointSummary(unwritten []R) *Summary {
+	fh, ok := e.handler.(ForgetHandler[I, R])
+	if !ok {
+		return e.summary()
+	}
+
+	summary := fh.SummaryWithout(&EngineState{
+		PeersQueued: e.queuedCount(),
+	}, unwritten)
+
+	if e.cfg.Resume != nil {
+		summary = summary.Merge(e.cfg.Resume.Summary)
+	}
+
+	return summary
+}
+
+// writeCheckpoint takes a snapshot of the engine state and writes it to
+// the configured checkpoint path.
+func (e *Engine[I, R]) writeCheckpoint() error {
+	if e.cfg.Checkpoint == nil || e.cfg.Checkpoint.Path == "" {
+		return nil
+	}
+
+	return e.checkpoint().Save(e.cfg.Checkpoint.Path)
+}
+
+// removeCheckpoint deletes the checkpoint file if one is configured.
+func (e *Engine[I, R]) removeCheckpoint() {
+	if e.cfg.Checkpoint == nil || e.cfg.Checkpoint.Path == "" {
+		return
+	}
+
+	if err := os.Remove(e.cfg.Checkpoint.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).Warnln("Failed removing checkpoint")
+	}
+}
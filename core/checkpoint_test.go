@@ -0,0 +1,276 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	lp2ptest "github.com/libp2p/go-libp2p/core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+func TestCheckpoint_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	peerID, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	cp := &Checkpoint{
+		CrawlID:   "1234",
+		Network:   "IPFS",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Queued: []CheckpointPeer{
+			{ID: []byte(peerID), Addrs: []string{"/ip4/127.0.0.1/tcp/3000"}},
+		},
+		Processed: []string{"processed"},
+		Summary: &Summary{
+			PeersCrawled: 1,
+			AgentVersion: map[string]int{"kubo": 1},
+		},
+	}
+
+	require.NoError(t, cp.Save(path))
+
+	loaded, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	assert.Equal(t, cp, loaded)
+
+	addrInfos := loaded.AddrInfos()
+	require.Len(t, addrInfos, 1)
+	assert.Equal(t, peerID, addrInfos[0].ID)
+	require.Len(t, addrInfos[0].Addrs, 1)
+	assert.Equal(t, "/ip4/127.0.0.1/tcp/3000", addrInfos[0].Addrs[0].String())
+}
+
+func TestSummary_Merge(t *testing.T) {
+	a := &Summary{
+		PeersCrawled:    2,
+		PeersDialable:   1,
+		PeersUndialable: 1,
+		PeersRemaining:  5,
+		AgentVersion:    map[string]int{"kubo": 1},
+		ConnErrs:        map[string]int{"io_timeout": 1},
+	}
+	b := &Summary{
+		PeersCrawled:    3,
+		PeersDialable:   3,
+		PeersUndialable: 0,
+		PeersRemaining:  10,
+		AgentVersion:    map[string]int{"kubo": 2, "other": 1},
+	}
+
+	merged := a.Merge(b)
+	assert.Equal(t, 5, merged.PeersCrawled)
+	assert.Equal(t, 4, merged.PeersDialable)
+	assert.Equal(t, 1, merged.PeersUndialable)
+	assert.Equal(t, 5, merged.PeersRemaining)
+	assert.Equal(t, map[string]int{"kubo": 3, "other": 1}, merged.AgentVersion)
+	assert.Equal(t, map[string]int{"io_timeout": 1}, merged.ConnErrs)
+
+	assert.Equal(t, a, a.Merge(nil))
+}
+
+func TestNewEngine_Run_resume(t *testing.T) {
+	defer goleak.VerifyNone(t, goleakIgnore...)
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancel := context.WithCancel(context.Background()) // cancelCtx to satisfy mock.IsType below
+	defer cancel()
+
+	processedPeer := &testPeerInfo{
+		peerID: peer.ID("processed"),
+		addrs:  []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/127.0.0.1/tcp/3000")},
+	}
+	queuedPeer := &testPeerInfo{
+		peerID: peer.ID("queued"),
+		addrs:  []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/127.0.0.1/tcp/3001")},
+	}
+
+	tasksChan := make(chan *testPeerInfo, 1)
+	tasksChan <- queuedPeer
+	close(tasksChan)
+
+	queuedPeerCrawlResult := CrawlResult[*testPeerInfo]{
+		CrawlerID: "1",
+		Info:      queuedPeer,
+		RoutingTable: &RoutingTable[*testPeerInfo]{
+			PeerID:    queuedPeer.peerID,
+			Neighbors: []*testPeerInfo{processedPeer},
+		},
+	}
+
+	// the processed peer must not be crawled again
+	crawler := newTestCrawler()
+	crawler.On("Work", mock.IsType(ctx), queuedPeer).
+		Return(queuedPeerCrawlResult, nil).Times(1)
+
+	writer := newTestWriter()
+	writer.On("Work", mock.Anything, mock.IsType(queuedPeerCrawlResult)).Return(WriteResult{}, nil)
+
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(crawler, nil)
+	driver.On("NewWriter").Return(writer, nil)
+	driver.On("Close").Times(1)
+	driver.On("Tasks").Return((<-chan *testPeerInfo)(tasksChan))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	resume := &Checkpoint{
+		Processed: []string{processedPeer.DeduplicationKey()},
+		Summary: &Summary{
+			PeersCrawled:  1,
+			PeersDialable: 1,
+		},
+	}
+	require.NoError(t, resume.Save(path))
+
+	cfg := DefaultEngineConfig()
+	cfg.WorkerCount = 1
+	cfg.Resume = resume
+	cfg.Checkpoint = &CheckpointConfig{
+		Path:     path,
+		Interval: time.Minute,
+	}
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	require.NoError(t, err)
+
+	summary, err := eng.Run(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, summary.PeersCrawled)
+	assert.Equal(t, 2, summary.PeersDialable)
+	crawler.AssertExpectations(t)
+
+	// the crawl has finished, so the checkpoint should have been removed
+	assert.NoFileExists(t, path)
+}
+
+func TestEngine_checkpoint(t *testing.T) {
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(newTestCrawler(), nil)
+	driver.On("NewWriter").Return(newTestWriter(), nil)
+	driver.On("Tasks").Return(make(<-chan *testPeerInfo))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	cfg := DefaultEngineConfig()
+	cfg.Checkpoint = &CheckpointConfig{
+		Path:     filepath.Join(t.TempDir(), "checkpoint.json"),
+		Interval: time.Minute,
+		CrawlID:  "1234",
+		Network:  "IPFS",
+	}
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	require.NoError(t, err)
+
+	queued := &testPeerInfo{peerID: peer.ID("queued")}
+	inflight := &testPeerInfo{peerID: peer.ID("inflight")}
+	unwritten := &testPeerInfo{peerID: peer.ID("unwritten")}
+
+	eng.peerQueue.Push(queued.DeduplicationKey(), queued, 1)
+	eng.inflight[inflight.DeduplicationKey()] = inflight
+	eng.processed["written"] = struct{}{}
+	eng.processed[unwritten.DeduplicationKey()] = struct{}{}
+	eng.writeQueue.Push(unwritten.DeduplicationKey(), CrawlResult[*testPeerInfo]{Info: unwritten, Agent: "unwritten"}, 0)
+
+	handler.HandlePeerResult(context.Background(), Result[CrawlResult[*testPeerInfo]]{Value: CrawlResult[*testPeerInfo]{Info: &testPeerInfo{peerID: "written"}, Agent: "written"}})
+	handler.HandlePeerResult(context.Background(), Result[CrawlResult[*testPeerInfo]]{Value: CrawlResult[*testPeerInfo]{Info: unwritten, Agent: "unwritten"}})
+
+	cp := eng.checkpoint()
+	assert.Equal(t, "1234", cp.CrawlID)
+	assert.Equal(t, "IPFS", cp.Network)
+	assert.Equal(t, []string{"written"}, cp.Processed)
+
+	queuedIDs := make([]peer.ID, len(cp.Queued))
+	for i, p := range cp.Queued {
+		queuedIDs[i] = p.AddrInfo().ID
+	}
+	assert.ElementsMatch(t, []peer.ID{"queued", "inflight", "unwritten"}, queuedIDs)
+
+	// the unwritten peer is crawled again after resuming and must not be
+	// counted twice
+	assert.Equal(t, 1, cp.Summary.PeersCrawled)
+	assert.Equal(t, map[string]int{"written": 1}, cp.Summary.AgentVersion)
+
+	// the handler itself still counts both peers
+	assert.Equal(t, 2, handler.CrawledPeers)
+}
+
+func TestNewEngine_Run_cancel_during_write(t *testing.T) {
+	defer goleak.VerifyNone(t, goleakIgnore...)
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testPeer := &testPeerInfo{
+		peerID: peer.ID("test-peer"),
+		addrs:  []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/127.0.0.1/tcp/3000")},
+	}
+
+	// keep the tasks channel open so that the engine only stops because
+	// of the cancellation below.
+	tasksChan := make(chan *testPeerInfo, 1)
+	tasksChan <- testPeer
+
+	cr := CrawlResult[*testPeerInfo]{
+		CrawlerID:    "1",
+		Info:         testPeer,
+		RoutingTable: &RoutingTable[*testPeerInfo]{PeerID: testPeer.peerID},
+	}
+
+	crawler := newTestCrawler()
+	crawler.On("Work", mock.Anything, testPeer).Return(cr, nil)
+
+	// the engine gets cancelled while the result is being written. The
+	// write must still be performed.
+	var writeErr error
+	writer := newTestWriter()
+	writer.On("Work", mock.Anything, mock.IsType(cr)).
+		Run(func(args mock.Arguments) {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+			writeErr = args.Get(0).(context.Context).Err()
+		}).
+		Return(WriteResult{}, nil)
+
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(crawler, nil)
+	driver.On("NewWriter").Return(writer, nil)
+	driver.On("Close").Times(1)
+	driver.On("Tasks").Return((<-chan *testPeerInfo)(tasksChan))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	cfg := DefaultEngineConfig()
+	cfg.WorkerCount = 1
+	cfg.WriterCount = 1
+	cfg.Checkpoint = &CheckpointConfig{
+		Path:     path,
+		Interval: time.Minute,
+	}
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	require.NoError(t, err)
+
+	_, err = eng.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoError(t, writeErr)
+	writer.AssertNumberOfCalls(t, "Work", 1)
+
+	cp, err := LoadCheckpoint(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testPeer.DeduplicationKey()}, cp.Processed)
+	assert.Empty(t, cp.Queued)
+	assert.Equal(t, 1, cp.Summary.PeersCrawled)
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+// ConnectStats can optionally be implemented by a [WorkResult] to inform the
+// adaptive concurrency controller about how connecting to the peer went.
+type ConnectStats interface {
+	// ConnectErrorClass returns the known error class of the connection
+	// error (see [db.NetError]) or an empty string if no error occurred.
+	ConnectErrorClass() string
+
+	// ConnectDuration returns the time it took to connect to the peer.
+	ConnectDuration() time.Duration
+}
+
+// pressureErrors are the connection error classes that indicate that our own
+// host is overloaded rather than the remote peer being unreachable.
+var pressureErrors = map[string]struct{}{
+	pgmodels.NetErrorCantAssignRequestedAddress: {},
+	pgmodels.NetErrorResourceLimitExceeded:      {},
+}
+
+// ConcurrencyConfig configures the adaptive worker concurrency. The maximum
+// number of concurrent workers is [EngineConfig.WorkerCount]. The engine
+// adjusts the number of active workers in an additive-increase,
+// multiplicative-decrease fashion: as long as all active workers are busy and
+// we don't observe signs of overload, the number of active workers grows by
+// IncreaseStep every Interval. If we observe signs of overload, the number of
+// active workers is multiplied by DecreaseFactor. Signs of overload are:
+//
+//  1. the fraction of connection errors that indicate local resource
+//     exhaustion (e.g., cant_assign_requested_address) exceeds ErrorThreshold
+//  2. the mean connection latency exceeds LatencyTolerance times the lowest
+//     mean latency that we have observed so far
+//  3. the fraction of open file descriptors of the soft limit exceeds
+//     FDThreshold
+type ConcurrencyConfig struct {
+	// the minimum number of active workers. The engine starts with this
+	// number of active workers.
+	MinWorkers int
+
+	// how often the number of active workers is adjusted.
+	Interval time.Duration
+
+	// the number of workers to add if we don't observe signs of overload.
+	IncreaseStep int
+
+	// the factor by which the number of active workers is multiplied if we
+	// observe signs of overload.
+	DecreaseFactor float64
+
+	// the fraction of results with resource exhaustion errors above which we
+	// decrease the number of active workers.
+	ErrorThreshold float64
+
+	// the factor by which the mean connection latency may exceed the lowest
+	// observed mean connection latency before we decrease the number of
+	// active workers. 0 disables the latency signal.
+	LatencyTolerance float64
+
+	// the fraction of open file descriptors of the soft limit above which we
+	// decrease the number of active workers. 0 disables the signal.
+	FDThreshold float64
+}
+
+// DefaultConcurrencyConfig returns the default configuration for the
+// adaptive worker concurrency.
+func DefaultConcurrencyConfig() *ConcurrencyConfig {
+	return &ConcurrencyConfig{
+		MinWorkers:       10,
+		Interval:         time.Second,
+		IncreaseStep:     20,
+		DecreaseFactor:   0.75,
+		ErrorThreshold:   0.05,
+		LatencyTolerance: 3,
+		FDThreshold:      0.8,
+	}
+}
+
+// Validate verifies the concurrency configuration's invariants. The maximum
+// number of workers is passed in because it's part of the [EngineConfig].
+func (cfg *ConcurrencyConfig) Validate(maxWorkers int) error {
+	if cfg.MinWorkers <= 0 {
+		return fmt.Errorf("min workers must not be zero or negative")
+	}
+
+	if cfg.MinWorkers > maxWorkers {
+		return fmt.Errorf("min workers must not be larger than the worker count (%d > %d)", cfg.MinWorkers, maxWorkers)
+	}
+
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("concurrency interval must not be zero or negative")
+	}
+
+	if cfg.IncreaseStep <= 0 {
+		return fmt.Errorf("concurrency increase step must not be zero or negative")
+	}
+
+	if cfg.DecreaseFactor <= 0 || cfg.DecreaseFactor >= 1 {
+		return fmt.Errorf("concurrency decrease factor must be between 0 and 1")
+	}
+
+	if cfg.ErrorThreshold <= 0 || cfg.ErrorThreshold > 1 {
+		return fmt.Errorf("concurrency error threshold must be between 0 and 1")
+	}
+
+	if cfg.LatencyTolerance != 0 && cfg.LatencyTolerance < 1 {
+		return fmt.Errorf("concurrency latency tolerance must be 0 or at least 1")
+	}
+
+	if cfg.FDThreshold < 0 || cfg.FDThreshold > 1 {
+		return fmt.Errorf("concurrency fd threshold must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// concurrencyController implements the AIMD logic that's described in
+// [ConcurrencyConfig]. It collects connection statistics from the results
+// of an interval and computes the new number of active workers at the end
+// of the interval.
+type concurrencyController struct {
+	cfg *ConcurrencyConfig
+
+	// the maximum number of active workers
+	max int
+
+	// the current number of active workers
+	target int
+
+	// statistics of the current interval
+	results      int
+	pressureErrs int
+	latencySum   time.Duration
+	latencyCount int
+
+	// the lowest mean connection latency of any interval
+	minLatency time.Duration
+
+	// returns the number of open file descriptors and the soft limit.
+	openFDs func() (int, uint64, error)
+}
+
+func newConcurrencyController(cfg *ConcurrencyConfig, max int) *concurrencyController {
+	return &concurrencyController{
+		cfg:     cfg,
+		max:     max,
+		target:  cfg.MinWorkers,
+		openFDs: utils.OpenFDs,
+	}
+}
+
+// observe records the connection statistics of the given result if it
+// implements [ConnectStats].
+func (c *concurrencyController) observe(result any) {
+	stats, ok := result.(ConnectStats)
+	if !ok {
+		return
+	}
+
+	c.results += 1
+
+	errClass := stats.ConnectErrorClass()
+	if _, found := pressureErrors[errClass]; found {
+		c.pressureErrs += 1
+	} else if errClass == "" {
+		c.latencySum += stats.ConnectDuration()
+		c.latencyCount += 1
+	}
+}
+
+// adjust computes the new number of active workers based on the statistics
+// of the past interval and resets them. busy indicates whether all active
+// workers were occupied. We only increase the number of active workers if
+// that's the case.
+func (c *concurrencyController) adjust(busy bool) int {
+	defer func() {
+		c.results = 0
+		c.pressureErrs = 0
+		c.latencySum = 0
+		c.latencyCount = 0
+	}()
+
+	logEntry := log.WithField("target", c.target)
+
+	if reason := c.overloaded(); reason != "" {
+		target := int(float64(c.target) * c.cfg.DecreaseFactor)
+		if target < c.cfg.MinWorkers {
+			target = c.cfg.MinWorkers
+		}
+
+		if target != c.target {
+			logEntry.WithField("reason", reason).Debugf("Decreasing worker concurrency to %d", target)
+		}
+		c.target = target
+
+		return c.target
+	}
+
+	if busy && c.target < c.max {
+		c.target += c.cfg.IncreaseStep
+		if c.target > c.max {
+			c.target = c.max
+		}
+		logEntry.Debugf("Increasing worker concurrency to %d", c.target)
+	}
+
+	return c.target
+}
+
+// overloaded returns a non-empty reason if the statistics of the past
+// interval show signs of overload.
+func (c *concurrencyController) overloaded() string {
+	if c.results > 0 && float64(c.pressureErrs)/float64(c.results) > c.cfg.ErrorThreshold {
+		return "errors"
+	}
+
+	if c.cfg.LatencyTolerance > 0 && c.latencyCount > 0 {
+		latency := c.latencySum / time.Duration(c.latencyCount)
+		if c.minLatency == 0 || latency < c.minLatency {
+			c.minLatency = latency
+		}
+
+		if float64(latency) > c.cfg.LatencyTolerance*float64(c.minLatency) {
+			return "latency"
+		}
+	}
+
+	if c.cfg.FDThreshold > 0 && c.openFDs != nil {
+		open, limit, err := c.openFDs()
+		if err == nil && limit > 0 && float64(open)/float64(limit) > c.cfg.FDThreshold {
+			return "fds"
+		}
+	}
+
+	return ""
+}
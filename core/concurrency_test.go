@@ -0,0 +1,145 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+func TestConcurrencyConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfgFn   func(*ConcurrencyConfig)
+		wantErr bool
+	}{
+		{name: "default", cfgFn: func(cfg *ConcurrencyConfig) {}, wantErr: false},
+		{name: "zero min workers", cfgFn: func(cfg *ConcurrencyConfig) { cfg.MinWorkers = 0 }, wantErr: true},
+		{name: "min workers too large", cfgFn: func(cfg *ConcurrencyConfig) { cfg.MinWorkers = 101 }, wantErr: true},
+		{name: "zero interval", cfgFn: func(cfg *ConcurrencyConfig) { cfg.Interval = 0 }, wantErr: true},
+		{name: "zero increase step", cfgFn: func(cfg *ConcurrencyConfig) { cfg.IncreaseStep = 0 }, wantErr: true},
+		{name: "decrease factor too large", cfgFn: func(cfg *ConcurrencyConfig) { cfg.DecreaseFactor = 1 }, wantErr: true},
+		{name: "zero error threshold", cfgFn: func(cfg *ConcurrencyConfig) { cfg.ErrorThreshold = 0 }, wantErr: true},
+		{name: "latency tolerance too small", cfgFn: func(cfg *ConcurrencyConfig) { cfg.LatencyTolerance = 0.5 }, wantErr: true},
+		{name: "latency disabled", cfgFn: func(cfg *ConcurrencyConfig) { cfg.LatencyTolerance = 0 }, wantErr: false},
+		{name: "fd threshold too large", cfgFn: func(cfg *ConcurrencyConfig) { cfg.FDThreshold = 1.1 }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConcurrencyConfig()
+			tt.cfgFn(cfg)
+			if err := cfg.Validate(100); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type testConnectStats struct {
+	errClass string
+	duration time.Duration
+}
+
+func (s testConnectStats) ConnectErrorClass() string      { return s.errClass }
+func (s testConnectStats) ConnectDuration() time.Duration { return s.duration }
+
+func newTestConcurrencyController() *concurrencyController {
+	cfg := DefaultConcurrencyConfig()
+	cfg.MinWorkers = 10
+	cfg.IncreaseStep = 10
+	cfg.DecreaseFactor = 0.5
+
+	c := newConcurrencyController(cfg, 50)
+	c.openFDs = func() (int, uint64, error) { return 10, 1000, nil }
+	return c
+}
+
+func TestConcurrencyController_increase(t *testing.T) {
+	c := newTestConcurrencyController()
+	assert.Equal(t, 10, c.target)
+
+	// don't increase if not all workers are busy
+	assert.Equal(t, 10, c.adjust(false))
+
+	for _, want := range []int{20, 30, 40, 50, 50} {
+		c.observe(testConnectStats{duration: time.Second})
+		assert.Equal(t, want, c.adjust(true))
+	}
+}
+
+func TestConcurrencyController_errors(t *testing.T) {
+	c := newTestConcurrencyController()
+	c.target = 40
+
+	for i := 0; i < 9; i++ {
+		c.observe(testConnectStats{errClass: pgmodels.NetErrorIoTimeout})
+	}
+	c.observe(testConnectStats{errClass: pgmodels.NetErrorCantAssignRequestedAddress})
+	assert.Equal(t, 20, c.adjust(true))
+
+	// never go below the minimum
+	c.observe(testConnectStats{errClass: pgmodels.NetErrorResourceLimitExceeded})
+	assert.Equal(t, 10, c.adjust(true))
+	c.observe(testConnectStats{errClass: pgmodels.NetErrorResourceLimitExceeded})
+	assert.Equal(t, 10, c.adjust(true))
+
+	// statistics are reset after each interval
+	assert.Equal(t, 20, c.adjust(true))
+}
+
+func TestConcurrencyController_latency(t *testing.T) {
+	c := newTestConcurrencyController()
+	c.target = 40
+
+	c.observe(testConnectStats{duration: time.Second})
+	assert.Equal(t, 50, c.adjust(true))
+
+	c.observe(testConnectStats{duration: 2 * time.Second})
+	assert.Equal(t, 50, c.adjust(true))
+
+	c.observe(testConnectStats{duration: 4 * time.Second})
+	assert.Equal(t, 25, c.adjust(true))
+}
+
+func TestConcurrencyController_fds(t *testing.T) {
+	c := newTestConcurrencyController()
+	c.target = 40
+
+	c.openFDs = func() (int, uint64, error) { return 900, 1000, nil }
+	assert.Equal(t, 20, c.adjust(true))
+
+	c.openFDs = func() (int, uint64, error) { return 0, 0, fmt.Errorf("not supported") }
+	assert.Equal(t, 30, c.adjust(true))
+}
+
+func TestConcurrencyController_ignores_unknown_results(t *testing.T) {
+	c := newTestConcurrencyController()
+	c.observe("not a result")
+	assert.Equal(t, 0, c.results)
+}
+
+func TestNewEngine_concurrency(t *testing.T) {
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(newTestCrawler(), nil)
+	driver.On("NewWriter").Return(newTestWriter(), nil)
+	driver.On("Tasks").Return(make(<-chan *testPeerInfo))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	cfg := DefaultEngineConfig()
+	cfg.WorkerCount = 5
+	cfg.Concurrency = DefaultConcurrencyConfig()
+	cfg.Concurrency.MinWorkers = 2
+
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, eng.workerPool.Size())
+	assert.Equal(t, 2, eng.workerPool.Limit())
+
+	cfg.Concurrency.MinWorkers = 6
+	_, err = NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	assert.Error(t, err)
+}
@@ -69,6 +69,19 @@ type Driver[I PeerInfo[I], R WorkResult[I]] interface {
 	Close()
 }
 
+// A RetryDriver is a [Driver] that provides dedicated workers for the retry
+// pass of the [Engine] (see [EngineConfig.RetryPasses]). This allows drivers
+// to give peers that have failed with transient errors a better chance, e.g.,
+// by using a longer dial timeout. Drivers that don't implement this interface
+// retry peers with their regular workers.
+type RetryDriver[I PeerInfo[I], R WorkResult[I]] interface {
+	Driver[I, R]
+
+	// NewRetryWorker returns a new [Worker] that processes the peers of the
+	// retry pass. It's only called when the first retry pass starts.
+	NewRetryWorker() (Worker[I, R], error)
+}
+
 // A Worker processes tasks of type T and returns results of type R or an error.
 // Workers are used to process a single peer or store a crawl result to the
 // database. It is the unit of concurrency in this system.
@@ -97,6 +110,41 @@ type Handler[I PeerInfo[I], R WorkResult[I]] interface {
 	Summary(*EngineState) *Summary
 }
 
+// A RetryHandler is a [Handler] that collects peers which have failed with
+// transient errors. After the engine has run out of peers to process, it asks
+// the handler for these peers and processes them again (see
+// [EngineConfig.RetryPasses]).
+type RetryHandler[I PeerInfo[I], R WorkResult[I]] interface {
+	Handler[I, R]
+
+	// RetryTasks returns the peers that should be processed again. The handler
+	// should only return each collected peer once. Results of retried peers
+	// are passed to HandlePeerResult like any other result.
+	RetryTasks() []I
+}
+
+// A ForgetHandler is a [Handler] that can compile its summary as if it had
+// never seen the given results. The engine checkpoints peers whose results
+// haven't been written yet as unprocessed. After resuming, these peers are
+// processed again, so their results must not be part of the checkpointed
+// summary.
+type ForgetHandler[I PeerInfo[I], R WorkResult[I]] interface {
+	Handler[I, R]
+
+	// SummaryWithout returns the summary without the given results. It must
+	// not modify the state of the handler.
+	SummaryWithout(state *EngineState, results []R) *Summary
+}
+
+// A RetryResult is a [WorkResult] that records the retry pass in which it
+// was produced (see [EngineConfig.RetryPasses]). This allows the writers to
+// persist whether a result belongs to a retried peer.
+type RetryResult[R any] interface {
+	// WithRetryPass returns a copy of the result that belongs to the given
+	// retry pass.
+	WithRetryPass(pass int) R
+}
+
 // EngineState represents a subset of the internal state of the [Engine]. This
 // is used to compile aggregate summary information in the peer [Handler].
 type EngineState struct {
@@ -129,6 +177,18 @@ type Summary struct {
 
 	// CrawlErrs maps crawl error types to their occurrence counts.
 	CrawlErrs map[string]int
+
+	// NetworkSize estimates the total number of peers in the network based
+	// on the routing tables of the crawled peers.
+	NetworkSize NetworkSize
+
+	// PeersRetried is the number of peers that were processed again after
+	// they had failed with a transient error.
+	PeersRetried int
+
+	// PeersRetrySucceeded is the number of retried peers that could be
+	// connected to on retry.
+	PeersRetrySucceeded int
 }
 
 // RoutingTable captures the routing table information and crawl error of a particular peer
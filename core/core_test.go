@@ -20,11 +20,14 @@ var (
 	_ WorkResult[*testPeerInfo]                          = DialResult[*testPeerInfo]{}
 	_ Worker[CrawlResult[*testPeerInfo], WriteResult]    = (*CrawlWriter[*testPeerInfo])(nil)
 	_ Worker[DialResult[*testPeerInfo], WriteResult]     = (*DialWriter[*testPeerInfo])(nil)
+	_ ConnectStats                                       = CrawlResult[*testPeerInfo]{}
+	_ ConnectStats                                       = DialResult[*testPeerInfo]{}
 )
 
 type testPeerInfo struct {
 	peerID peer.ID
 	addrs  []ma.Multiaddr
+	prefix uint64
 }
 
 var _ PeerInfo[*testPeerInfo] = (*testPeerInfo)(nil)
@@ -45,6 +48,7 @@ func (p *testPeerInfo) Merge(other *testPeerInfo) *testPeerInfo {
 	return &testPeerInfo{
 		peerID: p.peerID,
 		addrs:  utils.MergeMaddrs(p.addrs, other.addrs),
+		prefix: p.prefix,
 	}
 }
 
@@ -53,7 +57,7 @@ func (p *testPeerInfo) DeduplicationKey() string {
 }
 
 func (p *testPeerInfo) DiscoveryPrefix() uint64 {
-	return 0
+	return p.prefix
 }
 
 type testDriver struct {
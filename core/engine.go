@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"fmt"
+	"time"
 
 	ma "github.com/multiformats/go-multiaddr"
 	log "github.com/sirupsen/logrus"
@@ -25,11 +26,25 @@ type EngineConfig struct {
 	// the number of internal writers that store the results to disk.
 	WriterCount int
 
+	// maximum number of processing results that may wait to be written to
+	// disk. If the writers can't keep up, e.g., because the database stalls,
+	// the engine stops handing peers to the workers until the write queue
+	// has drained below this limit. 0 means no limit.
+	MaxWriteQueue int
+
 	// maximum number of peers to process before stopping the engine. 0 means
 	// to process peers until there are no more in the work queue. If
 	// [DuplicateProcessing] is true, process indefinitely.
 	Limit int
 
+	// the number of times the engine processes peers again that have failed
+	// with transient errors. A retry pass starts after the engine has run out
+	// of peers to process and all results were handed to the writers. The
+	// handler must implement [RetryHandler] to provide the peers to retry. If
+	// the driver implements [RetryDriver], the retry passes use its dedicated
+	// workers. 0 disables retrying.
+	RetryPasses int
+
 	// if set to true, the engine won't keep track of which peers were already
 	// processed to prevent processing a peer twice. The engine is solely driven
 	// by what the driver will emit on its tasks channel.
@@ -44,6 +59,46 @@ type EngineConfig struct {
 
 	// TracerProvider is the tracer provider to use when initialising tracing
 	TracerProvider trace.TracerProvider
+
+	// MetricAttributes are attached to all metrics of the engine. This allows
+	// distinguishing engines that run side by side in the same process.
+	MetricAttributes []attribute.KeyValue
+
+	// if set, the engine adapts the number of concurrently active workers
+	// between [ConcurrencyConfig.MinWorkers] and WorkerCount based on the
+	// observed connection errors, latencies, and open file descriptors. See
+	// [ConcurrencyConfig] for more information.
+	Concurrency *ConcurrencyConfig
+
+	// if set, the engine only processes peers in its slice of the keyspace
+	// and forwards all other peers to the shard that owns them. See
+	// [ShardConfig] for more information.
+	Shard *ShardConfig
+
+	// if set, the engine consults the policy before handing a peer to a
+	// worker. Peers that the policy doesn't allow to be processed right away
+	// are deferred until the policy has capacity again. See
+	// [SchedulingPolicy] for more information.
+	SchedulingPolicy SchedulingPolicy
+
+	// if set, the engine periodically writes a snapshot of its internal
+	// state to disk. See [CheckpointConfig] for more information.
+	Checkpoint *CheckpointConfig
+
+	// observers that are notified about every peer processing result. See
+	// [Observer] for more information.
+	Observers []Observer
+
+	// the network that the engine processes. It's attached to the events
+	// that are passed to the observers, so that they can tell apart the
+	// results of multiple networks that are crawled in the same process.
+	Network string
+
+	// if set, the engine continues from the given checkpoint. All peers that
+	// were processed in the previous run won't be processed again and the
+	// returned [Summary] will include the results of the previous run. The
+	// queued peers of the checkpoint must be emitted by the driver.
+	Resume *Checkpoint
 }
 
 // DefaultEngineConfig returns a default engine configuration that can and
@@ -70,6 +125,30 @@ func (cfg *EngineConfig) Validate() error {
 		return fmt.Errorf("writer count must not be zero or negative")
 	}
 
+	if cfg.MaxWriteQueue < 0 {
+		return fmt.Errorf("max write queue must not be negative")
+	}
+
+	if cfg.RetryPasses < 0 {
+		return fmt.Errorf("retry passes must not be negative")
+	}
+
+	if cfg.Concurrency != nil {
+		if err := cfg.Concurrency.Validate(cfg.WorkerCount); err != nil {
+			return fmt.Errorf("validate concurrency config: %w", err)
+		}
+	}
+
+	if cfg.Shard != nil {
+		if err := cfg.Shard.Validate(); err != nil {
+			return fmt.Errorf("validate shard config: %w", err)
+		}
+	}
+
+	if cfg.Checkpoint != nil && cfg.Checkpoint.Path != "" && cfg.Checkpoint.Interval <= 0 {
+		return fmt.Errorf("checkpoint interval must not be zero or negative")
+	}
+
 	return nil
 }
 
@@ -93,6 +172,17 @@ type Engine[I PeerInfo[I], R WorkResult[I]] struct {
 	// the channel on which the driver will emit peers to process
 	tasksChan <-chan I
 
+	// the channel on which the shard transport emits peers that other shards
+	// have forwarded to us. This is nil if sharding is not configured.
+	shardTasks <-chan any
+
+	// the deduplication keys of all peers that we have forwarded to other
+	// shards, so that we forward each peer only once.
+	forwarded map[string]struct{}
+
+	// whether we have reported to the shard transport that we're idle
+	shardIdle bool
+
 	// the data structure that drives the engine by handling peer processing
 	// and write results. It returns new tasks to do.
 	handler Handler[I, R]
@@ -118,7 +208,16 @@ type Engine[I PeerInfo[I], R WorkResult[I]] struct {
 	writeQueue *PriorityQueue[R]
 
 	// a map that keeps track of all peers we are currently communicating with.
-	inflight map[string]struct{}
+	inflight map[string]I
+
+	// peers that the [SchedulingPolicy] didn't allow to be processed yet. The
+	// outer map is keyed by the group that blocked the peer, and the inner map
+	// by the peer's deduplication key. deferredGroups maps the deduplication
+	// key back to the blocking group. When a peer of a group has finished
+	// processing, we move one deferred peer of that group back into the
+	// peerQueue.
+	deferred       map[string]map[string]I
+	deferredGroups map[string]string
 
 	// A set of peer IDs that indicates which peers have already been processed
 	// in the past, so we don't put them in the peer queue again. This map is
@@ -128,6 +227,15 @@ type Engine[I PeerInfo[I], R WorkResult[I]] struct {
 	// a counter that tracks the number of handled write results
 	writeCount int
 
+	// the number of retry passes that were started and whether there's
+	// nothing left to retry.
+	retryPasses int
+	retryDone   bool
+
+	// the retry pass of the peers that are currently being retried keyed by
+	// their deduplication key.
+	retrying map[string]int
+
 	// a filter function that removes multi addresses from the given slice.
 	// this is used to determine the position of a peer in the priority queue.
 	// If this field contains a filter function that removes all private
@@ -136,6 +244,9 @@ type Engine[I PeerInfo[I], R WorkResult[I]] struct {
 	// find more addresses for the peer while crawling the rest of the network.
 	maddrFilter func([]ma.Multiaddr) []ma.Multiaddr
 
+	// adapts the number of active workers if configured. Otherwise, nil.
+	concurrency *concurrencyController
+
 	// a reference to the engine's telemetry meters and tracer
 	telemetry *telemetry[I, R]
 }
@@ -178,13 +289,13 @@ func NewEngine[I PeerInfo[I], R WorkResult[I]](driver Driver[I, R], handler Hand
 		maddrFilter = utils.FilterPrivateMaddrs
 	}
 
-	telemetry, err := newTelemetry[I, R](cfg.TracerProvider, cfg.MeterProvider)
+	telemetry, err := newTelemetry[I, R](cfg.TracerProvider, cfg.MeterProvider, cfg.MetricAttributes)
 	if err != nil {
 		return nil, fmt.Errorf("new telemetry: %w", err)
 	}
 
 	// initialize empty maps for the different queues etc.
-	return &Engine[I, R]{
+	e := &Engine[I, R]{
 		cfg:         cfg,
 		driver:      driver,
 		handler:     handler,
@@ -194,10 +305,36 @@ func NewEngine[I PeerInfo[I], R WorkResult[I]](driver Driver[I, R], handler Hand
 		peerQueue:   NewPriorityQueue[I](),
 		writeQueue:  NewPriorityQueue[R](),
 		tasksChan:   driver.Tasks(),
-		inflight:    make(map[string]struct{}),
+		inflight:    make(map[string]I),
 		processed:   make(map[string]struct{}),
 		telemetry:   telemetry,
-	}, nil
+
+		deferred:       make(map[string]map[string]I),
+		deferredGroups: make(map[string]string),
+		forwarded:      make(map[string]struct{}),
+		retrying:       make(map[string]int),
+	}
+
+	if cfg.Shard != nil {
+		e.shardTasks = cfg.Shard.Transport.Tasks()
+	}
+
+	// if configured, start with the minimum number of active workers and
+	// let the concurrency controller adjust it while we're running.
+	if cfg.Concurrency != nil {
+		e.concurrency = newConcurrencyController(cfg.Concurrency, cfg.WorkerCount)
+		e.workerPool.SetLimit(e.concurrency.target)
+	}
+
+	// if we resume from a checkpoint, don't process the peers again that
+	// were already processed in the previous run.
+	if cfg.Resume != nil {
+		for _, key := range cfg.Resume.Processed {
+			e.processed[key] = struct{}{}
+		}
+	}
+
+	return e, nil
 }
 
 // Run is a blocking call that starts the worker and writer pools to accept and
@@ -224,18 +361,39 @@ func (e *Engine[I, R]) Run(ctx context.Context) (*Summary, error) {
 
 	// start the worker and writer worker pools to read from their respective
 	// task channel. The returned result channels are used to signal back any
-	// task completion from any worker of the respective pool.
+	// task completion from any worker of the respective pool. The writers
+	// don't stop on cancellation. Results that a writer has already taken
+	// aren't part of the write queue anymore and wouldn't end up in the
+	// checkpoint if their write was aborted.
 	peerResults := e.workerPool.Start(ctx, peerTasks)
-	writerResults := e.writerPool.Start(ctx, writeTasks)
+	writerResults := e.writerPool.Start(context.WithoutCancel(ctx), writeTasks)
+
+	// if configured, periodically write a snapshot of the engine state to
+	// disk. If the ticker channel stays nil, the select below won't ever
+	// pick that case.
+	var checkpointTicker <-chan time.Time
+	if e.cfg.Checkpoint != nil && e.cfg.Checkpoint.Path != "" {
+		ticker := time.NewTicker(e.cfg.Checkpoint.Interval)
+		defer ticker.Stop()
+		checkpointTicker = ticker.C
+	}
+
+	// if configured, periodically adjust the number of active workers.
+	var concurrencyTicker <-chan time.Time
+	if e.concurrency != nil {
+		ticker := time.NewTicker(e.cfg.Concurrency.Interval)
+		defer ticker.Stop()
+		concurrencyTicker = ticker.C
+	}
 
 	// start the core loop
 	for {
 		// track the number of tasks the engine is handling
-		e.telemetry.taskCount.Add(ctx, 1)
+		e.telemetry.taskCount.Add(ctx, 1, metric.WithAttributes(e.telemetry.attrs...))
 
 		// get a random peer to process and a random processing result that we
 		// should store in the database
-		peerTask, peerOk := e.peerQueue.Peek()
+		peerTask, peerOk := e.nextPeerTask()
 		writeTask, writeOk := e.writeQueue.Peek()
 
 		// if we still have peers to process, set the inner queue to the
@@ -244,13 +402,42 @@ func (e *Engine[I, R]) Run(ctx context.Context) (*Summary, error) {
 		// closed in the past, and we don't have any requests still inflight,
 		// and we don't anticipate new tasks on the tasksChan from the driver,
 		// close the channel and invalidate the variable -> we're done
-		// processing peers.
+		// processing peers. If the writers can't keep up, don't hand out new
+		// peers until the write queue has drained.
 		var innerPeerTasks chan I
 		if peerOk {
-			innerPeerTasks = peerTasks
-		} else if peerTasks != nil && len(e.inflight) == 0 && e.tasksChan == nil {
-			close(peerTasks)
-			peerTasks = nil
+			if !e.writeQueueFull() {
+				innerPeerTasks = peerTasks
+			}
+		} else if peerTasks != nil && len(e.inflight) == 0 && e.tasksChan == nil && e.shardTasks == nil {
+			if e.retryPending() {
+				// wait until all results were handed to the writers before
+				// we start the retry pass. Otherwise, the results of the
+				// retried peers would collide with their previous ones in
+				// the write queue.
+				if e.writeQueue.Len() == 0 {
+					var err error
+					peerTasks, peerResults, err = e.startRetryPass(ctx, peerTasks, peerResults)
+					if err != nil {
+						log.WithError(err).Warnln("Failed starting retry pass")
+						e.retryDone = true
+					}
+				}
+			} else {
+				close(peerTasks)
+				peerTasks = nil
+			}
+		}
+
+		// if we're part of a distributed crawl, let the other shards know
+		// whether we have run out of work. The shard transport closes the
+		// shardTasks channel when all shards have run out of work.
+		if e.cfg.Shard != nil {
+			idle := !peerOk && len(e.inflight) == 0 && e.tasksChan == nil
+			if idle != e.shardIdle {
+				e.shardIdle = idle
+				e.cfg.Shard.Transport.SetIdle(idle)
+			}
 		}
 
 		// if we still have write tasks to do, set the inner queue to the
@@ -276,16 +463,41 @@ func (e *Engine[I, R]) Run(ctx context.Context) (*Summary, error) {
 
 			e.enqueueTask(task)
 
+		case task, more := <-e.shardTasks:
+			if !more {
+				e.shardTasks = nil
+				break
+			}
+
+			peerTask, ok := task.(I)
+			if !ok {
+				log.Warnf("Received unexpected forwarded peer type %T", task)
+				break
+			}
+
+			e.enqueueTask(peerTask)
+
 		case observeFn, more := <-e.telemetry.obsChan:
 			// an opentelemetry gauge wants to perform an observation
 			if !more {
 				break
 			}
 			observeFn(e)
+		case <-concurrencyTicker:
+			// only grow the number of active workers if all of them are busy
+			busy := len(e.inflight) >= e.workerPool.Limit()
+			e.workerPool.SetLimit(e.concurrency.adjust(busy))
+		case <-checkpointTicker:
+			if err := e.writeCheckpoint(); err != nil {
+				log.WithError(err).Warnln("Failed writing checkpoint")
+			}
 		case innerPeerTasks <- peerTask:
 			// a worker was ready to accept a new task -> perform internal bookkeeping.
 			e.peerQueue.Drop(peerTask.DeduplicationKey())
-			e.inflight[peerTask.DeduplicationKey()] = struct{}{}
+			e.inflight[peerTask.DeduplicationKey()] = peerTask
+			if e.cfg.SchedulingPolicy != nil {
+				e.cfg.SchedulingPolicy.Acquire(peerTask.DeduplicationKey(), e.maddrFilter(peerTask.Addrs()))
+			}
 		case innerWriteTasks <- writeTask:
 			// a write worker was ready to accept a new task -> perform internal bookkeeping.
 			e.writeQueue.Drop(writeTask.PeerInfo().DeduplicationKey())
@@ -302,6 +514,14 @@ func (e *Engine[I, R]) Run(ctx context.Context) (*Summary, error) {
 				break
 			}
 
+			// the context was cancelled because we reached the processing
+			// limit, but the select statement may still pick up results of
+			// the remaining workers. Drop them, so that we don't process
+			// more peers than allowed.
+			if e.reachedProcessingLimit() {
+				break
+			}
+
 			// a worker finished a task by processing a peer. Handle it.
 			e.handlePeerResult(ctx, result)
 		case result, more := <-writerResults:
@@ -332,30 +552,44 @@ func (e *Engine[I, R]) Run(ctx context.Context) (*Summary, error) {
 
 			// drain results channels. They'll be closed after all workers have
 			// stopped working. That's the point in time where we exit these for
-			// loops
+			// loops. The writers finish the results they have already taken
+			// before the checkpoint below is written.
 			for range peerResults {
 				// drop result
 			}
-			for range writerResults {
-				// drop result
+			for result := range writerResults {
+				e.handleWriteResult(ctx, result)
 			}
 
 			// stop the telemetry collection
 			e.telemetry.Stop()
 
-			summary := e.handler.Summary(&EngineState{
-				PeersQueued: e.peerQueue.Len(),
-			})
-			return summary, ctx.Err()
+			// persist the final engine state so that the crawl can be
+			// resumed later. Peers whose results were dropped above are
+			// still inflight and will therefore be processed again.
+			if !e.reachedProcessingLimit() {
+				if err := e.writeCheckpoint(); err != nil {
+					log.WithError(err).Warnln("Failed writing checkpoint")
+				}
+			} else {
+				e.removeCheckpoint()
+			}
+
+			return e.summary(), ctx.Err()
 		}
 
 		if peerResults == nil && writerResults == nil {
 			log.Infoln("Closing driver...")
 			e.driver.Close()
-			summary := e.handler.Summary(&EngineState{
-				PeersQueued: e.peerQueue.Len(),
-			})
-			return summary, nil // no work to do, natural end
+
+			// stop the telemetry collection. Otherwise, collecting the
+			// engine's gauges would block forever.
+			e.telemetry.Stop()
+
+			// the crawl has finished, so there's nothing to resume anymore.
+			e.removeCheckpoint()
+
+			return e.summary(), nil // no work to do, natural end
 		}
 
 		// break the for loop after 1) all workers have stopped or 2) we have
@@ -366,6 +600,68 @@ func (e *Engine[I, R]) Run(ctx context.Context) (*Summary, error) {
 	}
 }
 
+// retryPending returns true if the engine is configured to retry peers and
+// hasn't exhausted its retry passes yet.
+func (e *Engine[I, R]) retryPending() bool {
+	if e.retryDone || e.retryPasses >= e.cfg.RetryPasses {
+		return false
+	}
+
+	_, ok := e.handler.(RetryHandler[I, R])
+	return ok
+}
+
+// startRetryPass puts the peers that the handler wants to retry back into
+// the peer queue. If the driver provides dedicated retry workers, it replaces
+// the worker pool when the first retry pass starts. This is safe because no
+// peer is inflight at that point. It returns the channels that the engine
+// should use to communicate with the (potentially new) worker pool.
+func (e *Engine[I, R]) startRetryPass(ctx context.Context, peerTasks chan I, peerResults <-chan Result[R]) (chan I, <-chan Result[R], error) {
+	tasks := e.handler.(RetryHandler[I, R]).RetryTasks()
+	if len(tasks) == 0 {
+		e.retryDone = true
+		return peerTasks, peerResults, nil
+	}
+
+	if driver, ok := e.driver.(RetryDriver[I, R]); ok && e.retryPasses == 0 {
+		workers := make([]Worker[I, R], e.workerPool.Size())
+		for i := range workers {
+			worker, err := driver.NewRetryWorker()
+			if err != nil {
+				return peerTasks, peerResults, fmt.Errorf("new retry worker: %w", err)
+			}
+			workers[i] = worker
+		}
+
+		// stop the current workers and start the retry workers with the
+		// same concurrency limit.
+		close(peerTasks)
+
+		pool := NewPool[I, R](workers...)
+		pool.SetLimit(e.workerPool.Limit())
+		e.workerPool = pool
+
+		peerTasks = make(chan I)
+		peerResults = e.workerPool.Start(ctx, peerTasks)
+	}
+
+	e.retryPasses += 1
+
+	log.WithFields(log.Fields{
+		"pass":  e.retryPasses,
+		"peers": len(tasks),
+	}).Infoln("Starting retry pass")
+
+	// the retried peers were already processed, so we bypass the
+	// deduplication logic in enqueueTask.
+	for _, task := range tasks {
+		e.retrying[task.DeduplicationKey()] = e.retryPasses
+		e.peerQueue.Push(task.DeduplicationKey(), task, e.priority(task))
+	}
+
+	return peerTasks, peerResults, nil
+}
+
 // handlePeerResult performs internal bookkeeping after a worker from the pool
 // has published a worker result. Here, we update several internal bookkeeping
 // maps and prometheus metrics as well as scheduling new peers to process.
@@ -375,7 +671,12 @@ func (e *Engine[I, R]) handlePeerResult(ctx context.Context, result Result[R]) {
 	logEntry.Debugln("Handling worker result")
 
 	// count the number of visits being made
-	e.telemetry.visitCount.Add(ctx, 1, metric.WithAttributes(attribute.Bool("success", result.Value.IsSuccess())))
+	e.telemetry.visitCount.Add(ctx, 1, metric.WithAttributes(e.telemetry.attrs...), metric.WithAttributes(attribute.Bool("success", result.Value.IsSuccess())))
+
+	// feed the connection statistics into the concurrency controller
+	if e.concurrency != nil {
+		e.concurrency.observe(wr)
+	}
 
 	// get hold of the deduplication key
 	key := wr.PeerInfo().DeduplicationKey()
@@ -383,6 +684,10 @@ func (e *Engine[I, R]) handlePeerResult(ctx context.Context, result Result[R]) {
 	// The operation for this peer is not inflight anymore -> delete it.
 	delete(e.inflight, key)
 
+	// free the capacity that the peer has occupied and reschedule deferred
+	// peers that were waiting for it.
+	e.releasePeer(key)
+
 	// Keep track that this peer was processed, so we don't do it again during
 	// this run. Unless we explicitly allow duplicate processing.
 	if !e.cfg.DuplicateProcessing {
@@ -390,6 +695,16 @@ func (e *Engine[I, R]) handlePeerResult(ctx context.Context, result Result[R]) {
 		logEntry = logEntry.WithField("processed", len(e.processed))
 	}
 
+	// Mark the results of retried peers, so that they can be told apart
+	// from the previous results of the same peers.
+	if pass, found := e.retrying[key]; found {
+		delete(e.retrying, key)
+		if rr, ok := any(wr).(RetryResult[R]); ok {
+			wr = rr.WithRetryPass(pass)
+			result.Value = wr
+		}
+	}
+
 	// Publish the processing result to the writer queue so that the data is
 	// saved to disk.
 	e.writeQueue.Push(key, wr, 0)
@@ -397,6 +712,9 @@ func (e *Engine[I, R]) handlePeerResult(ctx context.Context, result Result[R]) {
 	// let the handler work on the new peer result
 	newTasks := e.handler.HandlePeerResult(ctx, result)
 
+	// let everyone know who is interested in the result
+	e.notifyObservers(ctx, wr)
+
 	// process the new tasks that came out of handling the peer result
 	for _, task := range newTasks {
 		e.enqueueTask(task)
@@ -404,12 +722,13 @@ func (e *Engine[I, R]) handlePeerResult(ctx context.Context, result Result[R]) {
 
 	denominator := e.cfg.Limit
 	if e.cfg.Limit == 0 {
-		denominator = len(e.processed) + e.peerQueue.Len() + len(e.inflight)
+		denominator = len(e.processed) + e.queuedCount() + len(e.inflight)
 	}
 	pct := 100 * float32(len(e.processed)) / float32(denominator)
 
 	logEntry.WithFields(map[string]interface{}{
 		"queued":   e.peerQueue.Len(),
+		"deferred": len(e.deferredGroups),
 		"inflight": len(e.inflight),
 	}).Infof("Handled worker result [%.2f%%]", pct)
 }
@@ -435,6 +754,18 @@ func (e *Engine[I, R]) handleWriteResult(ctx context.Context, result Result[Writ
 func (e *Engine[I, R]) enqueueTask(task I) {
 	key := task.DeduplicationKey()
 
+	// If we're part of a distributed crawl and the peer is not in our slice
+	// of the keyspace, forward it to the shard that owns it.
+	if e.cfg.Shard != nil {
+		if owner := ShardOf(task.DiscoveryPrefix(), e.cfg.Shard.Count); owner != e.cfg.Shard.Index {
+			if _, forwarded := e.forwarded[key]; !forwarded {
+				e.forwarded[key] = struct{}{}
+				e.cfg.Shard.Transport.Forward(owner, task)
+			}
+			return
+		}
+	}
+
 	// Don't add this peer to the queue if we're currently querying it
 	if _, isInflight := e.inflight[key]; isInflight {
 		return
@@ -449,6 +780,14 @@ func (e *Engine[I, R]) enqueueTask(task I) {
 		return
 	}
 
+	// If the scheduling policy has deferred this peer, merge the new
+	// information with the deferred one. The peer will be put back into the
+	// queue when its group has capacity again.
+	if group, isDeferred := e.deferredGroups[key]; isDeferred {
+		e.deferred[group][key] = task.Merge(e.deferred[group][key])
+		return
+	}
+
 	// Check if we have already queued this peer. If so, merge the new
 	// information with the already existing ones.
 	queuedTask, isQueued := e.peerQueue.Find(key)
@@ -456,27 +795,105 @@ func (e *Engine[I, R]) enqueueTask(task I) {
 		task = task.Merge(queuedTask)
 	}
 
-	// If we don't know any multi addresses for the peer yet, we push it
-	// to the end of our priority queue by giving it a low priority. If we
-	// find that peer again in another routing table, we might find another
-	// multi address. In that case, we update the set of addresses and
-	// increase the priority.
-	priority := 1
-	if len(e.maddrFilter(task.Addrs())) == 0 {
-		priority = 0
-	}
-
 	// If the peer was already queued we only update its priority. If the
 	// peer wasn't queued, we push it to the queue.
 	if isQueued {
-		e.peerQueue.Update(key, task, priority)
+		e.peerQueue.Update(key, task, e.priority(task))
 	} else {
-		e.peerQueue.Push(key, task, priority)
+		e.peerQueue.Push(key, task, e.priority(task))
 	}
 }
 
+// priority returns the position of the given peer in the peer queue. If we
+// don't know any multi addresses for the peer yet, we push it to the end of
+// our priority queue by giving it a low priority. If we find that peer again
+// in another routing table, we might find another multi address. In that
+// case, we update the set of addresses and increase the priority.
+func (e *Engine[I, R]) priority(task I) int {
+	if len(e.maddrFilter(task.Addrs())) == 0 {
+		return 0
+	}
+	return 1
+}
+
+// nextPeerTask returns the next peer from the peer queue that the scheduling
+// policy allows to process. All peers in front of it that the policy
+// doesn't allow to process are moved out of the queue and deferred until
+// their group has capacity again.
+func (e *Engine[I, R]) nextPeerTask() (I, bool) {
+	for {
+		task, ok := e.peerQueue.Peek()
+		if !ok || e.cfg.SchedulingPolicy == nil {
+			return task, ok
+		}
+
+		group, allowed := e.cfg.SchedulingPolicy.Allow(e.maddrFilter(task.Addrs()))
+		if allowed {
+			return task, true
+		}
+
+		key := task.DeduplicationKey()
+		e.peerQueue.Drop(key)
+
+		if _, found := e.deferred[group]; !found {
+			e.deferred[group] = make(map[string]I)
+		}
+		e.deferred[group][key] = task
+		e.deferredGroups[key] = group
+	}
+}
+
+// releasePeer notifies the scheduling policy that the peer with the given key
+// has finished processing. For each group that has capacity again, we move
+// one of its deferred peers back into the peer queue.
+func (e *Engine[I, R]) releasePeer(key string) {
+	if e.cfg.SchedulingPolicy == nil {
+		return
+	}
+
+	for _, group := range e.cfg.SchedulingPolicy.Release(key) {
+		for deferredKey, task := range e.deferred[group] {
+			delete(e.deferred[group], deferredKey)
+			delete(e.deferredGroups, deferredKey)
+			e.peerQueue.Push(deferredKey, task, e.priority(task))
+			break
+		}
+
+		if len(e.deferred[group]) == 0 {
+			delete(e.deferred, group)
+		}
+	}
+}
+
+// queuedCount returns the number of peers that wait to be processed. This
+// includes the peers that were deferred by the scheduling policy.
+func (e *Engine[I, R]) queuedCount() int {
+	return e.peerQueue.Len() + len(e.deferredGroups)
+}
+
+// summary returns the aggregate information of the handler. If the engine
+// was resumed from a checkpoint, the summary of the previous run is included.
+func (e *Engine[I, R]) summary() *Summary {
+	summary := e.handler.Summary(&EngineState{
+		PeersQueued: e.queuedCount(),
+	})
+
+	if e.cfg.Resume != nil {
+		summary = summary.Merge(e.cfg.Resume.Summary)
+	}
+
+	return summary
+}
+
 // reachedProcessingLimit returns true if the processing limit is configured
 // (aka != 0) and the processed peers exceed this limit.
 func (e *Engine[I, R]) reachedProcessingLimit() bool {
 	return e.cfg.Limit > 0 && len(e.processed) >= e.cfg.Limit
 }
+
+// writeQueueFull returns true if the maximum write queue length is
+// configured (aka != 0) and the number of results that wait to be written
+// reached this limit.
+func (e *Engine[I, R]) writeQueueFull() bool {
+	return e.cfg.MaxWriteQueue > 0 && e.writeQueue.Len() >= e.cfg.MaxWriteQueue
+}
@@ -10,6 +10,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	"go.uber.org/goleak"
 
 	"github.com/dennis-tra/nebula-crawler/db"
@@ -51,6 +54,50 @@ func TestEngineConfig_Validate(t *testing.T) {
 		cfg.Limit = 100
 		assert.NoError(t, cfg.Validate())
 	})
+
+	t.Run("negative max write queue", func(t *testing.T) {
+		cfg := DefaultEngineConfig()
+		cfg.MaxWriteQueue = -1
+		assert.Error(t, cfg.Validate())
+		cfg.MaxWriteQueue = 0
+		assert.NoError(t, cfg.Validate())
+		cfg.MaxWriteQueue = 10
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("negative retry passes", func(t *testing.T) {
+		cfg := DefaultEngineConfig()
+		cfg.RetryPasses = -1
+		assert.Error(t, cfg.Validate())
+		cfg.RetryPasses = 0
+		assert.NoError(t, cfg.Validate())
+	})
+}
+
+func TestEngine_writeQueueFull(t *testing.T) {
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(newTestCrawler(), nil)
+	driver.On("NewWriter").Return(newTestWriter(), nil)
+	driver.On("Tasks").Return(make(<-chan *testPeerInfo))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	cfg := DefaultEngineConfig()
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	require.NoError(t, err)
+
+	eng.writeQueue.Push("peer-1", CrawlResult[*testPeerInfo]{}, 0)
+	eng.writeQueue.Push("peer-2", CrawlResult[*testPeerInfo]{}, 0)
+	assert.False(t, eng.writeQueueFull(), "unlimited write queue")
+
+	cfg.MaxWriteQueue = 3
+	assert.False(t, eng.writeQueueFull())
+
+	cfg.MaxWriteQueue = 2
+	assert.True(t, eng.writeQueueFull())
+
+	eng.writeQueue.Drop("peer-1")
+	assert.False(t, eng.writeQueueFull())
 }
 
 func TestNewEngine(t *testing.T) {
@@ -154,7 +201,7 @@ func TestNewEngine_Run(t *testing.T) {
 		crawler.On("Work", mock.IsType(ctx), mock.IsType(testPeer)).Return(cr, nil)
 
 		writer := newTestWriter()
-		writer.On("Work", mock.IsType(ctx), mock.IsType(cr)).Return(WriteResult{}, nil)
+		writer.On("Work", mock.Anything, mock.IsType(cr)).Return(WriteResult{}, nil)
 
 		driver := &testDriver{}
 		driver.On("NewWorker").Return(crawler, nil)
@@ -273,3 +320,71 @@ func TestNewEngine_Run_parking_peers(t *testing.T) {
 
 	require.NotNil(t, summary)
 }
+
+func TestNewEngine_Run_metric_attributes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background()) // cancelCtx to satisfy mock.IsType below
+	defer cancel()
+
+	testPeer := &testPeerInfo{
+		peerID: peer.ID("test-peer"),
+		addrs:  []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/3000")},
+	}
+
+	tasksChan := make(chan *testPeerInfo, 1)
+	tasksChan <- testPeer
+	close(tasksChan)
+
+	cr := CrawlResult[*testPeerInfo]{
+		CrawlerID:    "1",
+		Info:         testPeer,
+		RoutingTable: &RoutingTable[*testPeerInfo]{},
+	}
+
+	crawler := newTestCrawler()
+	crawler.On("Work", mock.IsType(ctx), mock.IsType(testPeer)).Return(cr, nil)
+
+	writer := newTestWriter()
+	writer.On("Work", mock.Anything, mock.IsType(cr)).Return(WriteResult{}, nil)
+
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(crawler, nil)
+	driver.On("NewWriter").Return(writer, nil)
+	driver.On("Close").Times(1)
+	driver.On("Tasks").Return((<-chan *testPeerInfo)(tasksChan))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	reader := sdkmetric.NewManualReader()
+
+	cfg := DefaultEngineConfig()
+	cfg.MeterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	cfg.MetricAttributes = []attribute.KeyValue{attribute.String("network", "test")}
+
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	require.NoError(t, err)
+
+	_, err = eng.Run(ctx)
+	require.NoError(t, err)
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(ctx, &rm))
+
+	var found bool
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "visits" {
+				continue
+			}
+
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			require.Len(t, sum.DataPoints, 1)
+
+			network, ok := sum.DataPoints[0].Attributes.Value("network")
+			assert.True(t, ok)
+			assert.Equal(t, "test", network.AsString())
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
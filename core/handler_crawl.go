@@ -3,6 +3,8 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"maps"
+	"slices"
 	"time"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -95,12 +97,21 @@ type CrawlResult[I PeerInfo[I]] struct {
 
 	// Debug flag that indicates whether to log the full error string
 	LogErrors bool
+
+	// The retry pass in which the peer was crawled. Zero for the first
+	// attempt. See [EngineConfig.RetryPasses].
+	RetryPass int
 }
 
 func (r CrawlResult[I]) PeerInfo() I {
 	return r.Info
 }
 
+func (r CrawlResult[I]) WithRetryPass(pass int) CrawlResult[I] {
+	r.RetryPass = pass
+	return r
+}
+
 func (r CrawlResult[I]) LogEntry() *log.Entry {
 	rtSize := -1
 	if r.RoutingTable != nil {
@@ -146,7 +157,31 @@ func (r CrawlResult[I]) ConnectDuration() time.Duration {
 	return r.ConnectEndTime.Sub(r.ConnectStartTime)
 }
 
-type CrawlHandlerConfig struct{}
+// ConnectErrorClass returns the known connection error or an empty string if no error occurred.
+func (r CrawlResult[I]) ConnectErrorClass() string {
+	if r.ConnectError == nil {
+		return ""
+	}
+	return r.ConnectErrorStr
+}
+
+// DefaultRetryErrors are the connection errors that are likely transient.
+// Peers that fail with one of these errors are worth another attempt.
+var DefaultRetryErrors = []string{
+	pgmodels.NetErrorConnectionRefused,
+	pgmodels.NetErrorIoTimeout,
+	pgmodels.NetErrorContextDeadlineExceeded,
+	pgmodels.NetErrorResourceLimitExceeded,
+	pgmodels.NetErrorConnectionResetByPeer,
+	pgmodels.NetErrorNoRecentNetworkActivity,
+}
+
+type CrawlHandlerConfig struct {
+	// the connection errors of peers that should be retried at the end of
+	// the crawl. The handler only collects peers to retry if this list isn't
+	// empty. See [EngineConfig.RetryPasses].
+	RetryErrors []string
+}
 
 // CrawlHandler is the default implementation for a [Handler] that can be used
 // as the basis for crawl operations.
@@ -167,6 +202,19 @@ type CrawlHandler[I PeerInfo[I]] struct {
 
 	// The number of peers that were crawled.
 	CrawledPeers int
+
+	// The network size estimate based on the crawled routing tables.
+	NetworkSize NetworkSize
+
+	// The number of peers that were crawled again and how many of them
+	// could be connected to on retry.
+	RetriedPeers        int
+	RetrySucceededPeers int
+
+	// the failed results of peers that should be retried and of peers that
+	// are currently being retried. Both are keyed by the deduplication key.
+	retry    map[string]CrawlResult[I]
+	retrying map[string]CrawlResult[I]
 }
 
 func NewCrawlHandler[I PeerInfo[I]](cfg *CrawlHandlerConfig) *CrawlHandler[I] {
@@ -177,11 +225,26 @@ func NewCrawlHandler[I PeerInfo[I]](cfg *CrawlHandlerConfig) *CrawlHandler[I] {
 		ConnErrs:     make(map[string]int),
 		CrawlErrs:    make(map[string]int),
 		CrawledPeers: 0,
+		retry:        make(map[string]CrawlResult[I]),
+		retrying:     make(map[string]CrawlResult[I]),
 	}
 }
 
 func (h *CrawlHandler[I]) HandlePeerResult(ctx context.Context, result Result[CrawlResult[I]]) []I {
 	cr := result.Value
+	key := cr.Info.DeduplicationKey()
+
+	// If this is the result of a retried peer, it replaces the previous
+	// result in our statistics.
+	if prev, isRetry := h.retrying[key]; isRetry {
+		delete(h.retrying, key)
+		h.forget(prev)
+
+		h.RetriedPeers += 1
+		if cr.ConnectError == nil {
+			h.RetrySucceededPeers += 1
+		}
+	}
 
 	// count the number of peers that we have crawled
 	h.CrawledPeers += 1
@@ -197,12 +260,26 @@ func (h *CrawlHandler[I]) HandlePeerResult(ctx context.Context, result Result[Cr
 	if cr.ConnectError != nil {
 		// Count connection errors
 		h.ConnErrs[cr.ConnectErrorStr] += 1
+
+		// Remember the peer for the retry pass if the error is transient
+		if slices.Contains(h.cfg.RetryErrors, cr.ConnectErrorStr) {
+			h.retry[key] = cr
+		}
 	}
 
 	if cr.CrawlError != nil {
 		h.CrawlErrs[cr.CrawlErrorStr] += 1
 	}
 
+	// Estimate the network size from the keyspace density around the peer
+	if cr.RoutingTable != nil && len(cr.RoutingTable.Neighbors) > 0 {
+		neighborPrefixes := make([]uint64, len(cr.RoutingTable.Neighbors))
+		for i, n := range cr.RoutingTable.Neighbors {
+			neighborPrefixes[i] = n.DiscoveryPrefix()
+		}
+		h.NetworkSize.Add(cr.Info.DiscoveryPrefix(), neighborPrefixes)
+	}
+
 	// Schedule crawls of all found neighbors unless we got the routing table from the API.
 	// In this case, the routing table information won't include any MultiAddresses. This means
 	// we can't use these peers for further crawls.
@@ -213,6 +290,44 @@ func (h *CrawlHandler[I]) HandlePeerResult(ctx context.Context, result Result[Cr
 	return nil
 }
 
+// RetryTasks returns the peers that have failed with one of the configured
+// retry errors since the last call.
+func (h *CrawlHandler[I]) RetryTasks() []I {
+	tasks := make([]I, 0, len(h.retry))
+	for key, cr := range h.retry {
+		tasks = append(tasks, cr.Info)
+		h.retrying[key] = cr
+	}
+	clear(h.retry)
+
+	return tasks
+}
+
+// forget removes the given result from the aggregate statistics.
+func (h *CrawlHandler[I]) forget(cr CrawlResult[I]) {
+	h.CrawledPeers -= 1
+	decrement(h.AgentVersion, cr.Agent)
+	for _, p := range cr.Protocols {
+		decrement(h.Protocols, p)
+	}
+
+	if cr.ConnectError != nil {
+		decrement(h.ConnErrs, cr.ConnectErrorStr)
+	}
+
+	if cr.CrawlError != nil {
+		decrement(h.CrawlErrs, cr.CrawlErrorStr)
+	}
+}
+
+// decrement decreases the count of the given key and removes it if it
+// reaches zero.
+func decrement(m map[string]int, key string) {
+	if m[key] -= 1; m[key] <= 0 {
+		delete(m, key)
+	}
+}
+
 func (h *CrawlHandler[I]) HandleWriteResult(ctx context.Context, result Result[WriteResult]) {
 }
 
@@ -226,6 +341,10 @@ func (h *CrawlHandler[I]) Summary(state *EngineState) *Summary {
 		Protocols:       h.Protocols,
 		ConnErrs:        h.ConnErrs,
 		CrawlErrs:       h.CrawlErrs,
+		NetworkSize:     h.NetworkSize,
+
+		PeersRetried:        h.RetriedPeers,
+		PeersRetrySucceeded: h.RetrySucceededPeers,
 	}
 }
 
@@ -237,3 +356,40 @@ func (h *CrawlHandler[I]) TotalErrors() int {
 	}
 	return sum
 }
+
+// SummaryWithout returns the summary as if the handler had never seen the
+// given results. It works on copies of the aggregate statistics, so the
+// handler isn't modified.
+func (h *CrawlHandler[I]) SummaryWithout(state *EngineState, results []CrawlResult[I]) *Summary {
+	other := &CrawlHandler[I]{
+		AgentVersion:        maps.Clone(h.AgentVersion),
+		Protocols:           maps.Clone(h.Protocols),
+		ConnErrs:            maps.Clone(h.ConnErrs),
+		CrawlErrs:           maps.Clone(h.CrawlErrs),
+		CrawledPeers:        h.CrawledPeers,
+		NetworkSize:         h.NetworkSize,
+		RetriedPeers:        h.RetriedPeers,
+		RetrySucceededPeers: h.RetrySucceededPeers,
+	}
+
+	for _, cr := range results {
+		other.forget(cr)
+
+		if cr.RetryPass > 0 {
+			other.RetriedPeers -= 1
+			if cr.ConnectError == nil {
+				other.RetrySucceededPeers -= 1
+			}
+		}
+
+		if cr.RoutingTable != nil && len(cr.RoutingTable.Neighbors) > 0 {
+			neighborPrefixes := make([]uint64, len(cr.RoutingTable.Neighbors))
+			for i, n := range cr.RoutingTable.Neighbors {
+				neighborPrefixes[i] = n.DiscoveryPrefix()
+			}
+			other.NetworkSize.Remove(cr.Info.DiscoveryPrefix(), neighborPrefixes)
+		}
+	}
+
+	return other.Summary(state)
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"math"
+	"slices"
+)
+
+// netSizeNeighbors is the number of closest neighbors per peer that are
+// considered for the network size estimation. The closest neighbors are the
+// most reliable ones because the deep routing table buckets aren't full and
+// therefore contain all peers in that part of the keyspace.
+const netSizeNeighbors = 8
+
+// NetworkSize estimates the total number of peers in the network from the
+// density of the keyspace. If the keys of the N peers in the network are
+// uniformly distributed, the normalized XOR distance of a peer to its j-th
+// closest neighbor is j/N on average. Summing over the closest neighbors of
+// all crawled peers, N is estimated as the ratio of the summed ranks j and
+// the summed distances. Because it only relies on the routing tables of the
+// crawled peers and not on the number of found peers, it also works for
+// partial crawls, e.g., if the crawl was limited.
+//
+// The fields are exported, so that estimates survive serialization and can be
+// merged, e.g., when resuming from a checkpoint or in a distributed crawl.
+type NetworkSize struct {
+	// Samples is the number of peers that contributed to the estimate.
+	Samples int
+
+	// Ranks is the sum of the per-peer rank sums.
+	Ranks float64
+
+	// Distances is the sum of the per-peer distance sums.
+	Distances float64
+
+	// The following sums are required for the confidence interval.
+	RanksSquared     float64
+	DistancesSquared float64
+	Products         float64
+}
+
+// Add records the discovery prefixes of a peer and its routing table
+// neighbors. Peers without neighbors are ignored.
+func (n *NetworkSize) Add(prefix uint64, neighborPrefixes []uint64) {
+	ranks, dists, ok := keyspaceSums(prefix, neighborPrefixes)
+	if !ok {
+		return
+	}
+
+	n.Samples += 1
+	n.Ranks += ranks
+	n.Distances += dists
+	n.RanksSquared += ranks * ranks
+	n.DistancesSquared += dists * dists
+	n.Products += ranks * dists
+}
+
+// Remove reverts a previous call to Add with the same arguments.
+func (n *NetworkSize) Remove(prefix uint64, neighborPrefixes []uint64) {
+	ranks, dists, ok := keyspaceSums(prefix, neighborPrefixes)
+	if !ok {
+		return
+	}
+
+	n.Samples -= 1
+	n.Ranks -= ranks
+	n.Distances -= dists
+	n.RanksSquared -= ranks * ranks
+	n.DistancesSquared -= dists * dists
+	n.Products -= ranks * dists
+}
+
+// keyspaceSums returns the rank and distance sums of the closest neighbors of
+// a peer. It returns false if the peer has no neighbors.
+func keyspaceSums(prefix uint64, neighborPrefixes []uint64) (ranks, dists float64, ok bool) {
+	distances := make([]float64, 0, len(neighborPrefixes))
+	for _, np := range neighborPrefixes {
+		if d := prefix ^ np; d != 0 {
+			distances = append(distances, float64(d)/math.Exp2(64))
+		}
+	}
+
+	if len(distances) == 0 {
+		return 0, 0, false
+	}
+
+	slices.Sort(distances)
+	distances = distances[:min(len(distances), netSizeNeighbors)]
+
+	for j, d := range distances {
+		ranks += float64(j + 1)
+		dists += d
+	}
+
+	return ranks, dists, true
+}
+
+// Merge returns the combination of both estimates.
+func (n NetworkSize) Merge(other NetworkSize) NetworkSize {
+	return NetworkSize{
+		Samples:          n.Samples + other.Samples,
+		Ranks:            n.Ranks + other.Ranks,
+		Distances:        n.Distances + other.Distances,
+		RanksSquared:     n.RanksSquared + other.RanksSquared,
+		DistancesSquared: n.DistancesSquared + other.DistancesSquared,
+		Products:         n.Products + other.Products,
+	}
+}
+
+// Estimate returns the estimated number of peers in the network together
+// with the bounds of its 95% confidence interval. The interval assumes that
+// the peers' neighborhoods are independent, which they aren't entirely
+// because nearby peers share neighbors. All values are zero if fewer than two
+// peers were added.
+func (n NetworkSize) Estimate() (estimate, lower, upper float64) {
+	if n.Samples < 2 || n.Distances == 0 {
+		return 0, 0, 0
+	}
+
+	// ratio estimator and its standard error (delta method)
+	count := float64(n.Samples)
+	ratio := n.Ranks / n.Distances
+	residuals := n.RanksSquared - 2*ratio*n.Products + ratio*ratio*n.DistancesSquared
+	variance := max(0, residuals/(count-1))
+	margin := 1.96 * math.Sqrt(variance/count) / (n.Distances / count)
+
+	return ratio, max(0, ratio-margin), ratio + margin
+}
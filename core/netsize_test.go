@@ -0,0 +1,77 @@
+package core
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkSize_Estimate(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	for _, size := range []int{500, 5000} {
+		prefixes := make([]uint64, size)
+		for i := range prefixes {
+			prefixes[i] = rng.Uint64()
+		}
+
+		// every sampled peer knows all other peers, Add only considers the
+		// closest ones.
+		var ns NetworkSize
+		for _, prefix := range prefixes[:200] {
+			ns.Add(prefix, prefixes)
+		}
+
+		estimate, lower, upper := ns.Estimate()
+		assert.InDelta(t, size, estimate, 0.1*float64(size))
+		assert.Less(t, lower, float64(size))
+		assert.Greater(t, upper, float64(size))
+	}
+}
+
+func TestNetworkSize_Estimate_insufficient(t *testing.T) {
+	var ns NetworkSize
+
+	estimate, lower, upper := ns.Estimate()
+	assert.Zero(t, estimate)
+	assert.Zero(t, lower)
+	assert.Zero(t, upper)
+
+	// peers without (other) neighbors are ignored
+	ns.Add(1, nil)
+	ns.Add(1, []uint64{1})
+	assert.Zero(t, ns.Samples)
+
+	ns.Add(1, []uint64{2, 3})
+	estimate, _, _ = ns.Estimate()
+	assert.Zero(t, estimate)
+}
+
+func TestNetworkSize_Merge(t *testing.T) {
+	var a, b, all NetworkSize
+
+	a.Add(1, []uint64{1 << 60, 1 << 61})
+	all.Add(1, []uint64{1 << 60, 1 << 61})
+
+	b.Add(1<<62, []uint64{1 << 59, 1 << 58, 1 << 57})
+	all.Add(1<<62, []uint64{1 << 59, 1 << 58, 1 << 57})
+
+	assert.Equal(t, all, a.Merge(b))
+	assert.Equal(t, all, b.Merge(a))
+}
+
+func TestNetworkSize_Remove(t *testing.T) {
+	var ns, expected NetworkSize
+
+	ns.Add(1, []uint64{1 << 60, 1 << 61})
+	expected.Add(1, []uint64{1 << 60, 1 << 61})
+
+	ns.Add(2, []uint64{1 << 62, 3})
+	ns.Remove(2, []uint64{1 << 62, 3})
+
+	assert.Equal(t, expected.Samples, ns.Samples)
+	assert.InDelta(t, expected.Ranks, ns.Ranks, 1e-9)
+	assert.InDelta(t, expected.Distances, ns.Distances, 1e-9)
+	assert.InDelta(t, expected.Products, ns.Products, 1e-9)
+}
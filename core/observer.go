@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+// Observer is notified about every peer processing result of the [Engine].
+// In contrast to the [Handler], an observer can't influence the engine. It
+// is intended for consumers that want to follow a crawl or monitoring run in
+// real time, e.g., dashboards or alerting.
+type Observer interface {
+	// ObservePeerResult is called from the engine's event loop for every
+	// [CrawlResult] or [DialResult]. Implementations must not block.
+	ObservePeerResult(ctx context.Context, event *ResultEvent)
+}
+
+// Event types of the [ResultEvent].
+const (
+	ResultEventCrawl = "crawl"
+	ResultEventDial  = "dial"
+)
+
+// ResultEvent is a self-contained and JSON-serializable representation of a
+// peer processing result. Fields that don't apply to the event type are
+// omitted.
+type ResultEvent struct {
+	// Type is either [ResultEventCrawl] or [ResultEventDial]
+	Type string `json:"type"`
+
+	// the network of the processed peer, see [EngineConfig.Network]
+	Network string `json:"network,omitempty"`
+
+	// the identifier of the worker that has produced the result
+	WorkerID string `json:"worker_id"`
+
+	// the processed peer and its known addresses
+	PeerID string   `json:"peer_id"`
+	Maddrs []string `json:"maddrs"`
+
+	// whether the peer was processed successfully
+	Success bool `json:"success"`
+
+	// when the processing started and how long it took
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration_ns"`
+
+	// information that's only set for crawl results
+	AgentVersion string          `json:"agent_version,omitempty"`
+	Protocols    []string        `json:"protocols,omitempty"`
+	ConnectMaddr string          `json:"connect_maddr,omitempty"`
+	ConnectError string          `json:"connect_error,omitempty"`
+	CrawlError   string          `json:"crawl_error,omitempty"`
+	Neighbors    []string        `json:"neighbors,omitempty"`
+	Properties   json.RawMessage `json:"properties,omitempty"`
+
+	// information that's only set for dial results
+	DialError string `json:"dial_error,omitempty"`
+}
+
+// resultEventer is implemented by all [WorkResult]s that can be passed to
+// an [Observer].
+type resultEventer interface {
+	resultEvent() *ResultEvent
+}
+
+func (r CrawlResult[I]) resultEvent() *ResultEvent {
+	event := &ResultEvent{
+		Type:         ResultEventCrawl,
+		WorkerID:     r.CrawlerID,
+		PeerID:       r.Info.ID().String(),
+		Maddrs:       utils.MaddrsToAddrs(r.Info.Addrs()),
+		Success:      r.IsSuccess(),
+		StartTime:    r.CrawlStartTime,
+		Duration:     r.CrawlDuration(),
+		AgentVersion: r.Agent,
+		Protocols:    r.Protocols,
+		ConnectError: r.ConnectErrorStr,
+		CrawlError:   r.CrawlErrorStr,
+		Properties:   r.Properties,
+	}
+
+	if r.ConnectMaddr != nil {
+		event.ConnectMaddr = r.ConnectMaddr.String()
+	}
+
+	if r.RoutingTable != nil {
+		event.Neighbors = make([]string, 0, len(r.RoutingTable.Neighbors))
+		for _, n := range r.RoutingTable.Neighbors {
+			event.Neighbors = append(event.Neighbors, n.ID().String())
+		}
+	}
+
+	return event
+}
+
+func (r DialResult[I]) resultEvent() *ResultEvent {
+	return &ResultEvent{
+		Type:      ResultEventDial,
+		WorkerID:  r.DialerID,
+		PeerID:    r.Info.ID().String(),
+		Maddrs:    utils.MaddrsToAddrs(r.Info.Addrs()),
+		Success:   r.IsSuccess(),
+		StartTime: r.DialStartTime,
+		Duration:  r.DialDuration(),
+		DialError: r.DialError,
+	}
+}
+
+// notifyObservers passes the given peer processing result to all configured
+// observers.
+func (e *Engine[I, R]) notifyObservers(ctx context.Context, wr R) {
+	if len(e.cfg.Observers) == 0 {
+		return
+	}
+
+	eventer, ok := any(wr).(resultEventer)
+	if !ok {
+		return
+	}
+
+	event := eventer.resultEvent()
+	event.Network = e.cfg.Network
+	for _, o := range e.cfg.Observers {
+		o.ObservePeerResult(ctx, event)
+	}
+}
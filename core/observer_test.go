@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+type testObserver struct {
+	events []*ResultEvent
+}
+
+var _ Observer = (*testObserver)(nil)
+
+func (o *testObserver) ObservePeerResult(ctx context.Context, event *ResultEvent) {
+	o.events = append(o.events, event)
+}
+
+func TestCrawlResult_resultEvent(t *testing.T) {
+	start := time.Now()
+	maddr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	cr := CrawlResult[*testPeerInfo]{
+		CrawlerID: "crawler-01",
+		Info:      &testPeerInfo{peerID: peer.ID("peer"), addrs: []ma.Multiaddr{maddr}},
+		RoutingTable: &RoutingTable[*testPeerInfo]{
+			Neighbors: []*testPeerInfo{{peerID: peer.ID("neighbor")}},
+		},
+		Agent:            "kubo/0.30.0",
+		ConnectMaddr:     maddr,
+		CrawlError:       fmt.Errorf("some error"),
+		CrawlErrorStr:    pgmodels.NetErrorIoTimeout,
+		CrawlStartTime:   start,
+		CrawlEndTime:     start.Add(time.Second),
+		ConnectEndTime:   start.Add(time.Second),
+		ConnectStartTime: start,
+	}
+
+	event := cr.resultEvent()
+	assert.Equal(t, ResultEventCrawl, event.Type)
+	assert.Equal(t, "crawler-01", event.WorkerID)
+	assert.Equal(t, peer.ID("peer").String(), event.PeerID)
+	assert.Equal(t, []string{maddr.String()}, event.Maddrs)
+	assert.Equal(t, maddr.String(), event.ConnectMaddr)
+	assert.False(t, event.Success)
+	assert.Equal(t, pgmodels.NetErrorIoTimeout, event.CrawlError)
+	assert.Equal(t, []string{peer.ID("neighbor").String()}, event.Neighbors)
+	assert.Equal(t, time.Second, event.Duration)
+	assert.Empty(t, event.DialError)
+}
+
+func TestDialResult_resultEvent(t *testing.T) {
+	start := time.Now()
+
+	dr := DialResult[*testPeerInfo]{
+		DialerID:      "dialer-01",
+		Info:          &testPeerInfo{peerID: peer.ID("peer")},
+		DialStartTime: start,
+		DialEndTime:   start.Add(time.Second),
+	}
+
+	event := dr.resultEvent()
+	assert.Equal(t, ResultEventDial, event.Type)
+	assert.Equal(t, "dialer-01", event.WorkerID)
+	assert.True(t, event.Success)
+	assert.Equal(t, time.Second, event.Duration)
+	assert.Nil(t, event.Neighbors)
+}
+
+func TestNewEngine_Run_observers(t *testing.T) {
+	defer goleak.VerifyNone(t, goleakIgnore...)
+
+	ctx, cancel := context.WithCancel(context.Background()) // cancelCtx to satisfy mock.IsType below
+	defer cancel()
+
+	testPeer := &testPeerInfo{
+		peerID: peer.ID("test-peer"),
+		addrs:  []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/3000")},
+	}
+
+	tasksChan := make(chan *testPeerInfo, 1)
+	tasksChan <- testPeer
+	close(tasksChan)
+
+	cr := CrawlResult[*testPeerInfo]{
+		CrawlerID:    "1",
+		Info:         testPeer,
+		RoutingTable: &RoutingTable[*testPeerInfo]{},
+	}
+
+	crawler := newTestCrawler()
+	crawler.On("Work", mock.IsType(ctx), mock.IsType(testPeer)).Return(cr, nil)
+
+	writer := newTestWriter()
+	writer.On("Work", mock.Anything, mock.IsType(cr)).Return(WriteResult{}, nil)
+
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(crawler, nil)
+	driver.On("NewWriter").Return(writer, nil)
+	driver.On("Close").Times(1)
+	driver.On("Tasks").Return((<-chan *testPeerInfo)(tasksChan))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	observer := &testObserver{}
+	cfg := DefaultEngineConfig()
+	cfg.Observers = []Observer{observer}
+	cfg.Network = "IPFS"
+
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	require.NoError(t, err)
+
+	_, err = eng.Run(ctx)
+	require.NoError(t, err)
+
+	require.Len(t, observer.events, 1)
+	assert.Equal(t, ResultEventCrawl, observer.events[0].Type)
+	assert.Equal(t, testPeer.peerID.String(), observer.events[0].PeerID)
+	assert.Equal(t, "IPFS", observer.events[0].Network)
+}
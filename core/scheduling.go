@@ -0,0 +1,234 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	log "github.com/sirupsen/logrus"
+)
+
+// SchedulingPolicy decides whether the [Engine] may hand a peer to a worker
+// right away or whether it should defer processing that peer. Peers are
+// associated with arbitrary groups (e.g., IP prefixes). If a peer is not
+// allowed to be processed, the engine parks it until a peer of the blocking
+// group has finished processing.
+//
+// All methods are called from the engine's single event loop goroutine, so
+// implementations don't need to be safe for concurrent use.
+type SchedulingPolicy interface {
+	// Allow returns true if a peer with the given multi addresses can be
+	// processed right away. If it returns false, the first return value is
+	// the group that prevents the peer from being processed.
+	Allow(maddrs []ma.Multiaddr) (string, bool)
+
+	// Acquire is called when the peer with the given deduplication key was
+	// handed to a worker.
+	Acquire(key string, maddrs []ma.Multiaddr)
+
+	// Release is called when the peer with the given deduplication key has
+	// finished processing. It returns the groups whose capacity has become
+	// available again.
+	Release(key string) []string
+}
+
+// ASNLookup resolves the autonomous system number of the given IP address.
+type ASNLookup func(ip net.IP) (uint, error)
+
+// PrefixPolicyConfig configures the [PrefixPolicy].
+type PrefixPolicyConfig struct {
+	// the maximum number of peers in the same IP prefix that can be processed
+	// concurrently. 0 disables the limit.
+	MaxPerPrefix int
+
+	// the prefix length to group IPv4 addresses by.
+	IPv4PrefixLen int
+
+	// the prefix length to group IPv6 addresses by.
+	IPv6PrefixLen int
+
+	// the maximum number of peers in the same autonomous system that can be
+	// processed concurrently. 0 disables the limit. This requires ASNLookup
+	// to be set.
+	MaxPerASN int
+
+	// a function that resolves an IP address to its autonomous system number.
+	ASNLookup ASNLookup
+}
+
+// DefaultPrefixPolicyConfig returns the default configuration for the
+// [PrefixPolicy]. It doesn't limit anything.
+func DefaultPrefixPolicyConfig() *PrefixPolicyConfig {
+	return &PrefixPolicyConfig{
+		MaxPerPrefix:  0,
+		IPv4PrefixLen: 24,
+		IPv6PrefixLen: 48,
+		MaxPerASN:     0,
+		ASNLookup:     nil,
+	}
+}
+
+// Validate verifies the prefix policy configuration's invariants.
+func (cfg *PrefixPolicyConfig) Validate() error {
+	if cfg.MaxPerPrefix < 0 {
+		return fmt.Errorf("max peers per prefix must not be negative")
+	}
+
+	if cfg.MaxPerASN < 0 {
+		return fmt.Errorf("max peers per ASN must not be negative")
+	}
+
+	if cfg.IPv4PrefixLen <= 0 || cfg.IPv4PrefixLen > 8*net.IPv4len {
+		return fmt.Errorf("invalid IPv4 prefix length %d", cfg.IPv4PrefixLen)
+	}
+
+	if cfg.IPv6PrefixLen <= 0 || cfg.IPv6PrefixLen > 8*net.IPv6len {
+		return fmt.Errorf("invalid IPv6 prefix length %d", cfg.IPv6PrefixLen)
+	}
+
+	if cfg.MaxPerASN > 0 && cfg.ASNLookup == nil {
+		return fmt.Errorf("max peers per ASN requires an ASN lookup")
+	}
+
+	return nil
+}
+
+// PrefixPolicy is a [SchedulingPolicy] that caps the number of concurrently
+// processed peers that share an IP prefix and, optionally, an autonomous
+// system. This prevents the engine from sending hundreds of concurrent
+// requests to the same hosting provider which would get us rate-limited and
+// skew the connection error statistics. Only public IP addresses are
+// considered. Peers without public IP addresses are never deferred.
+type PrefixPolicy struct {
+	cfg *PrefixPolicyConfig
+
+	// the number of peers that are currently processed per group
+	counts map[string]int
+
+	// the groups that each inflight peer has acquired
+	acquired map[string][]string
+}
+
+var _ SchedulingPolicy = (*PrefixPolicy)(nil)
+
+// NewPrefixPolicy initializes a new [PrefixPolicy].
+func NewPrefixPolicy(cfg *PrefixPolicyConfig) (*PrefixPolicy, error) {
+	if cfg == nil {
+		cfg = DefaultPrefixPolicyConfig()
+	} else if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	return &PrefixPolicy{
+		cfg:      cfg,
+		counts:   map[string]int{},
+		acquired: map[string][]string{},
+	}, nil
+}
+
+// Allow returns false if any of the groups that the multi addresses belong to
+// has reached its limit.
+func (p *PrefixPolicy) Allow(maddrs []ma.Multiaddr) (string, bool) {
+	for _, group := range p.groups(maddrs) {
+		if p.counts[group] >= p.limit(group) {
+			return group, false
+		}
+	}
+	return "", true
+}
+
+// Acquire increments the counters of all groups that the multi addresses
+// belong to.
+func (p *PrefixPolicy) Acquire(key string, maddrs []ma.Multiaddr) {
+	groups := p.groups(maddrs)
+	if len(groups) == 0 {
+		return
+	}
+
+	for _, group := range groups {
+		p.counts[group] += 1
+	}
+	p.acquired[key] = groups
+}
+
+// Release decrements the counters of all groups that the peer has acquired.
+func (p *PrefixPolicy) Release(key string) []string {
+	groups, found := p.acquired[key]
+	if !found {
+		return nil
+	}
+	delete(p.acquired, key)
+
+	for _, group := range groups {
+		p.counts[group] -= 1
+		if p.counts[group] <= 0 {
+			delete(p.counts, group)
+		}
+	}
+
+	return groups
+}
+
+// limit returns the maximum number of concurrently processed peers of the
+// given group.
+func (p *PrefixPolicy) limit(group string) int {
+	if strings.HasPrefix(group, "asn:") {
+		return p.cfg.MaxPerASN
+	}
+	return p.cfg.MaxPerPrefix
+}
+
+// groups returns the deduplicated list of groups the given multi addresses
+// belong to.
+func (p *PrefixPolicy) groups(maddrs []ma.Multiaddr) []string {
+	if p.cfg.MaxPerPrefix == 0 && p.cfg.MaxPerASN == 0 {
+		return nil
+	}
+
+	seen := map[string]struct{}{}
+	groups := make([]string, 0, len(maddrs))
+	add := func(group string) {
+		if _, found := seen[group]; found {
+			return
+		}
+		seen[group] = struct{}{}
+		groups = append(groups, group)
+	}
+
+	for _, maddr := range maddrs {
+		if !manet.IsPublicAddr(maddr) {
+			continue
+		}
+
+		ip, err := manet.ToIP(maddr)
+		if err != nil {
+			continue
+		}
+
+		if p.cfg.MaxPerPrefix > 0 {
+			mask := net.CIDRMask(p.cfg.IPv6PrefixLen, 8*net.IPv6len)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				mask = net.CIDRMask(p.cfg.IPv4PrefixLen, 8*net.IPv4len)
+			}
+			prefix := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+			add("net:" + prefix.String())
+		}
+
+		if p.cfg.MaxPerASN > 0 {
+			asn, err := p.cfg.ASNLookup(ip)
+			if err != nil {
+				log.WithError(err).WithField("ip", ip).Debugln("Could not resolve ASN")
+				continue
+			} else if asn == 0 {
+				continue
+			}
+			add("asn:" + strconv.FormatUint(uint64(asn), 10))
+		}
+	}
+
+	return groups
+}
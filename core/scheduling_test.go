@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+func TestPrefixPolicyConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfgFn   func(*PrefixPolicyConfig)
+		wantErr bool
+	}{
+		{name: "default", cfgFn: func(cfg *PrefixPolicyConfig) {}, wantErr: false},
+		{name: "negative prefix limit", cfgFn: func(cfg *PrefixPolicyConfig) { cfg.MaxPerPrefix = -1 }, wantErr: true},
+		{name: "negative asn limit", cfgFn: func(cfg *PrefixPolicyConfig) { cfg.MaxPerASN = -1 }, wantErr: true},
+		{name: "zero ipv4 prefix", cfgFn: func(cfg *PrefixPolicyConfig) { cfg.IPv4PrefixLen = 0 }, wantErr: true},
+		{name: "large ipv4 prefix", cfgFn: func(cfg *PrefixPolicyConfig) { cfg.IPv4PrefixLen = 33 }, wantErr: true},
+		{name: "large ipv6 prefix", cfgFn: func(cfg *PrefixPolicyConfig) { cfg.IPv6PrefixLen = 129 }, wantErr: true},
+		{name: "asn without lookup", cfgFn: func(cfg *PrefixPolicyConfig) { cfg.MaxPerASN = 1 }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultPrefixPolicyConfig()
+			tt.cfgFn(cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrefixPolicy(t *testing.T) {
+	cfg := DefaultPrefixPolicyConfig()
+	cfg.MaxPerPrefix = 2
+
+	policy, err := NewPrefixPolicy(cfg)
+	require.NoError(t, err)
+
+	maddrs1 := []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.1/tcp/4001")}
+	maddrs2 := []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.2/udp/4001/quic-v1")}
+	maddrs3 := []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.3/tcp/4001")}
+	other := []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.4.1/tcp/4001")}
+	private := []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/192.168.0.1/tcp/4001")}
+
+	_, allowed := policy.Allow(maddrs1)
+	assert.True(t, allowed)
+	policy.Acquire("1", maddrs1)
+
+	_, allowed = policy.Allow(maddrs2)
+	assert.True(t, allowed)
+	policy.Acquire("2", maddrs2)
+
+	group, allowed := policy.Allow(maddrs3)
+	assert.False(t, allowed)
+	assert.Equal(t, "net:1.2.3.0/24", group)
+
+	// different prefix and private addresses are not affected
+	_, allowed = policy.Allow(other)
+	assert.True(t, allowed)
+	_, allowed = policy.Allow(private)
+	assert.True(t, allowed)
+
+	assert.Equal(t, []string{"net:1.2.3.0/24"}, policy.Release("1"))
+	assert.Nil(t, policy.Release("1"))
+
+	_, allowed = policy.Allow(maddrs3)
+	assert.True(t, allowed)
+}
+
+func TestPrefixPolicy_ipv6(t *testing.T) {
+	cfg := DefaultPrefixPolicyConfig()
+	cfg.MaxPerPrefix = 1
+
+	policy, err := NewPrefixPolicy(cfg)
+	require.NoError(t, err)
+
+	policy.Acquire("1", []ma.Multiaddr{utils.MustMultiaddr(t, "/ip6/2a01:4f8:1:1::1/tcp/4001")})
+
+	group, allowed := policy.Allow([]ma.Multiaddr{utils.MustMultiaddr(t, "/ip6/2a01:4f8:1:2::1/tcp/4001")})
+	assert.False(t, allowed)
+	assert.Equal(t, "net:2a01:4f8:1::/48", group)
+
+	_, allowed = policy.Allow([]ma.Multiaddr{utils.MustMultiaddr(t, "/ip6/2a01:4f8:2::1/tcp/4001")})
+	assert.True(t, allowed)
+}
+
+func TestPrefixPolicy_asn(t *testing.T) {
+	cfg := DefaultPrefixPolicyConfig()
+	cfg.MaxPerASN = 1
+	cfg.ASNLookup = func(ip net.IP) (uint, error) {
+		switch ip.String() {
+		case "1.1.1.1", "2.2.2.2":
+			return 1234, nil
+		default:
+			return 0, fmt.Errorf("unknown ip")
+		}
+	}
+
+	policy, err := NewPrefixPolicy(cfg)
+	require.NoError(t, err)
+
+	policy.Acquire("1", []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.1.1.1/tcp/4001")})
+
+	group, allowed := policy.Allow([]ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/2.2.2.2/tcp/4001")})
+	assert.False(t, allowed)
+	assert.Equal(t, "asn:1234", group)
+
+	_, allowed = policy.Allow([]ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/3.3.3.3/tcp/4001")})
+	assert.True(t, allowed)
+}
+
+func TestNewEngine_Run_scheduling_policy(t *testing.T) {
+	defer goleak.VerifyNone(t, goleakIgnore...)
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancel := context.WithCancel(context.Background()) // cancelCtx to satisfy mock.IsType below
+	defer cancel()
+
+	peers := []*testPeerInfo{
+		{peerID: peer.ID("1"), addrs: []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.1/tcp/4001")}},
+		{peerID: peer.ID("2"), addrs: []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.2/tcp/4001")}},
+		{peerID: peer.ID("3"), addrs: []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.3/tcp/4001")}},
+		{peerID: peer.ID("4"), addrs: []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.4/tcp/4001")}},
+	}
+
+	tasksChan := make(chan *testPeerInfo, len(peers))
+	for _, p := range peers {
+		tasksChan <- p
+	}
+	close(tasksChan)
+
+	// track the maximum number of concurrently crawled peers in the same prefix
+	var (
+		current  atomic.Int32
+		maxCount atomic.Int32
+	)
+
+	crawler := newTestCrawler()
+	call := crawler.On("Work", mock.IsType(ctx), mock.IsType(&testPeerInfo{}))
+	call.RunFn = func(args mock.Arguments) {
+		count := current.Add(1)
+		for {
+			prev := maxCount.Load()
+			if count <= prev || maxCount.CompareAndSwap(prev, count) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		current.Add(-1)
+
+		info := args.Get(1).(*testPeerInfo)
+		call.ReturnArguments = mock.Arguments{CrawlResult[*testPeerInfo]{
+			CrawlerID:    "1",
+			Info:         info,
+			RoutingTable: &RoutingTable[*testPeerInfo]{PeerID: info.peerID},
+		}, nil}
+	}
+
+	writer := newTestWriter()
+	writer.On("Work", mock.Anything, mock.IsType(CrawlResult[*testPeerInfo]{})).Return(WriteResult{}, nil)
+
+	driver := &testDriver{}
+	driver.On("NewWorker").Return(crawler, nil)
+	driver.On("NewWriter").Return(writer, nil)
+	driver.On("Close").Times(1)
+	driver.On("Tasks").Return((<-chan *testPeerInfo)(tasksChan))
+
+	handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+
+	policyCfg := DefaultPrefixPolicyConfig()
+	policyCfg.MaxPerPrefix = 1
+	policy, err := NewPrefixPolicy(policyCfg)
+	require.NoError(t, err)
+
+	cfg := DefaultEngineConfig()
+	cfg.WorkerCount = len(peers)
+	cfg.SchedulingPolicy = policy
+	eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+	require.NoError(t, err)
+
+	summary, err := eng.Run(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, len(peers), summary.PeersCrawled)
+	assert.EqualValues(t, 1, maxCount.Load())
+	assert.Len(t, eng.deferred, 0)
+	assert.Len(t, eng.deferredGroups, 0)
+}
@@ -0,0 +1,293 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShardConfig configures the [Engine] to only process a slice of the
+// network. The keyspace of discovery prefixes (see [PeerInfo.DiscoveryPrefix])
+// is split into Count equally sized, contiguous ranges. The engine only
+// processes peers whose discovery prefix falls into the range with the given
+// Index. All other peers are forwarded to the owning shard via the Transport.
+type ShardConfig struct {
+	// the index of the range of the keyspace that this engine is responsible
+	// for. Must be in [0, Count).
+	Index int
+
+	// the total number of shards that participate in the crawl.
+	Count int
+
+	// the transport that exchanges peers between shards.
+	Transport ShardTransport
+}
+
+// Validate verifies the shard configuration's invariants.
+func (cfg *ShardConfig) Validate() error {
+	if cfg.Count <= 0 {
+		return fmt.Errorf("shard count must not be zero or negative")
+	}
+
+	if cfg.Index < 0 || cfg.Index >= cfg.Count {
+		return fmt.Errorf("shard index %d out of range [0, %d)", cfg.Index, cfg.Count)
+	}
+
+	if cfg.Transport == nil {
+		return fmt.Errorf("shard transport must not be nil")
+	}
+
+	return nil
+}
+
+// ShardTransport exchanges peers between engines that crawl different
+// slices of the keyspace. Because the [EngineConfig] isn't generic on the
+// [PeerInfo] type, peers are passed as values of type any. Transports that
+// send peers over the wire need to know how to encode and decode the
+// concrete [PeerInfo] type.
+type ShardTransport interface {
+	// Forward hands the given peer to the shard with the given index. It
+	// must not block because it's called from the engine's event loop.
+	Forward(shard int, task any)
+
+	// Tasks returns a channel on which the transport emits peers that other
+	// shards have forwarded to this shard. The transport closes the channel
+	// when all shards have run out of work which terminates the engine.
+	Tasks() <-chan any
+
+	// SetIdle is called by the engine whenever it runs out of work (true)
+	// or receives new work (false).
+	SetIdle(idle bool)
+}
+
+// ShardStatus is a snapshot of the activity of a single shard. It's used to
+// detect when a distributed crawl has finished.
+type ShardStatus struct {
+	// Idle indicates whether the shard has run out of work.
+	Idle bool
+
+	// Sent is the number of peers that the shard has forwarded to other shards.
+	Sent uint64
+
+	// Received is the number of forwarded peers that the shard has handed
+	// to its engine. Transports must count each forwarded peer only once,
+	// even if it was delivered multiple times.
+	Received uint64
+}
+
+// ShardsTerminated returns true if the distributed crawl has finished. This
+// is the case if all shards were idle in two consecutive snapshots, no shard
+// has sent or received any peers in between, and all forwarded peers were
+// received. Requiring two identical snapshots prevents us from terminating
+// early because a shard has just received a peer but hasn't reported that
+// it's busy again.
+func ShardsTerminated(prev []ShardStatus, cur []ShardStatus) bool {
+	if len(prev) == 0 || len(prev) != len(cur) {
+		return false
+	}
+
+	var sent, received uint64
+	for i := range cur {
+		if !prev[i].Idle || !cur[i].Idle || prev[i] != cur[i] {
+			return false
+		}
+		sent += cur[i].Sent
+		received += cur[i].Received
+	}
+
+	return sent == received
+}
+
+// ShardOf returns the index of the shard whose range of the keyspace contains
+// the given discovery prefix.
+func ShardOf(prefix uint64, count int) int {
+	hi, _ := bits.Mul64(prefix, uint64(count))
+	return int(hi)
+}
+
+// ShardRange returns the first and last discovery prefix (both inclusive)
+// that the shard with the given index is responsible for.
+func ShardRange(index int, count int) (uint64, uint64) {
+	start := func(i int) uint64 {
+		q, r := bits.Div64(uint64(i), 0, uint64(count))
+		if r > 0 {
+			q += 1
+		}
+		return q
+	}
+
+	if index == count-1 {
+		return start(index), math.MaxUint64
+	}
+
+	return start(index), start(index+1) - 1
+}
+
+// LoopbackNetwork connects multiple engines in the same process. It's mainly
+// intended for testing distributed crawls.
+type LoopbackNetwork struct {
+	shards   []*loopbackTransport
+	interval time.Duration
+}
+
+// NewLoopbackNetwork initializes a new [LoopbackNetwork] with the given
+// number of shards. The interval determines how often the network checks
+// whether all shards have run out of work.
+func NewLoopbackNetwork(count int, interval time.Duration) *LoopbackNetwork {
+	n := &LoopbackNetwork{
+		shards:   make([]*loopbackTransport, count),
+		interval: interval,
+	}
+
+	for i := range n.shards {
+		n.shards[i] = &loopbackTransport{
+			network: n,
+			tasks:   make(chan any),
+			notify:  make(chan struct{}, 1),
+			done:    make(chan struct{}),
+		}
+	}
+
+	return n
+}
+
+// Transport returns the [ShardTransport] for the shard with the given index.
+func (n *LoopbackNetwork) Transport(index int) ShardTransport {
+	return n.shards[index]
+}
+
+// Run delivers forwarded peers and blocks until all shards have run out of
+// work or the given context was cancelled. In both cases, it closes the
+// tasks channels of all transports.
+func (n *LoopbackNetwork) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range n.shards {
+		wg.Add(1)
+		go func(s *loopbackTransport) {
+			defer wg.Done()
+			s.deliver()
+		}(s)
+	}
+
+	defer func() {
+		for _, s := range n.shards {
+			close(s.done)
+		}
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	var prev []ShardStatus
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur := make([]ShardStatus, len(n.shards))
+		for i, s := range n.shards {
+			cur[i] = s.status()
+		}
+
+		if ShardsTerminated(prev, cur) {
+			return
+		}
+		prev = cur
+	}
+}
+
+// loopbackTransport is the [ShardTransport] of a single shard in a
+// [LoopbackNetwork].
+type loopbackTransport struct {
+	network *LoopbackNetwork
+
+	// forwarded peers that were not yet delivered to the engine
+	mu    sync.Mutex
+	queue []any
+	idle  bool
+
+	sent     atomic.Uint64
+	received atomic.Uint64
+
+	tasks  chan any
+	notify chan struct{}
+	done   chan struct{}
+}
+
+var _ ShardTransport = (*loopbackTransport)(nil)
+
+func (t *loopbackTransport) Forward(shard int, task any) {
+	t.sent.Add(1)
+
+	target := t.network.shards[shard]
+	target.mu.Lock()
+	target.queue = append(target.queue, task)
+	target.mu.Unlock()
+
+	select {
+	case target.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (t *loopbackTransport) Tasks() <-chan any {
+	return t.tasks
+}
+
+func (t *loopbackTransport) SetIdle(idle bool) {
+	t.mu.Lock()
+	t.idle = idle
+	t.mu.Unlock()
+}
+
+func (t *loopbackTransport) status() ShardStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return ShardStatus{
+		Idle:     t.idle,
+		Sent:     t.sent.Load(),
+		Received: t.received.Load(),
+	}
+}
+
+// deliver hands queued peers to the engine until the network has finished.
+func (t *loopbackTransport) deliver() {
+	defer close(t.tasks)
+
+	for {
+		t.mu.Lock()
+		var (
+			task  any
+			found bool
+		)
+		if len(t.queue) > 0 {
+			task, found = t.queue[0], true
+			t.queue = t.queue[1:]
+		}
+		t.mu.Unlock()
+
+		if !found {
+			select {
+			case <-t.notify:
+				continue
+			case <-t.done:
+				return
+			}
+		}
+
+		select {
+		case t.tasks <- task:
+			t.received.Add(1)
+		case <-t.done:
+			return
+		}
+	}
+}
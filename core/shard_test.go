@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+func TestShardOf(t *testing.T) {
+	assert.Equal(t, 0, ShardOf(0, 1))
+	assert.Equal(t, 0, ShardOf(math.MaxUint64, 1))
+
+	assert.Equal(t, 0, ShardOf(0, 2))
+	assert.Equal(t, 0, ShardOf(math.MaxUint64/2, 2))
+	assert.Equal(t, 1, ShardOf(math.MaxUint64/2+1, 2))
+	assert.Equal(t, 1, ShardOf(math.MaxUint64, 2))
+
+	for _, count := range []int{1, 2, 3, 7, 16} {
+		for i := 0; i < count; i++ {
+			start, end := ShardRange(i, count)
+			assert.Equal(t, i, ShardOf(start, count))
+			assert.Equal(t, i, ShardOf(end, count))
+			if i > 0 {
+				assert.Equal(t, i-1, ShardOf(start-1, count))
+			}
+			if i < count-1 {
+				assert.Equal(t, i+1, ShardOf(end+1, count))
+			} else {
+				assert.Equal(t, uint64(math.MaxUint64), end)
+			}
+		}
+	}
+}
+
+func TestShardConfig_Validate(t *testing.T) {
+	transport := NewLoopbackNetwork(2, time.Second).Transport(0)
+	assert.NoError(t, (&ShardConfig{Index: 1, Count: 2, Transport: transport}).Validate())
+	assert.Error(t, (&ShardConfig{Index: 2, Count: 2, Transport: transport}).Validate())
+	assert.Error(t, (&ShardConfig{Index: -1, Count: 2, Transport: transport}).Validate())
+	assert.Error(t, (&ShardConfig{Index: 0, Count: 0, Transport: transport}).Validate())
+	assert.Error(t, (&ShardConfig{Index: 0, Count: 2}).Validate())
+}
+
+func TestShardsTerminated(t *testing.T) {
+	idle := []ShardStatus{{Idle: true, Sent: 2, Received: 1}, {Idle: true, Sent: 1, Received: 2}}
+	busy := []ShardStatus{{Idle: false, Sent: 2, Received: 1}, {Idle: true, Sent: 1, Received: 2}}
+	pending := []ShardStatus{{Idle: true, Sent: 3, Received: 1}, {Idle: true, Sent: 1, Received: 2}}
+	changed := []ShardStatus{{Idle: true, Sent: 3, Received: 1}, {Idle: true, Sent: 1, Received: 3}}
+
+	assert.False(t, ShardsTerminated(nil, idle))
+	assert.True(t, ShardsTerminated(idle, idle))
+	assert.False(t, ShardsTerminated(busy, idle))
+	assert.False(t, ShardsTerminated(idle, busy))
+	assert.False(t, ShardsTerminated(pending, pending))
+	assert.False(t, ShardsTerminated(idle, changed))
+	assert.True(t, ShardsTerminated(changed, changed))
+}
+
+func TestNewEngine_Run_sharded(t *testing.T) {
+	defer goleak.VerifyNone(t, goleakIgnore...)
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancel := context.WithCancel(context.Background()) // cancelCtx to satisfy mock.IsType below
+	defer cancel()
+
+	newPeer := func(id string, prefix uint64) *testPeerInfo {
+		return &testPeerInfo{
+			peerID: peer.ID(id),
+			addrs:  []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/127.0.0.1/tcp/3000")},
+			prefix: prefix,
+		}
+	}
+
+	// peers a and d belong to shard 0. Peers b and c belong to shard 1.
+	a := newPeer("a", 0)
+	b := newPeer("b", math.MaxUint64)
+	c := newPeer("c", math.MaxUint64-1)
+	d := newPeer("d", 1)
+
+	neighbors := map[peer.ID][]*testPeerInfo{
+		a.peerID: {b, c},
+		b.peerID: {a, d},
+		c.peerID: {d},
+		d.peerID: {},
+	}
+
+	network := NewLoopbackNetwork(2, 10*time.Millisecond)
+
+	var (
+		mu      sync.Mutex
+		crawled = map[int][]peer.ID{}
+	)
+
+	engines := make([]*Engine[*testPeerInfo, CrawlResult[*testPeerInfo]], 2)
+	for i := range engines {
+		shard := i
+
+		// only the first shard knows about bootstrap peers and it knows
+		// about one in each shard.
+		tasksChan := make(chan *testPeerInfo, 2)
+		if shard == 0 {
+			tasksChan <- a
+			tasksChan <- b
+		}
+		close(tasksChan)
+
+		crawler := newTestCrawler()
+		call := crawler.On("Work", mock.IsType(ctx), mock.IsType(&testPeerInfo{}))
+		call.RunFn = func(args mock.Arguments) {
+			info := args.Get(1).(*testPeerInfo)
+
+			mu.Lock()
+			crawled[shard] = append(crawled[shard], info.peerID)
+			mu.Unlock()
+
+			call.ReturnArguments = mock.Arguments{CrawlResult[*testPeerInfo]{
+				CrawlerID: "1",
+				Info:      info,
+				RoutingTable: &RoutingTable[*testPeerInfo]{
+					PeerID:    info.peerID,
+					Neighbors: neighbors[info.peerID],
+				},
+			}, nil}
+		}
+
+		writer := newTestWriter()
+		writer.On("Work", mock.Anything, mock.IsType(CrawlResult[*testPeerInfo]{})).Return(WriteResult{}, nil)
+
+		driver := &testDriver{}
+		driver.On("NewWorker").Return(crawler, nil)
+		driver.On("NewWriter").Return(writer, nil)
+		driver.On("Close").Times(1)
+		driver.On("Tasks").Return((<-chan *testPeerInfo)(tasksChan))
+
+		cfg := DefaultEngineConfig()
+		cfg.WorkerCount = 1
+		cfg.Shard = &ShardConfig{
+			Index:     shard,
+			Count:     2,
+			Transport: network.Transport(shard),
+		}
+
+		handler := NewCrawlHandler[*testPeerInfo](&CrawlHandlerConfig{})
+		eng, err := NewEngine[*testPeerInfo, CrawlResult[*testPeerInfo]](driver, handler, cfg)
+		require.NoError(t, err)
+		engines[i] = eng
+	}
+
+	networkDone := make(chan struct{})
+	go func() {
+		network.Run(ctx)
+		close(networkDone)
+	}()
+
+	var wg sync.WaitGroup
+	summaries := make([]*Summary, len(engines))
+	for i, eng := range engines {
+		wg.Add(1)
+		go func(i int, eng *Engine[*testPeerInfo, CrawlResult[*testPeerInfo]]) {
+			defer wg.Done()
+			summary, err := eng.Run(ctx)
+			assert.NoError(t, err)
+			summaries[i] = summary
+		}(i, eng)
+	}
+	wg.Wait()
+	<-networkDone
+
+	assert.ElementsMatch(t, []peer.ID{"a", "d"}, crawled[0])
+	assert.ElementsMatch(t, []peer.ID{"b", "c"}, crawled[1])
+	assert.Equal(t, 2, summaries[0].PeersCrawled)
+	assert.Equal(t, 2, summaries[1].PeersCrawled)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 
@@ -29,16 +30,21 @@ type telemetry[I PeerInfo[I], R WorkResult[I]] struct {
 	obsChan   chan func(*Engine[I, R])
 	obsCancel context.CancelFunc
 
+	// attributes that are attached to all metrics of the engine
+	attrs []attribute.KeyValue
+
 	// counts the number of visits we have performed
 	visitCount metric.Int64Counter
 	taskCount  metric.Int64Counter
 	shutdown   chan struct{}
-	done       sync.WaitGroup
+	done       *sync.WaitGroup
 }
 
-func newTelemetry[I PeerInfo[I], R WorkResult[I]](tp trace.TracerProvider, mp metric.MeterProvider) (*telemetry[I, R], error) {
+func newTelemetry[I PeerInfo[I], R WorkResult[I]](tp trace.TracerProvider, mp metric.MeterProvider, attrs []attribute.KeyValue) (*telemetry[I, R], error) {
 	meter := mp.Meter(tele.MeterName)
 
+	attrOpt := metric.WithAttributes(attrs...)
+
 	shutdown := make(chan struct{})
 
 	obsChan := make(chan func(*Engine[I, R]))
@@ -52,40 +58,61 @@ func newTelemetry[I PeerInfo[I], R WorkResult[I]](tp trace.TracerProvider, mp me
 			name:        "visit_queue_length",
 			description: "Number of peers in the queue to visit",
 			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
-				o.Observe(int64(e.peerQueue.Len()))
+				o.Observe(int64(e.peerQueue.Len()), attrOpt)
+			},
+		},
+		{
+			name:        "deferred_queue_length",
+			description: "Number of peers that the scheduling policy has deferred",
+			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
+				o.Observe(int64(len(e.deferredGroups)), attrOpt)
+			},
+		},
+		{
+			name:        "forwarded_peers",
+			description: "Number of peers that were forwarded to other shards",
+			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
+				o.Observe(int64(len(e.forwarded)), attrOpt)
 			},
 		},
 		{
 			name:        "inflight_queue_length",
 			description: "Number of inflight crawls",
 			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
-				o.Observe(int64(len(e.inflight)))
+				o.Observe(int64(len(e.inflight)), attrOpt)
+			},
+		},
+		{
+			name:        "worker_target",
+			description: "Number of workers that are allowed to process peers concurrently",
+			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
+				o.Observe(int64(e.workerPool.Limit()), attrOpt)
 			},
 		},
 		{
 			name:        "processed_peers",
 			description: "Number of processed peers",
 			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
-				o.Observe(int64(len(e.processed)))
+				o.Observe(int64(len(e.processed)), attrOpt)
 			},
 		},
 		{
 			name:        "write_queue_length",
 			description: "Number of processing results ready to be written to the DB",
 			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
-				o.Observe(int64(e.writeQueue.Len()))
+				o.Observe(int64(e.writeQueue.Len()), attrOpt)
 			},
 		},
 		{
 			name:        "written_results",
 			description: "Number of written peer results",
 			observeFn: func(o metric.Int64Observer, e *Engine[I, R]) {
-				o.Observe(int64(e.writeCount))
+				o.Observe(int64(e.writeCount), attrOpt)
 			},
 		},
 	}
 
-	var done sync.WaitGroup
+	done := &sync.WaitGroup{}
 	for _, gauge := range gauges {
 		gauge := gauge
 		_, err := meter.Int64ObservableGauge(
@@ -134,6 +161,7 @@ func newTelemetry[I PeerInfo[I], R WorkResult[I]](tp trace.TracerProvider, mp me
 		obsChan:    obsChan,
 		shutdown:   shutdown,
 		done:       done,
+		attrs:      attrs,
 		visitCount: visitCount,
 		taskCount:  engineTaskCount,
 	}, nil
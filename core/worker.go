@@ -10,13 +10,26 @@ type Pool[T any, R any] struct {
 	start   sync.Once
 	results chan Result[R]
 	workers []Worker[T, R]
+
+	// the below fields restrict the number of workers that may process tasks
+	// concurrently. By default, all workers are allowed to work. Workers
+	// above the limit wait on cond until capacity becomes available or until
+	// the tasks channel was closed (indicated by done).
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+	done   bool
 }
 
 func NewPool[T any, R any](workers ...Worker[T, R]) *Pool[T, R] {
-	return &Pool[T, R]{
+	p := &Pool[T, R]{
 		results: make(chan Result[R]),
 		workers: workers,
+		limit:   len(workers),
 	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
 }
 
 // Start takes a channel on which tasks will be scheduled. It is guaranteed that
@@ -30,14 +43,21 @@ func (w *Pool[T, R]) Start(ctx context.Context, tasks <-chan T) <-chan Result[R]
 			wg.Add(1)
 			worker := worker
 			go func() {
-				for task := range tasks {
+				defer wg.Done()
+				for w.acquire() {
+					task, more := <-tasks
+					if !more {
+						w.finish()
+						return
+					}
+
 					result, err := worker.Work(ctx, task)
 					w.results <- Result[R]{
 						Value: result,
 						Error: err,
 					}
+					w.release()
 				}
-				wg.Done()
 			}()
 		}
 
@@ -53,3 +73,68 @@ func (w *Pool[T, R]) Start(ctx context.Context, tasks <-chan T) <-chan Result[R]
 func (w *Pool[T, R]) Size() int {
 	return len(w.workers)
 }
+
+// SetLimit restricts the number of workers that process tasks concurrently.
+// The limit is clamped between one and the number of workers in the pool.
+// Lowering the limit doesn't interrupt workers that are currently busy. They
+// will just not pick up new tasks until the number of active workers has
+// dropped below the new limit.
+func (w *Pool[T, R]) SetLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	} else if limit > len(w.workers) {
+		limit = len(w.workers)
+	}
+
+	w.mu.Lock()
+	w.limit = limit
+	w.mu.Unlock()
+
+	w.cond.Broadcast()
+}
+
+// Limit returns the number of workers that are allowed to process tasks
+// concurrently.
+func (w *Pool[T, R]) Limit() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.limit
+}
+
+// acquire blocks until the worker is allowed to pick up a new task. It
+// returns false if the tasks channel was closed in the meantime.
+func (w *Pool[T, R]) acquire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for !w.done && w.active >= w.limit {
+		w.cond.Wait()
+	}
+
+	if w.done {
+		return false
+	}
+
+	w.active += 1
+	return true
+}
+
+// release signals that the worker has finished processing its task.
+func (w *Pool[T, R]) release() {
+	w.mu.Lock()
+	w.active -= 1
+	w.mu.Unlock()
+
+	w.cond.Signal()
+}
+
+// finish signals that the tasks channel was closed which lets all waiting
+// workers exit.
+func (w *Pool[T, R]) finish() {
+	w.mu.Lock()
+	w.active -= 1
+	w.done = true
+	w.mu.Unlock()
+
+	w.cond.Broadcast()
+}
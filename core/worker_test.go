@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -168,3 +169,57 @@ func TestPoolMultipleWorkers(t *testing.T) {
 		assert.False(t, more)
 	})
 }
+
+func TestPoolLimit(t *testing.T) {
+	defer goleak.VerifyNone(t, goleakIgnore...)
+	ctx := context.Background()
+	tasks := make(chan string)
+
+	// two workers that block until they are allowed to return
+	hook := make(chan struct{})
+	workers := make([]Worker[string, int], 2)
+	for i := range workers {
+		worker := newTestWorker[string, int]()
+		worker.On("Work", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { <-hook }).
+			Return(0, nil)
+		workers[i] = worker
+	}
+
+	pool := NewPool[string, int](workers...)
+	assert.Equal(t, 2, pool.Limit())
+
+	pool.SetLimit(0)
+	assert.Equal(t, 1, pool.Limit())
+	pool.SetLimit(10)
+	assert.Equal(t, 2, pool.Limit())
+
+	pool.SetLimit(1)
+	results := pool.Start(ctx, tasks)
+
+	tasks <- "1"
+
+	// the second worker is not allowed to pick up the task
+	select {
+	case tasks <- "2":
+		t.Fatal("second worker picked up a task")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// raising the limit lets the second worker pick up the task
+	pool.SetLimit(2)
+	select {
+	case tasks <- "2":
+	case <-time.After(time.Second):
+		t.Fatal("second worker didn't pick up the task")
+	}
+
+	close(hook)
+	close(tasks)
+
+	count := 0
+	for range results {
+		count += 1
+	}
+	assert.Equal(t, 2, count)
+}
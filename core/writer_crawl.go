@@ -80,6 +80,7 @@ func (w *CrawlWriter[I]) Work(ctx context.Context, task CrawlResult[I]) (WriteRe
 		NeighborPrefixes: neighborPrefixes,
 		ErrorBits:        errorBits,
 		Properties:       task.Properties,
+		RetryPass:        task.RetryPass,
 	}
 
 	start := time.Now()
@@ -63,6 +63,19 @@ func (r DialResult[I]) DialDuration() time.Duration {
 	return r.DialEndTime.Sub(r.DialStartTime)
 }
 
+// ConnectDuration returns the time it took to dial the peer
+func (r DialResult[I]) ConnectDuration() time.Duration {
+	return r.DialDuration()
+}
+
+// ConnectErrorClass returns the known dial error or an empty string if no error occurred.
+func (r DialResult[I]) ConnectErrorClass() string {
+	if r.Error == nil {
+		return ""
+	}
+	return r.DialError
+}
+
 // DialWriter handles the insert/upsert/update operations for a particular crawl result.
 type DialWriter[I PeerInfo[I]] struct {
 	id  string
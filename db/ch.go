@@ -12,13 +12,13 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/golang-migrate/migrate/v4"
 	mch "github.com/golang-migrate/migrate/v4/database/clickhouse"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p/core/peer"
 	log "github.com/sirupsen/logrus"
@@ -39,6 +39,8 @@ const (
 	TableNameVisits                      = "visits"
 	TableNameNeighbors                   = "neighbors"
 	TableNameCrawls                      = "crawls"
+	TableNameCrawlProperties             = "crawl_properties"
+	TableNameSessions                    = "sessions"
 	TableNameDiscoveryIDPrefixesXPeerIDs = "discovery_id_prefixes_x_peer_ids"
 )
 
@@ -92,11 +94,19 @@ type ClickHouseClient struct {
 	// the database connection to clickhouse
 	conn driver.Conn
 
+	// the number of clients that share the above connection. See
+	// [ClickHouseClient.Fork].
+	refs *atomic.Int32
+
 	// database client implementations must track the crawl object internally.
 	// For more details see the [Client] documentation.
 	crawlMu sync.RWMutex
 	crawl   *ClickHouseCrawl
 
+	// the open and pending sessions of all peers. Forked clients share the
+	// sessions because they share the same sessions table.
+	sessions *chSessions
+
 	// this channel will receive all new visits. This channel is read in the
 	// [startFlusher] method. The visits are batched and pushed to clickhouse
 	// in chunks. Chunk size and flush interval can be configured in
@@ -136,16 +146,21 @@ func NewClickHouseClient(ctx context.Context, cfg *ClickHouseClientConfig) (*Cli
 		return nil, fmt.Errorf("new pgTelemetry: %w", err)
 	}
 
+	refs := &atomic.Int32{}
+	refs.Store(1)
+
 	flusherCtx, flusherCancel := context.WithCancel(context.Background())
 
 	client := &ClickHouseClient{
 		conn:          conn,
+		refs:          refs,
 		cfg:           cfg,
 		flusherCancel: flusherCancel,
 		visitsChan:    make(chan *ClickHouseVisit),
 		flushChan:     make(chan chan struct{}),
 		flusherDone:   make(chan struct{}),
 		telemetry:     telemetry,
+		sessions:      newCHSessions(),
 	}
 
 	if cfg.ApplyMigrations {
@@ -154,6 +169,38 @@ func NewClickHouseClient(ctx context.Context, cfg *ClickHouseClientConfig) (*Cli
 		}
 	}
 
+	if err = client.sessions.load(ctx, conn); err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+
+	go client.startFlusher(flusherCtx)
+
+	return client, nil
+}
+
+// Fork returns a new client that shares the database connection with this
+// client but tracks its own crawl of the network with the given identifier.
+// The new client batches its visits independently.
+func (c *ClickHouseClient) Fork(networkID string) (Client, error) {
+	cfg := *c.cfg
+	cfg.NetworkID = networkID
+
+	c.refs.Add(1)
+
+	flusherCtx, flusherCancel := context.WithCancel(context.Background())
+
+	client := &ClickHouseClient{
+		conn:          c.conn,
+		refs:          c.refs,
+		cfg:           &cfg,
+		flusherCancel: flusherCancel,
+		visitsChan:    make(chan *ClickHouseVisit),
+		flushChan:     make(chan chan struct{}),
+		flusherDone:   make(chan struct{}),
+		telemetry:     c.telemetry,
+		sessions:      c.sessions,
+	}
+
 	go client.startFlusher(flusherCtx)
 
 	return client, nil
@@ -163,23 +210,6 @@ func NewClickHouseClient(ctx context.Context, cfg *ClickHouseClientConfig) (*Cli
 // It uses the configured migrations directory and executes them against
 // the database. Returns an error if migrations fail or cannot be applied.
 func (c *ClickHouseClient) applyMigration() error {
-	var (
-		migrations embed.FS
-		path       string
-	)
-	if c.cfg.ReplicatedTableEngines {
-		migrations = clickhouseClusterMigrations
-		path = "migrations/chcluster"
-	} else {
-		migrations = clickhouseLocalMigrations
-		path = "migrations/chlocal"
-	}
-
-	migrationsDir, err := iofs.New(migrations, path)
-	if err != nil {
-		return fmt.Errorf("create iofs migrations source: %w", err)
-	}
-
 	db := clickhouse.OpenDB(c.cfg.Options())
 
 	mdriver, err := mch.WithInstance(db, &mch.Config{
@@ -191,9 +221,11 @@ func (c *ClickHouseClient) applyMigration() error {
 		return fmt.Errorf("create migrate driver: %w", err)
 	}
 
-	m, err := migrate.NewWithInstance("iofs", migrationsDir, c.cfg.DatabaseName, mdriver)
+	migrations, path := clickhouseMigrations(c.cfg.ReplicatedTableEngines)
+
+	m, err := newMigrate(migrations, path, c.cfg.DatabaseName, mdriver)
 	if err != nil {
-		return fmt.Errorf("create migrate instance: %w", err)
+		return err
 	}
 
 	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
@@ -364,17 +396,30 @@ func (c *ClickHouseClient) startFlusher(ctx context.Context) {
 }
 
 type ClickHouseCrawl struct {
-	ID              uuid.UUID  `ch:"id"`
-	State           string     `ch:"state"`
-	FinishedAt      *time.Time `ch:"finished_at"`
-	UpdatedAt       time.Time  `ch:"updated_at"`
-	CreatedAt       time.Time  `ch:"created_at"`
-	CrawledPeers    *int32     `ch:"crawled_peers"`
-	DialablePeers   *int32     `ch:"dialable_peers"`
-	UndialablePeers *int32     `ch:"undialable_peers"`
-	RemainingPeers  *int32     `ch:"remaining_peers"`
-	Version         string     `ch:"version"`
-	NetworkID       string     `ch:"network_id"`
+	ID                  uuid.UUID  `ch:"id"`
+	State               string     `ch:"state"`
+	FinishedAt          *time.Time `ch:"finished_at"`
+	UpdatedAt           time.Time  `ch:"updated_at"`
+	CreatedAt           time.Time  `ch:"created_at"`
+	CrawledPeers        *int32     `ch:"crawled_peers"`
+	DialablePeers       *int32     `ch:"dialable_peers"`
+	UndialablePeers     *int32     `ch:"undialable_peers"`
+	RemainingPeers      *int32     `ch:"remaining_peers"`
+	NetworkSize         *float64   `ch:"network_size"`
+	NetworkSizeLower    *float64   `ch:"network_size_lower"`
+	NetworkSizeUpper    *float64   `ch:"network_size_upper"`
+	RetriedPeers        *int32     `ch:"retried_peers"`
+	RetrySucceededPeers *int32     `ch:"retry_succeeded_peers"`
+	Version             string     `ch:"version"`
+	NetworkID           string     `ch:"network_id"`
+}
+
+type ClickHouseCrawlProperty struct {
+	CrawlID        uuid.UUID `ch:"crawl_id"`
+	CrawlCreatedAt time.Time `ch:"crawl_created_at"`
+	Property       string    `ch:"property"`
+	Value          string    `ch:"value"`
+	Count          uint32    `ch:"count"`
 }
 
 type ClickHouseVisit struct {
@@ -392,6 +437,7 @@ type ClickHouseVisit struct {
 	VisitStartedAt time.Time       `ch:"visit_started_at"`
 	VisitEndedAt   time.Time       `ch:"visit_ended_at"`
 	Properties     json.RawMessage `ch:"peer_properties"`
+	RetryPass      uint8           `ch:"retry_pass"`
 	neighbors      []*ClickhouseNeighbor
 	prefix         *ClickhouseDiscoveryIDPrefix
 }
@@ -411,6 +457,12 @@ type ClickhouseDiscoveryIDPrefix struct {
 }
 
 func (c *ClickHouseClient) InitCrawl(ctx context.Context, version string) error {
+	return c.InitCrawlAt(ctx, version, time.Now())
+}
+
+// InitCrawlAt inserts a crawl with the given start time as its creation
+// time. The neighbors of the crawl are partitioned by this time.
+func (c *ClickHouseClient) InitCrawlAt(ctx context.Context, version string, startedAt time.Time) error {
 	c.crawlMu.Lock()
 	defer c.crawlMu.Unlock()
 
@@ -441,13 +493,12 @@ func (c *ClickHouseClient) InitCrawl(ctx context.Context, version string) error
 		return fmt.Errorf("new uuid v7: %w", err)
 	}
 
-	now := time.Now()
 	crawl := &ClickHouseCrawl{
 		ID:              uuidv7,
 		State:           string(CrawlStateStarted),
 		FinishedAt:      nil,
-		UpdatedAt:       now,
-		CreatedAt:       now,
+		UpdatedAt:       time.Now(),
+		CreatedAt:       startedAt,
 		CrawledPeers:    nil,
 		DialablePeers:   nil,
 		UndialablePeers: nil,
@@ -479,6 +530,64 @@ func (c *ClickHouseClient) InitCrawl(ctx context.Context, version string) error
 	return nil
 }
 
+// ResumeCrawl loads the crawl with the given ID from the database and
+// inserts a new version of it in the state `started`.
+func (c *ClickHouseClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawl != nil {
+		return fmt.Errorf("crawl already initialized")
+	}
+
+	id, err := uuid.Parse(crawlID)
+	if err != nil {
+		return fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	crawl, err := c.selectCrawl(ctx, id)
+	if err != nil {
+		return fmt.Errorf("select crawl: %w", err)
+	} else if crawl.NetworkID != c.cfg.NetworkID {
+		return fmt.Errorf("network id mismatch (expected %s, got %s)", c.cfg.NetworkID, crawl.NetworkID)
+	}
+
+	crawl.State = string(CrawlStateStarted)
+	crawl.FinishedAt = nil
+	crawl.UpdatedAt = time.Now()
+
+	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO "+TableNameCrawls)
+	if err != nil {
+		return fmt.Errorf("prepare batch: %w", err)
+	}
+
+	if err = batch.AppendStruct(crawl); err != nil {
+		return fmt.Errorf("append crawl struct: %w", err)
+	}
+
+	if err = batch.Send(); err != nil {
+		return fmt.Errorf("insert crawl: %w", err)
+	}
+
+	c.crawl = crawl
+
+	log.WithField("id", c.crawl.ID).Infoln("Resumed crawl")
+
+	return nil
+}
+
+// CrawlID returns the UUID of the crawl as a string.
+func (c *ClickHouseClient) CrawlID() string {
+	c.crawlMu.RLock()
+	defer c.crawlMu.RUnlock()
+
+	if c.crawl == nil {
+		return ""
+	}
+
+	return c.crawl.ID.String()
+}
+
 func (c *ClickHouseClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) (err error) {
 	c.crawlMu.Lock()
 	defer c.crawlMu.Unlock()
@@ -507,8 +616,16 @@ func (c *ClickHouseClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) (
 	c.crawl.DialablePeers = toPtr(args.Dialable)
 	c.crawl.UndialablePeers = toPtr(args.Undialable)
 	c.crawl.RemainingPeers = toPtr(args.Remaining)
+	c.crawl.RetriedPeers = toPtr(args.Retried)
+	c.crawl.RetrySucceededPeers = toPtr(args.RetrySucceeded)
+	if args.NetworkSize != 0 {
+		c.crawl.NetworkSize = &args.NetworkSize
+		c.crawl.NetworkSizeLower = &args.NetworkSizeLower
+		c.crawl.NetworkSizeUpper = &args.NetworkSizeUpper
+	}
 	c.crawl.State = string(args.State)
-	c.crawl.FinishedAt = &now
+	finishedAt := args.finishedAt(now)
+	c.crawl.FinishedAt = &finishedAt
 
 	// Use Batch because of the convenience of AppendStruct
 	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO "+TableNameCrawls)
@@ -572,7 +689,13 @@ func (c *ClickHouseClient) QueryBootstrapPeers(ctx context.Context, limit int) (
 func (c *ClickHouseClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
 	// the crawl can be null if it's a visit from the monitoring task
 	var crawlID *uuid.UUID
-	if c.crawl != nil {
+	if args.CrawlID != "" {
+		id, err := uuid.Parse(args.CrawlID)
+		if err != nil {
+			return fmt.Errorf("parse crawl id %q: %w", args.CrawlID, err)
+		}
+		crawlID = &id
+	} else if c.crawl != nil {
 		crawlID = &c.crawl.ID
 	}
 
@@ -623,16 +746,27 @@ func (c *ClickHouseClient) InsertVisit(ctx context.Context, args *VisitArgs) err
 		VisitStartedAt: args.VisitStartedAt,
 		VisitEndedAt:   args.VisitEndedAt,
 		Properties:     args.Properties,
+		RetryPass:      uint8(args.RetryPass),
 		prefix: &ClickhouseDiscoveryIDPrefix{
 			PeerID: args.PeerID.String(),
 			Prefix: args.DiscoveryPrefix,
 		},
 	}
 
-	if c.cfg.PersistNeighbors && crawlID != nil {
+	// neighbors require the creation time of the crawl, so we only persist
+	// them for the crawl that the client tracks.
+	if c.cfg.PersistNeighbors && crawlID != nil && c.crawl != nil && *crawlID == c.crawl.ID {
+
+		// neighbors are stored by their discovery prefixes, which are
+		// missing if the visit was imported from older JSON files.
+		neighbors := args.Neighbors
+		if len(args.NeighborPrefixes) != len(neighbors) {
+			log.WithField("peer", args.PeerID.String()).Debugln("Skipping neighbors without discovery prefixes")
+			neighbors = nil
+		}
 
-		visit.neighbors = make([]*ClickhouseNeighbor, len(args.Neighbors))
-		for i, neighbor := range args.Neighbors {
+		visit.neighbors = make([]*ClickhouseNeighbor, len(neighbors))
+		for i, neighbor := range neighbors {
 			visit.neighbors[i] = &ClickhouseNeighbor{
 				CrawlID:        *crawlID,
 				CrawlCreatedAt: c.crawl.CreatedAt, // c.crawl is not nil because crawlID is not nil
@@ -651,12 +785,66 @@ func (c *ClickHouseClient) InsertVisit(ctx context.Context, args *VisitArgs) err
 	case <-ctx.Done():
 		return ctx.Err()
 	case c.visitsChan <- visit:
-		return nil
 	}
+
+	// another process may have changed the peer's session in the meantime
+	if err := c.sessions.loadPeer(ctx, c.conn, args.PeerID); err != nil {
+		log.WithError(err).WithField("pid", args.PeerID.ShortString()).Warnln("Could not load session")
+	}
+
+	c.sessions.visit(args)
+
+	return nil
 }
 
+// InsertCrawlProperties stores the aggregated agent version, protocol, and
+// error counts of the current crawl. The table deduplicates the rows by crawl,
+// property, and value, so persisting the properties of a resumed crawl again
+// replaces the previous counts.
 func (c *ClickHouseClient) InsertCrawlProperties(ctx context.Context, properties map[string]map[string]int) error {
-	// irrelevant for clickhouse
+	c.crawlMu.RLock()
+	defer c.crawlMu.RUnlock()
+
+	if c.crawl == nil {
+		return fmt.Errorf("crawl not initialized")
+	}
+
+	batch, err := c.conn.PrepareBatch(ctx, "INSERT INTO "+TableNameCrawlProperties)
+	if err != nil {
+		return fmt.Errorf("prepare batch: %w", err)
+	}
+
+	for property, valuesMap := range properties {
+		switch property {
+		case "agent_version", "protocol", "error":
+		default:
+			log.WithField("property", property).Warnln("Unknown crawl property")
+			continue
+		}
+
+		for value, count := range valuesMap {
+			if value == "" || count < 0 {
+				continue
+			}
+
+			cp := &ClickHouseCrawlProperty{
+				CrawlID:        c.crawl.ID,
+				CrawlCreatedAt: c.crawl.CreatedAt,
+				Property:       property,
+				Value:          value,
+				Count:          uint32(count),
+			}
+
+			if err = batch.AppendStruct(cp); err != nil {
+				return fmt.Errorf("append crawl property struct: %w", err)
+			}
+		}
+	}
+
+	if err = batch.Send(); err != nil {
+		return fmt.Errorf("insert crawl properties: %w", err)
+	}
+
 	return nil
 }
 
@@ -672,46 +860,21 @@ func (c *ClickHouseClient) InsertNeighbors(ctx context.Context, peerID peer.ID,
 	return c.conn.Exec(ctx, query, c.crawl.ID, peerID, neighbors, errorBits)
 }
 
+// SelectPeersToProbe returns all peers with open or pending sessions that are
+// due to be probed. It also writes the sessions that have changed since the
+// last call to the database, which happens periodically because the monitor
+// calls this method in regular intervals. Afterward, it reloads the sessions
+// to pick up the ones that other processes (e.g., crawls) have written.
 func (c *ClickHouseClient) SelectPeersToProbe(ctx context.Context) ([]peer.AddrInfo, error) {
-	query := `
-	SELECT
-		peer_id,
-		arrayConcat(dial_maddrs, extra_maddrs) AS multi_addresses
-	FROM ?
-	WHERE dial_errors IS NULL
-      AND visit_started_at BETWEEN (now() - INTERVAL '24 hours') AND now()
-	`
-
-	rows, err := c.conn.Query(ctx, query, TableNameVisits)
-	if err != nil {
-		return nil, err
+	if err := c.sessions.save(ctx, c.conn); err != nil {
+		return nil, fmt.Errorf("save sessions: %w", err)
 	}
 
-	var addrInfos []peer.AddrInfo
-	for rows.Next() {
-		var pidStr string
-		var maddrStrs []string
-		if err := rows.Scan(&pidStr, &maddrStrs); err != nil {
-			return nil, err
-		}
-
-		pid, err := peer.Decode(pidStr)
-		if err != nil {
-			return nil, fmt.Errorf("decode peer id: %w", err)
-		}
-
-		maddrs, err := utils.AddrsToMaddrs(maddrStrs)
-		if err != nil {
-			return nil, fmt.Errorf("parse multi addresses: %w", err)
-		}
-
-		addrInfos = append(addrInfos, peer.AddrInfo{
-			ID:    pid,
-			Addrs: maddrs,
-		})
+	if err := c.sessions.load(ctx, c.conn); err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
 	}
 
-	return addrInfos, err
+	return c.sessions.due(time.Now()), nil
 }
 
 func (c *ClickHouseClient) Flush(ctx context.Context) error {
@@ -726,8 +889,13 @@ func (c *ClickHouseClient) Flush(ctx context.Context) error {
 	case <-ctx.Done():
 		return ctx.Err()
 	case <-flushed:
-		return nil
 	}
+
+	if err := c.sessions.save(ctx, c.conn); err != nil {
+		return fmt.Errorf("save sessions: %w", err)
+	}
+
+	return nil
 }
 
 // Close releases resources associated with the clickhouse client. Make sure
@@ -753,6 +921,18 @@ func (c *ClickHouseClient) Close() error {
 	// close the flush channel because it's not needed anymore
 	close(c.flushChan)
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.sessions.save(ctx, c.conn); err != nil {
+		log.WithError(err).Warnln("Failed saving sessions")
+	}
+
+	// only close the connection if no other client uses it anymore
+	if c.refs.Add(-1) > 0 {
+		return nil
+	}
+
 	return c.conn.Close()
 }
 
@@ -768,6 +948,24 @@ func (c *ClickHouseClient) selectLatestCrawl(ctx context.Context) (*ClickHouseCr
 	return crawl, err
 }
 
+func (c *ClickHouseClient) selectCrawlProperties(ctx context.Context, crawlID uuid.UUID) ([]ClickHouseCrawlProperty, error) {
+	rows, err := c.conn.Query(ctx, "SELECT * FROM ? FINAL WHERE crawl_id = ?", TableNameCrawlProperties, crawlID)
+	if err != nil {
+		return nil, err
+	}
+
+	var properties []ClickHouseCrawlProperty
+	for rows.Next() {
+		property := ClickHouseCrawlProperty{}
+		if err := rows.ScanStruct(&property); err != nil {
+			return nil, err
+		}
+		properties = append(properties, property)
+	}
+
+	return properties, err
+}
+
 func (c *ClickHouseClient) selectLatestVisit(ctx context.Context) (*ClickHouseVisit, error) {
 	visit := &ClickHouseVisit{}
 	err := c.conn.QueryRow(ctx, `
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var _ CrawlLoader = (*ClickHouseClient)(nil)
+
+// LoadCrawl returns the peers that were visited during the crawl with the
+// given ID. The creation time of the crawl restricts the query to the
+// partitions of the visits table that can contain its visits.
+func (c *ClickHouseClient) LoadCrawl(ctx context.Context, crawlID string) (*CrawlSnapshot, error) {
+	id, err := uuid.Parse(crawlID)
+	if err != nil {
+		return nil, fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	createdAt, err := c.crawlCreatedAt(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT peer_id,
+		       connect_maddr IS NOT NULL,
+		       agent_version,
+		       protocols,
+		       arrayConcat(dial_maddrs, filtered_maddrs, extra_maddrs)
+		FROM %s
+		WHERE crawl_id = ? AND visit_started_at >= ?`, TableNameVisits)
+	rows, err := c.conn.Query(ctx, query, id, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("query visits: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	snapshot := newCrawlSnapshot(crawlID)
+	for rows.Next() {
+		var (
+			peerIDStr string
+			p         CrawlSnapshotPeer
+		)
+		if err = rows.Scan(&peerIDStr, &p.Dialable, &p.AgentVersion, &p.Protocols, &p.Maddrs); err != nil {
+			return nil, fmt.Errorf("scan visit: %w", err)
+		}
+
+		peerID, err := peer.Decode(peerIDStr)
+		if err != nil {
+			return nil, fmt.Errorf("decode peer id %s: %w", peerIDStr, err)
+		}
+
+		snapshot.add(peerID, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read visits: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// crawlCreatedAt returns the creation time of the given crawl. All visits of
+// the crawl started afterward.
+func (c *ClickHouseClient) crawlCreatedAt(ctx context.Context, crawlID uuid.UUID) (time.Time, error) {
+	var (
+		count     uint64
+		createdAt time.Time
+	)
+	query := fmt.Sprintf("SELECT count(), min(created_at) FROM %s WHERE id = ?", TableNameCrawls)
+	if err := c.conn.QueryRow(ctx, query, crawlID).Scan(&count, &createdAt); err != nil {
+		return time.Time{}, fmt.Errorf("query crawl: %w", err)
+	} else if count == 0 {
+		return time.Time{}, fmt.Errorf("crawl %s not found", crawlID)
+	}
+
+	return createdAt, nil
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ GraphLoader = (*ClickHouseClient)(nil)
+
+// LoadNeighborGraph returns the directed graph of the routing tables that
+// were fetched during the crawl with the given ID. The neighbors table
+// stores the discovery prefixes of the peers, which are mapped back to peer
+// IDs. ClickHouse doesn't store the countries and ASNs of the peers.
+func (c *ClickHouseClient) LoadNeighborGraph(ctx context.Context, crawlID string) (*NeighborGraph, error) {
+	id, err := uuid.Parse(crawlID)
+	if err != nil {
+		return nil, fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	snapshot, err := c.LoadCrawl(ctx, crawlID)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newNeighborGraph(crawlID)
+	g.addVisits(snapshot)
+
+	createdAt, err := c.crawlCreatedAt(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT DISTINCT discovery_id_prefix, peer_id
+		FROM %s
+		WHERE discovery_id_prefix IN (
+		    SELECT arrayJoin([peer_discovery_id_prefix, neighbor_discovery_id_prefix]) FROM %s WHERE crawl_id = ?
+		) OR peer_id IN (
+		    SELECT peer_id FROM %s WHERE crawl_id = ? AND visit_started_at >= ?
+		)`, TableNameDiscoveryIDPrefixesXPeerIDs, TableNameNeighbors, TableNameVisits)
+	rows, err := c.conn.Query(ctx, query, id, id, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("query discovery prefixes: %w", err)
+	}
+
+	prefixes := map[uint64]peer.ID{}
+	for rows.Next() {
+		var (
+			prefix    uint64
+			peerIDStr string
+		)
+		if err = rows.Scan(&prefix, &peerIDStr); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("scan discovery prefix: %w", err)
+		}
+
+		peerID, err := peer.Decode(peerIDStr)
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("decode peer id %s: %w", peerIDStr, err)
+		}
+
+		prefixes[prefix] = peerID
+		g.node(peerID).DiscoveryPrefix = &prefix
+	}
+	_ = rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read discovery prefixes: %w", err)
+	}
+
+	query = fmt.Sprintf("SELECT peer_discovery_id_prefix, neighbor_discovery_id_prefix FROM %s WHERE crawl_id = ?", TableNameNeighbors)
+	rows, err = c.conn.Query(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("query neighbors: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	unknown := 0
+	for rows.Next() {
+		var peerPrefix, neighborPrefix uint64
+		if err = rows.Scan(&peerPrefix, &neighborPrefix); err != nil {
+			return nil, fmt.Errorf("scan neighbor: %w", err)
+		}
+
+		from, fromFound := prefixes[peerPrefix]
+		to, toFound := prefixes[neighborPrefix]
+		if !fromFound || !toFound {
+			unknown += 1
+			continue
+		}
+
+		g.addEdge(from, to)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read neighbors: %w", err)
+	}
+
+	if unknown > 0 {
+		log.WithField("count", unknown).Warnln("Skipped neighbors with unknown discovery prefixes")
+	}
+
+	return g, nil
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var _ Pruner = (*ClickHouseClient)(nil)
+
+// Prune drops all monthly partitions of the visits and neighbors tables that
+// only contain data that exceeds the retention. ClickHouse only drops whole
+// partitions, so that up to one month of additional data is kept. The
+// sessions table isn't partitioned. Therefore, all versions of sessions that
+// weren't visited since the cutoff date are deleted with a mutation. Peer
+// logs aren't stored in ClickHouse.
+func (c *ClickHouseClient) Prune(ctx context.Context, cfg *PruneConfig) ([]*PruneResult, error) {
+	now := time.Now()
+
+	var results []*PruneResult
+	if cfg.VisitsRetention > 0 {
+		visitResults, err := c.dropPartitions(ctx, cfg, TableNameVisits, now.Add(-cfg.VisitsRetention))
+		results = append(results, visitResults...)
+		if err != nil {
+			return results, fmt.Errorf("prune visits: %w", err)
+		}
+	}
+
+	if cfg.NeighborsCrawls > 0 {
+		neighborResults, err := c.pruneNeighbors(ctx, cfg)
+		results = append(results, neighborResults...)
+		if err != nil {
+			return results, fmt.Errorf("prune neighbors: %w", err)
+		}
+	}
+
+	if cfg.SessionsRetention > 0 {
+		result, err := c.pruneSessions(ctx, cfg, now.Add(-cfg.SessionsRetention))
+		if err != nil {
+			return results, fmt.Errorf("prune sessions: %w", err)
+		}
+		results = append(results, result)
+	}
+
+	if cfg.PeerLogsRetention > 0 {
+		log.Warnln("ClickHouse doesn't store peer logs - ignoring retention")
+	}
+
+	return results, nil
+}
+
+// pruneNeighbors drops the partitions of the neighbors table that only
+// contain neighbors of crawls that are older than the configured number of
+// most recent crawls of each network.
+func (c *ClickHouseClient) pruneNeighbors(ctx context.Context, cfg *PruneConfig) ([]*PruneResult, error) {
+	var (
+		count  uint64
+		cutoff time.Time
+	)
+
+	query := `
+		SELECT count(), min(created_at)
+		FROM (
+			SELECT created_at, row_number() OVER (PARTITION BY network_id ORDER BY created_at DESC) AS rn
+			FROM crawls FINAL
+		)
+		WHERE rn <= ?`
+	if err := c.conn.QueryRow(ctx, query, cfg.NeighborsCrawls).Scan(&count, &cutoff); err != nil {
+		return nil, fmt.Errorf("query oldest crawl to keep: %w", err)
+	} else if count == 0 {
+		return nil, nil
+	}
+
+	return c.dropPartitions(ctx, cfg, TableNameNeighbors, cutoff)
+}
+
+// dropPartitions exports and drops all monthly partitions of the given table
+// that end before the cutoff date.
+func (c *ClickHouseClient) dropPartitions(ctx context.Context, cfg *PruneConfig, table string, cutoff time.Time) ([]*PruneResult, error) {
+	query := `
+		SELECT partition_id, sum(rows)
+		FROM system.parts
+		WHERE database = currentDatabase() AND table = ? AND active
+		GROUP BY partition_id
+		ORDER BY partition_id`
+	rows, err := c.conn.Query(ctx, query, table)
+	if err != nil {
+		return nil, fmt.Errorf("query partitions: %w", err)
+	}
+
+	type partition struct {
+		id   string
+		rows uint64
+	}
+
+	var partitions []partition
+	for rows.Next() {
+		var p partition
+		if err = rows.Scan(&p.id, &p.rows); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("scan partition: %w", err)
+		}
+		partitions = append(partitions, p)
+	}
+	_ = rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read partitions: %w", err)
+	}
+
+	var results []*PruneResult
+	for _, p := range partitions {
+		// the tables are partitioned by toStartOfMonth, e.g., 20240101
+		start, err := time.Parse("20060102", p.id)
+		if err != nil {
+			log.WithField("partition", p.id).Warnln("Skipping partition with unexpected ID")
+			continue
+		}
+
+		if start.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+
+		result := &PruneResult{
+			Table:     table,
+			Partition: p.id,
+			Rows:      int64(p.rows),
+		}
+
+		logEntry := log.WithFields(log.Fields{"table": table, "partition": p.id})
+		if cfg.DryRun {
+			logEntry.Infoln("Would drop partition")
+			results = append(results, result)
+			continue
+		}
+
+		if cfg.ExportDir != "" {
+			name := fmt.Sprintf("%s_%s", table, p.id)
+			query := fmt.Sprintf("SELECT formatRow('JSONEachRow', *) FROM %s WHERE _partition_id = ?", table)
+			if err = c.exportRows(ctx, cfg.ExportDir, name, result, query, p.id); err != nil {
+				return results, err
+			}
+		}
+
+		logEntry.Infoln("Dropping partition")
+		if err = c.conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s DROP PARTITION ID '%s'", table, p.id)); err != nil {
+			return results, fmt.Errorf("drop partition %s: %w", p.id, err)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// pruneSessions deletes all versions of the sessions that weren't visited
+// since the cutoff date.
+func (c *ClickHouseClient) pruneSessions(ctx context.Context, cfg *PruneConfig, cutoff time.Time) (*PruneResult, error) {
+	result := &PruneResult{
+		Table: TableNameSessions,
+		Rows:  -1,
+	}
+
+	if cfg.DryRun {
+		var count uint64
+		query := fmt.Sprintf("SELECT count() FROM %s FINAL WHERE last_visited_at < ?", TableNameSessions)
+		if err := c.conn.QueryRow(ctx, query, cutoff).Scan(&count); err != nil {
+			return nil, fmt.Errorf("count sessions: %w", err)
+		}
+		result.Rows = int64(count)
+		log.WithField("rows", count).Infoln("Would delete sessions")
+		return result, nil
+	}
+
+	if cfg.ExportDir != "" {
+		name := fmt.Sprintf("%s_%s", TableNameSessions, time.Now().UTC().Format("20060102T150405"))
+		query := fmt.Sprintf("SELECT formatRow('JSONEachRow', *) FROM %s FINAL WHERE last_visited_at < ?", TableNameSessions)
+		if err := c.exportRows(ctx, cfg.ExportDir, name, result, query, cutoff); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infoln("Deleting sessions")
+	query := fmt.Sprintf("ALTER TABLE %s DELETE WHERE last_visited_at < ?", TableNameSessions)
+	if err := c.conn.Exec(ctx, query, cutoff); err != nil {
+		return nil, fmt.Errorf("delete sessions: %w", err)
+	}
+
+	return result, nil
+}
+
+// exportRows writes the JSON encoded rows that the given query returns to an
+// export file with the given name and records it in the result.
+func (c *ClickHouseClient) exportRows(ctx context.Context, dir string, name string, result *PruneResult, query string, args ...any) error {
+	export, err := newPruneExport(dir, name)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("file", export.path).Infoln("Exporting rows")
+
+	rows, err := c.conn.Query(ctx, query, args...)
+	if err != nil {
+		_ = export.Close()
+		return fmt.Errorf("query rows: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var row string
+		if err = rows.Scan(&row); err != nil {
+			_ = export.Close()
+			return fmt.Errorf("scan row: %w", err)
+		}
+
+		if err = export.Write(row); err != nil {
+			_ = export.Close()
+			return fmt.Errorf("write row: %w", err)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		_ = export.Close()
+		return fmt.Errorf("read rows: %w", err)
+	}
+
+	if err = export.Close(); err != nil {
+		return fmt.Errorf("close export file: %w", err)
+	}
+
+	result.Rows = export.rows
+	result.ExportFile = export.path
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+// ClickHouseSession is a continuous streak of uptime of a peer. The
+// ClickHouse client tracks sessions with the same rules as the Postgres
+// client and inserts a new version of the row whenever a session changes.
+type ClickHouseSession struct {
+	PeerID                string     `ch:"peer_id"`
+	Maddrs                []string   `ch:"multi_addresses"`
+	State                 string     `ch:"state"`
+	FirstSuccessfulVisit  time.Time  `ch:"first_successful_visit"`
+	LastSuccessfulVisit   time.Time  `ch:"last_successful_visit"`
+	FirstFailedVisit      *time.Time `ch:"first_failed_visit"`
+	LastFailedVisit       *time.Time `ch:"last_failed_visit"`
+	LastVisitedAt         time.Time  `ch:"last_visited_at"`
+	NextVisitDueAt        *time.Time `ch:"next_visit_due_at"`
+	SuccessfulVisitsCount uint32     `ch:"successful_visits_count"`
+	RecoveredCount        uint32     `ch:"recovered_count"`
+	FailedVisitsCount     uint32     `ch:"failed_visits_count"`
+	FinishReason          *string    `ch:"finish_reason"`
+	UpdatedAt             time.Time  `ch:"updated_at"`
+}
+
+// update applies the result of a visit to the session. See
+// [SQLiteClient.upsertSession] for the rules.
+func (s *ClickHouseSession) update(visitStartedAt time.Time, visitEndedAt time.Time, connectErr string) {
+	s.LastVisitedAt = visitEndedAt
+
+	switch {
+	case connectErr == "":
+		if s.State == "pending" {
+			s.RecoveredCount += 1
+		}
+		s.State = "open"
+		s.NextVisitDueAt = ptrTo(nextVisit(visitEndedAt, s.LastSuccessfulVisit))
+		s.LastSuccessfulVisit = visitEndedAt
+		s.SuccessfulVisitsCount += 1
+		s.FirstFailedVisit = nil
+		s.LastFailedVisit = nil
+		s.FailedVisitsCount = 0
+		s.FinishReason = nil
+
+	case s.State == "open" && maxFailedVisits(s.FirstSuccessfulVisit, s.LastSuccessfulVisit, connectErr) > 0:
+		maxVisits := maxFailedVisits(s.FirstSuccessfulVisit, s.LastSuccessfulVisit, connectErr)
+		s.State = "pending"
+		s.FirstFailedVisit = ptrTo(visitStartedAt)
+		s.LastFailedVisit = ptrTo(visitEndedAt)
+		s.FailedVisitsCount += 1
+		s.FinishReason = ptrTo(connectErr)
+		s.NextVisitDueAt = ptrTo(visitEndedAt.Add(time.Duration(maxVisits) * time.Minute))
+
+	case s.State == "pending" && int(s.FailedVisitsCount) < maxFailedVisits(s.FirstSuccessfulVisit, s.LastSuccessfulVisit, connectErr):
+		s.LastFailedVisit = ptrTo(visitEndedAt)
+		s.FailedVisitsCount += 1
+		s.NextVisitDueAt = ptrTo(nextVisit(visitEndedAt, s.LastSuccessfulVisit))
+
+	default:
+		s.State = "closed"
+		if s.FirstFailedVisit == nil {
+			s.FirstFailedVisit = ptrTo(visitStartedAt)
+		}
+		s.LastFailedVisit = ptrTo(visitEndedAt)
+		s.FailedVisitsCount += 1
+		if s.FinishReason == nil {
+			s.FinishReason = ptrTo(connectErr)
+		}
+		s.NextVisitDueAt = nil
+	}
+}
+
+// chSessions tracks the open and pending sessions of all peers in memory.
+// Sessions that have changed since they were last written to ClickHouse are
+// marked as dirty. Closed sessions are removed from memory as soon as they
+// are written. Other processes (e.g., a crawler and a monitor) write the same
+// table, so the sessions are reloaded from the database before they're used
+// (see [chSessions.load] and [chSessions.loadPeer]).
+type chSessions struct {
+	mu       sync.Mutex
+	sessions map[peer.ID]*ClickHouseSession
+	dirty    map[*ClickHouseSession]struct{}
+}
+
+func newCHSessions() *chSessions {
+	return &chSessions{
+		sessions: map[peer.ID]*ClickHouseSession{},
+		dirty:    map[*ClickHouseSession]struct{}{},
+	}
+}
+
+// load reads all open and pending sessions from the database and merges them
+// into memory. Sessions that aren't open or pending in the database anymore
+// are removed from memory unless they have changes that weren't saved yet.
+func (s *chSessions) load(ctx context.Context, conn driver.Conn) error {
+	query := "SELECT * FROM ? FINAL WHERE state != 'closed'"
+	rows, err := conn.Query(ctx, query, TableNameSessions)
+	if err != nil {
+		return fmt.Errorf("query sessions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	loaded := map[peer.ID]*ClickHouseSession{}
+	for rows.Next() {
+		session := &ClickHouseSession{}
+		if err := rows.ScanStruct(session); err != nil {
+			return fmt.Errorf("scan session: %w", err)
+		}
+
+		peerID, err := peer.Decode(session.PeerID)
+		if err != nil {
+			log.WithError(err).WithField("pid", session.PeerID).Warnln("Could not parse session peer ID from database")
+			continue
+		}
+
+		loaded[peerID] = session
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for peerID, session := range s.sessions {
+		if _, found := loaded[peerID]; found {
+			continue
+		} else if _, dirty := s.dirty[session]; !dirty {
+			delete(s.sessions, peerID)
+		}
+	}
+
+	for peerID, session := range loaded {
+		s.merge(peerID, session)
+	}
+
+	return nil
+}
+
+// loadPeer reads the latest session of the given peer from the database and
+// merges it into memory.
+func (s *chSessions) loadPeer(ctx context.Context, conn driver.Conn, peerID peer.ID) error {
+	query := "SELECT * FROM ? FINAL WHERE peer_id = ? ORDER BY updated_at DESC LIMIT 1"
+	rows, err := conn.Query(ctx, query, TableNameSessions, peerID.String())
+	if err != nil {
+		return fmt.Errorf("query session: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	if !rows.Next() {
+		return rows.Err()
+	}
+
+	session := &ClickHouseSession{}
+	if err := rows.ScanStruct(session); err != nil {
+		return fmt.Errorf("scan session: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.merge(peerID, session)
+
+	return nil
+}
+
+// merge replaces the session of the given peer in memory if the given one
+// was updated more recently. Closed sessions are removed from memory. The
+// caller must hold the lock.
+func (s *chSessions) merge(peerID peer.ID, session *ClickHouseSession) {
+	current, found := s.sessions[peerID]
+	if found {
+		if !current.UpdatedAt.Before(session.UpdatedAt) {
+			return
+		}
+		delete(s.dirty, current)
+	}
+
+	if session.State == "closed" {
+		delete(s.sessions, peerID)
+	} else {
+		s.sessions[peerID] = session
+	}
+}
+
+// visit updates the session of the peer of the given visit.
+func (s *chSessions) visit(args *VisitArgs) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, found := s.sessions[args.PeerID]
+	if !found {
+		// offline peers without a session don't get one
+		if args.ConnectErrorStr != "" {
+			return
+		}
+
+		session = &ClickHouseSession{
+			PeerID:               args.PeerID.String(),
+			FirstSuccessfulVisit: args.VisitStartedAt,
+		}
+		s.sessions[args.PeerID] = session
+	}
+
+	if maddrs := slices.Concat(args.DialMaddrs, args.ExtraMaddrs); len(maddrs) > 0 {
+		session.Maddrs = utils.MaddrsToAddrs(maddrs)
+		sort.Strings(session.Maddrs)
+	}
+
+	session.update(args.VisitStartedAt, args.VisitEndedAt, args.ConnectErrorStr)
+	session.UpdatedAt = time.Now()
+	s.dirty[session] = struct{}{}
+
+	if session.State == "closed" {
+		delete(s.sessions, args.PeerID)
+	}
+}
+
+// due returns all peers whose sessions are due to be probed.
+func (s *chSessions) due(now time.Time) []peer.AddrInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrInfos := []peer.AddrInfo{}
+	for peerID, session := range s.sessions {
+		if session.NextVisitDueAt == nil || !session.NextVisitDueAt.Before(now) {
+			continue
+		}
+
+		maddrs, err := utils.AddrsToMaddrs(session.Maddrs)
+		if err != nil {
+			log.WithError(err).WithField("maddrs", session.Maddrs).Warnln("Could not parse session multi addresses")
+			continue
+		}
+
+		addrInfos = append(addrInfos, peer.AddrInfo{ID: peerID, Addrs: maddrs})
+	}
+
+	return addrInfos
+}
+
+// save inserts a new version of all sessions that have changed since the
+// last call. If the insert fails, the sessions stay dirty and are written
+// with the next call.
+func (s *chSessions) save(ctx context.Context, conn driver.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.dirty) == 0 {
+		return nil
+	}
+
+	batch, err := conn.PrepareBatch(ctx, "INSERT INTO "+TableNameSessions)
+	if err != nil {
+		return fmt.Errorf("prepare batch: %w", err)
+	}
+
+	for session := range s.dirty {
+		if err = batch.AppendStruct(session); err != nil {
+			return fmt.Errorf("append session struct: %w", err)
+		}
+	}
+
+	if err = batch.Send(); err != nil {
+		return fmt.Errorf("insert sessions: %w", err)
+	}
+
+	clear(s.dirty)
+
+	return nil
+}
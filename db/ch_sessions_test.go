@@ -0,0 +1,100 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+func TestCHSessions_visit(t *testing.T) {
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+
+	p := jsonTestPeers(t)
+	start := time.Now().Add(-3 * time.Hour)
+	sessions := newCHSessions()
+	visit := func(id string, at time.Time, connectErr string) {
+		sessions.visit(&VisitArgs{
+			PeerID:          p(id),
+			DialMaddrs:      []ma.Multiaddr{maddr},
+			VisitStartedAt:  at.Add(-time.Second),
+			VisitEndedAt:    at,
+			ConnectErrorStr: connectErr,
+		})
+	}
+
+	visit("offline", start, pgmodels.NetErrorIoTimeout)
+	visit("short", start, "")
+	visit("long", start, "")
+	visit("long", start.Add(2*time.Hour), "")
+
+	assert.Len(t, sessions.sessions, 2)
+	assert.Len(t, sessions.dirty, 2)
+
+	due := sessions.due(time.Now())
+	require.Len(t, due, 2)
+	assert.Equal(t, []ma.Multiaddr{maddr}, due[0].Addrs)
+
+	// the peer that was only online for a short time is gone immediately
+	// and the other one gets another chance.
+	visit("short", start.Add(2*time.Hour), pgmodels.NetErrorIoTimeout)
+	visit("long", start.Add(2*time.Hour+time.Minute), pgmodels.NetErrorIoTimeout)
+
+	require.Len(t, sessions.sessions, 1)
+	session := sessions.sessions[p("long")]
+	assert.Equal(t, "pending", session.State)
+	assert.EqualValues(t, 2, session.SuccessfulVisitsCount)
+	assert.EqualValues(t, 1, session.FailedVisitsCount)
+
+	// the closed session must still be written to the database
+	require.Len(t, sessions.dirty, 2)
+	for s := range sessions.dirty {
+		if s.PeerID == p("short").String() {
+			assert.Equal(t, "closed", s.State)
+			assert.Equal(t, pgmodels.NetErrorIoTimeout, *s.FinishReason)
+			assert.Nil(t, s.NextVisitDueAt)
+		}
+	}
+
+	visit("long", start.Add(2*time.Hour+2*time.Minute), "")
+	assert.Equal(t, "open", session.State)
+	assert.EqualValues(t, 1, session.RecoveredCount)
+	assert.Nil(t, session.FirstFailedVisit)
+}
+
+func TestCHSessions_merge(t *testing.T) {
+	p := jsonTestPeers(t)
+	now := time.Now()
+
+	sessions := newCHSessions()
+	sessions.visit(&VisitArgs{
+		PeerID:         p("a"),
+		VisitStartedAt: now.Add(-time.Second),
+		VisitEndedAt:   now,
+	})
+	local := sessions.sessions[p("a")]
+
+	// an older version from the database doesn't replace the local one
+	sessions.merge(p("a"), &ClickHouseSession{State: "open", UpdatedAt: local.UpdatedAt.Add(-time.Minute)})
+	assert.Same(t, local, sessions.sessions[p("a")])
+	assert.Contains(t, sessions.dirty, local)
+
+	// a newer version replaces the local one including its unsaved changes
+	remote := &ClickHouseSession{State: "pending", UpdatedAt: local.UpdatedAt.Add(time.Minute)}
+	sessions.merge(p("a"), remote)
+	assert.Same(t, remote, sessions.sessions[p("a")])
+	assert.Empty(t, sessions.dirty)
+
+	// a newer closed version removes the session
+	sessions.merge(p("a"), &ClickHouseSession{State: "closed", UpdatedAt: remote.UpdatedAt.Add(time.Minute)})
+	assert.NotContains(t, sessions.sessions, p("a"))
+
+	// sessions of unknown peers are added
+	sessions.merge(p("b"), &ClickHouseSession{State: "open", UpdatedAt: now})
+	assert.Contains(t, sessions.sessions, p("b"))
+}
@@ -65,7 +65,7 @@ func (suite *ClickHouseTestSuite) TearDownTest() {
 }
 
 func (suite *ClickHouseTestSuite) clearDatabase(ctx context.Context) {
-	for _, table := range []string{"crawls", "visits", "neighbors"} {
+	for _, table := range []string{"crawls", "visits", "neighbors", "crawl_properties", "sessions"} {
 		err := suite.client.conn.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE TRUE", table))
 		suite.Assert().NoError(err)
 	}
@@ -77,6 +77,7 @@ func (suite *ClickHouseTestSuite) resetClient() {
 
 	suite.client.crawl = nil
 	suite.client.cfg.NetworkID = "test_network"
+	suite.client.sessions = newCHSessions()
 }
 
 func (suite *ClickHouseTestSuite) timeoutCtx() context.Context {
@@ -272,6 +273,106 @@ func (suite *ClickHouseTestSuite) TestSealCrawl_queryBootstrapPeers() {
 	suite.Assert().Len(peers, count/2)
 }
 
+func (suite *ClickHouseTestSuite) TestInsertCrawlProperties() {
+	ctx := suite.timeoutCtx()
+
+	properties := map[string]map[string]int{
+		"agent_version": {"kubo/0.30.0": 3, "": 2},
+		"protocol":      {"/ipfs/kad/1.0.0": 4},
+		"error":         {"io_timeout": 1},
+	}
+
+	err := suite.client.InsertCrawlProperties(ctx, properties)
+	suite.Assert().Error(err) // crawl not initialized
+
+	suite.Require().NoError(suite.client.InitCrawl(ctx, "v1"))
+	suite.Require().NoError(suite.client.InsertCrawlProperties(ctx, properties))
+
+	// persisting the properties again replaces the counts
+	properties["agent_version"]["kubo/0.30.0"] = 5
+	suite.Require().NoError(suite.client.InsertCrawlProperties(ctx, properties))
+
+	stored, err := suite.client.selectCrawlProperties(ctx, suite.client.crawl.ID)
+	suite.Require().NoError(err)
+	suite.Require().Len(stored, 3)
+
+	counts := map[string]uint32{}
+	for _, cp := range stored {
+		suite.Assert().Equal(suite.client.crawl.ID, cp.CrawlID)
+		counts[cp.Property+"="+cp.Value] = cp.Count
+	}
+	suite.Assert().Equal(map[string]uint32{
+		"agent_version=kubo/0.30.0": 5,
+		"protocol=/ipfs/kad/1.0.0":  4,
+		"error=io_timeout":          1,
+	}, counts)
+}
+
+func (suite *ClickHouseTestSuite) TestSelectPeersToProbe() {
+	ctx := suite.timeoutCtx()
+
+	peers := test.GeneratePeerIDs(2)
+	maddr := utils.MustMultiaddr(suite.T(), "/ip4/127.0.0.1/tcp/1234")
+	start := time.Now().Add(-time.Hour).UTC()
+
+	for _, at := range []time.Time{start, start.Add(30 * time.Minute)} {
+		for _, peerID := range peers {
+			err := suite.client.InsertVisit(ctx, &VisitArgs{
+				PeerID:         peerID,
+				DialMaddrs:     []multiaddr.Multiaddr{maddr},
+				VisitStartedAt: at,
+				VisitEndedAt:   at,
+			})
+			suite.Require().NoError(err)
+		}
+	}
+
+	// the second peer goes offline and its session ends
+	err := suite.client.InsertVisit(ctx, &VisitArgs{
+		PeerID:          peers[1],
+		DialMaddrs:      []multiaddr.Multiaddr{maddr},
+		VisitStartedAt:  start.Add(40 * time.Minute),
+		VisitEndedAt:    start.Add(40 * time.Minute),
+		ConnectErrorStr: "io_timeout",
+	})
+	suite.Require().NoError(err)
+
+	toProbe, err := suite.client.SelectPeersToProbe(ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(toProbe, 1)
+	suite.Assert().Equal(peers[0], toProbe[0].ID)
+
+	// the sessions survive restarts
+	sessions := newCHSessions()
+	suite.Require().NoError(sessions.load(ctx, suite.client.conn))
+	suite.Require().Len(sessions.sessions, 1)
+
+	session := sessions.sessions[peers[0]]
+	suite.Require().NotNil(session)
+	suite.Assert().Equal("open", session.State)
+	suite.Assert().EqualValues(2, session.SuccessfulVisitsCount)
+
+	var closed uint64
+	err = suite.client.conn.QueryRow(ctx, "SELECT count() FROM sessions FINAL WHERE state = 'closed'").Scan(&closed)
+	suite.Require().NoError(err)
+	suite.Assert().EqualValues(1, closed)
+
+	// sessions that another process (e.g., a crawl) has written show up
+	other := newCHSessions()
+	otherPeer := test.GeneratePeerIDs(1)[0]
+	other.visit(&VisitArgs{
+		PeerID:         otherPeer,
+		DialMaddrs:     []multiaddr.Multiaddr{maddr},
+		VisitStartedAt: start,
+		VisitEndedAt:   start,
+	})
+	suite.Require().NoError(other.save(ctx, suite.client.conn))
+
+	toProbe, err = suite.client.SelectPeersToProbe(ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(toProbe, 2)
+}
+
 // In order for 'go test' to run this suite, we need to create
 // a normal test function and pass our suite to suite.Run
 func TestClickHouseTestSuite(t *testing.T) {
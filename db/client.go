@@ -1,9 +1,11 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"slices"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -36,6 +38,32 @@ type SealCrawlArgs struct {
 	Undialable int
 	Remaining  int
 	State      CrawlState
+
+	// NetworkSize is the estimated total number of peers in the network with
+	// the bounds of its 95% confidence interval. Zero means no estimate.
+	NetworkSize      float64
+	NetworkSizeLower float64
+	NetworkSizeUpper float64
+
+	// Retried is the number of peers that were crawled again after they had
+	// failed with a transient error and RetrySucceeded is the number of them
+	// that could be connected to on retry.
+	Retried        int
+	RetrySucceeded int
+
+	// FinishedAt overrides the time at which the crawl finished, which is
+	// the current time if it's zero. This is used to import crawls that ran
+	// earlier.
+	FinishedAt time.Time
+}
+
+// finishedAt returns the time at which the crawl finished or the given
+// current time if it wasn't overridden.
+func (args *SealCrawlArgs) finishedAt(now time.Time) time.Time {
+	if args.FinishedAt.IsZero() {
+		return now
+	}
+	return args.FinishedAt
 }
 
 type VisitArgs struct {
@@ -61,6 +89,34 @@ type VisitArgs struct {
 	NeighborPrefixes []uint64
 	ErrorBits        uint16
 	Properties       json.RawMessage
+
+	// RetryPass is the retry pass of the crawl in which the peer was
+	// visited. It's zero for the first visit of the peer in the crawl. A peer
+	// that's retried has more than one visit in the same crawl.
+	RetryPass int
+
+	// CrawlID overrides the crawl that the Client tracks internally. This is
+	// used to replay visits that were recorded during an earlier crawl. The
+	// ID must be in the format that [Client.CrawlID] returns. Neighbors are
+	// only persisted for the crawl that the Client tracks.
+	CrawlID string
+}
+
+// clone returns a copy of the visit arguments that doesn't share any slices
+// with the original. Clients may modify the arguments they receive, so
+// clients that receive the same visit concurrently need their own copy.
+func (args *VisitArgs) clone() *VisitArgs {
+	c := *args
+	c.Protocols = slices.Clone(args.Protocols)
+	c.DialMaddrs = slices.Clone(args.DialMaddrs)
+	c.FilteredMaddrs = slices.Clone(args.FilteredMaddrs)
+	c.ExtraMaddrs = slices.Clone(args.ExtraMaddrs)
+	c.ListenMaddrs = slices.Clone(args.ListenMaddrs)
+	c.DialErrors = slices.Clone(args.DialErrors)
+	c.Neighbors = slices.Clone(args.Neighbors)
+	c.NeighborPrefixes = slices.Clone(args.NeighborPrefixes)
+	c.Properties = bytes.Clone(args.Properties)
+	return &c
 }
 
 type Client interface {
@@ -75,6 +131,21 @@ type Client interface {
 	// off and IMO this is less bad.
 	InitCrawl(ctx context.Context, version string) error
 
+	// InitCrawlAt initializes a new crawl like InitCrawl but records the
+	// given start time instead of the current time. This is used to import
+	// crawls that ran earlier.
+	InitCrawlAt(ctx context.Context, version string, startedAt time.Time) error
+
+	// ResumeCrawl loads the crawl with the given ID and associates all later
+	// database queries with it. This is used instead of InitCrawl to continue
+	// an interrupted crawl. The ID must be in the format that CrawlID returns.
+	ResumeCrawl(ctx context.Context, crawlID string) error
+
+	// CrawlID returns the string representation of the database identifier
+	// of the crawl that the Client tracks internally. It returns an empty
+	// string if no crawl was initialized.
+	CrawlID() string
+
 	// SealCrawl marks the crawl (that the Client tracks internally) as done.
 	SealCrawl(ctx context.Context, args *SealCrawlArgs) error
 
@@ -98,9 +169,82 @@ type Client interface {
 	Flush(ctx context.Context) error
 }
 
+// Forker is implemented by clients that can derive additional clients from an
+// existing one. A forked client shares the database connection and caches
+// with its parent but tracks its own crawl. This allows us to crawl multiple
+// networks in the same process without opening a connection pool for each
+// network. The shared connection is closed after all clients were closed.
+type Forker interface {
+	// Fork returns a new client that writes data of the network with the
+	// given identifier.
+	Fork(networkID string) (Client, error)
+}
+
+// BatchInserter is implemented by clients that insert visits asynchronously
+// in batches. An insert error of such a client can't be returned from
+// [Client.InsertVisit] because the visit was already accepted. Instead, the
+// client hands the visits of a batch that couldn't be inserted to the
+// registered function.
+type BatchInserter interface {
+	// OnFailedVisits registers the function that receives the visits of a
+	// batch that couldn't be inserted.
+	OnFailedVisits(fn func(ctx context.Context, visits []*VisitArgs))
+}
+
+var _ BatchInserter = (*PostgresClient)(nil)
+
+// Shared returns true if the crawls of the given client are stored in a
+// database that multiple processes can access. The workers of a distributed
+// crawl resume the crawl that the coordinator has created, which fails for
+// clients that store crawls in local files.
+func Shared(client Client) bool {
+	switch c := client.(type) {
+	case *PostgresClient, *ClickHouseClient, *NoopClient:
+		return true
+	case *FanOutClient:
+		for _, backend := range c.clients {
+			if !Shared(backend) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	_ Forker = (*PostgresClient)(nil)
+	_ Forker = (*ClickHouseClient)(nil)
+	_ Forker = (*SQLiteClient)(nil)
+)
+
 var (
 	_ Client = (*PostgresClient)(nil)
 	_ Client = (*NoopClient)(nil)
 	_ Client = (*JSONClient)(nil)
 	_ Client = (*ClickHouseClient)(nil)
+	_ Client = (*SQLiteClient)(nil)
+	_ Client = (*ParquetClient)(nil)
+	_ Client = (*FanOutClient)(nil)
 )
+
+// SeparatesNetworks returns true if the given client keeps the data of
+// different networks apart. The Postgres schema doesn't store the network of
+// a crawl, so crawls of different networks would share peers and sessions and
+// seed each other with bootstrap peers.
+func SeparatesNetworks(client Client) bool {
+	switch c := client.(type) {
+	case *PostgresClient:
+		return false
+	case *FanOutClient:
+		for _, backend := range c.clients {
+			if !SeparatesNetworks(backend) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// CrawlLoader is implemented by database clients that can load the results
+// of a past crawl to compare it with another one.
+type CrawlLoader interface {
+	// LoadCrawl returns the peers that were visited during the crawl with
+	// the given ID.
+	LoadCrawl(ctx context.Context, crawlID string) (*CrawlSnapshot, error)
+}
+
+// CrawlSnapshot contains the peers that were visited during a single crawl.
+type CrawlSnapshot struct {
+	CrawlID string
+	Peers   map[peer.ID]*CrawlSnapshotPeer
+}
+
+// CrawlSnapshotPeer is the state of a peer as it was observed in a crawl.
+type CrawlSnapshotPeer struct {
+	// whether the crawler could connect to the peer
+	Dialable bool
+
+	// the agent version that the peer reported
+	AgentVersion string
+
+	// the sorted list of protocols that the peer supports
+	Protocols []string
+
+	// the sorted list of multi addresses that the peer advertised
+	Maddrs []string
+}
+
+func newCrawlSnapshot(crawlID string) *CrawlSnapshot {
+	return &CrawlSnapshot{
+		CrawlID: crawlID,
+		Peers:   map[peer.ID]*CrawlSnapshotPeer{},
+	}
+}
+
+// add records a visit of the given peer. If a peer was visited more than
+// once, a successful visit takes precedence.
+func (s *CrawlSnapshot) add(peerID peer.ID, p *CrawlSnapshotPeer) {
+	if prev, found := s.Peers[peerID]; found && prev.Dialable && !p.Dialable {
+		return
+	}
+
+	slices.Sort(p.Protocols)
+	p.Protocols = slices.Compact(p.Protocols)
+
+	slices.Sort(p.Maddrs)
+	p.Maddrs = slices.Compact(p.Maddrs)
+
+	s.Peers[peerID] = p
+}
+
+// CrawlDiff contains the differences between two crawls A and B.
+type CrawlDiff struct {
+	CrawlA string
+	CrawlB string
+
+	// the number of dialable peers in either crawl
+	DialablePeersA int
+	DialablePeersB int
+
+	// peers that were dialable in B but weren't found in A
+	NewPeers []peer.ID
+
+	// peers that were found but not dialable in A and dialable in B
+	ReappearedPeers []peer.ID
+
+	// peers that were dialable in A but weren't found or dialable in B
+	DisappearedPeers []peer.ID
+
+	// peers that were dialable in both crawls and reported different agent versions
+	AgentVersionChanges []*AgentVersionChange
+
+	// protocols that a different number of dialable peers supported
+	ProtocolChanges []*ProtocolChange
+
+	// peers that were found in both crawls and advertised different addresses
+	AddressChanges []*AddressChange
+}
+
+// AgentVersionChangeKind describes how the agent version of a peer changed.
+type AgentVersionChangeKind string
+
+const (
+	// AgentVersionUpgrade means the peer runs a newer version of the same software
+	AgentVersionUpgrade AgentVersionChangeKind = "upgrade"
+	// AgentVersionDowngrade means the peer runs an older version of the same software
+	AgentVersionDowngrade AgentVersionChangeKind = "downgrade"
+	// AgentVersionSwitch means the versions couldn't be compared, e.g.,
+	// because the peer runs different software.
+	AgentVersionSwitch AgentVersionChangeKind = "change"
+)
+
+// AgentVersionChange is a peer that reported different agent versions.
+type AgentVersionChange struct {
+	PeerID peer.ID
+	From   string
+	To     string
+	Kind   AgentVersionChangeKind
+}
+
+// ProtocolChange is a protocol that was supported by a different number of
+// dialable peers.
+type ProtocolChange struct {
+	Protocol string
+	PeersA   int
+	PeersB   int
+}
+
+// AddressChange is a peer that advertised different multi addresses.
+type AddressChange struct {
+	PeerID  peer.ID
+	Added   []string
+	Removed []string
+}
+
+// DiffCrawls compares crawl A with the later crawl B. A peer counts as
+// present in a crawl if it was dialable.
+func DiffCrawls(a *CrawlSnapshot, b *CrawlSnapshot) *CrawlDiff {
+	diff := &CrawlDiff{
+		CrawlA: a.CrawlID,
+		CrawlB: b.CrawlID,
+	}
+
+	protocols := map[string]*ProtocolChange{}
+	protocolChange := func(protocol string) *ProtocolChange {
+		if _, found := protocols[protocol]; !found {
+			protocols[protocol] = &ProtocolChange{Protocol: protocol}
+		}
+		return protocols[protocol]
+	}
+
+	for peerID, pa := range a.Peers {
+		if !pa.Dialable {
+			continue
+		}
+
+		diff.DialablePeersA += 1
+		for _, protocol := range pa.Protocols {
+			protocolChange(protocol).PeersA += 1
+		}
+
+		if pb, found := b.Peers[peerID]; !found || !pb.Dialable {
+			diff.DisappearedPeers = append(diff.DisappearedPeers, peerID)
+		}
+	}
+
+	for peerID, pb := range b.Peers {
+		pa, found := a.Peers[peerID]
+
+		if found && !slices.Equal(pa.Maddrs, pb.Maddrs) {
+			added, removed := diffSorted(pa.Maddrs, pb.Maddrs)
+			diff.AddressChanges = append(diff.AddressChanges, &AddressChange{
+				PeerID:  peerID,
+				Added:   added,
+				Removed: removed,
+			})
+		}
+
+		if !pb.Dialable {
+			continue
+		}
+
+		diff.DialablePeersB += 1
+		for _, protocol := range pb.Protocols {
+			protocolChange(protocol).PeersB += 1
+		}
+
+		if !found {
+			diff.NewPeers = append(diff.NewPeers, peerID)
+		} else if !pa.Dialable {
+			diff.ReappearedPeers = append(diff.ReappearedPeers, peerID)
+		} else if pa.AgentVersion != "" && pb.AgentVersion != "" && pa.AgentVersion != pb.AgentVersion {
+			diff.AgentVersionChanges = append(diff.AgentVersionChanges, &AgentVersionChange{
+				PeerID: peerID,
+				From:   pa.AgentVersion,
+				To:     pb.AgentVersion,
+				Kind:   agentVersionChangeKind(pa.AgentVersion, pb.AgentVersion),
+			})
+		}
+	}
+
+	for _, change := range protocols {
+		if change.PeersA != change.PeersB {
+			diff.ProtocolChanges = append(diff.ProtocolChanges, change)
+		}
+	}
+
+	comparePeerIDs := func(a, b peer.ID) int { return strings.Compare(string(a), string(b)) }
+	slices.SortFunc(diff.NewPeers, comparePeerIDs)
+	slices.SortFunc(diff.ReappearedPeers, comparePeerIDs)
+	slices.SortFunc(diff.DisappearedPeers, comparePeerIDs)
+	slices.SortFunc(diff.AgentVersionChanges, func(a, b *AgentVersionChange) int {
+		return comparePeerIDs(a.PeerID, b.PeerID)
+	})
+	slices.SortFunc(diff.AddressChanges, func(a, b *AddressChange) int {
+		return comparePeerIDs(a.PeerID, b.PeerID)
+	})
+	slices.SortFunc(diff.ProtocolChanges, func(a, b *ProtocolChange) int {
+		return strings.Compare(a.Protocol, b.Protocol)
+	})
+
+	return diff
+}
+
+// diffSorted returns the elements that are only in b and the elements that
+// are only in a. Both lists must be sorted.
+func diffSorted(a []string, b []string) (added []string, removed []string) {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			removed = append(removed, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			added = append(added, b[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return added, removed
+}
+
+// agentVersionChangeKind determines whether the agent version "to" is an
+// upgrade or downgrade of "from". Agent versions usually have the form
+// product/version/..., e.g., kubo/0.25.0/413a52d.
+func agentVersionChangeKind(from string, to string) AgentVersionChangeKind {
+	fromParts := strings.Split(from, "/")
+	toParts := strings.Split(to, "/")
+	if len(fromParts) < 2 || len(toParts) < 2 || fromParts[0] != toParts[0] {
+		return AgentVersionSwitch
+	}
+
+	cmp, ok := compareVersions(fromParts[1], toParts[1])
+	switch {
+	case !ok || cmp == 0:
+		return AgentVersionSwitch
+	case cmp < 0:
+		return AgentVersionUpgrade
+	default:
+		return AgentVersionDowngrade
+	}
+}
+
+// compareVersions compares two dot separated numeric versions with an
+// optional "v" prefix and pre-release or build suffix, e.g., v1.2.3-rc1. A
+// release is considered newer than a pre-release of the same version. It
+// returns false if either version can't be parsed.
+func compareVersions(a string, b string) (int, bool) {
+	aSegments, aSuffix, aOK := parseVersion(a)
+	bSegments, bSuffix, bOK := parseVersion(b)
+	if !aOK || !bOK {
+		return 0, false
+	}
+
+	for i := 0; i < max(len(aSegments), len(bSegments)); i++ {
+		var aSegment, bSegment int
+		if i < len(aSegments) {
+			aSegment = aSegments[i]
+		}
+		if i < len(bSegments) {
+			bSegment = bSegments[i]
+		}
+
+		if aSegment != bSegment {
+			if aSegment < bSegment {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+
+	switch {
+	case aSuffix == "" && bSuffix != "":
+		return 1, true
+	case aSuffix != "" && bSuffix == "":
+		return -1, true
+	default:
+		return 0, true
+	}
+}
+
+// parseVersion splits the given version into its numeric segments and the
+// suffix that starts at the first "-" or "+".
+func parseVersion(version string) ([]int, string, bool) {
+	version = strings.TrimPrefix(version, "v")
+
+	suffix := ""
+	if idx := strings.IndexAny(version, "-+"); idx >= 0 {
+		version, suffix = version[:idx], version[idx:]
+	}
+
+	var segments []int
+	for _, s := range strings.Split(version, ".") {
+		segment, err := strconv.Atoi(s)
+		if err != nil || segment < 0 {
+			return nil, "", false
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, suffix, true
+}
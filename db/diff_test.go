@@ -0,0 +1,129 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffCrawls(t *testing.T) {
+	p := jsonTestPeers(t)
+
+	a := newCrawlSnapshot("1")
+	a.add(p("stable"), &CrawlSnapshotPeer{Dialable: true, AgentVersion: "kubo/0.24.0/", Protocols: []string{"/ipfs/kad/1.0.0"}, Maddrs: []string{"/ip4/1.1.1.1/tcp/4001"}})
+	a.add(p("upgraded"), &CrawlSnapshotPeer{Dialable: true, AgentVersion: "kubo/0.24.0/", Protocols: []string{"/ipfs/kad/1.0.0"}})
+	a.add(p("switched"), &CrawlSnapshotPeer{Dialable: true, AgentVersion: "go-ipfs/0.8.0/"})
+	a.add(p("disappeared"), &CrawlSnapshotPeer{Dialable: true, Protocols: []string{"/ipfs/kad/1.0.0"}})
+	a.add(p("undialable"), &CrawlSnapshotPeer{Dialable: true})
+	a.add(p("reappeared"), &CrawlSnapshotPeer{Dialable: false})
+
+	b := newCrawlSnapshot("2")
+	b.add(p("stable"), &CrawlSnapshotPeer{Dialable: true, AgentVersion: "kubo/0.24.0/", Protocols: []string{"/ipfs/kad/1.0.0", "/ipfs/bitswap"}, Maddrs: []string{"/ip4/2.2.2.2/tcp/4001"}})
+	b.add(p("upgraded"), &CrawlSnapshotPeer{Dialable: true, AgentVersion: "kubo/0.25.0/", Protocols: []string{"/ipfs/kad/1.0.0"}})
+	b.add(p("switched"), &CrawlSnapshotPeer{Dialable: true, AgentVersion: "kubo/0.25.0/"})
+	b.add(p("undialable"), &CrawlSnapshotPeer{Dialable: false})
+	b.add(p("reappeared"), &CrawlSnapshotPeer{Dialable: true})
+	b.add(p("new"), &CrawlSnapshotPeer{Dialable: true})
+
+	diff := DiffCrawls(a, b)
+
+	assert.Equal(t, "1", diff.CrawlA)
+	assert.Equal(t, "2", diff.CrawlB)
+	assert.Equal(t, 5, diff.DialablePeersA)
+	assert.Equal(t, 5, diff.DialablePeersB)
+	assert.Equal(t, []peer.ID{p("new")}, diff.NewPeers)
+	assert.Equal(t, []peer.ID{p("reappeared")}, diff.ReappearedPeers)
+	assert.ElementsMatch(t, []peer.ID{p("disappeared"), p("undialable")}, diff.DisappearedPeers)
+
+	require.Len(t, diff.AgentVersionChanges, 2)
+	kinds := map[peer.ID]AgentVersionChangeKind{}
+	for _, change := range diff.AgentVersionChanges {
+		kinds[change.PeerID] = change.Kind
+	}
+	assert.Equal(t, AgentVersionUpgrade, kinds[p("upgraded")])
+	assert.Equal(t, AgentVersionSwitch, kinds[p("switched")])
+
+	assert.Equal(t, []*ProtocolChange{
+		{Protocol: "/ipfs/bitswap", PeersA: 0, PeersB: 1},
+		{Protocol: "/ipfs/kad/1.0.0", PeersA: 3, PeersB: 2},
+	}, diff.ProtocolChanges)
+
+	assert.Equal(t, []*AddressChange{
+		{PeerID: p("stable"), Added: []string{"/ip4/2.2.2.2/tcp/4001"}, Removed: []string{"/ip4/1.1.1.1/tcp/4001"}},
+	}, diff.AddressChanges)
+}
+
+func TestAgentVersionChangeKind(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want AgentVersionChangeKind
+	}{
+		{from: "kubo/0.24.0/", to: "kubo/0.25.0/abc", want: AgentVersionUpgrade},
+		{from: "kubo/0.25.0/", to: "kubo/0.24.1/", want: AgentVersionDowngrade},
+		{from: "kubo/0.25.0-rc1/", to: "kubo/0.25.0/", want: AgentVersionUpgrade},
+		{from: "kubo/v0.9/", to: "kubo/0.10.0/", want: AgentVersionUpgrade},
+		{from: "kubo/0.25.0/abc", to: "kubo/0.25.0/def", want: AgentVersionSwitch},
+		{from: "go-ipfs/0.8.0/", to: "kubo/0.25.0/", want: AgentVersionSwitch},
+		{from: "kubo/dev/", to: "kubo/0.25.0/", want: AgentVersionSwitch},
+		{from: "lotus", to: "lotus-1.25.0", want: AgentVersionSwitch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			assert.Equal(t, tt.want, agentVersionChangeKind(tt.from, tt.to))
+		})
+	}
+}
+
+func TestLoadJSONCrawl(t *testing.T) {
+	out := t.TempDir()
+	p := jsonTestPeers(t)
+
+	maddr1, err := ma.NewMultiaddr("/ip4/1.1.1.1/tcp/4001")
+	require.NoError(t, err)
+	maddr2, err := ma.NewMultiaddr("/ip4/2.2.2.2/tcp/4001")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(out, "2024-01-01T10:00_crawl.json"), []byte("{}"), 0o644))
+	writeJSONVisits(t, filepath.Join(out, "2024-01-01T10:00_visits.ndjson"),
+		JSONVisit{PeerID: p("a"), ConnectErrorStr: "connection_refused"},
+	)
+
+	require.NoError(t, os.WriteFile(filepath.Join(out, "2024-01-01T11:00_crawl.json"), []byte("{}"), 0o644))
+	writeJSONVisits(t, filepath.Join(out, "2024-01-01T11:00_visits.0000.ndjson"),
+		JSONVisit{PeerID: p("a"), AgentVersion: "kubo/0.25.0/", Protocols: []string{"b", "a"}, Maddrs: []ma.Multiaddr{maddr2}, FilteredMaddrs: []ma.Multiaddr{maddr1}},
+	)
+	writeJSONVisits(t, filepath.Join(out, "2024-01-01T11:00_visits.0001.ndjson"),
+		JSONVisit{PeerID: p("b"), ConnectErrorStr: "i_o_timeout"},
+	)
+
+	// the most recent crawl of the directory
+	snapshot, err := LoadJSONCrawl(out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2024-01-01T11:00", snapshot.CrawlID)
+	require.Len(t, snapshot.Peers, 2)
+	assert.Equal(t, &CrawlSnapshotPeer{
+		Dialable:     true,
+		AgentVersion: "kubo/0.25.0/",
+		Protocols:    []string{"a", "b"},
+		Maddrs:       []string{"/ip4/1.1.1.1/tcp/4001", "/ip4/2.2.2.2/tcp/4001"},
+	}, snapshot.Peers[p("a")])
+	assert.False(t, snapshot.Peers[p("b")].Dialable)
+
+	// a specific crawl by its prefix
+	snapshot, err = LoadJSONCrawl(filepath.Join(out, "2024-01-01T10:00"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "2024-01-01T10:00", snapshot.CrawlID)
+	require.Len(t, snapshot.Peers, 1)
+	assert.False(t, snapshot.Peers[p("a")].Dialable)
+
+	_, err = LoadJSONCrawl(filepath.Join(out, "2024-01-01T12:00"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// FanOutClient is a [Client] that writes all data to multiple backends at
+// once, e.g., to ClickHouse for dashboards and to Postgres for the session
+// tracking of the monitor.
+//
+// A failing backend doesn't affect the others. Write operations only return
+// an error if they failed for all backends. A backend that couldn't
+// initialize or resume the crawl is disabled for the rest of the crawl. Read
+// operations are served by the first backend (in the configured order) that
+// succeeds.
+//
+// The backends track their own crawls with their own IDs. Therefore, the
+// crawl ID of the FanOutClient is the comma separated list of the crawl IDs
+// of all backends.
+type FanOutClient struct {
+	names   []string
+	clients []Client
+
+	// disabled[i] is true if the i-th backend failed to initialize the crawl
+	disabled []atomic.Bool
+
+	// the number of failed write operations per backend
+	errCounts []atomic.Int64
+}
+
+// NewFanOutClient returns a new client that writes to all given clients. The
+// names identify the clients in log messages and must have the same length
+// as the list of clients.
+func NewFanOutClient(names []string, clients []Client) (*FanOutClient, error) {
+	if len(names) != len(clients) {
+		return nil, fmt.Errorf("got %d names for %d clients", len(names), len(clients))
+	} else if len(clients) == 0 {
+		return nil, fmt.Errorf("no clients given")
+	}
+
+	return &FanOutClient{
+		names:     names,
+		clients:   clients,
+		disabled:  make([]atomic.Bool, len(clients)),
+		errCounts: make([]atomic.Int64, len(clients)),
+	}, nil
+}
+
+// fanOut calls fn for all enabled backends in parallel and logs errors. It
+// only returns an error if fn failed for all of them.
+func (c *FanOutClient) fanOut(op string, fn func(i int, client Client) error) error {
+	var (
+		wg      sync.WaitGroup
+		errs    = make([]error, len(c.clients))
+		skipped = 0
+	)
+
+	for i, client := range c.clients {
+		if c.disabled[i].Load() {
+			skipped += 1
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(i, client); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", c.names[i], err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		failed += 1
+		c.errCounts[i].Add(1)
+		log.WithError(err).WithField("backend", c.names[i]).Warnln("Failed to " + op)
+	}
+
+	if skipped+failed == len(c.clients) {
+		return fmt.Errorf("%s failed for all backends: %w", op, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// InitCrawl initializes the crawl in all backends. Backends that fail to do
+// so are disabled for the rest of the crawl.
+func (c *FanOutClient) InitCrawl(ctx context.Context, version string) error {
+	return c.fanOut("init crawl", func(i int, client Client) error {
+		if err := client.InitCrawl(ctx, version); err != nil {
+			c.disabled[i].Store(true)
+			return err
+		}
+		return nil
+	})
+}
+
+// InitCrawlAt initializes the crawl with the given start time in all backends.
+// Backends that fail to do so are disabled for the rest of the crawl.
+func (c *FanOutClient) InitCrawlAt(ctx context.Context, version string, startedAt time.Time) error {
+	return c.fanOut("init crawl", func(i int, client Client) error {
+		if err := client.InitCrawlAt(ctx, version, startedAt); err != nil {
+			c.disabled[i].Store(true)
+			return err
+		}
+		return nil
+	})
+}
+
+// ResumeCrawl resumes the crawls of all backends. The given crawl ID must be
+// in the format that [FanOutClient.CrawlID] returns. Backends that fail to
+// resume the crawl or that didn't have a crawl are disabled.
+func (c *FanOutClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	crawlIDs := strings.Split(crawlID, ",")
+	if len(crawlIDs) != len(c.clients) {
+		return fmt.Errorf("crawl ID %q doesn't match the %d backends", crawlID, len(c.clients))
+	}
+
+	return c.fanOut("resume crawl", func(i int, client Client) error {
+		if crawlIDs[i] == "" {
+			c.disabled[i].Store(true)
+			return fmt.Errorf("no crawl to resume")
+		}
+
+		if err := client.ResumeCrawl(ctx, crawlIDs[i]); err != nil {
+			c.disabled[i].Store(true)
+			return err
+		}
+
+		return nil
+	})
+}
+
+// CrawlID returns the comma separated list of the crawl IDs of all backends.
+// The position of a disabled backend is left empty. It returns an empty
+// string if no backend has initialized a crawl.
+func (c *FanOutClient) CrawlID() string {
+	crawlIDs := make([]string, len(c.clients))
+
+	initialized := false
+	for i, client := range c.clients {
+		if c.disabled[i].Load() {
+			continue
+		}
+
+		crawlIDs[i] = client.CrawlID()
+		initialized = initialized || crawlIDs[i] != ""
+	}
+
+	if !initialized {
+		return ""
+	}
+
+	return strings.Join(crawlIDs, ",")
+}
+
+func (c *FanOutClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) error {
+	return c.fanOut("seal crawl", func(i int, client Client) error {
+		return client.SealCrawl(ctx, args)
+	})
+}
+
+// QueryBootstrapPeers returns the bootstrap peers of the first backend that
+// succeeds.
+func (c *FanOutClient) QueryBootstrapPeers(ctx context.Context, limit int) ([]peer.AddrInfo, error) {
+	return c.firstOf("query bootstrap peers", func(client Client) ([]peer.AddrInfo, error) {
+		return client.QueryBootstrapPeers(ctx, limit)
+	})
+}
+
+// InsertVisit inserts the visit into all backends. If the visit belongs to an
+// earlier crawl (see [VisitArgs.CrawlID]), each backend receives the visit
+// with its own crawl ID. Each backend receives its own copy of the visit
+// because backends may modify it.
+func (c *FanOutClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
+	var crawlIDs []string
+	if args.CrawlID != "" {
+		crawlIDs = strings.Split(args.CrawlID, ",")
+		if len(crawlIDs) != len(c.clients) {
+			return fmt.Errorf("crawl ID %q doesn't match the %d backends", args.CrawlID, len(c.clients))
+		}
+	}
+
+	backendArgs := make([]*VisitArgs, len(c.clients))
+	for i := range c.clients {
+		backendArgs[i] = args.clone()
+		if crawlIDs != nil {
+			backendArgs[i].CrawlID = crawlIDs[i]
+		}
+	}
+
+	return c.fanOut("insert visit", func(i int, client Client) error {
+		// the backend didn't take part in the earlier crawl
+		if crawlIDs != nil && crawlIDs[i] == "" {
+			return nil
+		}
+
+		return client.InsertVisit(ctx, backendArgs[i])
+	})
+}
+
+func (c *FanOutClient) InsertCrawlProperties(ctx context.Context, properties map[string]map[string]int) error {
+	return c.fanOut("insert crawl properties", func(i int, client Client) error {
+		return client.InsertCrawlProperties(ctx, properties)
+	})
+}
+
+// SelectPeersToProbe returns the peers to probe of the first backend that
+// succeeds.
+func (c *FanOutClient) SelectPeersToProbe(ctx context.Context) ([]peer.AddrInfo, error) {
+	return c.firstOf("select peers to probe", func(client Client) ([]peer.AddrInfo, error) {
+		return client.SelectPeersToProbe(ctx)
+	})
+}
+
+// firstOf calls fn for the enabled backends in order and returns the first
+// successful result.
+func (c *FanOutClient) firstOf(op string, fn func(client Client) ([]peer.AddrInfo, error)) ([]peer.AddrInfo, error) {
+	var errs []error
+	for i, client := range c.clients {
+		if c.disabled[i].Load() {
+			continue
+		}
+
+		addrInfos, err := fn(client)
+		if err == nil {
+			return addrInfos, nil
+		}
+
+		log.WithError(err).WithField("backend", c.names[i]).Warnln("Failed to " + op)
+		errs = append(errs, fmt.Errorf("%s: %w", c.names[i], err))
+	}
+
+	return nil, fmt.Errorf("%s failed for all backends: %w", op, errors.Join(errs...))
+}
+
+func (c *FanOutClient) Flush(ctx context.Context) error {
+	return c.fanOut("flush", func(i int, client Client) error {
+		return client.Flush(ctx)
+	})
+}
+
+// Close closes all backends and returns the errors of all of them.
+func (c *FanOutClient) Close() error {
+	var errs []error
+	for i, client := range c.clients {
+		if count := c.errCounts[i].Load(); count > 0 {
+			log.WithField("backend", c.names[i]).WithField("errors", count).Warnln("Backend had failed operations")
+		}
+
+		if err := client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.names[i], err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
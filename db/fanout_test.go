@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backendClient is a [flakyClient] that also tracks its crawl and serves
+// bootstrap peers.
+type backendClient struct {
+	flakyClient
+
+	initErr        error
+	bootstrapPeers []peer.AddrInfo
+	bootstrapErr   error
+}
+
+func (c *backendClient) InitCrawl(ctx context.Context, version string) error {
+	if c.initErr != nil {
+		return c.initErr
+	}
+	c.crawlID = "new"
+	return nil
+}
+
+func (c *backendClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	if c.initErr != nil {
+		return c.initErr
+	}
+	c.crawlID = crawlID
+	return nil
+}
+
+func (c *backendClient) QueryBootstrapPeers(ctx context.Context, limit int) ([]peer.AddrInfo, error) {
+	return c.bootstrapPeers, c.bootstrapErr
+}
+
+// sortingClient modifies the visit it receives like [ClickHouseClient] does.
+type sortingClient struct {
+	backendClient
+}
+
+func (c *sortingClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
+	sort.Strings(args.Protocols)
+	args.Properties = json.RawMessage(`{}`)
+	return c.backendClient.InsertVisit(ctx, args)
+}
+
+func newTestFanOutClient(t *testing.T, backends ...*backendClient) *FanOutClient {
+	names := make([]string, len(backends))
+	clients := make([]Client, len(backends))
+	for i, b := range backends {
+		names[i] = fmt.Sprintf("backend-%d", i)
+		clients[i] = b
+	}
+
+	c, err := NewFanOutClient(names, clients)
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestNewFanOutClient(t *testing.T) {
+	_, err := NewFanOutClient(nil, nil)
+	assert.Error(t, err)
+
+	_, err = NewFanOutClient([]string{"a"}, []Client{&NoopClient{}, &NoopClient{}})
+	assert.Error(t, err)
+}
+
+func TestFanOutClient_InsertVisit(t *testing.T) {
+	ctx := context.Background()
+	b0, b1 := &backendClient{}, &backendClient{}
+	c := newTestFanOutClient(t, b0, b1)
+
+	require.NoError(t, c.InsertVisit(ctx, testVisitArgs(t, "peer-1")))
+	assert.Len(t, b0.inserted(), 1)
+	assert.Len(t, b1.inserted(), 1)
+
+	// a failing backend doesn't affect the other one
+	b0.setFailing(true)
+	require.NoError(t, c.InsertVisit(ctx, testVisitArgs(t, "peer-2")))
+	assert.Len(t, b0.inserted(), 1)
+	assert.Len(t, b1.inserted(), 2)
+	assert.EqualValues(t, 1, c.errCounts[0].Load())
+
+	// only if all backends fail, the visit fails
+	b1.setFailing(true)
+	assert.Error(t, c.InsertVisit(ctx, testVisitArgs(t, "peer-3")))
+
+	require.NoError(t, c.Close())
+}
+
+func TestFanOutClient_InsertVisit_copies(t *testing.T) {
+	ctx := context.Background()
+	b0, b1 := &sortingClient{}, &sortingClient{}
+
+	c, err := NewFanOutClient([]string{"backend-0", "backend-1"}, []Client{b0, b1})
+	require.NoError(t, err)
+
+	// run with -race to detect backends that modify a shared visit
+	args := testVisitArgs(t, "peer-1")
+	args.Protocols = []string{"/b", "/a", "/c"}
+	require.NoError(t, c.InsertVisit(ctx, args))
+
+	assert.Equal(t, []string{"/b", "/a", "/c"}, args.Protocols)
+	assert.Nil(t, args.Properties)
+	require.Len(t, b0.inserted(), 1)
+	require.Len(t, b1.inserted(), 1)
+	assert.Equal(t, []string{"/a", "/b", "/c"}, b0.inserted()[0].Protocols)
+	assert.Equal(t, []string{"/a", "/b", "/c"}, b1.inserted()[0].Protocols)
+
+	require.NoError(t, c.Close())
+}
+
+func TestFanOutClient_crawl(t *testing.T) {
+	ctx := context.Background()
+	b0, b1, b2 := &backendClient{}, &backendClient{initErr: fmt.Errorf("unavailable")}, &backendClient{}
+	c := newTestFanOutClient(t, b0, b1, b2)
+
+	assert.Empty(t, c.CrawlID())
+
+	// the failing backend is disabled
+	require.NoError(t, c.InitCrawl(ctx, "test"))
+	assert.Equal(t, "new,,new", c.CrawlID())
+
+	require.NoError(t, c.InsertVisit(ctx, testVisitArgs(t, "peer-1")))
+	assert.Len(t, b0.inserted(), 1)
+	assert.Empty(t, b1.inserted())
+	assert.Len(t, b2.inserted(), 1)
+
+	// visits of earlier crawls are forwarded with the backend's crawl ID
+	args := testVisitArgs(t, "peer-2")
+	args.CrawlID = "1,,3"
+	require.NoError(t, c.InsertVisit(ctx, args))
+	assert.Equal(t, "1", b0.inserted()[1].CrawlID)
+	assert.Equal(t, "3", b2.inserted()[1].CrawlID)
+	assert.Equal(t, "1,,3", args.CrawlID)
+
+	args.CrawlID = "1,3"
+	assert.Error(t, c.InsertVisit(ctx, args))
+
+	// resuming the crawl assigns each backend its own crawl ID
+	b1.initErr = nil
+	c = newTestFanOutClient(t, b0, b1, b2)
+	require.NoError(t, c.ResumeCrawl(ctx, "1,,3"))
+	assert.Equal(t, "1,,3", c.CrawlID())
+	assert.True(t, c.disabled[1].Load())
+
+	c = newTestFanOutClient(t, b0, b1, b2)
+	assert.Error(t, c.ResumeCrawl(ctx, "1,3"))
+	assert.Error(t, c.ResumeCrawl(ctx, ",,"))
+}
+
+func TestFanOutClient_QueryBootstrapPeers(t *testing.T) {
+	ctx := context.Background()
+
+	bootstrapPeers := []peer.AddrInfo{{ID: peer.ID("peer-1")}}
+	b0 := &backendClient{bootstrapErr: fmt.Errorf("unavailable")}
+	b1 := &backendClient{bootstrapPeers: bootstrapPeers}
+	c := newTestFanOutClient(t, b0, b1)
+
+	peers, err := c.QueryBootstrapPeers(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, bootstrapPeers, peers)
+
+	b1.bootstrapErr = fmt.Errorf("unavailable")
+	_, err = c.QueryBootstrapPeers(ctx, 10)
+	assert.Error(t, err)
+}
+
+func TestShared(t *testing.T) {
+	assert.True(t, Shared(&PostgresClient{}))
+	assert.True(t, Shared(&ClickHouseClient{}))
+	assert.True(t, Shared(NewNoopClient()))
+	assert.False(t, Shared(&JSONClient{}))
+	assert.False(t, Shared(&ParquetClient{}))
+	assert.False(t, Shared(&SQLiteClient{}))
+
+	assert.True(t, Shared(&FanOutClient{clients: []Client{&PostgresClient{}, &ClickHouseClient{}}}))
+	assert.False(t, Shared(&FanOutClient{clients: []Client{&PostgresClient{}, &JSONClient{}}}))
+}
+
+func TestSeparatesNetworks(t *testing.T) {
+	assert.False(t, SeparatesNetworks(&PostgresClient{}))
+	assert.True(t, SeparatesNetworks(&ClickHouseClient{}))
+	assert.True(t, SeparatesNetworks(&SQLiteClient{}))
+	assert.True(t, SeparatesNetworks(&JSONClient{}))
+
+	assert.True(t, SeparatesNetworks(&FanOutClient{clients: []Client{&ClickHouseClient{}, &JSONClient{}}}))
+	assert.False(t, SeparatesNetworks(&FanOutClient{clients: []Client{&PostgresClient{}, &ClickHouseClient{}}}))
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// GraphLoader is implemented by database clients that can load the routing
+// tables of a past crawl as a graph.
+type GraphLoader interface {
+	// LoadNeighborGraph returns the directed graph of the routing tables
+	// that were fetched during the crawl with the given ID.
+	LoadNeighborGraph(ctx context.Context, crawlID string) (*NeighborGraph, error)
+}
+
+// NeighborGraph is the directed graph of the routing tables of a crawl. An
+// edge from peer A to peer B means that B was in the routing table of A.
+type NeighborGraph struct {
+	CrawlID string
+	Nodes   map[peer.ID]*GraphNode
+	Edges   []GraphEdge
+}
+
+// GraphNode contains the attributes of a peer in the neighbor graph. Peers
+// that were only found in routing tables weren't visited and lack most
+// attributes.
+type GraphNode struct {
+	// whether the peer was visited during the crawl
+	Visited bool
+
+	// whether the crawler could connect to the peer
+	Dialable bool
+
+	// the agent version that the peer reported
+	AgentVersion string
+
+	// the country and autonomous system of the peer's addresses if they were
+	// resolved. The ASN is zero if unknown.
+	Country string
+	ASN     int
+
+	// the discovery prefix of the peer if it's stored by the database
+	DiscoveryPrefix *uint64
+}
+
+// GraphEdge is a directed edge in the neighbor graph.
+type GraphEdge struct {
+	From peer.ID
+	To   peer.ID
+}
+
+func newNeighborGraph(crawlID string) *NeighborGraph {
+	return &NeighborGraph{
+		CrawlID: crawlID,
+		Nodes:   map[peer.ID]*GraphNode{},
+	}
+}
+
+// node returns the node of the given peer and adds it if it's missing.
+func (g *NeighborGraph) node(peerID peer.ID) *GraphNode {
+	n, found := g.Nodes[peerID]
+	if !found {
+		n = &GraphNode{}
+		g.Nodes[peerID] = n
+	}
+	return n
+}
+
+// addEdge adds an edge from the given peer to its neighbor.
+func (g *NeighborGraph) addEdge(from peer.ID, to peer.ID) {
+	g.node(from)
+	g.node(to)
+	g.Edges = append(g.Edges, GraphEdge{From: from, To: to})
+}
+
+// addVisits sets the attributes of all visited peers of the given crawl.
+func (g *NeighborGraph) addVisits(snapshot *CrawlSnapshot) {
+	for peerID, p := range snapshot.Peers {
+		n := g.node(peerID)
+		n.Visited = true
+		n.Dialable = p.Dialable
+		n.AgentVersion = p.AgentVersion
+	}
+}
+
+// sortedNodes returns the peer IDs of all nodes in a deterministic order.
+func (g *NeighborGraph) sortedNodes() []peer.ID {
+	peerIDs := make([]peer.ID, 0, len(g.Nodes))
+	for peerID := range g.Nodes {
+		peerIDs = append(peerIDs, peerID)
+	}
+	slices.SortFunc(peerIDs, func(a, b peer.ID) int {
+		return strings.Compare(string(a), string(b))
+	})
+	return peerIDs
+}
+
+// sortEdges orders the edges by their source and target peers.
+func (g *NeighborGraph) sortEdges() {
+	slices.SortFunc(g.Edges, func(a, b GraphEdge) int {
+		if cmp := strings.Compare(string(a.From), string(b.From)); cmp != 0 {
+			return cmp
+		}
+		return strings.Compare(string(a.To), string(b.To))
+	})
+}
+
+// peerIDDecoder decodes peer IDs and caches the results because peers
+// appear in many routing tables.
+type peerIDDecoder map[string]peer.ID
+
+func (d peerIDDecoder) decode(s string) (peer.ID, error) {
+	if peerID, found := d[s]; found {
+		return peerID, nil
+	}
+
+	peerID, err := peer.Decode(s)
+	if err != nil {
+		return "", fmt.Errorf("decode peer id %s: %w", s, err)
+	}
+	d[s] = peerID
+
+	return peerID, nil
+}
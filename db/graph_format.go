@@ -0,0 +1,304 @@
+package db
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GraphFormat is a file format to which a [NeighborGraph] can be written.
+type GraphFormat string
+
+const (
+	// GraphFormatGraphML is the XML based format that networkx and most other
+	// graph libraries can read.
+	GraphFormatGraphML GraphFormat = "graphml"
+	// GraphFormatGEXF is the native XML based format of Gephi.
+	GraphFormatGEXF GraphFormat = "gexf"
+	// GraphFormatDOT is the plain text format of Graphviz.
+	GraphFormatDOT GraphFormat = "dot"
+)
+
+// GraphFormats returns all supported graph formats.
+func GraphFormats() []GraphFormat {
+	return []GraphFormat{GraphFormatGraphML, GraphFormatGEXF, GraphFormatDOT}
+}
+
+// GraphFormatFromPath derives the graph format from the extension of the
+// given file path.
+func GraphFormatFromPath(path string) (GraphFormat, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".graphml":
+		return GraphFormatGraphML, nil
+	case ".gexf":
+		return GraphFormatGEXF, nil
+	case ".dot", ".gv":
+		return GraphFormatDOT, nil
+	default:
+		return "", fmt.Errorf("unknown graph file extension %q", ext)
+	}
+}
+
+// graphAttribute is a node attribute that is written to the graph files.
+type graphAttribute struct {
+	name string
+
+	// the attribute type in GraphML and GEXF
+	typ string
+
+	// returns the formatted value of the attribute and false if the node
+	// doesn't have it
+	value func(n *GraphNode) (string, bool)
+}
+
+var graphNodeAttributes = []graphAttribute{
+	{
+		name:  "visited",
+		typ:   "boolean",
+		value: func(n *GraphNode) (string, bool) { return strconv.FormatBool(n.Visited), true },
+	},
+	{
+		name:  "dialable",
+		typ:   "boolean",
+		value: func(n *GraphNode) (string, bool) { return strconv.FormatBool(n.Dialable), n.Visited },
+	},
+	{
+		name:  "agent_version",
+		typ:   "string",
+		value: func(n *GraphNode) (string, bool) { return n.AgentVersion, n.AgentVersion != "" },
+	},
+	{
+		name:  "country",
+		typ:   "string",
+		value: func(n *GraphNode) (string, bool) { return n.Country, n.Country != "" },
+	},
+	{
+		name:  "asn",
+		typ:   "long",
+		value: func(n *GraphNode) (string, bool) { return strconv.Itoa(n.ASN), n.ASN != 0 },
+	},
+	{
+		name: "discovery_prefix",
+		typ:  "string",
+		value: func(n *GraphNode) (string, bool) {
+			if n.DiscoveryPrefix == nil {
+				return "", false
+			}
+			return fmt.Sprintf("%016x", *n.DiscoveryPrefix), true
+		},
+	},
+}
+
+// Write writes the graph in the given format. Nodes and edges are ordered by
+// their peer IDs.
+func (g *NeighborGraph) Write(w io.Writer, format GraphFormat) error {
+	g.sortEdges()
+
+	switch format {
+	case GraphFormatGraphML:
+		return g.writeGraphML(w)
+	case GraphFormatGEXF:
+		return g.writeGEXF(w)
+	case GraphFormatDOT:
+		return g.writeDOT(w)
+	default:
+		return fmt.Errorf("unknown graph format %q", format)
+	}
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+func (g *NeighborGraph) writeGraphML(w io.Writer) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphMLGraph{
+			ID:          g.CrawlID,
+			EdgeDefault: "directed",
+			Nodes:       make([]graphMLNode, 0, len(g.Nodes)),
+			Edges:       make([]graphMLEdge, 0, len(g.Edges)),
+		},
+	}
+
+	for _, attr := range graphNodeAttributes {
+		doc.Keys = append(doc.Keys, graphMLKey{ID: attr.name, For: "node", Name: attr.name, Type: attr.typ})
+	}
+
+	for _, peerID := range g.sortedNodes() {
+		node := graphMLNode{ID: peerID.String()}
+		for _, attr := range graphNodeAttributes {
+			if value, ok := attr.value(g.Nodes[peerID]); ok {
+				node.Data = append(node.Data, graphMLData{Key: attr.name, Value: value})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+
+	for _, edge := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: edge.From.String(), Target: edge.To.String()})
+	}
+
+	return writeXML(w, doc)
+}
+
+type gexf struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string         `xml:"defaultedgetype,attr"`
+	Mode            string         `xml:"mode,attr"`
+	Attributes      gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode     `xml:"nodes>node"`
+	Edges           []gexfEdge     `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+func (g *NeighborGraph) writeGEXF(w io.Writer) error {
+	doc := gexf{
+		XMLNS:   "http://www.gexf.net/1.2draft",
+		Version: "1.2",
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Mode:            "static",
+			Attributes:      gexfAttributes{Class: "node"},
+			Nodes:           make([]gexfNode, 0, len(g.Nodes)),
+			Edges:           make([]gexfEdge, 0, len(g.Edges)),
+		},
+	}
+
+	for _, attr := range graphNodeAttributes {
+		doc.Graph.Attributes.Attributes = append(doc.Graph.Attributes.Attributes, gexfAttribute{ID: attr.name, Title: attr.name, Type: attr.typ})
+	}
+
+	for _, peerID := range g.sortedNodes() {
+		node := gexfNode{ID: peerID.String(), Label: peerID.String()}
+		for _, attr := range graphNodeAttributes {
+			if value, ok := attr.value(g.Nodes[peerID]); ok {
+				node.AttValues = append(node.AttValues, gexfAttValue{For: attr.name, Value: value})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+
+	for i, edge := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{ID: strconv.Itoa(i), Source: edge.From.String(), Target: edge.To.String()})
+	}
+
+	return writeXML(w, doc)
+}
+
+// writeXML writes the given document with an XML header.
+func writeXML(w io.Writer, doc any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encode xml: %w", err)
+	}
+
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("close xml encoder: %w", err)
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// dotEscaper escapes the characters that have a special meaning in quoted
+// DOT strings.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (g *NeighborGraph) writeDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	_, _ = fmt.Fprintf(bw, "digraph \"%s\" {\n", dotEscaper.Replace(g.CrawlID))
+
+	for _, peerID := range g.sortedNodes() {
+		var attrs []string
+		for _, attr := range graphNodeAttributes {
+			if value, ok := attr.value(g.Nodes[peerID]); ok {
+				attrs = append(attrs, fmt.Sprintf("%s=\"%s\"", attr.name, dotEscaper.Replace(value)))
+			}
+		}
+		_, _ = fmt.Fprintf(bw, "  \"%s\" [%s];\n", peerID, strings.Join(attrs, ", "))
+	}
+
+	for _, edge := range g.Edges {
+		_, _ = fmt.Fprintf(bw, "  \"%s\" -> \"%s\";\n", edge.From, edge.To)
+	}
+
+	_, _ = bw.WriteString("}\n")
+
+	return bw.Flush()
+}
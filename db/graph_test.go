@@ -0,0 +1,158 @@
+package db
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNeighborGraph(t *testing.T) (*NeighborGraph, func(name string) peer.ID) {
+	p := jsonTestPeers(t)
+
+	prefix := uint64(0xabcdef)
+
+	g := newNeighborGraph("42")
+	g.addEdge(p("a"), p("b"))
+	g.addEdge(p("a"), p("c"))
+	g.addEdge(p("b"), p("a"))
+
+	a := g.node(p("a"))
+	a.Visited = true
+	a.Dialable = true
+	a.AgentVersion = `kubo/0.25.0/"quoted"`
+	a.Country = "DE"
+	a.ASN = 3320
+	a.DiscoveryPrefix = &prefix
+
+	b := g.node(p("b"))
+	b.Visited = true
+
+	return g, p
+}
+
+func TestGraphFormatFromPath(t *testing.T) {
+	tests := map[string]GraphFormat{
+		"graph.graphml":  GraphFormatGraphML,
+		"graph.GEXF":     GraphFormatGEXF,
+		"out/graph.dot":  GraphFormatDOT,
+		"out/graph.gv":   GraphFormatDOT,
+		"graph.json":     "",
+		"graph.graphml/": "",
+	}
+	for path, want := range tests {
+		t.Run(path, func(t *testing.T) {
+			got, err := GraphFormatFromPath(path)
+			if want == "" {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, want, got)
+			}
+		})
+	}
+}
+
+func TestNeighborGraph_Write_graphml(t *testing.T) {
+	g, p := testNeighborGraph(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, g.Write(&buf, GraphFormatGraphML))
+
+	var doc graphML
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+
+	assert.Len(t, doc.Keys, len(graphNodeAttributes))
+	assert.Equal(t, "directed", doc.Graph.EdgeDefault)
+	require.Len(t, doc.Graph.Nodes, 3)
+	require.Len(t, doc.Graph.Edges, 3)
+
+	data := map[string]map[string]string{}
+	for _, node := range doc.Graph.Nodes {
+		data[node.ID] = map[string]string{}
+		for _, d := range node.Data {
+			data[node.ID][d.Key] = d.Value
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"visited":          "true",
+		"dialable":         "true",
+		"agent_version":    `kubo/0.25.0/"quoted"`,
+		"country":          "DE",
+		"asn":              "3320",
+		"discovery_prefix": "0000000000abcdef",
+	}, data[p("a").String()])
+	assert.Equal(t, map[string]string{"visited": "true", "dialable": "false"}, data[p("b").String()])
+	assert.Equal(t, map[string]string{"visited": "false"}, data[p("c").String()])
+
+	assert.Contains(t, doc.Graph.Edges, graphMLEdge{Source: p("b").String(), Target: p("a").String()})
+}
+
+func TestNeighborGraph_Write_gexf(t *testing.T) {
+	g, p := testNeighborGraph(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, g.Write(&buf, GraphFormatGEXF))
+
+	var doc gexf
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+
+	assert.Equal(t, "directed", doc.Graph.DefaultEdgeType)
+	assert.Len(t, doc.Graph.Attributes.Attributes, len(graphNodeAttributes))
+	require.Len(t, doc.Graph.Nodes, 3)
+	require.Len(t, doc.Graph.Edges, 3)
+
+	for _, node := range doc.Graph.Nodes {
+		if node.ID == p("c").String() {
+			assert.Equal(t, []gexfAttValue{{For: "visited", Value: "false"}}, node.AttValues)
+		}
+	}
+}
+
+func TestNeighborGraph_Write_dot(t *testing.T) {
+	g, p := testNeighborGraph(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, g.Write(&buf, GraphFormatDOT))
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "digraph \"42\" {\n"))
+	assert.True(t, strings.HasSuffix(out, "}\n"))
+	assert.Contains(t, out, `agent_version="kubo/0.25.0/\"quoted\""`)
+	assert.Contains(t, out, "\""+p("a").String()+"\" -> \""+p("b").String()+"\";\n")
+	assert.Equal(t, 3, strings.Count(out, " -> "))
+}
+
+func TestLoadJSONNeighborGraph(t *testing.T) {
+	out := t.TempDir()
+	p := jsonTestPeers(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(out, "2024-01-01T10:00_crawl.json"), []byte("{}"), 0o644))
+	writeJSONVisits(t, filepath.Join(out, "2024-01-01T10:00_visits.ndjson"),
+		JSONVisit{PeerID: p("a"), AgentVersion: "kubo/0.25.0/"},
+		JSONVisit{PeerID: p("b"), ConnectErrorStr: "connection_refused"},
+	)
+
+	f, err := os.Create(filepath.Join(out, "2024-01-01T10:00_neighbors.ndjson"))
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"PeerID":"` + p("a").String() + `","NeighborIDs":["` + p("b").String() + `","` + p("c").String() + `"],"ErrorBits":"0000000000000000"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	g, err := LoadJSONNeighborGraph(out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2024-01-01T10:00", g.CrawlID)
+	require.Len(t, g.Nodes, 3)
+	assert.Equal(t, &GraphNode{Visited: true, Dialable: true, AgentVersion: "kubo/0.25.0/"}, g.Nodes[p("a")])
+	assert.Equal(t, &GraphNode{Visited: true}, g.Nodes[p("b")])
+	assert.Equal(t, &GraphNode{}, g.Nodes[p("c")])
+	assert.ElementsMatch(t, []GraphEdge{{From: p("a"), To: p("b")}, {From: p("a"), To: p("c")}}, g.Edges)
+}
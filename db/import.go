@@ -0,0 +1,427 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+// JSONImport summarizes a crawl that was imported from the files of the JSON
+// client.
+type JSONImport struct {
+	// the common file name prefix of the imported files
+	Crawl string
+
+	// the ID of the crawl in the database it was imported into
+	CrawlID string
+
+	// the number of imported visits
+	Visits int
+
+	// the number of imported routing tables
+	Neighbors int
+
+	// whether the crawl was sealed. Interrupted crawls are left in the
+	// started state because their files lack the crawl results.
+	Sealed bool
+}
+
+// ListJSONCrawls returns the file name prefixes of all crawls in the given
+// output directory of the JSON client. The oldest crawl comes first.
+func ListJSONCrawls(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*_crawl.json"))
+	if err != nil {
+		return nil, fmt.Errorf("glob crawl files: %w", err)
+	}
+
+	// the file names start with the time of the crawl
+	slices.Sort(files)
+
+	prefixes := make([]string, len(files))
+	for i, file := range files {
+		prefixes[i] = strings.TrimSuffix(file, "_crawl.json")
+	}
+
+	return prefixes, nil
+}
+
+// ImportJSONCrawl replays the files of a crawl that the JSON client wrote
+// through the given database client. The crawl is given as for
+// [LoadJSONCrawl]. The crawl keeps its original start and finish times,
+// version, and results. The database client must not track a crawl yet.
+func ImportJSONCrawl(ctx context.Context, dbc Client, crawl string) (*JSONImport, error) {
+	prefix, err := jsonCrawlPrefix(crawl)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(prefix + "_crawl.json")
+	if err != nil {
+		return nil, fmt.Errorf("read crawl json: %w", err)
+	}
+
+	meta := &pgmodels.Crawl{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("unmarshal crawl json: %w", err)
+	}
+
+	neighbors, err := openImportNeighbors(prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer neighbors.close()
+
+	if err = dbc.InitCrawlAt(ctx, meta.Version, meta.StartedAt); err != nil {
+		return nil, fmt.Errorf("init crawl: %w", err)
+	}
+
+	result := &JSONImport{
+		Crawl:   filepath.Base(prefix),
+		CrawlID: dbc.CrawlID(),
+	}
+
+	files, err := filepath.Glob(prefix + "_visits*.ndjson*")
+	if err != nil {
+		return nil, fmt.Errorf("glob visits files: %w", err)
+	}
+
+	// rotated segments are numbered in the order they were written
+	slices.Sort(files)
+
+	for _, file := range files {
+		if err = importJSONVisits(ctx, dbc, file, neighbors, result); err != nil {
+			return nil, err
+		}
+	}
+
+	remaining, err := neighbors.skipped()
+	if err != nil {
+		return nil, err
+	} else if remaining > 0 {
+		log.WithField("count", remaining).Warnln("Skipped routing tables without a visit")
+	}
+
+	if meta.State == string(CrawlStateStarted) {
+		log.WithField("crawl", result.Crawl).Warnln("Crawl was interrupted, leaving it in the started state")
+		if err = dbc.Flush(ctx); err != nil {
+			return nil, fmt.Errorf("flush: %w", err)
+		}
+		return result, nil
+	}
+
+	args := &SealCrawlArgs{
+		Crawled:          meta.CrawledPeers.Int,
+		Dialable:         meta.DialablePeers.Int,
+		Undialable:       meta.UndialablePeers.Int,
+		Remaining:        meta.RemainingPeers.Int,
+		State:            CrawlState(meta.State),
+		NetworkSize:      meta.NetworkSize.Float64,
+		NetworkSizeLower: meta.NetworkSizeLower.Float64,
+		NetworkSizeUpper: meta.NetworkSizeUpper.Float64,
+		Retried:          meta.RetriedPeers.Int,
+		RetrySucceeded:   meta.RetrySucceededPeers.Int,
+		FinishedAt:       meta.FinishedAt.Time,
+	}
+
+	if err = dbc.SealCrawl(ctx, args); err != nil {
+		return nil, fmt.Errorf("seal crawl: %w", err)
+	}
+	result.Sealed = true
+
+	data, err = os.ReadFile(prefix + "_crawl_properties.json")
+	if err == nil {
+		properties := map[string]map[string]int{}
+		if err = json.Unmarshal(data, &properties); err != nil {
+			return nil, fmt.Errorf("unmarshal crawl properties json: %w", err)
+		}
+
+		if err = dbc.InsertCrawlProperties(ctx, properties); err != nil {
+			return nil, fmt.Errorf("insert crawl properties: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read crawl properties json: %w", err)
+	}
+
+	if err = dbc.Flush(ctx); err != nil {
+		return nil, fmt.Errorf("flush: %w", err)
+	}
+
+	return result, nil
+}
+
+// importNeighborsWindow is the maximum number of routing tables that are read
+// ahead of the visits. The JSON client writes the routing table of a peer
+// together with its visit, so both streams are in the same order. Files of
+// older versions were written by concurrent writers without coordination, so
+// their order may differ slightly.
+const importNeighborsWindow = 1000
+
+// importNeighbors reads the routing tables of a crawl alongside its visits,
+// so that only a small window of routing tables is held in memory.
+type importNeighbors struct {
+	files []string
+	file  string
+	f     io.Closer
+	dec   *json.Decoder
+
+	// the routing tables that were read ahead of their visits together with
+	// their position in the neighbors stream.
+	pending []*importNeighborsEntry
+
+	// the position of the next routing table in the neighbors stream
+	next int
+
+	// the number of routing tables without a visit
+	dropped int
+}
+
+type importNeighborsEntry struct {
+	*JSONNeighbors
+	pos int
+}
+
+// openImportNeighbors prepares reading the neighbors files of the crawl with
+// the given prefix.
+func openImportNeighbors(prefix string) (*importNeighbors, error) {
+	files, err := filepath.Glob(prefix + "_neighbors*.ndjson*")
+	if err != nil {
+		return nil, fmt.Errorf("glob neighbors files: %w", err)
+	}
+
+	// rotated segments are numbered in the order they were written
+	slices.Sort(files)
+
+	return &importNeighbors{files: files}, nil
+}
+
+// pop returns the routing table of the given visit or nil if the visit didn't
+// yield one. A peer is visited more than once if it was retried, so the retry
+// pass distinguishes the routing tables of its visits.
+func (n *importNeighbors) pop(visit *jsonImportVisit) (*JSONNeighbors, error) {
+	for {
+		idx := slices.IndexFunc(n.pending, func(e *importNeighborsEntry) bool {
+			return e.PeerID == visit.PeerID && e.RetryPass == visit.RetryPass
+		})
+
+		if idx >= 0 {
+			entry := n.pending[idx]
+			n.pending = slices.Delete(n.pending, idx, idx+1)
+
+			// the visits of routing tables that are much older than this one
+			// must have passed already.
+			n.pending = slices.DeleteFunc(n.pending, func(e *importNeighborsEntry) bool {
+				if entry.pos-e.pos > importNeighborsWindow {
+					n.dropped += 1
+					return true
+				}
+				return false
+			})
+
+			return entry.JSONNeighbors, nil
+		}
+
+		if len(n.pending) >= importNeighborsWindow {
+			return nil, nil
+		}
+
+		neighbors, err := n.read()
+		if err != nil {
+			return nil, err
+		} else if neighbors == nil {
+			return nil, nil
+		}
+
+		n.pending = append(n.pending, &importNeighborsEntry{JSONNeighbors: neighbors, pos: n.next})
+		n.next += 1
+	}
+}
+
+// read returns the next routing table of the neighbors files or nil if all
+// files were read.
+func (n *importNeighbors) read() (*JSONNeighbors, error) {
+	for {
+		if n.dec == nil {
+			if len(n.files) == 0 {
+				return nil, nil
+			}
+
+			f, err := openJSONSegment(n.files[0])
+			if err != nil {
+				return nil, fmt.Errorf("open neighbors file: %w", err)
+			}
+
+			n.file, n.files = n.files[0], n.files[1:]
+			n.f, n.dec = f, json.NewDecoder(f)
+		}
+
+		neighbors := &JSONNeighbors{}
+		err := n.dec.Decode(neighbors)
+		if err == nil {
+			return neighbors, nil
+		} else if !errors.Is(err, io.EOF) {
+			// the last line is incomplete if the crawl was interrupted
+			log.WithError(err).WithField("file", n.file).Debugln("Stopped reading neighbors file")
+		}
+
+		n.close()
+	}
+}
+
+// skipped reads the remaining routing tables and returns the number of all
+// routing tables without a visit.
+func (n *importNeighbors) skipped() (int, error) {
+	for {
+		neighbors, err := n.read()
+		if err != nil {
+			return 0, err
+		} else if neighbors == nil {
+			break
+		}
+		n.dropped += 1
+	}
+
+	n.dropped += len(n.pending)
+	n.pending = nil
+
+	return n.dropped, nil
+}
+
+// close closes the currently open neighbors file.
+func (n *importNeighbors) close() {
+	if n.f != nil {
+		_ = n.f.Close()
+	}
+	n.f, n.dec = nil, nil
+}
+
+// importJSONVisits inserts all visits of the given, possibly compressed,
+// visits file together with the routing tables of the visited peers.
+func importJSONVisits(ctx context.Context, dbc Client, file string, neighbors *importNeighbors, result *JSONImport) error {
+	f, err := openJSONSegment(file)
+	if err != nil {
+		return fmt.Errorf("open visits file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := json.NewDecoder(f)
+	for {
+		visit := &jsonImportVisit{}
+		if err := dec.Decode(visit); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// the last line is incomplete if the crawl was interrupted
+			log.WithError(err).WithField("file", file).Debugln("Stopped reading visits file")
+			break
+		}
+
+		nbs, err := neighbors.pop(visit)
+		if err != nil {
+			return err
+		}
+
+		args, err := importVisitArgs(visit, nbs)
+		if err != nil {
+			return fmt.Errorf("visit of %s in %s: %w", visit.PeerID, file, err)
+		}
+
+		if err = dbc.InsertVisit(ctx, args); err != nil {
+			return fmt.Errorf("insert visit: %w", err)
+		}
+
+		result.Visits += 1
+		if len(args.Neighbors) > 0 || args.ErrorBits != 0 {
+			result.Neighbors += 1
+		}
+	}
+
+	return nil
+}
+
+// jsonImportVisit is a [JSONVisit] whose connect address may be empty, which
+// it is for undialable peers.
+type jsonImportVisit struct {
+	JSONVisit
+	ConnectMaddr string
+}
+
+// importVisitArgs converts the given visit and the routing table of the
+// peer, which may be nil, back to the arguments that were used to insert the
+// visit. Dial errors, dial durations, and extra addresses aren't written to
+// the JSON files and stay empty.
+func importVisitArgs(visit *jsonImportVisit, neighbors *JSONNeighbors) (*VisitArgs, error) {
+	args := &VisitArgs{
+		PeerID:          visit.PeerID,
+		DiscoveryPrefix: visit.DiscoveryPrefix,
+		AgentVersion:    visit.AgentVersion,
+		Protocols:       visit.Protocols,
+		DialMaddrs:      visit.Maddrs,
+		FilteredMaddrs:  visit.FilteredMaddrs,
+		ListenMaddrs:    visit.ListenMaddrs,
+		VisitStartedAt:  visit.VisitStartedAt,
+		VisitEndedAt:    visit.VisitEndedAt,
+		ConnectErrorStr: visit.ConnectErrorStr,
+		CrawlErrorStr:   visit.CrawlErrorStr,
+		VisitType:       VisitTypeCrawl,
+		RetryPass:       visit.RetryPass,
+	}
+
+	var err error
+	if visit.ConnectMaddr != "" {
+		if args.ConnectMaddr, err = ma.NewMultiaddr(visit.ConnectMaddr); err != nil {
+			return nil, fmt.Errorf("parse connect maddr: %w", err)
+		}
+	}
+
+	if args.ConnectDuration, err = parseImportDuration(visit.ConnectDuration); err != nil {
+		return nil, fmt.Errorf("parse connect duration: %w", err)
+	}
+
+	if args.CrawlDuration, err = parseImportDuration(visit.CrawlDuration); err != nil {
+		return nil, fmt.Errorf("parse crawl duration: %w", err)
+	}
+
+	if visit.Properties.Valid {
+		args.Properties = json.RawMessage(visit.Properties.JSON)
+	}
+
+	if neighbors == nil {
+		return args, nil
+	}
+
+	errorBits, err := strconv.ParseUint(neighbors.ErrorBits, 2, 16)
+	if err != nil {
+		return nil, fmt.Errorf("parse error bits %q: %w", neighbors.ErrorBits, err)
+	}
+
+	args.Neighbors = neighbors.NeighborIDs
+	args.ErrorBits = uint16(errorBits)
+
+	// files of older versions don't contain the discovery prefixes
+	if len(neighbors.NeighborPrefixes) == len(neighbors.NeighborIDs) {
+		args.NeighborPrefixes = neighbors.NeighborPrefixes
+	}
+
+	return args, nil
+}
+
+// parseImportDuration parses a duration that was formatted with
+// [time.Duration.String]. Empty strings are zero.
+func parseImportDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportJSONCrawl(t *testing.T) {
+	ctx := context.Background()
+	p := jsonTestPeers(t)
+
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+
+	// write a crawl from last year with rotated and compressed files
+	cfg := &JSONClientConfig{
+		Out:         t.TempDir(),
+		Compression: JSONCompressionGzip,
+		RotateCount: 1,
+	}
+	jsonClient, err := NewJSONClient(cfg)
+	require.NoError(t, err)
+
+	startedAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	finishedAt := startedAt.Add(10 * time.Minute)
+	require.NoError(t, jsonClient.InitCrawlAt(ctx, "v1.2.3", startedAt))
+
+	require.NoError(t, jsonClient.InsertVisit(ctx, &VisitArgs{
+		PeerID:           p("a"),
+		DiscoveryPrefix:  1,
+		AgentVersion:     "kubo/0.25.0/",
+		Protocols:        []string{"/ipfs/kad/1.0.0"},
+		DialMaddrs:       []ma.Multiaddr{maddr},
+		ConnectMaddr:     maddr,
+		ConnectDuration:  time.Second,
+		CrawlDuration:    2 * time.Second,
+		VisitStartedAt:   startedAt.Add(time.Minute),
+		VisitEndedAt:     startedAt.Add(time.Minute + 2*time.Second),
+		VisitType:        VisitTypeCrawl,
+		Neighbors:        []peer.ID{p("b"), p("c")},
+		NeighborPrefixes: []uint64{2, 3},
+		Properties:       []byte(`{"is_exposed":true}`),
+	}))
+	require.NoError(t, jsonClient.InsertVisit(ctx, &VisitArgs{
+		PeerID:          p("b"),
+		DiscoveryPrefix: 2,
+		DialMaddrs:      []ma.Multiaddr{maddr},
+		VisitStartedAt:  startedAt.Add(2 * time.Minute),
+		VisitEndedAt:    startedAt.Add(2*time.Minute + time.Second),
+		VisitType:       VisitTypeCrawl,
+		ConnectErrorStr: "connection_refused",
+		ErrorBits:       1,
+	}))
+
+	require.NoError(t, jsonClient.SealCrawl(ctx, &SealCrawlArgs{
+		Crawled:    2,
+		Dialable:   1,
+		Undialable: 1,
+		State:      CrawlStateSucceeded,
+		FinishedAt: finishedAt,
+	}))
+	require.NoError(t, jsonClient.InsertCrawlProperties(ctx, map[string]map[string]int{
+		"agent_version": {"kubo/0.25.0/": 1},
+	}))
+	require.NoError(t, jsonClient.Close())
+
+	ctx, client := setupSQLite(t)
+
+	result, err := ImportJSONCrawl(ctx, client, cfg.Out)
+	require.NoError(t, err)
+	assert.Equal(t, &JSONImport{
+		Crawl:     filepath.Base(jsonClient.prefix),
+		CrawlID:   "1",
+		Visits:    2,
+		Neighbors: 2,
+		Sealed:    true,
+	}, result)
+
+	var (
+		state         string
+		version       string
+		crawled       int
+		gotStartedAt  time.Time
+		gotFinishedAt time.Time
+		properties    int
+	)
+	err = client.dbh.QueryRow("SELECT state, version, crawled_peers, started_at, finished_at FROM crawls WHERE id = 1").
+		Scan(&state, &version, &crawled, &gotStartedAt, &gotFinishedAt)
+	require.NoError(t, err)
+
+	assert.Equal(t, string(CrawlStateSucceeded), state)
+	assert.Equal(t, "v1.2.3", version)
+	assert.Equal(t, 2, crawled)
+	assert.True(t, startedAt.Equal(gotStartedAt))
+	assert.True(t, finishedAt.Equal(gotFinishedAt))
+
+	require.NoError(t, client.dbh.QueryRow("SELECT count(*) FROM crawl_properties WHERE crawl_id = 1").Scan(&properties))
+	assert.Equal(t, 1, properties)
+
+	snapshot, err := client.LoadCrawl(ctx, "1")
+	require.NoError(t, err)
+	require.Len(t, snapshot.Peers, 2)
+	assert.Equal(t, &CrawlSnapshotPeer{
+		Dialable:     true,
+		AgentVersion: "kubo/0.25.0/",
+		Protocols:    []string{"/ipfs/kad/1.0.0"},
+		Maddrs:       []string{maddr.String()},
+	}, snapshot.Peers[p("a")])
+	assert.False(t, snapshot.Peers[p("b")].Dialable)
+
+	g, err := client.LoadNeighborGraph(ctx, "1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []GraphEdge{{From: p("a"), To: p("b")}, {From: p("a"), To: p("c")}}, g.Edges)
+}
+
+func TestImportJSONCrawl_interrupted(t *testing.T) {
+	out := t.TempDir()
+	p := jsonTestPeers(t)
+
+	require.NoError(t, os.WriteFile(filepath.Join(out, "2024-01-01T10:00_crawl.json"), []byte(`{"state":"started","started_at":"2024-01-01T10:00:00Z","version":"v1"}`), 0o644))
+	writeJSONVisits(t, filepath.Join(out, "2024-01-01T10:00_visits.ndjson"),
+		JSONVisit{PeerID: p("a"), ConnectDuration: "1s", CrawlDuration: "2s", VisitStartedAt: time.Now(), VisitEndedAt: time.Now()},
+	)
+
+	// files of older versions lack the discovery prefixes of the neighbors
+	require.NoError(t, os.WriteFile(filepath.Join(out, "2024-01-01T10:00_neighbors.ndjson"),
+		[]byte(`{"PeerID":"`+p("a").String()+`","NeighborIDs":["`+p("b").String()+`"],"ErrorBits":"0000000000000010"}`+"\n"), 0o644))
+
+	ctx, client := setupSQLite(t)
+
+	result, err := ImportJSONCrawl(ctx, client, filepath.Join(out, "2024-01-01T10:00"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Visits)
+	assert.Equal(t, 1, result.Neighbors)
+	assert.False(t, result.Sealed)
+
+	var (
+		state      string
+		finishedAt *time.Time
+		errorBits  int
+	)
+	require.NoError(t, client.dbh.QueryRow("SELECT state, finished_at FROM crawls WHERE id = 1").Scan(&state, &finishedAt))
+	assert.Equal(t, string(CrawlStateStarted), state)
+	assert.Nil(t, finishedAt)
+
+	require.NoError(t, client.dbh.QueryRow("SELECT error_bits FROM neighbors WHERE crawl_id = 1").Scan(&errorBits))
+	assert.Equal(t, 2, errorBits)
+}
+
+func TestImportNeighbors_pop(t *testing.T) {
+	out := t.TempDir()
+	p := jsonTestPeers(t)
+
+	// the routing table of the retried peer a belongs to its second visit,
+	// and the routing tables of b and c are out of order.
+	writeJSONNeighbors := func(file string, neighbors ...JSONNeighbors) {
+		f, err := os.Create(filepath.Join(out, file))
+		require.NoError(t, err)
+		enc := json.NewEncoder(f)
+		for _, n := range neighbors {
+			require.NoError(t, enc.Encode(n))
+		}
+		require.NoError(t, f.Close())
+	}
+	writeJSONNeighbors("crawl_neighbors_0000.ndjson",
+		JSONNeighbors{PeerID: p("c"), ErrorBits: "0000000000000000"},
+		JSONNeighbors{PeerID: p("b"), ErrorBits: "0000000000000000"},
+	)
+	writeJSONNeighbors("crawl_neighbors_0001.ndjson",
+		JSONNeighbors{PeerID: p("a"), ErrorBits: "0000000000000000", RetryPass: 1},
+		JSONNeighbors{PeerID: p("d"), ErrorBits: "0000000000000000"},
+	)
+
+	neighbors, err := openImportNeighbors(filepath.Join(out, "crawl"))
+	require.NoError(t, err)
+	defer neighbors.close()
+
+	pop := func(id string, retryPass int) *JSONNeighbors {
+		visit := &jsonImportVisit{JSONVisit: JSONVisit{PeerID: p(id), RetryPass: retryPass}}
+		n, err := neighbors.pop(visit)
+		require.NoError(t, err)
+		return n
+	}
+
+	assert.Nil(t, pop("a", 0))
+	assert.Equal(t, p("b"), pop("b", 0).PeerID)
+	assert.Equal(t, p("c"), pop("c", 0).PeerID)
+	assert.Equal(t, p("a"), pop("a", 1).PeerID)
+
+	// the routing table of d doesn't have a visit
+	skipped, err := neighbors.skipped()
+	require.NoError(t, err)
+	assert.Equal(t, 1, skipped)
+}
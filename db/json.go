@@ -3,9 +3,12 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"sync"
 	"time"
@@ -19,60 +22,91 @@ import (
 )
 
 type JSONClient struct {
+	cfg *JSONClientConfig
+
 	out string
 
 	prefix string
 
-	visitsFile       *os.File
-	neighborsFile    *os.File
-	visitEncoder     *json.Encoder
-	neighborsEncoder *json.Encoder
+	// the manifest lists the segments of the visits and neighbors streams
+	manifest  *jsonManifest
+	visits    *jsonStream
+	neighbors *jsonStream
 
-	// ... TODO
-	routingTables map[peer.ID]struct {
-		neighbors []peer.ID
-		errorBits uint16
-	}
+	// writes the routing table and the visit of a peer together, so that
+	// the neighbors stream is in the same order as the visits stream.
+	visitMu sync.Mutex
 
+	// ... TODO
 	crawlMu sync.Mutex
 	crawl   *pgmodels.Crawl
+
+	// the file-backed session state of all peers
+	sessions *jsonSessions
 }
 
-func NewJSONClient(out string) (*JSONClient, error) {
-	log.WithField("out", out).Infoln("Initializing JSON client")
+func NewJSONClient(cfg *JSONClientConfig) (*JSONClient, error) {
+	log.WithField("out", cfg.Out).Infoln("Initializing JSON client")
 
-	if err := os.MkdirAll(out, 0o755); err != nil {
-		return nil, fmt.Errorf("make json out directory: %w", err)
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate json client config: %w", err)
 	}
-	prefix := path.Join(out, time.Now().Format("2006-01-02T15:04"))
 
-	vf, err := os.Create(prefix + "_visits.ndjson")
-	if err != nil {
-		return nil, fmt.Errorf("create visits file: %w", err)
+	if err := os.MkdirAll(cfg.Out, 0o755); err != nil {
+		return nil, fmt.Errorf("make json out directory: %w", err)
 	}
+	prefix := path.Join(cfg.Out, time.Now().Format("2006-01-02T15:04"))
 
-	nf, err := os.Create(prefix + "_neighbors.ndjson")
+	sessions, err := loadJSONSessions(cfg.Out)
 	if err != nil {
-		return nil, fmt.Errorf("create neighbors file: %w", err)
+		return nil, fmt.Errorf("load sessions: %w", err)
 	}
 
 	client := &JSONClient{
-		out:              out,
-		prefix:           prefix,
-		visitsFile:       vf,
-		neighborsFile:    nf,
-		visitEncoder:     json.NewEncoder(vf),
-		neighborsEncoder: json.NewEncoder(nf),
-		routingTables: make(map[peer.ID]struct {
-			neighbors []peer.ID
-			errorBits uint16
-		}),
+		cfg:      cfg,
+		out:      cfg.Out,
+		prefix:   prefix,
+		sessions: sessions,
+	}
+
+	if err = client.openStreams(); err != nil {
+		return nil, err
 	}
 
 	return client, nil
 }
 
-func (c *JSONClient) InitCrawl(ctx context.Context, version string) (err error) {
+// openStreams loads the manifest of the crawl with the current prefix and
+// opens the visits and neighbors streams.
+func (c *JSONClient) openStreams() error {
+	manifest, err := loadJSONManifest(c.prefix+"_manifest.json", path.Base(c.prefix))
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+
+	visits, err := openJSONStream(c.cfg, c.prefix, "visits", manifest)
+	if err != nil {
+		return fmt.Errorf("open visits stream: %w", err)
+	}
+
+	neighbors, err := openJSONStream(c.cfg, c.prefix, "neighbors", manifest)
+	if err != nil {
+		_ = visits.Close()
+		return fmt.Errorf("open neighbors stream: %w", err)
+	}
+
+	c.manifest = manifest
+	c.visits = visits
+	c.neighbors = neighbors
+
+	return nil
+}
+
+func (c *JSONClient) InitCrawl(ctx context.Context, version string) error {
+	return c.InitCrawlAt(ctx, version, time.Now())
+}
+
+func (c *JSONClient) InitCrawlAt(ctx context.Context, version string, startedAt time.Time) (err error) {
 	c.crawlMu.Lock()
 	defer c.crawlMu.Unlock()
 
@@ -90,7 +124,7 @@ func (c *JSONClient) InitCrawl(ctx context.Context, version string) (err error)
 
 	c.crawl = &pgmodels.Crawl{
 		State:     pgmodels.CrawlStateStarted,
-		StartedAt: now,
+		StartedAt: startedAt,
 		Version:   version,
 		UpdatedAt: now,
 		CreatedAt: now,
@@ -108,6 +142,67 @@ func (c *JSONClient) InitCrawl(ctx context.Context, version string) (err error)
 	return nil
 }
 
+// ResumeCrawl continues writing to the files of a previous crawl in the same
+// output directory. The crawl ID is the common file name prefix of these files.
+func (c *JSONClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawl != nil {
+		return fmt.Errorf("crawl already initialized")
+	}
+
+	prefix := path.Join(c.out, crawlID)
+
+	data, err := os.ReadFile(prefix + "_crawl.json")
+	if err != nil {
+		return fmt.Errorf("read crawl json: %w", err)
+	}
+
+	crawl := &pgmodels.Crawl{}
+	if err = json.Unmarshal(data, crawl); err != nil {
+		return fmt.Errorf("unmarshal crawl json: %w", err)
+	}
+
+	// the files that were created when initializing the client are still
+	// empty and not needed anymore.
+	if err = c.closeStreams(); err != nil {
+		log.WithError(err).Warnln("Failed closing JSON files")
+	}
+	if c.prefix != prefix {
+		for _, file := range c.manifest.files() {
+			_ = os.Remove(path.Join(c.out, file))
+		}
+		_ = os.Remove(c.prefix + "_manifest.json")
+	}
+
+	c.prefix = prefix
+	if err = c.openStreams(); err != nil {
+		return err
+	}
+
+	crawl.State = pgmodels.CrawlStateStarted
+	crawl.FinishedAt = null.TimeFromPtr(nil)
+	crawl.UpdatedAt = time.Now()
+	c.crawl = crawl
+
+	data, err = json.MarshalIndent(c.crawl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal crawl json: %w", err)
+	}
+
+	if err = os.WriteFile(c.prefix+"_crawl.json", data, 0o644); err != nil {
+		return fmt.Errorf("write crawl json: %w", err)
+	}
+
+	return nil
+}
+
+// CrawlID returns the common file name prefix of all files of this crawl.
+func (c *JSONClient) CrawlID() string {
+	return path.Base(c.prefix)
+}
+
 func (c *JSONClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) (err error) {
 	c.crawlMu.Lock()
 	defer c.crawlMu.Unlock()
@@ -126,8 +221,13 @@ func (c *JSONClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) (err er
 	c.crawl.DialablePeers = null.IntFrom(args.Dialable)
 	c.crawl.UndialablePeers = null.IntFrom(args.Undialable)
 	c.crawl.RemainingPeers = null.IntFrom(args.Remaining)
+	c.crawl.NetworkSize = null.NewFloat64(args.NetworkSize, args.NetworkSize != 0)
+	c.crawl.NetworkSizeLower = null.NewFloat64(args.NetworkSizeLower, args.NetworkSize != 0)
+	c.crawl.NetworkSizeUpper = null.NewFloat64(args.NetworkSizeUpper, args.NetworkSize != 0)
+	c.crawl.RetriedPeers = null.IntFrom(args.Retried)
+	c.crawl.RetrySucceededPeers = null.IntFrom(args.RetrySucceeded)
 	c.crawl.State = string(args.State)
-	c.crawl.FinishedAt = null.TimeFrom(now)
+	c.crawl.FinishedAt = null.TimeFrom(args.finishedAt(now))
 
 	data, err := json.MarshalIndent(c.crawl, "", "  ")
 	if err != nil {
@@ -141,8 +241,135 @@ func (c *JSONClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) (err er
 	return nil
 }
 
+// QueryBootstrapPeers reads the visits files of previous crawls in the output
+// directory and returns the peers that were dialable in their most recent
+// visit. The most recently visited peers come first.
 func (c *JSONClient) QueryBootstrapPeers(ctx context.Context, limit int) ([]peer.AddrInfo, error) {
-	return []peer.AddrInfo{}, nil
+	files, err := filepath.Glob(path.Join(c.out, "*_visits*.ndjson*"))
+	if err != nil {
+		return nil, fmt.Errorf("glob visits files: %w", err)
+	}
+
+	// the file names start with the time of the crawl followed by the
+	// segment index, so the newest files come last.
+	slices.Sort(files)
+	slices.Reverse(files)
+
+	seen := map[peer.ID]struct{}{}
+	var dialable []*jsonBootstrapVisit
+	for _, file := range files {
+		if len(dialable) >= limit {
+			break
+		}
+
+		fileDialable, err := readDialableVisits(file, seen, limit-len(dialable))
+		if err != nil {
+			log.WithError(err).WithField("file", file).Warnln("Could not read visits file")
+			continue
+		}
+		dialable = append(dialable, fileDialable...)
+	}
+
+	addrInfos := make([]peer.AddrInfo, 0, min(limit, len(dialable)))
+	for _, visit := range dialable[:min(limit, len(dialable))] {
+		addrInfos = append(addrInfos, peer.AddrInfo{ID: visit.PeerID, Addrs: visit.Maddrs})
+	}
+
+	return addrInfos, nil
+}
+
+// jsonBootstrapVisit contains the fields of a [JSONVisit] that are needed to
+// find bootstrap peers.
+type jsonBootstrapVisit struct {
+	PeerID          peer.ID
+	Maddrs          []ma.Multiaddr
+	VisitEndedAt    time.Time
+	ConnectErrorStr string
+}
+
+// readDialableVisits returns the most recent visits of at most limit peers of
+// the given, possibly compressed, visits file that were dialable in their
+// latest visit. Peers in the seen set are skipped because a newer file already
+// contained a visit of them. All peers of the file are added to the set.
+//
+// The file is read twice, so that only the end times of the latest visits and
+// the returned visits have to be kept in memory. The first pass finds the
+// latest visit of each peer, the second pass collects the dialable ones.
+func readDialableVisits(file string, seen map[peer.ID]struct{}, limit int) ([]*jsonBootstrapVisit, error) {
+	latest := map[peer.ID]time.Time{}
+	err := streamVisits(file, func(visit *jsonBootstrapVisit) {
+		if _, found := seen[visit.PeerID]; found {
+			return
+		}
+
+		if visit.VisitEndedAt.After(latest[visit.PeerID]) {
+			latest[visit.PeerID] = visit.VisitEndedAt
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// sorted by the end of the visit with the most recent visit first
+	var dialable []*jsonBootstrapVisit
+	err = streamVisits(file, func(visit *jsonBootstrapVisit) {
+		endedAt, found := latest[visit.PeerID]
+		if !found || !visit.VisitEndedAt.Equal(endedAt) {
+			return
+		}
+
+		if visit.ConnectErrorStr != "" || len(visit.Maddrs) == 0 {
+			return
+		}
+
+		i, _ := slices.BinarySearchFunc(dialable, visit, func(a, b *jsonBootstrapVisit) int {
+			return b.VisitEndedAt.Compare(a.VisitEndedAt)
+		})
+		if i >= limit {
+			return
+		}
+
+		dialable = slices.Insert(dialable, i, visit)
+		dialable = dialable[:min(len(dialable), limit)]
+
+		// don't consider duplicates of the same visit
+		latest[visit.PeerID] = time.Time{}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for peerID := range latest {
+		seen[peerID] = struct{}{}
+	}
+
+	return dialable, nil
+}
+
+// streamVisits decodes the given, possibly compressed, visits file one visit
+// at a time and passes each visit to fn.
+func streamVisits(file string, fn func(visit *jsonBootstrapVisit)) error {
+	f, err := openJSONSegment(file)
+	if err != nil {
+		return fmt.Errorf("open visits file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := json.NewDecoder(f)
+	for {
+		visit := &jsonBootstrapVisit{}
+		if err := dec.Decode(visit); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// the last line is incomplete if the crawl was interrupted
+			log.WithError(err).WithField("file", file).Debugln("Stopped reading visits file")
+			break
+		}
+
+		fn(visit)
+	}
+
+	return nil
 }
 
 func (c *JSONClient) InsertCrawlProperties(ctx context.Context, properties map[string]map[string]int) error {
@@ -160,6 +387,7 @@ func (c *JSONClient) InsertCrawlProperties(ctx context.Context, properties map[s
 
 type JSONVisit struct {
 	PeerID          peer.ID
+	DiscoveryPrefix uint64
 	Maddrs          []ma.Multiaddr
 	FilteredMaddrs  []ma.Multiaddr
 	ListenMaddrs    []ma.Multiaddr
@@ -173,11 +401,13 @@ type JSONVisit struct {
 	ConnectErrorStr string
 	CrawlErrorStr   string
 	Properties      null.JSON
+	RetryPass       int
 }
 
 func (c *JSONClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
 	data := JSONVisit{
 		PeerID:          args.PeerID,
+		DiscoveryPrefix: args.DiscoveryPrefix,
 		Maddrs:          slices.Concat(args.DialMaddrs),
 		FilteredMaddrs:  args.FilteredMaddrs,
 		ListenMaddrs:    args.ListenMaddrs,
@@ -191,52 +421,111 @@ func (c *JSONClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
 		ConnectErrorStr: args.ConnectErrorStr,
 		CrawlErrorStr:   args.CrawlErrorStr,
 		Properties:      null.JSONFrom(args.Properties),
+		RetryPass:       args.RetryPass,
 	}
 
+	c.visitMu.Lock()
 	if len(args.Neighbors) > 0 || args.ErrorBits != 0 {
-		if err := c.InsertNeighbors(ctx, args.PeerID, args.Neighbors, args.ErrorBits); err != nil {
+		if err := c.insertNeighbors(args.PeerID, args.Neighbors, args.NeighborPrefixes, args.ErrorBits, args.RetryPass); err != nil {
+			c.visitMu.Unlock()
 			return fmt.Errorf("persiting neighbor information: %w", err)
 		}
 	}
 
-	if err := c.visitEncoder.Encode(data); err != nil {
+	if err := c.visits.Encode(data); err != nil {
+		c.visitMu.Unlock()
 		return fmt.Errorf("encoding visit: %w", err)
 	}
+	c.visitMu.Unlock()
+
+	if err := c.sessions.visit(args); err != nil {
+		return fmt.Errorf("update session: %w", err)
+	}
 
 	return nil
 }
 
 type JSONNeighbors struct {
-	PeerID      peer.ID
-	NeighborIDs []peer.ID
-	ErrorBits   string
+	PeerID           peer.ID
+	NeighborIDs      []peer.ID
+	NeighborPrefixes []uint64
+	ErrorBits        string
+	RetryPass        int
 }
 
 func (c *JSONClient) InsertNeighbors(ctx context.Context, peerID peer.ID, neighbors []peer.ID, errorBits uint16) error {
+	return c.insertNeighbors(peerID, neighbors, nil, errorBits, 0)
+}
+
+// insertNeighbors writes the neighbors of the given peer together with their
+// discovery prefixes, which are needed to import the crawl into ClickHouse.
+func (c *JSONClient) insertNeighbors(peerID peer.ID, neighbors []peer.ID, prefixes []uint64, errorBits uint16, retryPass int) error {
 	data := JSONNeighbors{
-		PeerID:      peerID,
-		NeighborIDs: neighbors,
-		ErrorBits:   fmt.Sprintf("%016b", errorBits),
+		PeerID:           peerID,
+		NeighborIDs:      neighbors,
+		NeighborPrefixes: prefixes,
+		ErrorBits:        fmt.Sprintf("%016b", errorBits),
+		RetryPass:        retryPass,
 	}
 
-	if err := c.neighborsEncoder.Encode(data); err != nil {
+	if err := c.neighbors.Encode(data); err != nil {
 		return fmt.Errorf("encoding visit: %w", err)
 	}
 
 	return nil
 }
 
+// SelectPeersToProbe returns all peers with open or pending sessions that are
+// due to be probed. It also persists the session state, which happens
+// periodically because the monitor calls this method in regular intervals.
 func (c *JSONClient) SelectPeersToProbe(ctx context.Context) ([]peer.AddrInfo, error) {
-	return []peer.AddrInfo{}, nil
+	if err := c.sessions.save(); err != nil {
+		return nil, fmt.Errorf("save sessions: %w", err)
+	}
+
+	return c.sessions.due(time.Now()), nil
 }
 
+// Flush writes the data that the compressors buffer to disk and persists the
+// manifest and session state.
 func (c *JSONClient) Flush(ctx context.Context) error {
+	if err := c.visits.Flush(); err != nil {
+		return fmt.Errorf("flush visits: %w", err)
+	}
+
+	if err := c.neighbors.Flush(); err != nil {
+		return fmt.Errorf("flush neighbors: %w", err)
+	}
+
+	if err := c.manifest.save(); err != nil {
+		return fmt.Errorf("save manifest: %w", err)
+	}
+
+	if err := c.sessions.save(); err != nil {
+		return fmt.Errorf("save sessions: %w", err)
+	}
+
 	return nil
 }
 
 func (c *JSONClient) Close() error {
-	err1 := c.visitsFile.Close()
-	err2 := c.neighborsFile.Close()
+	if err := c.sessions.save(); err != nil {
+		log.WithError(err).Warnln("Failed saving sessions")
+	}
+
+	return c.closeStreams()
+}
+
+// closeStreams closes the current segments of the visits and neighbors
+// streams and saves the manifest.
+func (c *JSONClient) closeStreams() error {
+	err1 := c.visits.Close()
+	err2 := c.neighbors.Close()
+
+	if err := c.manifest.save(); err != nil {
+		log.WithError(err).Warnln("Failed saving manifest")
+	}
+
 	if err1 != nil && err2 != nil {
 		return fmt.Errorf("failed closing JSON files: %w", fmt.Errorf("%s: %w (neighbors)", err1, err2))
 	} else if err1 != nil {
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+// LoadJSONCrawl reads the visits files of a crawl that the JSON client wrote.
+// The crawl is either given as the common file name prefix of its files,
+// e.g., out/2024-01-01T10:00, or as an output directory, in which case the
+// most recent crawl in that directory is loaded.
+func LoadJSONCrawl(crawl string) (*CrawlSnapshot, error) {
+	prefix, err := jsonCrawlPrefix(crawl)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(prefix + "_visits*.ndjson*")
+	if err != nil {
+		return nil, fmt.Errorf("glob visits files: %w", err)
+	} else if len(files) == 0 {
+		return nil, fmt.Errorf("no visits files found for crawl %s", prefix)
+	}
+
+	snapshot := newCrawlSnapshot(filepath.Base(prefix))
+	for _, file := range files {
+		if err = readSnapshotVisits(file, snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshot, nil
+}
+
+// jsonCrawlPrefix returns the common file name prefix of the files of the
+// given crawl. See [LoadJSONCrawl].
+func jsonCrawlPrefix(crawl string) (string, error) {
+	info, err := os.Stat(crawl)
+	if err != nil || !info.IsDir() {
+		return strings.TrimSuffix(crawl, "_crawl.json"), nil
+	}
+
+	prefixes, err := ListJSONCrawls(crawl)
+	if err != nil {
+		return "", err
+	} else if len(prefixes) == 0 {
+		return "", fmt.Errorf("no crawl found in %s", crawl)
+	}
+
+	return prefixes[len(prefixes)-1], nil
+}
+
+// jsonSnapshotVisit contains the fields of a [JSONVisit] that are needed to
+// compare crawls.
+type jsonSnapshotVisit struct {
+	PeerID          peer.ID
+	Maddrs          []ma.Multiaddr
+	FilteredMaddrs  []ma.Multiaddr
+	Protocols       []string
+	AgentVersion    string
+	ConnectErrorStr string
+}
+
+// readSnapshotVisits adds the visits of the given, possibly compressed,
+// visits file to the snapshot.
+func readSnapshotVisits(file string, snapshot *CrawlSnapshot) error {
+	f, err := openJSONSegment(file)
+	if err != nil {
+		return fmt.Errorf("open visits file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := json.NewDecoder(f)
+	for {
+		visit := &jsonSnapshotVisit{}
+		if err := dec.Decode(visit); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// the last line is incomplete if the crawl was interrupted
+			log.WithError(err).WithField("file", file).Debugln("Stopped reading visits file")
+			break
+		}
+
+		snapshot.add(visit.PeerID, &CrawlSnapshotPeer{
+			Dialable:     visit.ConnectErrorStr == "",
+			AgentVersion: visit.AgentVersion,
+			Protocols:    visit.Protocols,
+			Maddrs:       utils.MaddrsToAddrs(slices.Concat(visit.Maddrs, visit.FilteredMaddrs)),
+		})
+	}
+
+	return nil
+}
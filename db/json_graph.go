@@ -0,0 +1,70 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LoadJSONNeighborGraph reads the visits and neighbors files of a crawl that
+// the JSON client wrote and returns the directed graph of its routing tables.
+// The crawl is given as for [LoadJSONCrawl].
+func LoadJSONNeighborGraph(crawl string) (*NeighborGraph, error) {
+	snapshot, err := LoadJSONCrawl(crawl)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := jsonCrawlPrefix(crawl)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newNeighborGraph(snapshot.CrawlID)
+	g.addVisits(snapshot)
+
+	files, err := filepath.Glob(prefix + "_neighbors*.ndjson*")
+	if err != nil {
+		return nil, fmt.Errorf("glob neighbors files: %w", err)
+	}
+
+	for _, file := range files {
+		if err = readGraphNeighbors(file, g); err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// readGraphNeighbors adds the routing tables of the given, possibly
+// compressed, neighbors file to the graph.
+func readGraphNeighbors(file string, g *NeighborGraph) error {
+	f, err := openJSONSegment(file)
+	if err != nil {
+		return fmt.Errorf("open neighbors file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := json.NewDecoder(f)
+	for {
+		neighbors := &JSONNeighbors{}
+		if err := dec.Decode(neighbors); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// the last line is incomplete if the crawl was interrupted
+			log.WithError(err).WithField("file", file).Debugln("Stopped reading neighbors file")
+			break
+		}
+
+		for _, neighbor := range neighbors.NeighborIDs {
+			g.addEdge(neighbors.PeerID, neighbor)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/volatiletech/null/v8"
+)
+
+// JSONSession is a continuous streak of uptime of a peer. The JSON client
+// tracks sessions with the same rules as the Postgres client.
+type JSONSession struct {
+	PeerID                peer.ID
+	Maddrs                []ma.Multiaddr
+	State                 string
+	FirstSuccessfulVisit  time.Time
+	LastSuccessfulVisit   time.Time
+	FirstFailedVisit      null.Time
+	LastFailedVisit       null.Time
+	LastVisitedAt         time.Time
+	NextVisitDueAt        null.Time
+	SuccessfulVisitsCount int
+	RecoveredCount        int
+	FailedVisitsCount     int
+	FinishReason          null.String
+
+	// UpdatedAt is the time when the session was last changed. Sessions that
+	// were written by older versions don't have it and are considered older
+	// than all others.
+	UpdatedAt time.Time
+}
+
+// update applies the result of a visit to the session. See
+// [SQLiteClient.upsertSession] for the rules.
+func (s *JSONSession) update(visitStartedAt time.Time, visitEndedAt time.Time, connectErr string) {
+	s.LastVisitedAt = visitEndedAt
+
+	switch {
+	case connectErr == "":
+		if s.State == "pending" {
+			s.RecoveredCount += 1
+		}
+		s.State = "open"
+		s.NextVisitDueAt = null.TimeFrom(nextVisit(visitEndedAt, s.LastSuccessfulVisit))
+		s.LastSuccessfulVisit = visitEndedAt
+		s.SuccessfulVisitsCount += 1
+		s.FirstFailedVisit = null.Time{}
+		s.LastFailedVisit = null.Time{}
+		s.FailedVisitsCount = 0
+		s.FinishReason = null.String{}
+
+	case s.State == "open" && maxFailedVisits(s.FirstSuccessfulVisit, s.LastSuccessfulVisit, connectErr) > 0:
+		maxVisits := maxFailedVisits(s.FirstSuccessfulVisit, s.LastSuccessfulVisit, connectErr)
+		s.State = "pending"
+		s.FirstFailedVisit = null.TimeFrom(visitStartedAt)
+		s.LastFailedVisit = null.TimeFrom(visitEndedAt)
+		s.FailedVisitsCount += 1
+		s.FinishReason = null.StringFrom(connectErr)
+		s.NextVisitDueAt = null.TimeFrom(visitEndedAt.Add(time.Duration(maxVisits) * time.Minute))
+
+	case s.State == "pending" && s.FailedVisitsCount < maxFailedVisits(s.FirstSuccessfulVisit, s.LastSuccessfulVisit, connectErr):
+		s.LastFailedVisit = null.TimeFrom(visitEndedAt)
+		s.FailedVisitsCount += 1
+		s.NextVisitDueAt = null.TimeFrom(nextVisit(visitEndedAt, s.LastSuccessfulVisit))
+
+	default:
+		s.State = "closed"
+		if !s.FirstFailedVisit.Valid {
+			s.FirstFailedVisit = null.TimeFrom(visitStartedAt)
+		}
+		s.LastFailedVisit = null.TimeFrom(visitEndedAt)
+		s.FailedVisitsCount += 1
+		if !s.FinishReason.Valid {
+			s.FinishReason = null.StringFrom(connectErr)
+		}
+		s.NextVisitDueAt = null.Time{}
+	}
+}
+
+// jsonSessions is the file-backed session state of the JSON client. The
+// open and pending sessions are kept in memory and written to a JSON file,
+// so that they survive restarts. Closed sessions are appended to an NDJSON
+// file and removed from memory. Several processes (e.g., a crawler and a
+// monitor) may share the files. Therefore, the sessions are re-read when
+// the file has changed and merged with the ones in memory by their
+// UpdatedAt timestamp. Writes happen under a file lock.
+type jsonSessions struct {
+	// the path to the file with the open and pending sessions
+	path string
+
+	// the path to the file with the closed sessions
+	closedPath string
+
+	// the lock that guards writes to the sessions file across processes
+	lock *flock.Flock
+
+	mu       sync.Mutex
+	sessions map[peer.ID]*JSONSession
+
+	// the peers whose sessions have changed since they were last written
+	dirty map[peer.ID]struct{}
+
+	// the peers whose sessions were closed since the sessions were last
+	// written together with the time they were closed. Older versions of
+	// these sessions in the file must not be brought back.
+	closed map[peer.ID]time.Time
+
+	// the sessions file as it was when it was last read or written
+	stored os.FileInfo
+}
+
+// loadJSONSessions loads the sessions from the given directory. It starts
+// with an empty state if no sessions were stored yet.
+func loadJSONSessions(dir string) (*jsonSessions, error) {
+	s := &jsonSessions{
+		path:       path.Join(dir, "sessions.json"),
+		closedPath: path.Join(dir, "closed_sessions.ndjson"),
+		lock:       flock.New(path.Join(dir, "sessions.json.lock")),
+		sessions:   map[peer.ID]*JSONSession{},
+		dirty:      map[peer.ID]struct{}{},
+		closed:     map[peer.ID]time.Time{},
+	}
+
+	if err := s.refresh(true); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// refresh reads the sessions file and merges its sessions into memory. If
+// force is false, the file is only read if it seems to have changed since it
+// was last read or written. The caller must hold the mutex unless the
+// sessions aren't shared yet.
+func (s *jsonSessions) refresh(force bool) error {
+	fi, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("stat sessions json: %w", err)
+	} else if !force && s.stored != nil && os.SameFile(fi, s.stored) && fi.ModTime().Equal(s.stored.ModTime()) && fi.Size() == s.stored.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("read sessions json: %w", err)
+	}
+
+	var sessions []*JSONSession
+	if err = json.Unmarshal(data, &sessions); err != nil {
+		return fmt.Errorf("unmarshal sessions json: %w", err)
+	}
+
+	s.merge(sessions)
+	s.stored = fi
+
+	return nil
+}
+
+// merge merges the given sessions from the sessions file into memory. The
+// more recently updated version of a session wins. Sessions that aren't in
+// the file anymore were closed by another process and are removed unless
+// they have changed in the meantime. The caller must hold the mutex.
+func (s *jsonSessions) merge(sessions []*JSONSession) {
+	stored := make(map[peer.ID]struct{}, len(sessions))
+	for _, session := range sessions {
+		stored[session.PeerID] = struct{}{}
+
+		if closedAt, found := s.closed[session.PeerID]; found && !closedAt.Before(session.UpdatedAt) {
+			continue
+		}
+
+		if current, found := s.sessions[session.PeerID]; found && !current.UpdatedAt.Before(session.UpdatedAt) {
+			continue
+		}
+
+		s.sessions[session.PeerID] = session
+		delete(s.dirty, session.PeerID)
+		delete(s.closed, session.PeerID)
+	}
+
+	for peerID := range s.sessions {
+		if _, found := stored[peerID]; found {
+			continue
+		} else if _, dirty := s.dirty[peerID]; !dirty {
+			delete(s.sessions, peerID)
+		}
+	}
+}
+
+// visit updates the session of the peer of the given visit. If the session
+// was closed, it's appended to the closed sessions file.
+func (s *jsonSessions) visit(args *VisitArgs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another process may have changed the peer's session in the meantime
+	if err := s.refresh(false); err != nil {
+		return fmt.Errorf("refresh sessions: %w", err)
+	}
+
+	session, found := s.sessions[args.PeerID]
+	if !found {
+		// offline peers without a session don't get one
+		if args.ConnectErrorStr != "" {
+			return nil
+		}
+
+		session = &JSONSession{
+			PeerID:               args.PeerID,
+			FirstSuccessfulVisit: args.VisitStartedAt,
+		}
+		s.sessions[args.PeerID] = session
+	}
+
+	if maddrs := slices.Concat(args.DialMaddrs, args.ExtraMaddrs); len(maddrs) > 0 {
+		session.Maddrs = maddrs
+	}
+
+	session.update(args.VisitStartedAt, args.VisitEndedAt, args.ConnectErrorStr)
+	session.UpdatedAt = time.Now()
+	s.dirty[args.PeerID] = struct{}{}
+
+	if session.State != "closed" {
+		return nil
+	}
+
+	delete(s.sessions, args.PeerID)
+	delete(s.dirty, args.PeerID)
+	s.closed[args.PeerID] = session.UpdatedAt
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+
+	f, err := os.OpenFile(s.closedPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open closed sessions file: %w", err)
+	}
+
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write closed session: %w", err)
+	}
+
+	return f.Close()
+}
+
+// due returns all peers whose sessions are due to be probed.
+func (s *jsonSessions) due(now time.Time) []peer.AddrInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrInfos := []peer.AddrInfo{}
+	for _, session := range s.sessions {
+		if session.NextVisitDueAt.Valid && session.NextVisitDueAt.Time.Before(now) {
+			addrInfos = append(addrInfos, peer.AddrInfo{ID: session.PeerID, Addrs: session.Maddrs})
+		}
+	}
+
+	return addrInfos
+}
+
+// save merges the sessions that other processes have written into memory and
+// writes the open and pending sessions to disk if they have changed. It holds
+// the file lock while doing so, so that no changes of other processes get
+// lost.
+func (s *jsonSessions) save() error {
+	if err := s.lock.Lock(); err != nil {
+		return fmt.Errorf("lock sessions json: %w", err)
+	}
+	defer func() { _ = s.lock.Unlock() }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(true); err != nil {
+		return fmt.Errorf("refresh sessions: %w", err)
+	}
+
+	if len(s.dirty) == 0 && len(s.closed) == 0 {
+		return nil
+	}
+
+	sessions := make([]*JSONSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return fmt.Errorf("marshal sessions json: %w", err)
+	}
+
+	// write to a temporary file first, so that we don't end up with a
+	// corrupted file if we crash in the middle of writing.
+	if err = os.WriteFile(s.path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("write sessions json: %w", err)
+	}
+
+	if err = os.Rename(s.path+".tmp", s.path); err != nil {
+		return fmt.Errorf("rename sessions json: %w", err)
+	}
+
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("stat sessions json: %w", err)
+	}
+
+	s.stored = fi
+	clear(s.dirty)
+	clear(s.closed)
+
+	return nil
+}
@@ -0,0 +1,429 @@
+package db
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/volatiletech/null/v8"
+)
+
+// The supported compression algorithms of the JSON client.
+const (
+	JSONCompressionNone = "none"
+	JSONCompressionGzip = "gzip"
+	JSONCompressionZstd = "zstd"
+)
+
+// JSONClientConfig holds the configuration for the JSON client.
+type JSONClientConfig struct {
+	// the directory to which the JSON files should be written
+	Out string
+
+	// the compression of the visits and neighbors files. One of "none",
+	// "gzip", or "zstd". An empty string means no compression.
+	Compression string
+
+	// start a new segment of the visits and neighbors files after this many
+	// uncompressed bytes. Zero disables size-based rotation.
+	RotateSize int64
+
+	// start a new segment of the visits and neighbors files after this many
+	// records. Zero disables count-based rotation.
+	RotateCount int
+}
+
+// Validate validates the configuration and returns an error if it's invalid.
+func (cfg *JSONClientConfig) Validate() error {
+	switch cfg.Compression {
+	case "", JSONCompressionNone, JSONCompressionGzip, JSONCompressionZstd:
+	default:
+		return fmt.Errorf("unknown json compression: %s", cfg.Compression)
+	}
+
+	if cfg.RotateSize < 0 {
+		return fmt.Errorf("json rotate size must not be negative")
+	}
+
+	if cfg.RotateCount < 0 {
+		return fmt.Errorf("json rotate count must not be negative")
+	}
+
+	return nil
+}
+
+// rotates returns true if the files should be split into segments.
+func (cfg *JSONClientConfig) rotates() bool {
+	return cfg.RotateSize > 0 || cfg.RotateCount > 0
+}
+
+// extension returns the file extension of the files with the configured
+// compression.
+func (cfg *JSONClientConfig) extension() string {
+	switch cfg.Compression {
+	case JSONCompressionGzip:
+		return ".ndjson.gz"
+	case JSONCompressionZstd:
+		return ".ndjson.zst"
+	default:
+		return ".ndjson"
+	}
+}
+
+// JSONManifest lists all segments of the visits and neighbors files of a
+// crawl.
+type JSONManifest struct {
+	CrawlID  string
+	Segments []*JSONSegment
+}
+
+// JSONSegment is a single file of a stream of records.
+type JSONSegment struct {
+	// the stream the segment belongs to (visits or neighbors)
+	Stream string
+
+	// the file name of the segment relative to the output directory
+	File string
+
+	// the compression of the segment (none, gzip, or zstd)
+	Compression string
+
+	// the number of records in the segment
+	Records int
+
+	// the number of uncompressed bytes in the segment
+	Bytes int64
+
+	CreatedAt time.Time
+	ClosedAt  null.Time
+}
+
+// jsonManifest is the manifest file of a crawl that is updated whenever a
+// segment is opened or closed.
+type jsonManifest struct {
+	path string
+
+	mu       sync.Mutex
+	manifest *JSONManifest
+}
+
+// loadJSONManifest loads the manifest at the given path. It starts with an
+// empty manifest if the file doesn't exist.
+func loadJSONManifest(path string, crawlID string) (*jsonManifest, error) {
+	m := &jsonManifest{
+		path: path,
+		manifest: &JSONManifest{
+			CrawlID:  crawlID,
+			Segments: []*JSONSegment{},
+		},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	if err = json.Unmarshal(data, m.manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// segments returns the number of segments of the given stream.
+func (m *jsonManifest) segments(stream string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, s := range m.manifest.Segments {
+		if s.Stream == stream {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+// open returns the segment of the given stream with the given file name. If
+// the manifest doesn't contain it yet, it's added.
+func (m *jsonManifest) open(stream string, file string, compression string) *JSONSegment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.manifest.Segments {
+		if s.Stream == stream && s.File == file {
+			s.ClosedAt = null.Time{}
+			return s
+		}
+	}
+
+	segment := &JSONSegment{
+		Stream:      stream,
+		File:        file,
+		Compression: compression,
+		CreatedAt:   time.Now(),
+	}
+	m.manifest.Segments = append(m.manifest.Segments, segment)
+
+	return segment
+}
+
+// files returns the file names of all segments.
+func (m *jsonManifest) files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]string, len(m.manifest.Segments))
+	for i, s := range m.manifest.Segments {
+		files[i] = s.File
+	}
+
+	return files
+}
+
+// save atomically writes the manifest to disk.
+func (m *jsonManifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+
+	if err = os.WriteFile(m.path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	if err = os.Rename(m.path+".tmp", m.path); err != nil {
+		return fmt.Errorf("rename manifest: %w", err)
+	}
+
+	return nil
+}
+
+// jsonStream writes newline delimited JSON records to a sequence of
+// optionally compressed segment files. If rotation is configured, a new
+// segment is started after the configured number of bytes or records.
+type jsonStream struct {
+	cfg      *JSONClientConfig
+	prefix   string
+	name     string
+	manifest *jsonManifest
+
+	mu      sync.Mutex
+	index   int
+	file    *os.File
+	writer  io.Writer
+	closer  io.Closer // the compressor or nil
+	segment *JSONSegment
+}
+
+// openJSONStream opens the next segment of the stream with the given name.
+// Without rotation, records are appended to the single file of the stream.
+func openJSONStream(cfg *JSONClientConfig, prefix string, name string, manifest *jsonManifest) (*jsonStream, error) {
+	s := &jsonStream{
+		cfg:      cfg,
+		prefix:   prefix,
+		name:     name,
+		manifest: manifest,
+	}
+
+	if cfg.rotates() {
+		s.index = manifest.segments(name)
+	}
+
+	if err := s.openSegment(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// segmentPath returns the path of the segment with the given index.
+func (s *jsonStream) segmentPath(index int) string {
+	if !s.cfg.rotates() {
+		return s.prefix + "_" + s.name + s.cfg.extension()
+	}
+	return fmt.Sprintf("%s_%s.%04d%s", s.prefix, s.name, index, s.cfg.extension())
+}
+
+// openSegment opens the segment with the current index.
+func (s *jsonStream) openSegment() error {
+	p := s.segmentPath(s.index)
+
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s file: %w", s.name, err)
+	}
+
+	s.file = f
+	s.writer = f
+	s.closer = nil
+
+	switch s.cfg.Compression {
+	case JSONCompressionGzip:
+		gw := gzip.NewWriter(f)
+		s.writer, s.closer = gw, gw
+	case JSONCompressionZstd:
+		zw, err := zstd.NewWriter(f)
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("create zstd writer: %w", err)
+		}
+		s.writer, s.closer = zw, zw
+	}
+
+	compression := s.cfg.Compression
+	if compression == "" {
+		compression = JSONCompressionNone
+	}
+	s.segment = s.manifest.open(s.name, path.Base(p), compression)
+
+	return s.manifest.save()
+}
+
+// closeSegment closes the current segment.
+func (s *jsonStream) closeSegment() error {
+	var errs []error
+	if s.closer != nil {
+		if err := s.closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close compressor: %w", err))
+		}
+	}
+
+	if err := s.file.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	s.manifest.mu.Lock()
+	s.segment.ClosedAt = null.TimeFrom(time.Now())
+	s.manifest.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// Encode writes the given record to the stream. If the current segment is
+// full, it's closed and a new one is started first.
+func (s *jsonStream) Encode(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.full(len(data)) {
+		if err := s.closeSegment(); err != nil {
+			return fmt.Errorf("close %s segment: %w", s.name, err)
+		}
+
+		s.index += 1
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	if _, err = s.writer.Write(data); err != nil {
+		return err
+	}
+
+	s.manifest.mu.Lock()
+	s.segment.Records += 1
+	s.segment.Bytes += int64(len(data))
+	s.manifest.mu.Unlock()
+
+	return nil
+}
+
+// full returns true if the given number of bytes don't fit into the current
+// segment anymore. A segment always contains at least one record.
+func (s *jsonStream) full(n int) bool {
+	s.manifest.mu.Lock()
+	defer s.manifest.mu.Unlock()
+
+	if s.segment.Records == 0 {
+		return false
+	}
+
+	if s.cfg.RotateCount > 0 && s.segment.Records >= s.cfg.RotateCount {
+		return true
+	}
+
+	return s.cfg.RotateSize > 0 && s.segment.Bytes+int64(n) > s.cfg.RotateSize
+}
+
+// Flush writes all data that the compressor buffers to the file.
+func (s *jsonStream) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+// Close closes the current segment.
+func (s *jsonStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeSegment()
+}
+
+// openJSONSegment opens the given segment file for reading and decompresses
+// it based on its file extension.
+func openJSONSegment(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(file, ".gz"):
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("create gzip reader: %w", err)
+		}
+		return &readCloser{Reader: gr, closers: []io.Closer{gr, f}}, nil
+	case strings.HasSuffix(file, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("create zstd reader: %w", err)
+		}
+		return &readCloser{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), f}}, nil
+	default:
+		return f, nil
+	}
+}
+
+// readCloser is a reader that closes multiple closers.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var errs []error
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,354 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	lp2ptest "github.com/libp2p/go-libp2p/core/test"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+func writeJSONVisits(t *testing.T, file string, visits ...JSONVisit) {
+	t.Helper()
+
+	f, err := os.Create(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, v := range visits {
+		require.NoError(t, enc.Encode(v))
+	}
+}
+
+// jsonTestPeers returns a function that maps names to random peer IDs.
+func jsonTestPeers(t *testing.T) func(name string) peer.ID {
+	peers := map[string]peer.ID{}
+	return func(name string) peer.ID {
+		if _, found := peers[name]; !found {
+			peerID, err := lp2ptest.RandPeerID()
+			require.NoError(t, err)
+			peers[name] = peerID
+		}
+		return peers[name]
+	}
+}
+
+func TestJSONClient_QueryBootstrapPeers(t *testing.T) {
+	ctx := context.Background()
+	out := t.TempDir()
+
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+
+	p := jsonTestPeers(t)
+	now := time.Now()
+	visit := func(id string, ago time.Duration, connectErr string) JSONVisit {
+		return JSONVisit{
+			PeerID:          p(id),
+			Maddrs:          []ma.Multiaddr{maddr},
+			VisitStartedAt:  now.Add(-ago - time.Second),
+			VisitEndedAt:    now.Add(-ago),
+			ConnectErrorStr: connectErr,
+		}
+	}
+
+	writeJSONVisits(t, path.Join(out, "2025-01-01T10:00_visits.ndjson"),
+		visit("peer-1", 3*time.Hour, ""),
+		visit("peer-2", 3*time.Hour, ""),
+		visit("peer-3", 3*time.Hour, ""),
+	)
+	writeJSONVisits(t, path.Join(out, "2025-01-01T12:00_visits.ndjson"),
+		visit("peer-2", time.Hour, pgmodels.NetErrorIoTimeout), // not dialable anymore
+		visit("peer-4", 2*time.Hour, ""),
+		visit("peer-5", time.Hour, ""),
+		visit("peer-5", 50*time.Minute, pgmodels.NetErrorIoTimeout),
+	)
+
+	client, err := NewJSONClient(&JSONClientConfig{Out: out})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, client.Close()) }()
+
+	addrInfos, err := client.QueryBootstrapPeers(ctx, 10)
+	require.NoError(t, err)
+
+	ids := make([]peer.ID, len(addrInfos))
+	for i, addrInfo := range addrInfos {
+		ids[i] = addrInfo.ID
+		assert.Equal(t, []ma.Multiaddr{maddr}, addrInfo.Addrs)
+	}
+	require.Len(t, ids, 3)
+	assert.Equal(t, p("peer-4"), ids[0])
+	assert.ElementsMatch(t, []peer.ID{p("peer-1"), p("peer-3")}, ids[1:])
+
+	// the newest file has enough peers
+	addrInfos, err = client.QueryBootstrapPeers(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, addrInfos, 1)
+	assert.Equal(t, p("peer-4"), addrInfos[0].ID)
+}
+
+func TestJSONClient_sessions(t *testing.T) {
+	ctx := context.Background()
+	out := t.TempDir()
+
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+
+	p := jsonTestPeers(t)
+	start := time.Now().Add(-3 * time.Hour)
+	visit := func(client *JSONClient, id string, at time.Time, connectErr string) {
+		args := &VisitArgs{
+			PeerID:          p(id),
+			DialMaddrs:      []ma.Multiaddr{maddr},
+			VisitStartedAt:  at.Add(-time.Second),
+			VisitEndedAt:    at,
+			ConnectErrorStr: connectErr,
+			VisitType:       VisitTypeDial,
+		}
+		require.NoError(t, client.InsertVisit(ctx, args))
+	}
+
+	client, err := NewJSONClient(&JSONClientConfig{Out: out})
+	require.NoError(t, err)
+
+	visit(client, "offline", start, pgmodels.NetErrorIoTimeout)
+	visit(client, "short", start, "")
+	visit(client, "long", start, "")
+	visit(client, "long", start.Add(2*time.Hour), "")
+
+	toProbe, err := client.SelectPeersToProbe(ctx)
+	require.NoError(t, err)
+	assert.Len(t, toProbe, 2)
+
+	// the peer that was only online for a short time is gone immediately
+	// and the other one gets another chance.
+	visit(client, "short", start.Add(2*time.Hour), pgmodels.NetErrorIoTimeout)
+	visit(client, "long", start.Add(2*time.Hour+time.Minute), pgmodels.NetErrorIoTimeout)
+	require.NoError(t, client.Close())
+
+	// the session state survives restarts
+	client, err = NewJSONClient(&JSONClientConfig{Out: out})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, client.Close()) }()
+
+	require.Len(t, client.sessions.sessions, 1)
+	session := client.sessions.sessions[p("long")]
+	assert.Equal(t, "pending", session.State)
+	assert.Equal(t, 2, session.SuccessfulVisitsCount)
+	assert.Equal(t, 1, session.FailedVisitsCount)
+	assert.Equal(t, []ma.Multiaddr{maddr}, session.Maddrs)
+
+	visit(client, "long", start.Add(2*time.Hour+2*time.Minute), "")
+	assert.Equal(t, "open", session.State)
+	assert.Equal(t, 1, session.RecoveredCount)
+
+	f, err := os.Open(path.Join(out, "closed_sessions.ndjson"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	var closed []JSONSession
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s JSONSession
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &s))
+		closed = append(closed, s)
+	}
+	require.Len(t, closed, 1)
+	assert.Equal(t, p("short"), closed[0].PeerID)
+	assert.Equal(t, "closed", closed[0].State)
+	assert.Equal(t, pgmodels.NetErrorIoTimeout, closed[0].FinishReason.String)
+}
+
+func TestJSONClient_sessions_shared(t *testing.T) {
+	ctx := context.Background()
+	out := t.TempDir()
+
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+
+	p := jsonTestPeers(t)
+	start := time.Now().Add(-3 * time.Hour)
+	visit := func(client *JSONClient, id string, at time.Time, connectErr string) {
+		args := &VisitArgs{
+			PeerID:          p(id),
+			DialMaddrs:      []ma.Multiaddr{maddr},
+			VisitStartedAt:  at.Add(-time.Second),
+			VisitEndedAt:    at,
+			ConnectErrorStr: connectErr,
+			VisitType:       VisitTypeDial,
+		}
+		require.NoError(t, client.InsertVisit(ctx, args))
+	}
+
+	// a monitor and a crawler share the same output directory
+	monitor, err := NewJSONClient(&JSONClientConfig{Out: out})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, monitor.Close()) }()
+
+	crawler, err := NewJSONClient(&JSONClientConfig{Out: out})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, crawler.Close()) }()
+
+	visit(monitor, "monitored", start, "")
+	require.NoError(t, monitor.Flush(ctx))
+
+	visit(crawler, "crawled", start, "")
+	require.NoError(t, crawler.Flush(ctx))
+
+	// the monitor picks up the session of the crawled peer and the crawler
+	// didn't overwrite the session of the monitored peer.
+	toProbe, err := monitor.SelectPeersToProbe(ctx)
+	require.NoError(t, err)
+	assert.Len(t, toProbe, 2)
+
+	// the monitor closes the session of the crawled peer
+	visit(monitor, "crawled", start.Add(time.Minute), pgmodels.NetErrorIoTimeout)
+	require.NoError(t, monitor.Flush(ctx))
+
+	// the crawler doesn't bring it back when it writes its sessions again
+	visit(crawler, "monitored", start.Add(2*time.Minute), "")
+	require.NoError(t, crawler.Flush(ctx))
+	assert.NotContains(t, crawler.sessions.sessions, p("crawled"))
+
+	toProbe, err = monitor.SelectPeersToProbe(ctx)
+	require.NoError(t, err)
+	require.Len(t, toProbe, 1)
+	assert.Equal(t, p("monitored"), toProbe[0].ID)
+	assert.Equal(t, 2, monitor.sessions.sessions[p("monitored")].SuccessfulVisitsCount)
+}
+
+func TestJSONClient_compression_and_rotation(t *testing.T) {
+	ctx := context.Background()
+
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+
+	for _, compression := range []string{JSONCompressionNone, JSONCompressionGzip, JSONCompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			cfg := &JSONClientConfig{
+				Out:         t.TempDir(),
+				Compression: compression,
+				RotateCount: 2,
+			}
+
+			client, err := NewJSONClient(cfg)
+			require.NoError(t, err)
+			require.NoError(t, client.InitCrawl(ctx, "test"))
+
+			p := jsonTestPeers(t)
+			for _, id := range []string{"peer-1", "peer-2", "peer-3"} {
+				require.NoError(t, client.InsertVisit(ctx, &VisitArgs{
+					PeerID:         p(id),
+					DialMaddrs:     []ma.Multiaddr{maddr},
+					Neighbors:      []peer.ID{p("peer-4")},
+					VisitStartedAt: time.Now().Add(-time.Second),
+					VisitEndedAt:   time.Now(),
+					VisitType:      VisitTypeCrawl,
+				}))
+			}
+			require.NoError(t, client.Close())
+
+			data, err := os.ReadFile(client.prefix + "_manifest.json")
+			require.NoError(t, err)
+
+			manifest := &JSONManifest{}
+			require.NoError(t, json.Unmarshal(data, manifest))
+			assert.Equal(t, client.CrawlID(), manifest.CrawlID)
+			require.Len(t, manifest.Segments, 4)
+
+			records := map[string]int{}
+			for _, segment := range manifest.Segments {
+				assert.Equal(t, compression, segment.Compression)
+				assert.True(t, segment.ClosedAt.Valid)
+				records[segment.Stream] += segment.Records
+
+				// the files contain as many records as the manifest says
+				f, err := openJSONSegment(path.Join(cfg.Out, segment.File))
+				require.NoError(t, err)
+
+				count := 0
+				scanner := bufio.NewScanner(f)
+				for scanner.Scan() {
+					count += 1
+				}
+				require.NoError(t, scanner.Err())
+				require.NoError(t, f.Close())
+				assert.Equal(t, segment.Records, count)
+			}
+			assert.Equal(t, map[string]int{"visits": 3, "neighbors": 3}, records)
+
+			// the bootstrap peers are read from all segments
+			client, err = NewJSONClient(cfg)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, client.Close()) }()
+
+			addrInfos, err := client.QueryBootstrapPeers(ctx, 10)
+			require.NoError(t, err)
+			assert.Len(t, addrInfos, 3)
+		})
+	}
+}
+
+func TestJSONClient_ResumeCrawl_segments(t *testing.T) {
+	ctx := context.Background()
+	cfg := &JSONClientConfig{
+		Out:         t.TempDir(),
+		Compression: JSONCompressionGzip,
+		RotateSize:  1024,
+	}
+
+	p := jsonTestPeers(t)
+	visit := func(client *JSONClient, id string) {
+		require.NoError(t, client.InsertVisit(ctx, &VisitArgs{
+			PeerID:         p(id),
+			VisitStartedAt: time.Now().Add(-time.Second),
+			VisitEndedAt:   time.Now(),
+			VisitType:      VisitTypeCrawl,
+		}))
+	}
+
+	// use a crawl in the past so that the resumed client has another prefix
+	crawlID := "2025-01-01T10:00"
+	crawlPath := path.Join(cfg.Out, crawlID+"_crawl.json")
+	require.NoError(t, os.WriteFile(crawlPath, []byte(`{"state":"started"}`), 0o644))
+
+	client, err := NewJSONClient(cfg)
+	require.NoError(t, err)
+	require.NoError(t, client.ResumeCrawl(ctx, crawlID))
+	visit(client, "peer-1")
+	require.NoError(t, client.Close())
+
+	client, err = NewJSONClient(cfg)
+	require.NoError(t, err)
+	initialPrefix := client.prefix
+	require.NoError(t, client.ResumeCrawl(ctx, crawlID))
+	visit(client, "peer-2")
+	require.NoError(t, client.Flush(ctx))
+
+	// the files of the initial prefix are gone
+	matches, err := filepath.Glob(initialPrefix + "_*")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	// resuming starts new segments and keeps the old ones
+	files := client.manifest.files()
+	require.NoError(t, client.Close())
+	assert.Equal(t, []string{
+		crawlID + "_visits.0000.ndjson.gz",
+		crawlID + "_neighbors.0000.ndjson.gz",
+		crawlID + "_visits.0001.ndjson.gz",
+		crawlID + "_neighbors.0001.ndjson.gz",
+	}, files)
+}
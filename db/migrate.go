@@ -0,0 +1,391 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	mch "github.com/golang-migrate/migrate/v4/database/clickhouse"
+	mpg "github.com/golang-migrate/migrate/v4/database/postgres"
+	msqlite "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	log "github.com/sirupsen/logrus"
+)
+
+// newMigrate returns a migrate instance that applies the migrations in the
+// given directory of the embedded file system with the given database driver.
+func newMigrate(migrations embed.FS, path string, databaseName string, driver database.Driver) (*migrate.Migrate, error) {
+	migrationsDir, err := iofs.New(migrations, path)
+	if err != nil {
+		return nil, fmt.Errorf("create iofs migrations source: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", migrationsDir, databaseName, driver)
+	if err != nil {
+		return nil, fmt.Errorf("create migrate instance: %w", err)
+	}
+
+	return m, nil
+}
+
+// clickhouseMigrations returns the embedded ClickHouse migrations and their
+// directory. Replicated table engines are used in clustered deployments.
+func clickhouseMigrations(replicated bool) (embed.FS, string) {
+	if replicated {
+		return clickhouseClusterMigrations, "migrations/chcluster"
+	}
+	return clickhouseLocalMigrations, "migrations/chlocal"
+}
+
+// Migrator manages the schema migrations of a database. In contrast to the
+// clients, which only apply all pending migrations on startup, it allows
+// inspecting the migration state and migrating to arbitrary versions.
+type Migrator struct {
+	m *migrate.Migrate
+
+	// a separate handle on the migrations to read their SQL for dry runs
+	source source.Driver
+
+	// the database handler that the migrate instance uses
+	dbh *sql.DB
+}
+
+// NewPostgresMigrator returns a migrator for the Postgres migrations.
+func NewPostgresMigrator(cfg *PostgresClientConfig) (*Migrator, error) {
+	dbh, err := sql.Open("postgres", cfg.DatabaseSourceName())
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	driver, err := mpg.WithInstance(dbh, &mpg.Config{})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("create driver instance: %w", err), dbh.Close())
+	}
+
+	return newMigrator(postgresMigrations, "migrations/pg", cfg.DatabaseName, driver, dbh)
+}
+
+// NewClickHouseMigrator returns a migrator for the ClickHouse migrations
+// with or without replicated table engines.
+func NewClickHouseMigrator(cfg *ClickHouseClientConfig) (*Migrator, error) {
+	dbh := clickhouse.OpenDB(cfg.Options())
+
+	driver, err := mch.WithInstance(dbh, &mch.Config{
+		DatabaseName:          cfg.DatabaseName,
+		ClusterName:           cfg.ClusterName,
+		MigrationsTableEngine: cfg.MigrationsTableEngine,
+	})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("create migrate driver: %w", err), dbh.Close())
+	}
+
+	migrations, path := clickhouseMigrations(cfg.ReplicatedTableEngines)
+
+	return newMigrator(migrations, path, cfg.DatabaseName, driver, dbh)
+}
+
+// NewSQLiteMigrator returns a migrator for the SQLite migrations.
+func NewSQLiteMigrator(cfg *SQLiteClientConfig) (*Migrator, error) {
+	dbh, err := sql.Open("sqlite3", cfg.DatabaseSourceName())
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	driver, err := msqlite.WithInstance(dbh, &msqlite.Config{})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("create driver instance: %w", err), dbh.Close())
+	}
+
+	return newMigrator(sqliteMigrations, "migrations/sqlite", "sqlite3", driver, dbh)
+}
+
+func newMigrator(migrations embed.FS, path string, databaseName string, driver database.Driver, dbh *sql.DB) (*Migrator, error) {
+	m, err := newMigrate(migrations, path, databaseName, driver)
+	if err != nil {
+		return nil, errors.Join(err, dbh.Close())
+	}
+	m.Log = migrateLogger{}
+
+	src, err := iofs.New(migrations, path)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("create iofs migrations source: %w", err), dbh.Close())
+	}
+
+	return &Migrator{
+		m:      m,
+		source: src,
+		dbh:    dbh,
+	}, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	if err := m.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("number of migrations to roll back must be positive")
+	}
+
+	if err := m.m.Steps(-n); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Goto migrates up or down to the given version.
+func (m *Migrator) Goto(version uint) error {
+	if err := m.m.Migrate(version); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Force sets the migration version without running any migrations and
+// clears the dirty flag. A version of -1 means that no migration is applied.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// MigrationStatus is the migration state of a database.
+type MigrationStatus struct {
+	// the version of the most recently applied migration. Nil if no
+	// migration was applied yet.
+	Version *uint
+
+	// true if the most recent migration failed and the database needs to be
+	// fixed manually. See [Migrator.Force].
+	Dirty bool
+
+	// all available migrations in ascending order
+	Migrations []*Migration
+}
+
+// Migration is a single available migration.
+type Migration struct {
+	Version    uint
+	Identifier string
+	Applied    bool
+}
+
+// Status returns the migration state of the database.
+func (m *Migrator) Status() (*MigrationStatus, error) {
+	status := &MigrationStatus{}
+
+	version, dirty, err := m.m.Version()
+	if err == nil {
+		status.Version = &version
+		status.Dirty = dirty
+	} else if !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, fmt.Errorf("get version: %w", err)
+	}
+
+	versions, err := m.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		identifier, err := m.identifier(v)
+		if err != nil {
+			return nil, err
+		}
+
+		status.Migrations = append(status.Migrations, &Migration{
+			Version:    v,
+			Identifier: identifier,
+			Applied:    status.Version != nil && v <= *status.Version,
+		})
+	}
+
+	return status, nil
+}
+
+// MigrationStep is a migration that would be applied in the given direction.
+type MigrationStep struct {
+	Version    uint
+	Identifier string
+	Up         bool
+	SQL        string
+}
+
+// PlanUp returns the migrations that [Migrator.Up] would apply.
+func (m *Migrator) PlanUp() ([]*MigrationStep, error) {
+	versions, current, err := m.position()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.steps(versions[current+1:], true)
+}
+
+// PlanDown returns the migrations that [Migrator.Down] would roll back.
+func (m *Migrator) PlanDown(n int) ([]*MigrationStep, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of migrations to roll back must be positive")
+	}
+
+	versions, current, err := m.position()
+	if err != nil {
+		return nil, err
+	}
+
+	if n > current+1 {
+		return nil, fmt.Errorf("can't roll back %d migrations, only %d applied", n, current+1)
+	}
+
+	rollback := slices.Clone(versions[current+1-n : current+1])
+	slices.Reverse(rollback)
+
+	return m.steps(rollback, false)
+}
+
+// PlanGoto returns the migrations that [Migrator.Goto] would apply or roll
+// back.
+func (m *Migrator) PlanGoto(version uint) ([]*MigrationStep, error) {
+	versions, current, err := m.position()
+	if err != nil {
+		return nil, err
+	}
+
+	target := -1
+	for i, v := range versions {
+		if v == version {
+			target = i
+		}
+	}
+
+	if target == -1 {
+		return nil, fmt.Errorf("unknown migration version %d", version)
+	} else if target > current {
+		return m.steps(versions[current+1:target+1], true)
+	}
+
+	rollback := slices.Clone(versions[target+1 : current+1])
+	slices.Reverse(rollback)
+
+	return m.steps(rollback, false)
+}
+
+// Close closes the migrations source and the database handler.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr, m.source.Close(), m.dbh.Close())
+}
+
+// versions returns all available migration versions in ascending order.
+func (m *Migrator) versions() ([]uint, error) {
+	v, err := m.source.First()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read first migration: %w", err)
+	}
+
+	versions := []uint{v}
+	for {
+		v, err = m.source.Next(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			return versions, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read next migration: %w", err)
+		}
+		versions = append(versions, v)
+	}
+}
+
+// position returns all available versions and the index of the currently
+// applied version. The index is -1 if no migration was applied yet.
+func (m *Migrator) position() ([]uint, int, error) {
+	versions, err := m.versions()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return versions, -1, nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("get version: %w", err)
+	} else if dirty {
+		return nil, 0, fmt.Errorf("database is dirty at version %d - fix it manually and force a version", version)
+	}
+
+	for i, v := range versions {
+		if v == version {
+			return versions, i, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("applied version %d not found in migrations", version)
+}
+
+// steps reads the SQL of the given migrations in the given direction.
+func (m *Migrator) steps(versions []uint, up bool) ([]*MigrationStep, error) {
+	steps := make([]*MigrationStep, 0, len(versions))
+	for _, v := range versions {
+		var (
+			r          io.ReadCloser
+			identifier string
+			err        error
+		)
+		if up {
+			r, identifier, err = m.source.ReadUp(v)
+		} else {
+			r, identifier, err = m.source.ReadDown(v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read migration %d: %w", v, err)
+		}
+
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read migration %d: %w", v, err)
+		}
+
+		steps = append(steps, &MigrationStep{
+			Version:    v,
+			Identifier: identifier,
+			Up:         up,
+			SQL:        string(data),
+		})
+	}
+
+	return steps, nil
+}
+
+// identifier returns the name of the migration with the given version.
+func (m *Migrator) identifier(version uint) (string, error) {
+	r, identifier, err := m.source.ReadUp(version)
+	if err != nil {
+		return "", fmt.Errorf("read migration %d: %w", version, err)
+	}
+
+	return identifier, r.Close()
+}
+
+// migrateLogger forwards the log messages of the migrate library.
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...any) {
+	log.Infof(strings.TrimSpace(format), v...)
+}
+
+func (migrateLogger) Verbose() bool {
+	return log.IsLevelEnabled(log.DebugLevel)
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	m, err := NewSQLiteMigrator(&SQLiteClientConfig{
+		DatabasePath: filepath.Join(t.TempDir(), "nebula.db"),
+	})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, m.Close()) }()
+
+	status, err := m.Status()
+	require.NoError(t, err)
+	assert.Nil(t, status.Version)
+	require.NotEmpty(t, status.Migrations)
+	for _, migration := range status.Migrations {
+		assert.False(t, migration.Applied)
+	}
+	latest := status.Migrations[len(status.Migrations)-1].Version
+
+	steps, err := m.PlanUp()
+	require.NoError(t, err)
+	require.Len(t, steps, len(status.Migrations))
+	assert.True(t, steps[0].Up)
+	assert.Contains(t, steps[0].SQL, "CREATE TABLE")
+
+	// planning doesn't apply anything
+	status, err = m.Status()
+	require.NoError(t, err)
+	assert.Nil(t, status.Version)
+
+	require.NoError(t, m.Up())
+	require.NoError(t, m.Up()) // no change
+
+	status, err = m.Status()
+	require.NoError(t, err)
+	require.NotNil(t, status.Version)
+	assert.Equal(t, latest, *status.Version)
+	assert.False(t, status.Dirty)
+	for _, migration := range status.Migrations {
+		assert.True(t, migration.Applied)
+	}
+
+	steps, err = m.PlanUp()
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+
+	steps, err = m.PlanDown(1)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	assert.False(t, steps[0].Up)
+	assert.Equal(t, latest, steps[0].Version)
+	assert.Contains(t, steps[0].SQL, "DROP")
+
+	_, err = m.PlanDown(len(status.Migrations) + 1)
+	assert.Error(t, err)
+
+	_, err = m.PlanGoto(latest + 1)
+	assert.Error(t, err)
+
+	steps, err = m.PlanGoto(latest)
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+
+	require.NoError(t, m.Down(len(status.Migrations)))
+
+	status, err = m.Status()
+	require.NoError(t, err)
+	assert.Nil(t, status.Version)
+
+	require.NoError(t, m.Goto(latest))
+	require.NoError(t, m.Force(-1))
+
+	status, err = m.Status()
+	require.NoError(t, err)
+	assert.Nil(t, status.Version)
+}
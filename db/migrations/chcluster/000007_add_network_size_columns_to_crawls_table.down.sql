@@ -0,0 +1,4 @@
+ALTER TABLE crawls
+    DROP COLUMN network_size_upper,
+    DROP COLUMN network_size_lower,
+    DROP COLUMN network_size;
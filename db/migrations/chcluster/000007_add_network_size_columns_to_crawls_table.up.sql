@@ -0,0 +1,4 @@
+ALTER TABLE crawls
+    ADD COLUMN network_size Nullable(Float64) AFTER remaining_peers,
+    ADD COLUMN network_size_lower Nullable(Float64) AFTER network_size,
+    ADD COLUMN network_size_upper Nullable(Float64) AFTER network_size_lower;
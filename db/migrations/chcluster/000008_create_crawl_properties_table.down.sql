@@ -0,0 +1 @@
+DROP TABLE IF EXISTS crawl_properties;
\ No newline at end of file
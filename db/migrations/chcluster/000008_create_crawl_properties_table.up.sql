@@ -0,0 +1,23 @@
+-- Captures aggregated information of one particular crawl, e.g., how many
+-- peers with a specific agent version were found.
+CREATE TABLE crawl_properties
+(
+    -- identifies the crawl that these aggregated properties belong to
+    crawl_id         UUID,
+
+    -- the date when the crawl was created/started. This is used to partition
+    -- the data (see the neighbors table).
+    crawl_created_at DATETIME64(3),
+
+    -- the kind of the property
+    property         Enum('agent_version', 'protocol', 'error'),
+
+    -- the agent version, protocol, or dial error
+    value            String,
+
+    -- the number of peers with the above property value during the crawl
+    count            UInt32
+) ENGINE ReplicatedReplacingMergeTree()
+    PRIMARY KEY (crawl_id, property, value)
+    PARTITION BY toStartOfMonth(crawl_created_at)
+    TTL toDateTime(crawl_created_at) + INTERVAL 1 YEAR;
\ No newline at end of file
@@ -0,0 +1,51 @@
+-- Captures a continuous streak of uptime of a peer. Nebula tracks the sessions
+-- in memory and inserts a new version of a session row whenever it changes.
+-- Therefore, always query this table with FINAL.
+CREATE TABLE sessions
+(
+    -- the peer ID of the peer in base58 format
+    peer_id                 String,
+
+    -- the multi addresses that the peer was last reachable at
+    multi_addresses         Array(String),
+
+    -- open: the peer is online, pending: the peer is about to go offline,
+    -- closed: the peer went offline.
+    state                   Enum('open', 'pending', 'closed'),
+
+    -- the timestamp of the first successful visit of this session. Together
+    -- with the peer ID this identifies a session.
+    first_successful_visit  DateTime64(3),
+
+    -- the timestamp of the most recent successful visit of this session
+    last_successful_visit   DateTime64(3),
+
+    -- the timestamp of the first failed visit after the peer was online
+    first_failed_visit      Nullable(DateTime64(3)),
+
+    -- the timestamp of the most recent failed visit
+    last_failed_visit       Nullable(DateTime64(3)),
+
+    -- the timestamp of the most recent visit of the peer
+    last_visited_at         DateTime64(3),
+
+    -- the timestamp when the peer should be visited again. NULL for closed
+    -- sessions.
+    next_visit_due_at       Nullable(DateTime64(3)),
+
+    -- the number of successful visits in this session
+    successful_visits_count UInt32,
+
+    -- the number of times the peer came back online after a failed visit
+    recovered_count         UInt32,
+
+    -- the number of failed visits in a row
+    failed_visits_count     UInt32,
+
+    -- the dial error that ended the session
+    finish_reason           Nullable(String),
+
+    -- the timestamp when this version of the session was written
+    updated_at              DateTime64(3)
+) ENGINE ReplicatedReplacingMergeTree(updated_at)
+    PRIMARY KEY (peer_id, first_successful_visit);
\ No newline at end of file
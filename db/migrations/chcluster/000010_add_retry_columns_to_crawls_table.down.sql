@@ -0,0 +1,3 @@
+ALTER TABLE crawls
+    DROP COLUMN retry_succeeded_peers,
+    DROP COLUMN retried_peers;
@@ -0,0 +1,3 @@
+ALTER TABLE crawls
+    ADD COLUMN retried_peers Nullable(Int) AFTER network_size_upper,
+    ADD COLUMN retry_succeeded_peers Nullable(Int) AFTER retried_peers;
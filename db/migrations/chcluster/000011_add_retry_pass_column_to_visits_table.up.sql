@@ -0,0 +1,4 @@
+-- a retried peer has more than one visit in the same crawl. The retry pass
+-- is zero for the first visit of the peer in the crawl.
+ALTER TABLE visits
+    ADD COLUMN retry_pass UInt8 DEFAULT 0 AFTER peer_properties;
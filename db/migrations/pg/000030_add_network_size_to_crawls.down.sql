@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE crawls DROP COLUMN network_size_upper;
+ALTER TABLE crawls DROP COLUMN network_size_lower;
+ALTER TABLE crawls DROP COLUMN network_size;
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE crawls ADD COLUMN network_size       DOUBLE PRECISION;
+ALTER TABLE crawls ADD COLUMN network_size_lower DOUBLE PRECISION;
+ALTER TABLE crawls ADD COLUMN network_size_upper DOUBLE PRECISION;
+
+COMMENT ON COLUMN crawls.network_size IS 'The estimated total number of peers in the network based on the routing tables of the crawled peers.';
+COMMENT ON COLUMN crawls.network_size_lower IS 'The lower bound of the 95% confidence interval of the network size estimate.';
+COMMENT ON COLUMN crawls.network_size_upper IS 'The upper bound of the 95% confidence interval of the network size estimate.';
+
+COMMIT;
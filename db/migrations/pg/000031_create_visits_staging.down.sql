@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP FUNCTION IF EXISTS insert_visits_batch;
+DROP TABLE IF EXISTS visits_staging;
+
+COMMIT;
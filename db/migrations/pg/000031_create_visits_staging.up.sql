@@ -0,0 +1,229 @@
+BEGIN;
+
+-- The `visits_staging` table receives batches of visits via COPY. The
+-- `insert_visits_batch` function then inserts all staged visits set-wise and
+-- removes them from the staging table again in the same transaction. Hence,
+-- this table is always empty from the perspective of other transactions.
+CREATE UNLOGGED TABLE visits_staging
+(
+    -- The position of the visit in the batch. Visits of the same peer are applied in this order.
+    seq               INT         NOT NULL,
+    -- Same as the arguments of the `insert_visit` function.
+    crawl_id          INT,
+    peer_multi_hash   TEXT        NOT NULL,
+    multi_addresses   TEXT[],
+    agent_version_id  INT,
+    protocols_set_id  INT,
+    dial_duration     INTERVAL,
+    connect_duration  INTERVAL,
+    crawl_duration    INTERVAL,
+    visit_started_at  TIMESTAMPTZ NOT NULL,
+    visit_ended_at    TIMESTAMPTZ NOT NULL,
+    type              visit_type  NOT NULL,
+    connect_error     net_error,
+    crawl_error       net_error,
+    peer_properties   JSONB,
+    -- The following columns are populated by the `insert_visits_batch` function.
+    peer_id           INT,
+    multi_address_ids INT[],
+    session_id        INT,
+    round             INT
+);
+
+COMMENT ON TABLE visits_staging IS 'Receives batches of visits via COPY that are then inserted set-wise by the insert_visits_batch function.';
+
+-- Inserts all visits of the staging table. This is the batched equivalent of
+-- the `insert_visit` function. Peers and multi addresses are upserted
+-- set-wise. Sessions are updated with the same rules as in `upsert_session`.
+-- If the batch contains multiple visits of the same peer, they are applied
+-- in rounds, so that each round contains at most one visit per peer.
+CREATE OR REPLACE FUNCTION insert_visits_batch()
+    RETURNS TABLE (peer_multi_hash TEXT, peer_id INT) AS
+$insert_visits_batch$
+#variable_conflict use_column
+DECLARE
+    current_round INT;
+    max_round     INT;
+BEGIN
+    -- Upsert all peers with the most recent non-NULL values of the batch.
+    -- Ordering by the multi hash prevents deadlocks between concurrent batches.
+    WITH latest AS (
+        SELECT vs.peer_multi_hash,
+               (array_agg(vs.agent_version_id ORDER BY vs.seq DESC) FILTER (WHERE vs.agent_version_id IS NOT NULL))[1] AS agent_version_id,
+               (array_agg(vs.protocols_set_id ORDER BY vs.seq DESC) FILTER (WHERE vs.protocols_set_id IS NOT NULL))[1] AS protocols_set_id,
+               (array_agg(vs.peer_properties ORDER BY vs.seq DESC) FILTER (WHERE vs.peer_properties IS NOT NULL))[1]   AS properties,
+               max(vs.visit_ended_at)                                                                                   AS visited_at
+        FROM visits_staging vs
+        GROUP BY vs.peer_multi_hash
+    )
+    INSERT INTO peers AS p (multi_hash, agent_version_id, protocols_set_id, properties, updated_at, created_at)
+    SELECT l.peer_multi_hash, l.agent_version_id, l.protocols_set_id, l.properties, l.visited_at, l.visited_at
+    FROM latest l
+    ORDER BY l.peer_multi_hash
+    ON CONFLICT ON CONSTRAINT uq_peers_multi_hash DO UPDATE
+        SET agent_version_id = coalesce(EXCLUDED.agent_version_id, p.agent_version_id),
+            protocols_set_id = coalesce(EXCLUDED.protocols_set_id, p.protocols_set_id),
+            properties       = coalesce(EXCLUDED.properties, p.properties),
+            updated_at       = EXCLUDED.updated_at
+        WHERE (EXCLUDED.properties IS NOT NULL AND coalesce(p.properties, '{}'::JSONB) != EXCLUDED.properties)
+           OR (EXCLUDED.agent_version_id IS NOT NULL AND coalesce(p.agent_version_id, -1) != EXCLUDED.agent_version_id)
+           OR (EXCLUDED.protocols_set_id IS NOT NULL AND coalesce(p.protocols_set_id, -1) != EXCLUDED.protocols_set_id);
+
+    UPDATE visits_staging vs
+    SET peer_id = p.id,
+        round   = r.round
+    FROM peers p,
+         (SELECT seq, row_number() OVER (PARTITION BY peer_multi_hash ORDER BY seq) AS round FROM visits_staging) r
+    WHERE p.multi_hash = vs.peer_multi_hash
+      AND r.seq = vs.seq;
+
+    -- Upsert all multi addresses of the batch at once.
+    PERFORM upsert_multi_addresses(ARRAY(SELECT DISTINCT unnest(vs.multi_addresses) FROM visits_staging vs));
+
+    UPDATE visits_staging vs
+    SET multi_address_ids = (SELECT array_agg(ma.id ORDER BY ma.id)
+                             FROM multi_addresses ma
+                             WHERE ma.maddr = ANY (vs.multi_addresses));
+
+    -- The multi addresses of the most recent visit of a peer replace its
+    -- previous multi addresses.
+    DELETE
+    FROM peers_x_multi_addresses pxma
+        USING visits_staging vs
+    WHERE pxma.peer_id = vs.peer_id;
+
+    INSERT INTO peers_x_multi_addresses (peer_id, multi_address_id)
+    SELECT latest.peer_id, unnest(latest.multi_address_ids)
+    FROM (SELECT DISTINCT ON (vs.peer_id) vs.peer_id, vs.multi_address_ids
+          FROM visits_staging vs
+          ORDER BY vs.peer_id, vs.seq DESC) latest
+    ON CONFLICT DO NOTHING;
+
+    -- Update the sessions. Each round contains at most one visit per peer.
+    SELECT max(vs.round) INTO max_round FROM visits_staging vs;
+
+    FOR current_round IN 1..coalesce(max_round, 0)
+        LOOP
+            WITH visit AS (
+                SELECT vs.seq, vs.peer_id, vs.visit_started_at, vs.visit_ended_at, vs.connect_error
+                FROM visits_staging vs
+                WHERE vs.round = current_round
+            ), existing_session AS (
+                SELECT so.id,
+                       so.state,
+                       so.first_successful_visit,
+                       so.last_successful_visit,
+                       so.first_failed_visit,
+                       so.successful_visits_count,
+                       so.failed_visits_count,
+                       so.recovered_count,
+                       so.finish_reason,
+                       so.uptime,
+                       v.seq,
+                       v.visit_started_at                                                                           AS new_visit_started_at,
+                       v.visit_ended_at                                                                             AS new_visit_ended_at,
+                       v.connect_error                                                                              AS new_error,
+                       calc_max_failed_visits(so.first_successful_visit, so.last_successful_visit, v.connect_error) AS max_visits
+                FROM visit v
+                         INNER JOIN sessions_open so ON so.peer_id = v.peer_id
+            ), new_session AS (
+                INSERT INTO sessions_open (
+                    peer_id, first_successful_visit, last_successful_visit, last_visited_at, next_visit_due_at, updated_at,
+                    created_at, successful_visits_count, state, recovered_count, failed_visits_count, uptime)
+                SELECT v.peer_id, v.visit_started_at, v.visit_ended_at, v.visit_ended_at, calc_next_visit(v.visit_ended_at),
+                       NOW(), NOW(), 1, 'open', 0, 0, TSTZRANGE(v.visit_started_at, NULL)
+                FROM visit v
+                WHERE v.connect_error IS NULL
+                  AND NOT EXISTS (SELECT NULL FROM existing_session es WHERE es.seq = v.seq)
+                RETURNING id, peer_id
+            ), update_session_no_error AS (
+                UPDATE sessions_open AS so
+                    SET state                   = 'open',
+                        last_successful_visit   = es.new_visit_ended_at,
+                        last_visited_at         = es.new_visit_ended_at,
+                        successful_visits_count = es.successful_visits_count + 1,
+                        updated_at              = NOW(),
+                        first_failed_visit      = NULL,
+                        last_failed_visit       = NULL,
+                        failed_visits_count     = 0,
+                        finish_reason           = NULL,
+                        uptime                  = TSTZRANGE(es.first_successful_visit, es.new_visit_ended_at),
+                        recovered_count         = es.recovered_count + (es.state = 'pending')::INT,
+                        next_visit_due_at       = calc_next_visit(es.new_visit_ended_at, es.last_successful_visit)
+                    FROM existing_session AS es
+                    WHERE so.id = es.id AND es.new_error IS NULL
+            ), update_open_session_error AS (
+                UPDATE sessions_open AS so
+                    SET state               = 'pending',
+                        first_failed_visit  = es.new_visit_started_at,
+                        last_failed_visit   = es.new_visit_ended_at,
+                        last_visited_at     = es.new_visit_ended_at,
+                        failed_visits_count = es.failed_visits_count + 1,
+                        updated_at          = NOW(),
+                        finish_reason       = es.new_error,
+                        next_visit_due_at   = es.new_visit_ended_at + es.max_visits * '1m'::INTERVAL
+                    FROM existing_session AS es
+                    WHERE so.id = es.id AND es.state = 'open' AND es.new_error IS NOT NULL AND es.max_visits > 0
+            ), update_pending_session_error AS (
+                UPDATE sessions_open AS so
+                    SET last_failed_visit   = es.new_visit_ended_at,
+                        last_visited_at     = es.new_visit_ended_at,
+                        failed_visits_count = es.failed_visits_count + 1,
+                        updated_at          = NOW(),
+                        next_visit_due_at   = calc_next_visit(es.new_visit_ended_at, es.last_successful_visit)
+                    FROM existing_session AS es
+                    WHERE so.id = es.id AND es.state = 'pending' AND es.new_error IS NOT NULL AND es.failed_visits_count < es.max_visits
+            ), close_session_error AS (
+                UPDATE sessions AS s
+                    SET state               = 'closed',
+                        first_failed_visit  = COALESCE(es.first_failed_visit, es.new_visit_started_at),
+                        last_failed_visit   = es.new_visit_ended_at,
+                        last_visited_at     = es.new_visit_ended_at,
+                        failed_visits_count = es.failed_visits_count + 1,
+                        uptime              = TSTZRANGE(lower(es.uptime), es.last_successful_visit),
+                        updated_at          = NOW(),
+                        finish_reason       = COALESCE(es.finish_reason, es.new_error),
+                        next_visit_due_at   = NULL
+                    FROM existing_session AS es
+                    WHERE s.id = es.id AND es.new_error IS NOT NULL
+                        AND NOT (es.state = 'open' AND es.max_visits > 0)
+                        AND NOT (es.state = 'pending' AND es.failed_visits_count < es.max_visits)
+            )
+            UPDATE visits_staging vs
+            SET session_id = coalesce(es.id, ns.id)
+            FROM visit v
+                     LEFT JOIN existing_session es ON es.seq = v.seq
+                     LEFT JOIN new_session ns ON ns.peer_id = v.peer_id
+            WHERE vs.seq = v.seq;
+        END LOOP;
+
+    -- Now we're able to create the normalized visit instances
+    INSERT INTO visits (peer_id, crawl_id, session_id, dial_duration, connect_duration, crawl_duration,
+                        visit_started_at, visit_ended_at, created_at, type, connect_error, crawl_error,
+                        agent_version_id, protocols_set_id, multi_address_ids, peer_properties)
+    SELECT vs.peer_id,
+           vs.crawl_id,
+           vs.session_id,
+           vs.dial_duration,
+           vs.connect_duration,
+           vs.crawl_duration,
+           vs.visit_started_at,
+           vs.visit_ended_at,
+           NOW(),
+           vs.type,
+           vs.connect_error,
+           vs.crawl_error,
+           vs.agent_version_id,
+           vs.protocols_set_id,
+           vs.multi_address_ids,
+           vs.peer_properties
+    FROM visits_staging vs
+    ORDER BY vs.seq;
+
+    RETURN QUERY SELECT DISTINCT vs.peer_multi_hash, vs.peer_id FROM visits_staging vs;
+
+    DELETE FROM visits_staging;
+END;
+$insert_visits_batch$ LANGUAGE plpgsql;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP FUNCTION IF EXISTS upsert_discovery_id_prefixes;
+ALTER TABLE peers DROP COLUMN discovery_id_prefix;
+
+COMMIT;
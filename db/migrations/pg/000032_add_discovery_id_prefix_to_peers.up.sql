@@ -0,0 +1,26 @@
+BEGIN;
+
+-- Postgres doesn't support unsigned integers. So we store the uint64 prefix
+-- as the BIGINT with the same bits. Cast it to BIT(64) to work with the bits.
+ALTER TABLE peers ADD COLUMN discovery_id_prefix BIGINT;
+
+COMMENT ON COLUMN peers.discovery_id_prefix IS 'The first 64 bits of the key of the peer in the keyspace of the discovery protocol, interpreted as a signed integer.';
+
+-- Sets the discovery prefixes of the given peers if they aren't known yet.
+-- Peers that don't exist yet are inserted. The prefix of a peer never
+-- changes, so existing prefixes aren't updated.
+CREATE OR REPLACE FUNCTION upsert_discovery_id_prefixes(
+    new_multi_hashes TEXT[],
+    new_prefixes     BIGINT[]
+) RETURNS VOID AS
+$upsert_discovery_id_prefixes$
+INSERT INTO peers AS p (multi_hash, discovery_id_prefix, updated_at, created_at)
+SELECT new.multi_hash, new.prefix, NOW(), NOW()
+FROM unnest(new_multi_hashes, new_prefixes) new(multi_hash, prefix)
+ORDER BY new.multi_hash -- prevents deadlocks between concurrent upserts
+ON CONFLICT ON CONSTRAINT uq_peers_multi_hash DO UPDATE
+    SET discovery_id_prefix = EXCLUDED.discovery_id_prefix
+    WHERE p.discovery_id_prefix IS NULL;
+$upsert_discovery_id_prefixes$ LANGUAGE sql;
+
+COMMIT;
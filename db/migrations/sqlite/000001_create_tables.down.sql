@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS crawl_properties;
+DROP TABLE IF EXISTS neighbors;
+DROP TABLE IF EXISTS visits;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS peers;
+DROP TABLE IF EXISTS crawls;
@@ -0,0 +1,167 @@
+-- The SQLite schema is a denormalized version of the Postgres schema. Lists
+-- (e.g., protocols and multi addresses) are stored as JSON arrays, which can
+-- be queried with SQLite's JSON functions. All timestamps are stored in UTC.
+
+CREATE TABLE crawls
+(
+    -- A unique id that identifies a particular crawl
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- The network that was crawled
+    network_id         TEXT     NOT NULL,
+    -- The state of the crawl (started, cancelled, failed, succeeded)
+    state              TEXT     NOT NULL,
+    -- When did the crawl start
+    started_at         DATETIME NOT NULL,
+    -- When did the crawl finish
+    finished_at        DATETIME,
+    -- The number of peers that were crawled
+    crawled_peers      INTEGER,
+    -- The number of peers that were dialable
+    dialable_peers     INTEGER,
+    -- The number of peers that were undialable
+    undialable_peers   INTEGER,
+    -- The number of peers that remained in the queue
+    remaining_peers    INTEGER,
+    -- The estimated total number of peers in the network and the bounds of its 95% confidence interval
+    network_size       REAL,
+    network_size_lower REAL,
+    network_size_upper REAL,
+    -- The nebula version that performed the crawl
+    version            TEXT     NOT NULL,
+    -- When was this crawl updated the last time
+    updated_at         DATETIME NOT NULL,
+    -- When was this crawl created
+    created_at         DATETIME NOT NULL
+);
+
+CREATE TABLE peers
+(
+    -- An internal unique id that identifies a unique peer in the network
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- The peer ID in the form of Qm... or 12D3...
+    multi_hash      TEXT     NOT NULL UNIQUE,
+    -- The most recent agent version of the peer
+    agent_version   TEXT,
+    -- The most recent JSON array of protocols that the peer supports
+    protocols       TEXT,
+    -- The most recent JSON array of multi addresses of the peer
+    multi_addresses TEXT,
+    -- Additional network specific properties of the peer as a JSON object
+    properties      TEXT,
+    -- When was this peer updated the last time
+    updated_at      DATETIME NOT NULL,
+    -- When was this peer created
+    created_at      DATETIME NOT NULL
+);
+
+CREATE TABLE sessions
+(
+    -- A unique id that identifies this particular session
+    id                      INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- Reference to the remote peer ID
+    peer_id                 INTEGER  NOT NULL REFERENCES peers (id) ON DELETE CASCADE,
+    -- The state this session is in (open, pending, closed)
+    state                   TEXT     NOT NULL,
+    -- Timestamp of the first time we were able to visit that peer
+    first_successful_visit  DATETIME NOT NULL,
+    -- Timestamp of the last time we were able to visit that peer
+    last_successful_visit   DATETIME NOT NULL,
+    -- Timestamp when we should start visiting this peer again
+    next_visit_due_at       DATETIME,
+    -- When did we notice that this peer is not reachable
+    first_failed_visit      DATETIME,
+    -- When did we last notice that this peer is not reachable
+    last_failed_visit       DATETIME,
+    -- When did we last visit this peer
+    last_visited_at         DATETIME NOT NULL,
+    -- Number of successful visits in this session
+    successful_visits_count INTEGER  NOT NULL,
+    -- The number of times this session went from pending to open again
+    recovered_count         INTEGER  NOT NULL,
+    -- Number of failed visits before closing this session
+    failed_visits_count     INTEGER  NOT NULL,
+    -- What's the first error before we close this session
+    finish_reason           TEXT,
+    -- When was this session updated the last time
+    updated_at              DATETIME NOT NULL,
+    -- When was this session created
+    created_at              DATETIME NOT NULL
+);
+
+-- There shouldn't be two active sessions for the same peer
+CREATE UNIQUE INDEX uq_sessions_active_peer_id ON sessions (peer_id) WHERE state != 'closed';
+CREATE INDEX idx_sessions_next_visit_due_at ON sessions (next_visit_due_at) WHERE state != 'closed';
+
+CREATE TABLE visits
+(
+    -- A unique id that identifies this particular visit
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- Reference to the visited peer
+    peer_id          INTEGER  NOT NULL REFERENCES peers (id) ON DELETE CASCADE,
+    -- Reference to the crawl during which the peer was visited (NULL for dials)
+    crawl_id         INTEGER REFERENCES crawls (id) ON DELETE SET NULL,
+    -- Reference to the session of the peer at the time of the visit
+    session_id       INTEGER REFERENCES sessions (id) ON DELETE SET NULL,
+    -- The type of the visit (crawl, dial)
+    type             TEXT     NOT NULL,
+    -- The agent version that the peer reported
+    agent_version    TEXT,
+    -- The JSON array of protocols that the peer reported
+    protocols        TEXT,
+    -- The JSON arrays of multi addresses that we dialed, didn't dial, additionally learned, and that the peer listens on
+    dial_maddrs      TEXT,
+    filtered_maddrs  TEXT,
+    extra_maddrs     TEXT,
+    listen_maddrs    TEXT,
+    -- The multi address of the connection that we have established to the peer
+    connect_maddr    TEXT,
+    -- The JSON array of errors that belong to each of the dialed addresses
+    dial_errors      TEXT,
+    -- The time it took to dial, connect, and crawl the peer in seconds
+    dial_duration    REAL,
+    connect_duration REAL,
+    crawl_duration   REAL,
+    -- When did the visit start and end
+    visit_started_at DATETIME NOT NULL,
+    visit_ended_at   DATETIME NOT NULL,
+    -- The error that occurred when connecting to the peer
+    connect_error    TEXT,
+    -- The error that occurred when fetching the routing table of the peer
+    crawl_error      TEXT,
+    -- Additional network specific properties of the peer as a JSON object
+    peer_properties  TEXT,
+    -- When was this visit created
+    created_at       DATETIME NOT NULL
+);
+
+CREATE INDEX idx_visits_peer_id ON visits (peer_id);
+CREATE INDEX idx_visits_crawl_id ON visits (crawl_id);
+
+CREATE TABLE neighbors
+(
+    -- Reference to the crawl during which the routing table was fetched
+    crawl_id     INTEGER NOT NULL REFERENCES crawls (id) ON DELETE CASCADE,
+    -- Reference to the peer whose routing table this is
+    peer_id      INTEGER NOT NULL REFERENCES peers (id) ON DELETE CASCADE,
+    -- The JSON array of peer IDs (database IDs) in the routing table
+    neighbor_ids TEXT    NOT NULL,
+    -- Little endian representation of the buckets that couldn't be fetched
+    error_bits   INTEGER NOT NULL,
+
+    PRIMARY KEY (crawl_id, peer_id)
+);
+
+CREATE TABLE crawl_properties
+(
+    -- A unique id that identifies this particular crawl property
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- Reference to the crawl
+    crawl_id   INTEGER  NOT NULL REFERENCES crawls (id) ON DELETE CASCADE,
+    -- The property (e.g., agent_version, protocol) and its value
+    property   TEXT     NOT NULL,
+    value      TEXT     NOT NULL,
+    -- The number of peers with this property value
+    count      INTEGER  NOT NULL,
+    -- When was this crawl property created
+    created_at DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE visits DROP COLUMN retry_pass;
+ALTER TABLE crawls DROP COLUMN retry_succeeded_peers;
+ALTER TABLE crawls DROP COLUMN retried_peers;
@@ -0,0 +1,8 @@
+-- The number of peers that were crawled again after they had failed with a transient error
+ALTER TABLE crawls ADD COLUMN retried_peers INTEGER;
+-- The number of retried peers that could be connected to on retry
+ALTER TABLE crawls ADD COLUMN retry_succeeded_peers INTEGER;
+
+-- The retry pass of the crawl in which the peer was visited. A retried peer
+-- has more than one visit in the same crawl. Zero for the first visit.
+ALTER TABLE visits ADD COLUMN retry_pass INTEGER NOT NULL DEFAULT 0;
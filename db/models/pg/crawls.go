@@ -44,61 +44,91 @@ type Crawl struct { // An internal unique id that identifies a crawl.
 	// The number of remaining peers in the crawl queue if the process was cancelled.
 	RemainingPeers null.Int `boil:"remaining_peers" json:"remaining_peers,omitempty" toml:"remaining_peers" yaml:"remaining_peers,omitempty"`
 	Version        string   `boil:"version" json:"version" toml:"version" yaml:"version"`
+	// The estimated total number of peers in the network based on the routing tables of the crawled peers.
+	NetworkSize null.Float64 `boil:"network_size" json:"network_size,omitempty" toml:"network_size" yaml:"network_size,omitempty"`
+	// The lower bound of the 95% confidence interval of the network size estimate.
+	NetworkSizeLower null.Float64 `boil:"network_size_lower" json:"network_size_lower,omitempty" toml:"network_size_lower" yaml:"network_size_lower,omitempty"`
+	// The upper bound of the 95% confidence interval of the network size estimate.
+	NetworkSizeUpper null.Float64 `boil:"network_size_upper" json:"network_size_upper,omitempty" toml:"network_size_upper" yaml:"network_size_upper,omitempty"`
+	// The number of peers that were crawled again after they had failed with a transient error.
+	RetriedPeers null.Int `boil:"retried_peers" json:"retried_peers,omitempty" toml:"retried_peers" yaml:"retried_peers,omitempty"`
+	// The number of retried peers that could be connected to on retry.
+	RetrySucceededPeers null.Int `boil:"retry_succeeded_peers" json:"retry_succeeded_peers,omitempty" toml:"retry_succeeded_peers" yaml:"retry_succeeded_peers,omitempty"`
 
 	R *crawlR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L crawlL  `boil:"-" json:"-" toml:"-" yaml:"-"`
 }
 
 var CrawlColumns = struct {
-	ID              string
-	State           string
-	StartedAt       string
-	FinishedAt      string
-	UpdatedAt       string
-	CreatedAt       string
-	CrawledPeers    string
-	DialablePeers   string
-	UndialablePeers string
-	RemainingPeers  string
-	Version         string
+	ID                  string
+	State               string
+	StartedAt           string
+	FinishedAt          string
+	UpdatedAt           string
+	CreatedAt           string
+	CrawledPeers        string
+	DialablePeers       string
+	UndialablePeers     string
+	RemainingPeers      string
+	Version             string
+	NetworkSize         string
+	NetworkSizeLower    string
+	NetworkSizeUpper    string
+	RetriedPeers        string
+	RetrySucceededPeers string
 }{
-	ID:              "id",
-	State:           "state",
-	StartedAt:       "started_at",
-	FinishedAt:      "finished_at",
-	UpdatedAt:       "updated_at",
-	CreatedAt:       "created_at",
-	CrawledPeers:    "crawled_peers",
-	DialablePeers:   "dialable_peers",
-	UndialablePeers: "undialable_peers",
-	RemainingPeers:  "remaining_peers",
-	Version:         "version",
+	ID:                  "id",
+	State:               "state",
+	StartedAt:           "started_at",
+	FinishedAt:          "finished_at",
+	UpdatedAt:           "updated_at",
+	CreatedAt:           "created_at",
+	CrawledPeers:        "crawled_peers",
+	DialablePeers:       "dialable_peers",
+	UndialablePeers:     "undialable_peers",
+	RemainingPeers:      "remaining_peers",
+	Version:             "version",
+	NetworkSize:         "network_size",
+	NetworkSizeLower:    "network_size_lower",
+	NetworkSizeUpper:    "network_size_upper",
+	RetriedPeers:        "retried_peers",
+	RetrySucceededPeers: "retry_succeeded_peers",
 }
 
 var CrawlTableColumns = struct {
-	ID              string
-	State           string
-	StartedAt       string
-	FinishedAt      string
-	UpdatedAt       string
-	CreatedAt       string
-	CrawledPeers    string
-	DialablePeers   string
-	UndialablePeers string
-	RemainingPeers  string
-	Version         string
+	ID                  string
+	State               string
+	StartedAt           string
+	FinishedAt          string
+	UpdatedAt           string
+	CreatedAt           string
+	CrawledPeers        string
+	DialablePeers       string
+	UndialablePeers     string
+	RemainingPeers      string
+	Version             string
+	NetworkSize         string
+	NetworkSizeLower    string
+	NetworkSizeUpper    string
+	RetriedPeers        string
+	RetrySucceededPeers string
 }{
-	ID:              "crawls.id",
-	State:           "crawls.state",
-	StartedAt:       "crawls.started_at",
-	FinishedAt:      "crawls.finished_at",
-	UpdatedAt:       "crawls.updated_at",
-	CreatedAt:       "crawls.created_at",
-	CrawledPeers:    "crawls.crawled_peers",
-	DialablePeers:   "crawls.dialable_peers",
-	UndialablePeers: "crawls.undialable_peers",
-	RemainingPeers:  "crawls.remaining_peers",
-	Version:         "crawls.version",
+	ID:                  "crawls.id",
+	State:               "crawls.state",
+	StartedAt:           "crawls.started_at",
+	FinishedAt:          "crawls.finished_at",
+	UpdatedAt:           "crawls.updated_at",
+	CreatedAt:           "crawls.created_at",
+	CrawledPeers:        "crawls.crawled_peers",
+	DialablePeers:       "crawls.dialable_peers",
+	UndialablePeers:     "crawls.undialable_peers",
+	RemainingPeers:      "crawls.remaining_peers",
+	Version:             "crawls.version",
+	NetworkSize:         "crawls.network_size",
+	NetworkSizeLower:    "crawls.network_size_lower",
+	NetworkSizeUpper:    "crawls.network_size_upper",
+	RetriedPeers:        "crawls.retried_peers",
+	RetrySucceededPeers: "crawls.retry_succeeded_peers",
 }
 
 // Generated where
@@ -127,30 +157,64 @@ func (w whereHelpernull_Time) GTE(x null.Time) qm.QueryMod {
 func (w whereHelpernull_Time) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
 func (w whereHelpernull_Time) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
 
+type whereHelpernull_Float64 struct{ field string }
+
+func (w whereHelpernull_Float64) EQ(x null.Float64) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, false, x)
+}
+func (w whereHelpernull_Float64) NEQ(x null.Float64) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, true, x)
+}
+func (w whereHelpernull_Float64) LT(x null.Float64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpernull_Float64) LTE(x null.Float64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpernull_Float64) GT(x null.Float64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpernull_Float64) GTE(x null.Float64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+
+func (w whereHelpernull_Float64) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
+func (w whereHelpernull_Float64) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
+
 var CrawlWhere = struct {
-	ID              whereHelperint
-	State           whereHelperstring
-	StartedAt       whereHelpertime_Time
-	FinishedAt      whereHelpernull_Time
-	UpdatedAt       whereHelpertime_Time
-	CreatedAt       whereHelpertime_Time
-	CrawledPeers    whereHelpernull_Int
-	DialablePeers   whereHelpernull_Int
-	UndialablePeers whereHelpernull_Int
-	RemainingPeers  whereHelpernull_Int
-	Version         whereHelperstring
+	ID                  whereHelperint
+	State               whereHelperstring
+	StartedAt           whereHelpertime_Time
+	FinishedAt          whereHelpernull_Time
+	UpdatedAt           whereHelpertime_Time
+	CreatedAt           whereHelpertime_Time
+	CrawledPeers        whereHelpernull_Int
+	DialablePeers       whereHelpernull_Int
+	UndialablePeers     whereHelpernull_Int
+	RemainingPeers      whereHelpernull_Int
+	Version             whereHelperstring
+	NetworkSize         whereHelpernull_Float64
+	NetworkSizeLower    whereHelpernull_Float64
+	NetworkSizeUpper    whereHelpernull_Float64
+	RetriedPeers        whereHelpernull_Int
+	RetrySucceededPeers whereHelpernull_Int
 }{
-	ID:              whereHelperint{field: "\"crawls\".\"id\""},
-	State:           whereHelperstring{field: "\"crawls\".\"state\""},
-	StartedAt:       whereHelpertime_Time{field: "\"crawls\".\"started_at\""},
-	FinishedAt:      whereHelpernull_Time{field: "\"crawls\".\"finished_at\""},
-	UpdatedAt:       whereHelpertime_Time{field: "\"crawls\".\"updated_at\""},
-	CreatedAt:       whereHelpertime_Time{field: "\"crawls\".\"created_at\""},
-	CrawledPeers:    whereHelpernull_Int{field: "\"crawls\".\"crawled_peers\""},
-	DialablePeers:   whereHelpernull_Int{field: "\"crawls\".\"dialable_peers\""},
-	UndialablePeers: whereHelpernull_Int{field: "\"crawls\".\"undialable_peers\""},
-	RemainingPeers:  whereHelpernull_Int{field: "\"crawls\".\"remaining_peers\""},
-	Version:         whereHelperstring{field: "\"crawls\".\"version\""},
+	ID:                  whereHelperint{field: "\"crawls\".\"id\""},
+	State:               whereHelperstring{field: "\"crawls\".\"state\""},
+	StartedAt:           whereHelpertime_Time{field: "\"crawls\".\"started_at\""},
+	FinishedAt:          whereHelpernull_Time{field: "\"crawls\".\"finished_at\""},
+	UpdatedAt:           whereHelpertime_Time{field: "\"crawls\".\"updated_at\""},
+	CreatedAt:           whereHelpertime_Time{field: "\"crawls\".\"created_at\""},
+	CrawledPeers:        whereHelpernull_Int{field: "\"crawls\".\"crawled_peers\""},
+	DialablePeers:       whereHelpernull_Int{field: "\"crawls\".\"dialable_peers\""},
+	UndialablePeers:     whereHelpernull_Int{field: "\"crawls\".\"undialable_peers\""},
+	RemainingPeers:      whereHelpernull_Int{field: "\"crawls\".\"remaining_peers\""},
+	Version:             whereHelperstring{field: "\"crawls\".\"version\""},
+	NetworkSize:         whereHelpernull_Float64{field: "\"crawls\".\"network_size\""},
+	NetworkSizeLower:    whereHelpernull_Float64{field: "\"crawls\".\"network_size_lower\""},
+	NetworkSizeUpper:    whereHelpernull_Float64{field: "\"crawls\".\"network_size_upper\""},
+	RetriedPeers:        whereHelpernull_Int{field: "\"crawls\".\"retried_peers\""},
+	RetrySucceededPeers: whereHelpernull_Int{field: "\"crawls\".\"retry_succeeded_peers\""},
 }
 
 // CrawlRels is where relationship names are stored.
@@ -181,9 +245,9 @@ func (r *crawlR) GetCrawlProperties() CrawlPropertySlice {
 type crawlL struct{}
 
 var (
-	crawlAllColumns            = []string{"id", "state", "started_at", "finished_at", "updated_at", "created_at", "crawled_peers", "dialable_peers", "undialable_peers", "remaining_peers", "version"}
+	crawlAllColumns            = []string{"id", "state", "started_at", "finished_at", "updated_at", "created_at", "crawled_peers", "dialable_peers", "undialable_peers", "remaining_peers", "version", "network_size", "network_size_lower", "network_size_upper", "retried_peers", "retry_succeeded_peers"}
 	crawlColumnsWithoutDefault = []string{"state", "started_at", "updated_at", "created_at", "version"}
-	crawlColumnsWithDefault    = []string{"id", "finished_at", "crawled_peers", "dialable_peers", "undialable_peers", "remaining_peers"}
+	crawlColumnsWithDefault    = []string{"id", "finished_at", "crawled_peers", "dialable_peers", "undialable_peers", "remaining_peers", "network_size", "network_size_lower", "network_size_upper", "retried_peers", "retry_succeeded_peers"}
 	crawlPrimaryKeyColumns     = []string{"id"}
 	crawlGeneratedColumns      = []string{"id"}
 )
@@ -24,52 +24,57 @@ import (
 
 // Peer is an object representing the database table.
 type Peer struct {
-	ID             int       `boil:"id" json:"id" toml:"id" yaml:"id"`
-	AgentVersionID null.Int  `boil:"agent_version_id" json:"agent_version_id,omitempty" toml:"agent_version_id" yaml:"agent_version_id,omitempty"`
-	ProtocolsSetID null.Int  `boil:"protocols_set_id" json:"protocols_set_id,omitempty" toml:"protocols_set_id" yaml:"protocols_set_id,omitempty"`
-	MultiHash      string    `boil:"multi_hash" json:"multi_hash" toml:"multi_hash" yaml:"multi_hash"`
-	UpdatedAt      time.Time `boil:"updated_at" json:"updated_at" toml:"updated_at" yaml:"updated_at"`
-	CreatedAt      time.Time `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
-	Properties     null.JSON `boil:"properties" json:"properties,omitempty" toml:"properties" yaml:"properties,omitempty"`
+	ID                int        `boil:"id" json:"id" toml:"id" yaml:"id"`
+	AgentVersionID    null.Int   `boil:"agent_version_id" json:"agent_version_id,omitempty" toml:"agent_version_id" yaml:"agent_version_id,omitempty"`
+	ProtocolsSetID    null.Int   `boil:"protocols_set_id" json:"protocols_set_id,omitempty" toml:"protocols_set_id" yaml:"protocols_set_id,omitempty"`
+	MultiHash         string     `boil:"multi_hash" json:"multi_hash" toml:"multi_hash" yaml:"multi_hash"`
+	UpdatedAt         time.Time  `boil:"updated_at" json:"updated_at" toml:"updated_at" yaml:"updated_at"`
+	CreatedAt         time.Time  `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
+	Properties        null.JSON  `boil:"properties" json:"properties,omitempty" toml:"properties" yaml:"properties,omitempty"`
+	DiscoveryIDPrefix null.Int64 `boil:"discovery_id_prefix" json:"discovery_id_prefix,omitempty" toml:"discovery_id_prefix" yaml:"discovery_id_prefix,omitempty"`
 
 	R *peerR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L peerL  `boil:"-" json:"-" toml:"-" yaml:"-"`
 }
 
 var PeerColumns = struct {
-	ID             string
-	AgentVersionID string
-	ProtocolsSetID string
-	MultiHash      string
-	UpdatedAt      string
-	CreatedAt      string
-	Properties     string
+	ID                string
+	AgentVersionID    string
+	ProtocolsSetID    string
+	MultiHash         string
+	UpdatedAt         string
+	CreatedAt         string
+	Properties        string
+	DiscoveryIDPrefix string
 }{
-	ID:             "id",
-	AgentVersionID: "agent_version_id",
-	ProtocolsSetID: "protocols_set_id",
-	MultiHash:      "multi_hash",
-	UpdatedAt:      "updated_at",
-	CreatedAt:      "created_at",
-	Properties:     "properties",
+	ID:                "id",
+	AgentVersionID:    "agent_version_id",
+	ProtocolsSetID:    "protocols_set_id",
+	MultiHash:         "multi_hash",
+	UpdatedAt:         "updated_at",
+	CreatedAt:         "created_at",
+	Properties:        "properties",
+	DiscoveryIDPrefix: "discovery_id_prefix",
 }
 
 var PeerTableColumns = struct {
-	ID             string
-	AgentVersionID string
-	ProtocolsSetID string
-	MultiHash      string
-	UpdatedAt      string
-	CreatedAt      string
-	Properties     string
+	ID                string
+	AgentVersionID    string
+	ProtocolsSetID    string
+	MultiHash         string
+	UpdatedAt         string
+	CreatedAt         string
+	Properties        string
+	DiscoveryIDPrefix string
 }{
-	ID:             "peers.id",
-	AgentVersionID: "peers.agent_version_id",
-	ProtocolsSetID: "peers.protocols_set_id",
-	MultiHash:      "peers.multi_hash",
-	UpdatedAt:      "peers.updated_at",
-	CreatedAt:      "peers.created_at",
-	Properties:     "peers.properties",
+	ID:                "peers.id",
+	AgentVersionID:    "peers.agent_version_id",
+	ProtocolsSetID:    "peers.protocols_set_id",
+	MultiHash:         "peers.multi_hash",
+	UpdatedAt:         "peers.updated_at",
+	CreatedAt:         "peers.created_at",
+	Properties:        "peers.properties",
+	DiscoveryIDPrefix: "peers.discovery_id_prefix",
 }
 
 // Generated where
@@ -98,22 +103,62 @@ func (w whereHelpernull_JSON) GTE(x null.JSON) qm.QueryMod {
 func (w whereHelpernull_JSON) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
 func (w whereHelpernull_JSON) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
 
+type whereHelpernull_Int64 struct{ field string }
+
+func (w whereHelpernull_Int64) EQ(x null.Int64) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, false, x)
+}
+func (w whereHelpernull_Int64) NEQ(x null.Int64) qm.QueryMod {
+	return qmhelper.WhereNullEQ(w.field, true, x)
+}
+func (w whereHelpernull_Int64) LT(x null.Int64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LT, x)
+}
+func (w whereHelpernull_Int64) LTE(x null.Int64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.LTE, x)
+}
+func (w whereHelpernull_Int64) GT(x null.Int64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GT, x)
+}
+func (w whereHelpernull_Int64) GTE(x null.Int64) qm.QueryMod {
+	return qmhelper.Where(w.field, qmhelper.GTE, x)
+}
+func (w whereHelpernull_Int64) IN(slice []int64) qm.QueryMod {
+	values := make([]interface{}, 0, len(slice))
+	for _, value := range slice {
+		values = append(values, value)
+	}
+	return qm.WhereIn(fmt.Sprintf("%s IN ?", w.field), values...)
+}
+func (w whereHelpernull_Int64) NIN(slice []int64) qm.QueryMod {
+	values := make([]interface{}, 0, len(slice))
+	for _, value := range slice {
+		values = append(values, value)
+	}
+	return qm.WhereNotIn(fmt.Sprintf("%s NOT IN ?", w.field), values...)
+}
+
+func (w whereHelpernull_Int64) IsNull() qm.QueryMod    { return qmhelper.WhereIsNull(w.field) }
+func (w whereHelpernull_Int64) IsNotNull() qm.QueryMod { return qmhelper.WhereIsNotNull(w.field) }
+
 var PeerWhere = struct {
-	ID             whereHelperint
-	AgentVersionID whereHelpernull_Int
-	ProtocolsSetID whereHelpernull_Int
-	MultiHash      whereHelperstring
-	UpdatedAt      whereHelpertime_Time
-	CreatedAt      whereHelpertime_Time
-	Properties     whereHelpernull_JSON
+	ID                whereHelperint
+	AgentVersionID    whereHelpernull_Int
+	ProtocolsSetID    whereHelpernull_Int
+	MultiHash         whereHelperstring
+	UpdatedAt         whereHelpertime_Time
+	CreatedAt         whereHelpertime_Time
+	Properties        whereHelpernull_JSON
+	DiscoveryIDPrefix whereHelpernull_Int64
 }{
-	ID:             whereHelperint{field: "\"peers\".\"id\""},
-	AgentVersionID: whereHelpernull_Int{field: "\"peers\".\"agent_version_id\""},
-	ProtocolsSetID: whereHelpernull_Int{field: "\"peers\".\"protocols_set_id\""},
-	MultiHash:      whereHelperstring{field: "\"peers\".\"multi_hash\""},
-	UpdatedAt:      whereHelpertime_Time{field: "\"peers\".\"updated_at\""},
-	CreatedAt:      whereHelpertime_Time{field: "\"peers\".\"created_at\""},
-	Properties:     whereHelpernull_JSON{field: "\"peers\".\"properties\""},
+	ID:                whereHelperint{field: "\"peers\".\"id\""},
+	AgentVersionID:    whereHelpernull_Int{field: "\"peers\".\"agent_version_id\""},
+	ProtocolsSetID:    whereHelpernull_Int{field: "\"peers\".\"protocols_set_id\""},
+	MultiHash:         whereHelperstring{field: "\"peers\".\"multi_hash\""},
+	UpdatedAt:         whereHelpertime_Time{field: "\"peers\".\"updated_at\""},
+	CreatedAt:         whereHelpertime_Time{field: "\"peers\".\"created_at\""},
+	Properties:        whereHelpernull_JSON{field: "\"peers\".\"properties\""},
+	DiscoveryIDPrefix: whereHelpernull_Int64{field: "\"peers\".\"discovery_id_prefix\""},
 }
 
 // PeerRels is where relationship names are stored.
@@ -174,9 +219,9 @@ func (r *peerR) GetMultiAddresses() MultiAddressSlice {
 type peerL struct{}
 
 var (
-	peerAllColumns            = []string{"id", "agent_version_id", "protocols_set_id", "multi_hash", "updated_at", "created_at", "properties"}
+	peerAllColumns            = []string{"id", "agent_version_id", "protocols_set_id", "multi_hash", "updated_at", "created_at", "properties", "discovery_id_prefix"}
 	peerColumnsWithoutDefault = []string{"multi_hash", "updated_at", "created_at"}
-	peerColumnsWithDefault    = []string{"id", "agent_version_id", "protocols_set_id", "properties"}
+	peerColumnsWithDefault    = []string{"id", "agent_version_id", "protocols_set_id", "properties", "discovery_id_prefix"}
 	peerPrimaryKeyColumns     = []string{"id"}
 	peerGeneratedColumns      = []string{"id"}
 )
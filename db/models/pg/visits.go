@@ -42,6 +42,8 @@ type Visit struct {
 	CrawlDuration   null.String      `boil:"crawl_duration" json:"crawl_duration,omitempty" toml:"crawl_duration" yaml:"crawl_duration,omitempty"`
 	MultiAddressIds types.Int64Array `boil:"multi_address_ids" json:"multi_address_ids,omitempty" toml:"multi_address_ids" yaml:"multi_address_ids,omitempty"`
 	PeerProperties  null.JSON        `boil:"peer_properties" json:"peer_properties,omitempty" toml:"peer_properties" yaml:"peer_properties,omitempty"`
+	// The retry pass of the crawl in which the peer was visited. Zero for the first visit of the peer in the crawl.
+	RetryPass int16 `boil:"retry_pass" json:"retry_pass" toml:"retry_pass" yaml:"retry_pass"`
 
 	R *visitR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L visitL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -65,6 +67,7 @@ var VisitColumns = struct {
 	CrawlDuration   string
 	MultiAddressIds string
 	PeerProperties  string
+	RetryPass       string
 }{
 	ID:              "id",
 	PeerID:          "peer_id",
@@ -83,6 +86,7 @@ var VisitColumns = struct {
 	CrawlDuration:   "crawl_duration",
 	MultiAddressIds: "multi_address_ids",
 	PeerProperties:  "peer_properties",
+	RetryPass:       "retry_pass",
 }
 
 var VisitTableColumns = struct {
@@ -103,6 +107,7 @@ var VisitTableColumns = struct {
 	CrawlDuration   string
 	MultiAddressIds string
 	PeerProperties  string
+	RetryPass       string
 }{
 	ID:              "visits.id",
 	PeerID:          "visits.peer_id",
@@ -121,6 +126,7 @@ var VisitTableColumns = struct {
 	CrawlDuration:   "visits.crawl_duration",
 	MultiAddressIds: "visits.multi_address_ids",
 	PeerProperties:  "visits.peer_properties",
+	RetryPass:       "visits.retry_pass",
 }
 
 // Generated where
@@ -143,6 +149,7 @@ var VisitWhere = struct {
 	CrawlDuration   whereHelpernull_String
 	MultiAddressIds whereHelpertypes_Int64Array
 	PeerProperties  whereHelpernull_JSON
+	RetryPass       whereHelperint16
 }{
 	ID:              whereHelperint{field: "\"visits\".\"id\""},
 	PeerID:          whereHelperint{field: "\"visits\".\"peer_id\""},
@@ -161,6 +168,7 @@ var VisitWhere = struct {
 	CrawlDuration:   whereHelpernull_String{field: "\"visits\".\"crawl_duration\""},
 	MultiAddressIds: whereHelpertypes_Int64Array{field: "\"visits\".\"multi_address_ids\""},
 	PeerProperties:  whereHelpernull_JSON{field: "\"visits\".\"peer_properties\""},
+	RetryPass:       whereHelperint16{field: "\"visits\".\"retry_pass\""},
 }
 
 // VisitRels is where relationship names are stored.
@@ -180,9 +188,9 @@ func (*visitR) NewStruct() *visitR {
 type visitL struct{}
 
 var (
-	visitAllColumns            = []string{"id", "peer_id", "crawl_id", "session_id", "agent_version_id", "protocols_set_id", "type", "connect_error", "crawl_error", "visit_started_at", "visit_ended_at", "created_at", "dial_duration", "connect_duration", "crawl_duration", "multi_address_ids", "peer_properties"}
+	visitAllColumns            = []string{"id", "peer_id", "crawl_id", "session_id", "agent_version_id", "protocols_set_id", "type", "connect_error", "crawl_error", "visit_started_at", "visit_ended_at", "created_at", "dial_duration", "connect_duration", "crawl_duration", "multi_address_ids", "peer_properties", "retry_pass"}
 	visitColumnsWithoutDefault = []string{"peer_id", "type", "visit_started_at", "visit_ended_at", "created_at"}
-	visitColumnsWithDefault    = []string{"id", "crawl_id", "session_id", "agent_version_id", "protocols_set_id", "connect_error", "crawl_error", "dial_duration", "connect_duration", "crawl_duration", "multi_address_ids", "peer_properties"}
+	visitColumnsWithDefault    = []string{"id", "crawl_id", "session_id", "agent_version_id", "protocols_set_id", "connect_error", "crawl_error", "dial_duration", "connect_duration", "crawl_duration", "multi_address_ids", "peer_properties", "retry_pass"}
 	visitPrimaryKeyColumns     = []string{"id", "visit_started_at"}
 	visitGeneratedColumns      = []string{"id"}
 )
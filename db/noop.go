@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 )
@@ -16,6 +17,18 @@ func (n *NoopClient) InitCrawl(ctx context.Context, version string) error {
 	return nil
 }
 
+func (n *NoopClient) InitCrawlAt(ctx context.Context, version string, startedAt time.Time) error {
+	return nil
+}
+
+func (n *NoopClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	return nil
+}
+
+func (n *NoopClient) CrawlID() string {
+	return ""
+}
+
 func (n *NoopClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) error {
 	return nil
 }
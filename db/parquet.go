@@ -0,0 +1,605 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/parquet-go/parquet-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+// ParquetClientConfig holds the configuration for the Parquet client.
+type ParquetClientConfig struct {
+	// the directory to which the Parquet files should be written
+	Out string
+
+	// the maximum number of rows in a single row group. Zero means that the
+	// default of the parquet library is used.
+	RowGroupSize int
+
+	// Whether to write the files of each crawl into a separate `crawl_id=<id>`
+	// subdirectory. Query engines like DuckDB or Spark recognize this layout
+	// as hive partitioning and expose the crawl_id as a column. Therefore,
+	// the files themselves don't contain the crawl_id column in this case.
+	PartitionByCrawl bool
+}
+
+// ParquetClient writes the crawl results as Apache Parquet files to a
+// directory on disk. It writes the following files for each crawl:
+//
+//	<crawl_id>_crawl.parquet             crawl metadata (single row)
+//	<crawl_id>_crawl_properties.parquet  aggregated crawl properties
+//	<crawl_id>_visits.parquet            one row per visit
+//	<crawl_id>_neighbors.parquet         one row per routing table
+//
+// If the crawl is resumed, new visits and neighbors files with a numeric
+// suffix are created because Parquet files can't be appended to.
+type ParquetClient struct {
+	cfg *ParquetClientConfig
+
+	// the identifier of the crawl. Like the JSON client, this is the time
+	// when the client was initialized.
+	crawlID string
+
+	// guards the visits and neighbors files
+	filesMu   sync.Mutex
+	visits    *parquetFile[ParquetVisit]
+	neighbors *parquetFile[ParquetNeighbors]
+
+	crawlMu sync.Mutex
+	crawl   *ParquetCrawl
+}
+
+// ParquetCrawl is the schema of the crawl metadata file.
+type ParquetCrawl struct {
+	CrawlID             string     `parquet:"crawl_id"`
+	State               string     `parquet:"state"`
+	StartedAt           time.Time  `parquet:"started_at,timestamp(millisecond)"`
+	FinishedAt          *time.Time `parquet:"finished_at,optional"`
+	CrawledPeers        *int64     `parquet:"crawled_peers,optional"`
+	DialablePeers       *int64     `parquet:"dialable_peers,optional"`
+	UndialablePeers     *int64     `parquet:"undialable_peers,optional"`
+	RemainingPeers      *int64     `parquet:"remaining_peers,optional"`
+	NetworkSize         *float64   `parquet:"network_size,optional"`
+	NetworkSizeLower    *float64   `parquet:"network_size_lower,optional"`
+	NetworkSizeUpper    *float64   `parquet:"network_size_upper,optional"`
+	RetriedPeers        *int64     `parquet:"retried_peers,optional"`
+	RetrySucceededPeers *int64     `parquet:"retry_succeeded_peers,optional"`
+	Version             string     `parquet:"version"`
+	UpdatedAt           time.Time  `parquet:"updated_at,timestamp(millisecond)"`
+	CreatedAt           time.Time  `parquet:"created_at,timestamp(millisecond)"`
+}
+
+// ParquetCrawlProperty is the schema of the crawl properties file.
+type ParquetCrawlProperty struct {
+	CrawlID  string `parquet:"crawl_id"`
+	Property string `parquet:"property,dict"`
+	Value    string `parquet:"value,dict"`
+	Count    int64  `parquet:"count"`
+}
+
+// ParquetVisit is the schema of the visits file. All durations are given in
+// seconds and the properties are a JSON object.
+type ParquetVisit struct {
+	CrawlID         string    `parquet:"crawl_id,dict"`
+	PeerID          string    `parquet:"peer_id"`
+	VisitType       string    `parquet:"visit_type,dict"`
+	AgentVersion    *string   `parquet:"agent_version,optional,dict"`
+	Protocols       []string  `parquet:"protocols,list"`
+	DialMaddrs      []string  `parquet:"dial_maddrs,list"`
+	FilteredMaddrs  []string  `parquet:"filtered_maddrs,list"`
+	ExtraMaddrs     []string  `parquet:"extra_maddrs,list"`
+	ListenMaddrs    []string  `parquet:"listen_maddrs,list"`
+	ConnectMaddr    *string   `parquet:"connect_maddr,optional"`
+	DialErrors      []string  `parquet:"dial_errors,list"`
+	DialDuration    *float64  `parquet:"dial_duration,optional"`
+	ConnectDuration *float64  `parquet:"connect_duration,optional"`
+	CrawlDuration   *float64  `parquet:"crawl_duration,optional"`
+	VisitStartedAt  time.Time `parquet:"visit_started_at,timestamp(millisecond)"`
+	VisitEndedAt    time.Time `parquet:"visit_ended_at,timestamp(millisecond)"`
+	ConnectError    *string   `parquet:"connect_error,optional,dict"`
+	CrawlError      *string   `parquet:"crawl_error,optional,dict"`
+	Properties      *string   `parquet:"properties,optional"`
+	RetryPass       int32     `parquet:"retry_pass"`
+}
+
+// ParquetNeighbors is the schema of the neighbors file.
+type ParquetNeighbors struct {
+	CrawlID     string   `parquet:"crawl_id,dict"`
+	PeerID      string   `parquet:"peer_id"`
+	NeighborIDs []string `parquet:"neighbor_ids,list"`
+	ErrorBits   int32    `parquet:"error_bits"`
+}
+
+// NewParquetClient initializes a new client that writes Parquet files to the
+// configured output directory.
+func NewParquetClient(cfg *ParquetClientConfig) (*ParquetClient, error) {
+	log.WithField("out", cfg.Out).Infoln("Initializing Parquet client")
+
+	client := &ParquetClient{
+		cfg:     cfg,
+		crawlID: time.Now().Format("2006-01-02T15:04"),
+	}
+
+	if err := client.openFiles(0); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// path returns the file path of the file with the given name that belongs
+// to the given crawl.
+func (c *ParquetClient) path(crawlID string, name string) string {
+	if c.cfg.PartitionByCrawl {
+		return filepath.Join(c.cfg.Out, "crawl_id="+hivePartitionValue(crawlID), name+".parquet")
+	}
+	return filepath.Join(c.cfg.Out, crawlID+"_"+name+".parquet")
+}
+
+// hivePartitionValue escapes the characters of the given partition value that
+// aren't allowed in hive partition paths the same way as Hive does. The crawl
+// IDs contain colons, and Spark interprets commas in paths as lists of paths.
+func hivePartitionValue(value string) string {
+	var sb strings.Builder
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune("\"#%'*/:=?\\{[]^,", r) {
+			fmt.Fprintf(&sb, "%%%02X", r)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// partName returns the file name of the given part of a file. Parts are
+// numbered starting from zero and the first part doesn't have a suffix.
+func partName(name string, part int) string {
+	if part == 0 {
+		return name
+	}
+	return name + "_" + strconv.Itoa(part)
+}
+
+// openFiles creates the visits and neighbors files of the given part for
+// the current crawl.
+func (c *ParquetClient) openFiles(part int) error {
+	if err := os.MkdirAll(filepath.Dir(c.path(c.crawlID, "crawl")), 0o755); err != nil {
+		return fmt.Errorf("make parquet out directory: %w", err)
+	}
+
+	opts := []parquet.WriterOption{
+		parquet.Compression(&parquet.Zstd),
+		parquet.CreatedBy("nebula", "", ""),
+	}
+	if c.cfg.RowGroupSize > 0 {
+		opts = append(opts, parquet.MaxRowsPerRowGroup(int64(c.cfg.RowGroupSize)))
+	}
+
+	visits, err := createParquetFile[ParquetVisit](c.path(c.crawlID, partName("visits", part)), c.cfg.PartitionByCrawl, opts...)
+	if err != nil {
+		return fmt.Errorf("create visits file: %w", err)
+	}
+
+	neighbors, err := createParquetFile[ParquetNeighbors](c.path(c.crawlID, partName("neighbors", part)), c.cfg.PartitionByCrawl, opts...)
+	if err != nil {
+		_ = visits.Close()
+		return fmt.Errorf("create neighbors file: %w", err)
+	}
+
+	c.visits = visits
+	c.neighbors = neighbors
+
+	return nil
+}
+
+func (c *ParquetClient) InitCrawl(ctx context.Context, version string) error {
+	return c.InitCrawlAt(ctx, version, time.Now())
+}
+
+func (c *ParquetClient) InitCrawlAt(ctx context.Context, version string, startedAt time.Time) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawl != nil {
+		return fmt.Errorf("crawl already initialized")
+	}
+
+	now := time.Now()
+	crawl := &ParquetCrawl{
+		CrawlID:   c.crawlID,
+		State:     string(CrawlStateStarted),
+		StartedAt: startedAt,
+		Version:   version,
+		UpdatedAt: now,
+		CreatedAt: now,
+	}
+
+	if err := c.writeCrawl(crawl); err != nil {
+		return err
+	}
+
+	c.crawl = crawl
+
+	return nil
+}
+
+// ResumeCrawl continues writing the files of a previous crawl in the same
+// output directory. New visits and neighbors files with the next free
+// numeric suffix are created for the resumed crawl.
+func (c *ParquetClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawl != nil {
+		return fmt.Errorf("crawl already initialized")
+	}
+
+	crawls, err := parquet.ReadFile[ParquetCrawl](c.path(crawlID, "crawl"))
+	if err != nil {
+		return fmt.Errorf("read crawl parquet: %w", err)
+	} else if len(crawls) != 1 {
+		return fmt.Errorf("unexpected number of crawls in crawl parquet: %d", len(crawls))
+	}
+	crawl := &crawls[0]
+
+	// partitioned files don't contain the crawl_id column
+	crawl.CrawlID = crawlID
+
+	c.filesMu.Lock()
+	defer c.filesMu.Unlock()
+
+	// the files that were created when initializing the client are still
+	// empty and not needed anymore.
+	if err = c.closeFiles(); err != nil {
+		log.WithError(err).Warnln("Failed closing Parquet files")
+	}
+	if c.crawlID != crawlID {
+		_ = os.Remove(c.path(c.crawlID, "visits"))
+		_ = os.Remove(c.path(c.crawlID, "neighbors"))
+		if c.cfg.PartitionByCrawl {
+			_ = os.Remove(filepath.Dir(c.path(c.crawlID, "crawl")))
+		}
+	}
+
+	c.crawlID = crawlID
+
+	part := 1
+	for ; ; part++ {
+		if _, err := os.Stat(c.path(crawlID, partName("visits", part))); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+
+	if err = c.openFiles(part); err != nil {
+		return err
+	}
+
+	crawl.State = string(CrawlStateStarted)
+	crawl.FinishedAt = nil
+	crawl.UpdatedAt = time.Now()
+
+	if err = c.writeCrawl(crawl); err != nil {
+		return err
+	}
+
+	c.crawl = crawl
+
+	return nil
+}
+
+// CrawlID returns the identifier of the crawl, which is the common file name
+// prefix of all files of this crawl.
+func (c *ParquetClient) CrawlID() string {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	return c.crawlID
+}
+
+func (c *ParquetClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawl == nil {
+		return fmt.Errorf("crawl not initialized")
+	}
+
+	now := time.Now()
+	crawl := *c.crawl
+	crawl.UpdatedAt = now
+	crawl.CrawledPeers = ptrTo(int64(args.Crawled))
+	crawl.DialablePeers = ptrTo(int64(args.Dialable))
+	crawl.UndialablePeers = ptrTo(int64(args.Undialable))
+	crawl.RemainingPeers = ptrTo(int64(args.Remaining))
+	crawl.RetriedPeers = ptrTo(int64(args.Retried))
+	crawl.RetrySucceededPeers = ptrTo(int64(args.RetrySucceeded))
+	if args.NetworkSize != 0 {
+		crawl.NetworkSize = ptrTo(args.NetworkSize)
+		crawl.NetworkSizeLower = ptrTo(args.NetworkSizeLower)
+		crawl.NetworkSizeUpper = ptrTo(args.NetworkSizeUpper)
+	}
+	crawl.State = string(args.State)
+	finishedAt := args.finishedAt(now)
+	crawl.FinishedAt = &finishedAt
+
+	if err := c.writeCrawl(&crawl); err != nil {
+		return err
+	}
+
+	c.crawl = &crawl
+
+	return nil
+}
+
+// writeCrawl replaces the crawl metadata file with the given crawl.
+func (c *ParquetClient) writeCrawl(crawl *ParquetCrawl) error {
+	if err := writeParquetFile(c.path(crawl.CrawlID, "crawl"), c.cfg.PartitionByCrawl, []ParquetCrawl{*crawl}); err != nil {
+		return fmt.Errorf("write crawl parquet: %w", err)
+	}
+	return nil
+}
+
+func (c *ParquetClient) QueryBootstrapPeers(ctx context.Context, limit int) ([]peer.AddrInfo, error) {
+	return []peer.AddrInfo{}, nil
+}
+
+func (c *ParquetClient) InsertCrawlProperties(ctx context.Context, properties map[string]map[string]int) error {
+	crawlID := c.CrawlID()
+
+	var rows []ParquetCrawlProperty
+	for property, valuesMap := range properties {
+		for value, count := range valuesMap {
+			rows = append(rows, ParquetCrawlProperty{
+				CrawlID:  crawlID,
+				Property: property,
+				Value:    value,
+				Count:    int64(count),
+			})
+		}
+	}
+
+	if err := writeParquetFile(c.path(crawlID, "crawl_properties"), c.cfg.PartitionByCrawl, rows); err != nil {
+		return fmt.Errorf("write crawl properties parquet: %w", err)
+	}
+
+	return nil
+}
+
+func (c *ParquetClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
+	crawlID := args.CrawlID
+	if crawlID == "" {
+		crawlID = c.CrawlID()
+	}
+
+	var connectMaddr *string
+	if args.ConnectMaddr != nil {
+		connectMaddr = ptrTo(args.ConnectMaddr.String())
+	}
+
+	visit := ParquetVisit{
+		CrawlID:         crawlID,
+		PeerID:          args.PeerID.String(),
+		VisitType:       string(args.VisitType),
+		AgentVersion:    nullString(args.AgentVersion),
+		Protocols:       args.Protocols,
+		DialMaddrs:      utils.MaddrsToAddrs(args.DialMaddrs),
+		FilteredMaddrs:  utils.MaddrsToAddrs(args.FilteredMaddrs),
+		ExtraMaddrs:     utils.MaddrsToAddrs(args.ExtraMaddrs),
+		ListenMaddrs:    utils.MaddrsToAddrs(args.ListenMaddrs),
+		ConnectMaddr:    connectMaddr,
+		DialErrors:      args.DialErrors,
+		DialDuration:    durationToSeconds(args.DialDuration),
+		ConnectDuration: durationToSeconds(args.ConnectDuration),
+		CrawlDuration:   durationToSeconds(args.CrawlDuration),
+		VisitStartedAt:  args.VisitStartedAt,
+		VisitEndedAt:    args.VisitEndedAt,
+		ConnectError:    nullString(args.ConnectErrorStr),
+		CrawlError:      nullString(args.CrawlErrorStr),
+		Properties:      jsonObject(args.Properties),
+		RetryPass:       int32(args.RetryPass),
+	}
+
+	c.filesMu.Lock()
+	defer c.filesMu.Unlock()
+
+	if len(args.Neighbors) > 0 || args.ErrorBits != 0 {
+		neighbors := ParquetNeighbors{
+			CrawlID:     crawlID,
+			PeerID:      args.PeerID.String(),
+			NeighborIDs: make([]string, len(args.Neighbors)),
+			ErrorBits:   int32(args.ErrorBits),
+		}
+		for i, n := range args.Neighbors {
+			neighbors.NeighborIDs[i] = n.String()
+		}
+
+		if err := c.neighbors.Write(neighbors); err != nil {
+			return fmt.Errorf("write neighbors: %w", err)
+		}
+	}
+
+	if err := c.visits.Write(visit); err != nil {
+		return fmt.Errorf("write visit: %w", err)
+	}
+
+	return nil
+}
+
+func (c *ParquetClient) SelectPeersToProbe(ctx context.Context) ([]peer.AddrInfo, error) {
+	return []peer.AddrInfo{}, nil
+}
+
+// Flush writes all buffered visits and neighbors as new row groups to disk.
+// The files only become readable after the client was closed, though,
+// because the Parquet footer is written last.
+func (c *ParquetClient) Flush(ctx context.Context) error {
+	c.filesMu.Lock()
+	defer c.filesMu.Unlock()
+
+	if err := c.visits.Flush(); err != nil {
+		return fmt.Errorf("flush visits: %w", err)
+	}
+
+	if err := c.neighbors.Flush(); err != nil {
+		return fmt.Errorf("flush neighbors: %w", err)
+	}
+
+	return nil
+}
+
+func (c *ParquetClient) Close() error {
+	c.filesMu.Lock()
+	defer c.filesMu.Unlock()
+
+	return c.closeFiles()
+}
+
+// closeFiles writes the footers of the visits and neighbors files and closes
+// them. It must be called with the filesMu held.
+func (c *ParquetClient) closeFiles() error {
+	err1 := c.visits.Close()
+	err2 := c.neighbors.Close()
+	if err1 != nil && err2 != nil {
+		return fmt.Errorf("failed closing Parquet files: %w", fmt.Errorf("%s: %w (neighbors)", err1, err2))
+	} else if err1 != nil {
+		return fmt.Errorf("failed closing visits file: %w", err1)
+	} else if err2 != nil {
+		return fmt.Errorf("failed closing neighbors files: %w", err2)
+	}
+
+	return nil
+}
+
+// parquetFile is a Parquet file on disk that rows of type T can be written
+// to. It's not safe for concurrent use.
+type parquetFile[T any] struct {
+	file   *os.File
+	layout *parquetLayout[T]
+	writer *parquet.Writer
+}
+
+func createParquetFile[T any](path string, partitioned bool, opts ...parquet.WriterOption) (*parquetFile[T], error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := newParquetLayout[T](partitioned)
+
+	return &parquetFile[T]{
+		file:   f,
+		layout: layout,
+		writer: parquet.NewWriter(f, append([]parquet.WriterOption{layout.schema}, opts...)...),
+	}, nil
+}
+
+func (f *parquetFile[T]) Write(rows ...T) error {
+	for _, row := range rows {
+		if err := f.writer.Write(f.layout.row(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *parquetFile[T]) Flush() error {
+	return f.writer.Flush()
+}
+
+func (f *parquetFile[T]) Close() error {
+	if err := f.writer.Close(); err != nil {
+		_ = f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// writeParquetFile atomically replaces the file at the given path with a
+// Parquet file that contains the given rows.
+func writeParquetFile[T any](path string, partitioned bool, rows []T) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("make directory: %w", err)
+	}
+
+	layout := newParquetLayout[T](partitioned)
+	layoutRows := make([]any, len(rows))
+	for i, row := range rows {
+		layoutRows[i] = layout.row(row)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := parquet.WriteFile(tmpPath, layoutRows, layout.schema, parquet.Compression(&parquet.Zstd)); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// parquetLayout describes the rows of type T as they are written to disk. If
+// the files are partitioned by crawl, the crawl ID is already part of the
+// directory name. Query engines would then see the crawl_id column twice, so
+// it's left out of the files.
+type parquetLayout[T any] struct {
+	schema *parquet.Schema
+
+	// the type of the written rows and the indexes of the fields of T that
+	// it consists of. The type is nil if the rows are written as they are.
+	typ    reflect.Type
+	fields []int
+}
+
+func newParquetLayout[T any](partitioned bool) *parquetLayout[T] {
+	if !partitioned {
+		return &parquetLayout[T]{schema: parquet.SchemaOf(new(T))}
+	}
+
+	t := reflect.TypeFor[T]()
+
+	layout := &parquetLayout[T]{}
+	var fields []reflect.StructField
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if name, _, _ := strings.Cut(field.Tag.Get("parquet"), ","); name == "crawl_id" {
+			continue
+		}
+		fields = append(fields, field)
+		layout.fields = append(layout.fields, i)
+	}
+	layout.typ = reflect.StructOf(fields)
+	layout.schema = parquet.SchemaOf(reflect.New(layout.typ).Interface())
+
+	return layout
+}
+
+// row converts the given row to the row that's written to disk.
+func (l *parquetLayout[T]) row(row T) any {
+	if l.typ == nil {
+		return row
+	}
+
+	src := reflect.ValueOf(row)
+	dst := reflect.New(l.typ).Elem()
+	for i, field := range l.fields {
+		dst.Field(i).Set(src.Field(field))
+	}
+
+	return dst.Interface()
+}
+
+// ptrTo returns a pointer to a copy of the given value.
+func ptrTo[T any](v T) *T {
+	return &v
+}
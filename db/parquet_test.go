@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lp2ptest "github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parquetVisitArgs(t *testing.T, neighbors int) *VisitArgs {
+	t.Helper()
+
+	peerID, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	ma1, err := multiaddr.NewMultiaddr("/ip4/100.0.0.1/tcp/2000")
+	require.NoError(t, err)
+
+	args := &VisitArgs{
+		PeerID:         peerID,
+		DialMaddrs:     []multiaddr.Multiaddr{ma1},
+		ConnectMaddr:   ma1,
+		Protocols:      []string{"protocol-1", "protocol-2"},
+		AgentVersion:   "agent-1",
+		DialDuration:   time.Second,
+		VisitStartedAt: time.Now().Add(-time.Second),
+		VisitEndedAt:   time.Now(),
+		VisitType:      VisitTypeCrawl,
+		Properties:     json.RawMessage(`{"is_exposed":true}`),
+	}
+
+	for range neighbors {
+		n, err := lp2ptest.RandPeerID()
+		require.NoError(t, err)
+		args.Neighbors = append(args.Neighbors, n)
+	}
+
+	return args
+}
+
+func TestParquetClient(t *testing.T) {
+	ctx := context.Background()
+	out := t.TempDir()
+
+	client, err := NewParquetClient(&ParquetClientConfig{Out: out, RowGroupSize: 2})
+	require.NoError(t, err)
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+
+	args := []*VisitArgs{
+		parquetVisitArgs(t, 2),
+		parquetVisitArgs(t, 0),
+		parquetVisitArgs(t, 0),
+	}
+	args[1].AgentVersion = ""
+	args[1].ConnectErrorStr = "io_timeout"
+
+	for _, a := range args {
+		require.NoError(t, client.InsertVisit(ctx, a))
+	}
+
+	require.NoError(t, client.InsertCrawlProperties(ctx, map[string]map[string]int{
+		"agent_version": {"agent-1": 2},
+	}))
+
+	require.NoError(t, client.SealCrawl(ctx, &SealCrawlArgs{
+		Crawled:     3,
+		Dialable:    2,
+		Undialable:  1,
+		State:       CrawlStateSucceeded,
+		NetworkSize: 100,
+	}))
+	require.NoError(t, client.Flush(ctx))
+	require.NoError(t, client.Close())
+
+	prefix := filepath.Join(out, client.CrawlID())
+
+	crawls, err := parquet.ReadFile[ParquetCrawl](prefix + "_crawl.parquet")
+	require.NoError(t, err)
+	require.Len(t, crawls, 1)
+	assert.Equal(t, client.CrawlID(), crawls[0].CrawlID)
+	assert.Equal(t, string(CrawlStateSucceeded), crawls[0].State)
+	assert.EqualValues(t, 3, *crawls[0].CrawledPeers)
+	assert.Equal(t, 100.0, *crawls[0].NetworkSize)
+	assert.NotNil(t, crawls[0].FinishedAt)
+
+	visits, err := parquet.ReadFile[ParquetVisit](prefix + "_visits.parquet")
+	require.NoError(t, err)
+	require.Len(t, visits, 3)
+	assert.Equal(t, args[0].PeerID.String(), visits[0].PeerID)
+	assert.Equal(t, client.CrawlID(), visits[0].CrawlID)
+	assert.Equal(t, "agent-1", *visits[0].AgentVersion)
+	assert.Equal(t, []string{"protocol-1", "protocol-2"}, visits[0].Protocols)
+	assert.Equal(t, []string{"/ip4/100.0.0.1/tcp/2000"}, visits[0].DialMaddrs)
+	assert.Equal(t, 1.0, *visits[0].DialDuration)
+	assert.Nil(t, visits[0].ConnectDuration)
+	assert.Nil(t, visits[0].ConnectError)
+	assert.JSONEq(t, `{"is_exposed":true}`, *visits[0].Properties)
+	assert.Nil(t, visits[1].AgentVersion)
+	assert.Equal(t, "io_timeout", *visits[1].ConnectError)
+
+	f, err := os.Open(prefix + "_visits.parquet")
+	require.NoError(t, err)
+	defer f.Close()
+
+	stat, err := f.Stat()
+	require.NoError(t, err)
+
+	pf, err := parquet.OpenFile(f, stat.Size())
+	require.NoError(t, err)
+	assert.Len(t, pf.RowGroups(), 2)
+
+	neighbors, err := parquet.ReadFile[ParquetNeighbors](prefix + "_neighbors.parquet")
+	require.NoError(t, err)
+	require.Len(t, neighbors, 1)
+	assert.Equal(t, args[0].PeerID.String(), neighbors[0].PeerID)
+	assert.Equal(t, []string{args[0].Neighbors[0].String(), args[0].Neighbors[1].String()}, neighbors[0].NeighborIDs)
+
+	properties, err := parquet.ReadFile[ParquetCrawlProperty](prefix + "_crawl_properties.parquet")
+	require.NoError(t, err)
+	assert.Equal(t, []ParquetCrawlProperty{{CrawlID: client.CrawlID(), Property: "agent_version", Value: "agent-1", Count: 2}}, properties)
+}
+
+func TestParquetClient_partition_and_resume(t *testing.T) {
+	ctx := context.Background()
+	out := t.TempDir()
+	cfg := &ParquetClientConfig{Out: out, PartitionByCrawl: true}
+
+	client, err := NewParquetClient(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+	require.NoError(t, client.InsertVisit(ctx, parquetVisitArgs(t, 0)))
+	require.NoError(t, client.Close())
+
+	crawlID := client.CrawlID()
+	dir := filepath.Join(out, "crawl_id="+hivePartitionValue(crawlID))
+	assert.FileExists(t, filepath.Join(dir, "crawl.parquet"))
+	assert.FileExists(t, filepath.Join(dir, "visits.parquet"))
+	assert.FileExists(t, filepath.Join(dir, "neighbors.parquet"))
+
+	// pretend the new client was started at another time
+	client, err = NewParquetClient(cfg)
+	require.NoError(t, err)
+	client.crawlID = "other"
+
+	require.NoError(t, client.ResumeCrawl(ctx, crawlID))
+	assert.Equal(t, crawlID, client.CrawlID())
+
+	visit := parquetVisitArgs(t, 0)
+	require.NoError(t, client.InsertVisit(ctx, visit))
+	require.NoError(t, client.SealCrawl(ctx, &SealCrawlArgs{State: CrawlStateSucceeded}))
+	require.NoError(t, client.Close())
+
+	visits, err := parquet.ReadFile[ParquetVisit](filepath.Join(dir, "visits_1.parquet"))
+	require.NoError(t, err)
+	require.Len(t, visits, 1)
+	assert.Equal(t, visit.PeerID.String(), visits[0].PeerID)
+
+	// the crawl ID is only part of the partition path
+	f, err := os.Open(filepath.Join(dir, "visits_1.parquet"))
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, f.Close()) }()
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	pf, err := parquet.OpenFile(f, stat.Size())
+	require.NoError(t, err)
+	_, found := pf.Schema().Lookup("crawl_id")
+	assert.False(t, found)
+
+	crawls, err := parquet.ReadFile[ParquetCrawl](filepath.Join(dir, "crawl.parquet"))
+	require.NoError(t, err)
+	require.Len(t, crawls, 1)
+	assert.Equal(t, string(CrawlStateSucceeded), crawls[0].State)
+
+	// resuming an unknown crawl fails
+	client, err = NewParquetClient(cfg)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, client.Close()) }()
+
+	assert.Error(t, client.ResumeCrawl(ctx, "unknown"))
+}
+
+func TestHivePartitionValue(t *testing.T) {
+	assert.Equal(t, "2025-01-01T10%3A00", hivePartitionValue("2025-01-01T10:00"))
+	assert.Equal(t, "1%2C2025-01-01T10%3A00", hivePartitionValue("1,2025-01-01T10:00"))
+	assert.Equal(t, "a%2Fb%3Dc", hivePartitionValue("a/b=c"))
+	assert.Equal(t, "1234", hivePartitionValue("1234"))
+}
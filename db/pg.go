@@ -13,15 +13,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"github.com/golang-migrate/migrate/v4"
 	mpg "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
 	lru "github.com/hashicorp/golang-lru"
-	_ "github.com/lib/pq"
 	"github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 	log "github.com/sirupsen/logrus"
@@ -87,6 +86,26 @@ type PostgresClientConfig struct {
 	// Whether to persist the routing tables to disk
 	PersistNeighbors bool
 
+	// The maximum number of routing tables to hold in memory until the
+	// database IDs of all their peers are known. If the buffer is full, the
+	// oldest routing tables are inserted right away.
+	NeighborsBufferSize int
+
+	// The maximum number of visits to hold in memory before inserting them
+	// in a single batch. Zero disables batching and inserts each visit
+	// individually. Batched visits are only written when the batch is
+	// inserted and errors aren't reported to the caller of InsertVisit. See
+	// [PostgresClient.OnFailedVisits].
+	BatchSize int
+
+	// The maximum time to hold visits in memory before inserting them.
+	BatchTimeout time.Duration
+
+	// The network that the client writes data of. The Postgres schema
+	// doesn't store it, but forked clients must write data of the same
+	// network.
+	NetworkID string
+
 	// MeterProvider is the meter provider to use when initialising metric instruments.
 	MeterProvider metric.MeterProvider
 
@@ -116,6 +135,10 @@ type PostgresClient struct {
 	// Database handler
 	dbh *sql.DB
 
+	// the number of clients that share the above database handler. See
+	// [PostgresClient.Fork].
+	refs *atomic.Int32
+
 	// protocols cache
 	agentVersions *lru.Cache
 
@@ -131,12 +154,16 @@ type PostgresClient struct {
 	peerMappingsMu sync.RWMutex
 	peerMappings   map[peer.ID]int
 
-	// ... TODO
-	routingTablesMu sync.Mutex
-	routingTables   map[peer.ID]struct {
-		neighbors []peer.ID
-		errorBits uint16
-	}
+	// the peers whose discovery prefixes were already stored. The prefix of
+	// a peer never changes, so it only needs to be written once. The cache
+	// holds as many peers as the neighbors buffer holds routing tables.
+	// Writing the prefix of an evicted peer again is harmless because the
+	// database only sets missing prefixes.
+	storedPrefixes *lru.Cache
+
+	// the routing tables of crawled peers that wait for the database IDs of
+	// their neighbors. See [pgNeighbors].
+	neighbors *pgNeighbors
 
 	// the crawl entity that was created in the database
 	// we don't propagate this object through the rest of the code but instead
@@ -145,6 +172,23 @@ type PostgresClient struct {
 	crawlMu sync.Mutex
 	crawl   *pgmodels.Crawl
 
+	// the visits that wait to be inserted in the next batch if batching is
+	// enabled. See [PostgresClient.flushVisits].
+	batchMu sync.Mutex
+	batch   []*pgStagedVisit
+
+	// receives the visits of batches that couldn't be inserted. See
+	// [PostgresClient.OnFailedVisits].
+	onFailedVisits func(ctx context.Context, visits []*VisitArgs)
+
+	// serializes the insertion of batches
+	flushMu sync.Mutex
+
+	// the flusher periodically inserts the staged visits. The cancel func
+	// stops it and the channel is closed when it has exited.
+	flusherCancel context.CancelFunc
+	flusherDone   chan struct{}
+
 	// reference to all relevant db telemetry
 	telemetry *pgTelemetry
 }
@@ -183,16 +227,17 @@ func NewPostgresClient(ctx context.Context, cfg *PostgresClientConfig) (*Postgre
 		return nil, fmt.Errorf("new pgTelemetry: %w", err)
 	}
 
+	refs := &atomic.Int32{}
+	refs.Store(1)
+
 	client := &PostgresClient{
 		ctx:          ctx,
 		cfg:          cfg,
 		dbh:          dbh,
+		refs:         refs,
 		peerMappings: make(map[peer.ID]int),
-		routingTables: make(map[peer.ID]struct {
-			neighbors []peer.ID
-			errorBits uint16
-		}),
-		telemetry: telemetry,
+		neighbors:    newPGNeighbors(cfg.NeighborsBufferSize),
+		telemetry:    telemetry,
 	}
 
 	if cfg.ApplyMigrations {
@@ -216,6 +261,12 @@ func NewPostgresClient(ctx context.Context, cfg *PostgresClientConfig) (*Postgre
 		return nil, fmt.Errorf("new protocols set lru cache: %w", err)
 	}
 
+	// the neighbors buffer can be disabled, but the cache needs a size
+	client.storedPrefixes, err = lru.New(max(1, cfg.NeighborsBufferSize))
+	if err != nil {
+		return nil, fmt.Errorf("new stored prefixes lru cache: %w", err)
+	}
+
 	if err = client.fillAgentVersionsCache(ctx); err != nil {
 		return nil, fmt.Errorf("fill agent versions cache: %w", err)
 	}
@@ -238,31 +289,90 @@ func NewPostgresClient(ctx context.Context, cfg *PostgresClientConfig) (*Postgre
 		}
 	}()
 
+	client.startBatching()
+
 	return client, nil
 }
 
+// startBatching starts the flusher that periodically inserts the staged
+// visits if batching with a timeout is enabled.
+func (c *PostgresClient) startBatching() {
+	if c.cfg.BatchSize <= 0 || c.cfg.BatchTimeout <= 0 {
+		return
+	}
+
+	flusherCtx, flusherCancel := context.WithCancel(context.Background())
+	c.flusherCancel = flusherCancel
+	c.flusherDone = make(chan struct{})
+
+	go c.startFlusher(flusherCtx)
+}
+
 func (c *PostgresClient) Handle() *sql.DB {
 	return c.dbh
 }
 
+// Fork returns a new client that shares the database handler and caches with
+// this client but tracks its own crawl. The Postgres schema doesn't
+// distinguish between networks, so the forked client must write data of the
+// same network (see [SeparatesNetworks]).
+func (c *PostgresClient) Fork(networkID string) (Client, error) {
+	if networkID != c.cfg.NetworkID {
+		return nil, fmt.Errorf("postgres doesn't support multiple networks (client of %s can't write %s data)", c.cfg.NetworkID, networkID)
+	}
+
+	c.refs.Add(1)
+
+	client := &PostgresClient{
+		ctx:            c.ctx,
+		cfg:            c.cfg,
+		dbh:            c.dbh,
+		refs:           c.refs,
+		agentVersions:  c.agentVersions,
+		protocols:      c.protocols,
+		protocolsSets:  c.protocolsSets,
+		storedPrefixes: c.storedPrefixes,
+		peerMappings:   make(map[peer.ID]int),
+		neighbors:      newPGNeighbors(c.cfg.NeighborsBufferSize),
+		telemetry:      c.telemetry,
+	}
+
+	client.startBatching()
+
+	return client, nil
+}
+
 func (c *PostgresClient) Close() error {
+	if c.flusherCancel != nil {
+		c.flusherCancel()
+		<-c.flusherDone
+	}
+
+	// insert the remaining visits
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := c.flushVisits(ctx); err != nil {
+		log.WithError(err).Warnln("Failed to insert remaining visits")
+	}
+
+	// only close the database handler if no other client uses it anymore
+	if c.refs.Add(-1) > 0 {
+		return nil
+	}
+
 	return c.dbh.Close()
 }
 
 func (c *PostgresClient) applyMigrations(dbh *sql.DB) error {
-	migrationsDir, err := iofs.New(postgresMigrations, "migrations/pg")
-	if err != nil {
-		return fmt.Errorf("create iofs migrations source: %w", err)
-	}
-
 	driver, err := mpg.WithInstance(dbh, &mpg.Config{})
 	if err != nil {
 		return fmt.Errorf("create driver instance: %w", err)
 	}
 
-	m, err := migrate.NewWithInstance("iofs", migrationsDir, c.cfg.DatabaseName, driver)
+	m, err := newMigrate(postgresMigrations, "migrations/pg", c.cfg.DatabaseName, driver)
 	if err != nil {
-		return fmt.Errorf("create migrate instance: %w", err)
+		return err
 	}
 
 	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
@@ -329,7 +439,14 @@ func partitionQuery(table string, lower time.Time, upper time.Time) string {
 
 // InitCrawl inserts a crawl instance into the database in the state `started`.
 // This is done to receive a database ID that all subsequent database entities can be linked to.
-func (c *PostgresClient) InitCrawl(ctx context.Context, version string) (err error) {
+func (c *PostgresClient) InitCrawl(ctx context.Context, version string) error {
+	return c.InitCrawlAt(ctx, version, time.Now())
+}
+
+// InitCrawlAt inserts a crawl instance with the given start time. It makes
+// sure that the partitions for the visits of the crawl exist because they're
+// only created in advance for the current time.
+func (c *PostgresClient) InitCrawlAt(ctx context.Context, version string, startedAt time.Time) (err error) {
 	c.crawlMu.Lock()
 	defer c.crawlMu.Unlock()
 
@@ -343,9 +460,14 @@ func (c *PostgresClient) InitCrawl(ctx context.Context, version string) (err err
 		return fmt.Errorf("crawl already initialized")
 	}
 
+	if time.Since(startedAt) > time.Hour {
+		c.ensurePartitions(ctx, startedAt)
+		c.ensurePartitions(ctx, startedAt.Add(24*time.Hour))
+	}
+
 	c.crawl = &pgmodels.Crawl{
 		State:     pgmodels.CrawlStateStarted,
-		StartedAt: time.Now(),
+		StartedAt: startedAt,
 		Version:   version,
 	}
 
@@ -358,6 +480,51 @@ func (c *PostgresClient) InitCrawl(ctx context.Context, version string) (err err
 	return nil
 }
 
+// ResumeCrawl loads the crawl with the given ID from the database and puts it
+// back into the state `started`.
+func (c *PostgresClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawl != nil {
+		return fmt.Errorf("crawl already initialized")
+	}
+
+	id, err := strconv.Atoi(crawlID)
+	if err != nil {
+		return fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	crawl, err := pgmodels.FindCrawl(ctx, c.dbh, id)
+	if err != nil {
+		return fmt.Errorf("find crawl: %w", err)
+	}
+
+	crawl.State = pgmodels.CrawlStateStarted
+	crawl.FinishedAt = null.TimeFromPtr(nil)
+	if _, err = crawl.Update(ctx, c.dbh, boil.Infer()); err != nil {
+		return fmt.Errorf("update crawl: %w", err)
+	}
+
+	c.crawl = crawl
+
+	log.WithField("id", c.crawl.ID).Infoln("Resumed crawl")
+
+	return nil
+}
+
+// CrawlID returns the database ID of the crawl as a string.
+func (c *PostgresClient) CrawlID() string {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawl == nil {
+		return ""
+	}
+
+	return strconv.Itoa(c.crawl.ID)
+}
+
 func (c *PostgresClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) (err error) {
 	c.crawlMu.Lock()
 	defer c.crawlMu.Unlock()
@@ -382,8 +549,13 @@ func (c *PostgresClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) (er
 	c.crawl.DialablePeers = null.IntFrom(args.Dialable)
 	c.crawl.UndialablePeers = null.IntFrom(args.Undialable)
 	c.crawl.RemainingPeers = null.IntFrom(args.Remaining)
+	c.crawl.NetworkSize = null.NewFloat64(args.NetworkSize, args.NetworkSize != 0)
+	c.crawl.NetworkSizeLower = null.NewFloat64(args.NetworkSizeLower, args.NetworkSize != 0)
+	c.crawl.NetworkSizeUpper = null.NewFloat64(args.NetworkSizeUpper, args.NetworkSize != 0)
+	c.crawl.RetriedPeers = null.IntFrom(args.Retried)
+	c.crawl.RetrySucceededPeers = null.IntFrom(args.RetrySucceeded)
 	c.crawl.State = string(args.State)
-	c.crawl.FinishedAt = null.TimeFrom(now)
+	c.crawl.FinishedAt = null.TimeFrom(args.finishedAt(now))
 
 	_, err = c.crawl.Update(ctx, c.dbh, boil.Infer())
 	return err
@@ -640,46 +812,81 @@ func (c *PostgresClient) InsertVisit(ctx context.Context, args *VisitArgs) error
 	}
 	wg.Wait()
 
-	if c.cfg.PersistNeighbors && (len(args.Neighbors) > 0 || args.ErrorBits != 0) {
-		c.routingTablesMu.Lock()
-		c.routingTables[args.PeerID] = struct {
-			neighbors []peer.ID
-			errorBits uint16
-		}{
-			neighbors: args.Neighbors,
-			errorBits: args.ErrorBits,
-		}
-		c.routingTablesMu.Unlock()
-	}
-
 	var crawlID *int
-	if c.crawl != nil {
+	if args.CrawlID != "" {
+		id, err := strconv.Atoi(args.CrawlID)
+		if err != nil {
+			return fmt.Errorf("parse crawl id %q: %w", args.CrawlID, err)
+		}
+		crawlID = &id
+	} else if c.crawl != nil {
 		crawlID = &c.crawl.ID
 	} else if args.CrawlDuration > 0 {
 		log.Warnln("Crawl duration provided but no crawl initialized.")
 	}
 
+	// routing tables are stored with the crawl that the client tracks.
+	// Therefore, ignore neighbors of visits from other crawls. They are
+	// inserted as soon as the database IDs of the peer and its neighbors are
+	// known.
+	ownCrawl := args.CrawlID == "" || args.CrawlID == c.CrawlID()
+	if c.cfg.PersistNeighbors && ownCrawl && (len(args.Neighbors) > 0 || args.ErrorBits != 0) {
+		if err := c.stageNeighbors(ctx, args.PeerID, args.Neighbors, args.ErrorBits); err != nil {
+			return fmt.Errorf("persiting neighbor information: %w", err)
+		}
+	}
+
 	maddrs := slices.Concat(args.DialMaddrs, args.FilteredMaddrs, args.ExtraMaddrs)
 
+	visit := &pgStagedVisit{
+		peerID:          args.PeerID,
+		crawlID:         crawlID,
+		maddrs:          utils.MaddrsToAddrs(maddrs),
+		agentVersionID:  agentVersionID,
+		protocolsSetID:  protocolsSetID,
+		dialDuration:    durationToInterval(args.DialDuration),
+		connectDuration: durationToInterval(args.ConnectDuration),
+		crawlDuration:   durationToInterval(args.CrawlDuration),
+		visitStartedAt:  args.VisitStartedAt,
+		visitEndedAt:    args.VisitEndedAt,
+		visitType:       args.VisitType,
+		connectError:    null.NewString(args.ConnectErrorStr, args.ConnectErrorStr != ""),
+		crawlError:      null.NewString(args.CrawlErrorStr, args.CrawlErrorStr != ""),
+		properties:      jsonObject(args.Properties),
+		prefixes:        c.discoveryPrefixes(args),
+		retryPass:       args.RetryPass,
+		args:            args,
+	}
+
+	if c.cfg.BatchSize > 0 {
+		return c.stageVisit(ctx, visit)
+	}
+
+	return c.insertVisit(ctx, visit)
+}
+
+// insertVisit inserts a single visit with the insert_visit database function.
+func (c *PostgresClient) insertVisit(ctx context.Context, visit *pgStagedVisit) error {
 	start := time.Now()
-	rows, err := queries.Raw("SELECT insert_visit($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)",
-		crawlID,
-		args.PeerID.String(),
-		types.StringArray(utils.MaddrsToAddrs(maddrs)),
-		agentVersionID,
-		protocolsSetID,
-		durationToInterval(args.DialDuration),
-		durationToInterval(args.ConnectDuration),
-		durationToInterval(args.CrawlDuration),
-		args.VisitStartedAt,
-		args.VisitEndedAt,
-		args.VisitType,
-		null.NewString(args.ConnectErrorStr, args.ConnectErrorStr != ""),
-		null.NewString(args.CrawlErrorStr, args.CrawlErrorStr != ""),
-		null.JSONFrom(args.Properties),
+	rows, err := queries.Raw("SELECT insert_visit($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)",
+		visit.crawlID,
+		visit.peerID.String(),
+		visit.maddrs,
+		visit.agentVersionID,
+		visit.protocolsSetID,
+		visit.dialDuration,
+		visit.connectDuration,
+		visit.crawlDuration,
+		visit.visitStartedAt,
+		visit.visitEndedAt,
+		visit.visitType,
+		visit.connectError,
+		visit.crawlError,
+		null.JSONFrom(visit.args.Properties),
+		visit.retryPass,
 	).QueryContext(ctx, c.dbh)
 	c.telemetry.insertVisitHistogram.Record(ctx, time.Since(start).Milliseconds(), metric.WithAttributes(
-		attribute.String("type", string(args.VisitType)),
+		attribute.String("type", string(visit.visitType)),
 		attribute.Bool("success", err == nil),
 	))
 	if err != nil {
@@ -693,7 +900,7 @@ func (c *PostgresClient) InsertVisit(ctx context.Context, args *VisitArgs) error
 	}()
 
 	ivr := insertVisitResult{
-		PID: args.PeerID,
+		PID: visit.peerID,
 	}
 	if !rows.Next() {
 		return nil
@@ -704,10 +911,14 @@ func (c *PostgresClient) InsertVisit(ctx context.Context, args *VisitArgs) error
 	}
 
 	c.peerMappingsMu.Lock()
-	defer c.peerMappingsMu.Unlock()
 	c.peerMappings[ivr.PID] = *ivr.PeerID
+	c.peerMappingsMu.Unlock()
 
-	return nil
+	if err = c.resolveNeighbors(ctx, ivr.PID); err != nil {
+		return fmt.Errorf("persiting neighbor information: %w", err)
+	}
+
+	return c.upsertDiscoveryPrefixes(ctx, visit.prefixes)
 }
 
 type insertVisitResult struct {
@@ -796,6 +1007,12 @@ func (c *PostgresClient) InsertCrawlProperties(ctx context.Context, properties m
 	}
 	defer Rollback(txn)
 
+	// a resumed crawl persists the properties again, and these already
+	// include the counts of the previous runs. Replace the stored ones.
+	if _, err := pgmodels.CrawlProperties(pgmodels.CrawlPropertyWhere.CrawlID.EQ(c.crawl.ID)).DeleteAll(ctx, txn); err != nil {
+		return fmt.Errorf("delete crawl properties: %w", err)
+	}
+
 	for property, valuesMap := range properties {
 		for value, count := range valuesMap {
 
@@ -934,36 +1151,19 @@ func (c *PostgresClient) FetchUnresolvedMultiAddresses(ctx context.Context, limi
 	).All(ctx, c.dbh)
 }
 
-// Flush .
+// Flush inserts the staged visits if batching is enabled and then stores
+// the routing tables of the crawled peers that are still waiting for the
+// database IDs of their neighbors.
 func (c *PostgresClient) Flush(ctx context.Context) error {
-	c.routingTablesMu.Lock()
-	defer c.routingTablesMu.Unlock()
-	if len(c.routingTables) == 0 || !c.cfg.PersistNeighbors {
-		return nil
+	// the neighbors are stored with the database IDs of the peers, so the
+	// visits must be inserted first.
+	if err := c.flushVisits(ctx); err != nil {
+		return fmt.Errorf("insert visits batch: %w", err)
 	}
 
-	log.Infoln("Storing neighbor information...")
-
-	start := time.Now()
-	neighborsCount := 0
-	i := 0
-	for p, rt := range c.routingTables {
-		if i%100 == 0 && i > 0 {
-			log.Infof("Stored %d peers and their neighbors", i)
-		}
-		i++
-		neighborsCount += len(rt.neighbors)
-
-		if err := c.InsertNeighbors(ctx, p, rt.neighbors, rt.errorBits); err != nil {
-			return fmt.Errorf("persiting neighbor information: %w", err)
-		}
+	if err := c.flushNeighbors(ctx); err != nil {
+		return fmt.Errorf("persiting neighbor information: %w", err)
 	}
-	log.WithFields(log.Fields{
-		"duration":       time.Since(start).String(),
-		"avg":            fmt.Sprintf("%.2fms", time.Since(start).Seconds()/float64(len(c.routingTables))*1000),
-		"peers":          len(c.routingTables),
-		"totalNeighbors": neighborsCount,
-	}).Infoln("Finished storing neighbor information")
 
 	return nil
 }
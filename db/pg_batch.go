@@ -0,0 +1,303 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// pgStagedVisit is a visit that waits to be inserted in the next batch. The
+// fields correspond to the columns of the visits_staging table.
+type pgStagedVisit struct {
+	peerID          peer.ID
+	crawlID         *int
+	maddrs          types.StringArray
+	agentVersionID  *int
+	protocolsSetID  *int
+	dialDuration    *string
+	connectDuration *string
+	crawlDuration   *string
+	visitStartedAt  time.Time
+	visitEndedAt    time.Time
+	visitType       VisitType
+	connectError    null.String
+	crawlError      null.String
+	properties      *string // JSONB must be passed as text to COPY
+	retryPass       int
+
+	// the discovery prefixes of the peer and its neighbors that are stored
+	// after the batch was inserted. See [PostgresClient.discoveryPrefixes].
+	prefixes map[peer.ID]uint64
+
+	// the original visit. It's handed to the failed visits handler if the
+	// batch couldn't be inserted.
+	args *VisitArgs
+
+	// the number of batches with this visit that couldn't be inserted
+	attempts int
+}
+
+// pgBatchAttempts is the number of batches that a visit can be part of before
+// it's inserted on its own. A single visit that the database rejects fails
+// every batch that it's part of, so retrying it forever would stall all
+// other visits as well.
+const pgBatchAttempts = 5
+
+// OnFailedVisits registers the function that receives the visits of a batch
+// that couldn't be inserted. Without a handler, the visits of a failed batch
+// are kept and inserted with the next batch (see [PostgresClient.retainBatch]).
+func (c *PostgresClient) OnFailedVisits(fn func(ctx context.Context, visits []*VisitArgs)) {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	c.onFailedVisits = fn
+}
+
+// stageVisit adds the given visit to the current batch. If the batch is
+// full, it's inserted right away. The client owns a visit once it's staged.
+// If the batch can't be inserted, its visits are retried or handed to the
+// failed visits handler (see [PostgresClient.retainBatch]). Therefore, the
+// insert error isn't returned to the caller, which could only handle the
+// visit that triggered the insert but not the rest of the batch.
+func (c *PostgresClient) stageVisit(ctx context.Context, visit *pgStagedVisit) error {
+	c.batchMu.Lock()
+	c.batch = append(c.batch, visit)
+	full := len(c.batch) >= c.cfg.BatchSize
+	c.batchMu.Unlock()
+
+	if !full {
+		return nil
+	}
+
+	if err := c.flushVisits(ctx); err != nil {
+		log.WithError(err).Warnln("Failed to insert visits batch")
+	}
+
+	return nil
+}
+
+// startFlusher periodically inserts the staged visits until the given
+// context is cancelled. An insert that is in progress when the context is
+// cancelled is not aborted, so that no visits are lost on shutdown.
+func (c *PostgresClient) startFlusher(ctx context.Context) {
+	defer close(c.flusherDone)
+
+	ticker := time.NewTicker(c.cfg.BatchTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.flushVisits(context.WithoutCancel(ctx)); err != nil {
+				log.WithError(err).Warnln("Failed to insert visits batch")
+			}
+		}
+	}
+}
+
+// flushVisits copies all staged visits into the visits_staging table and
+// inserts them with the insert_visits_batch database function in a single
+// transaction. The visits of a failed batch aren't lost, see
+// [PostgresClient.retainBatch].
+func (c *PostgresClient) flushVisits(ctx context.Context) error {
+	// batches are inserted one after the other, so that the visits of the
+	// same peer are applied in the order in which they were staged.
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.batchMu.Lock()
+	batch := c.batch
+	c.batch = nil
+	c.batchMu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	peerIDs, err := c.insertVisitsBatch(ctx, batch)
+	c.telemetry.insertVisitHistogram.Record(ctx, time.Since(start).Milliseconds(), metric.WithAttributes(
+		attribute.String("type", "batch"),
+		attribute.Bool("success", err == nil),
+	))
+	if err != nil {
+		c.retainBatch(ctx, batch)
+		return fmt.Errorf("insert %d visits: %w", len(batch), err)
+	}
+
+	resolved := make([]peer.ID, 0, len(batch))
+
+	c.peerMappingsMu.Lock()
+	for _, visit := range batch {
+		if id, found := peerIDs[visit.peerID.String()]; found {
+			c.peerMappings[visit.peerID] = id
+			resolved = append(resolved, visit.peerID)
+		}
+	}
+	c.peerMappingsMu.Unlock()
+
+	if err = c.resolveNeighbors(ctx, resolved...); err != nil {
+		return fmt.Errorf("persiting neighbor information: %w", err)
+	}
+
+	// like the database, keep the first prefix if a peer occurs more than once
+	prefixes := map[peer.ID]uint64{}
+	for _, visit := range batch {
+		for peerID, prefix := range visit.prefixes {
+			if _, found := prefixes[peerID]; !found {
+				prefixes[peerID] = prefix
+			}
+		}
+	}
+
+	return c.upsertDiscoveryPrefixes(ctx, prefixes)
+}
+
+// retainBatch takes care of the visits of a batch that couldn't be inserted.
+// The whole batch is inserted in a single transaction, so none of its visits
+// were written. If a failed visits handler is registered, e.g., by the
+// [SpillClient], the visits are handed to it. Otherwise, they are put back in
+// front of the staged visits, so that the next flush retries them in their
+// original order. Visits that were part of too many failed batches are
+// inserted one by one instead, and dropped if that fails as well.
+func (c *PostgresClient) retainBatch(ctx context.Context, batch []*pgStagedVisit) {
+	c.batchMu.Lock()
+	fn := c.onFailedVisits
+	var exhausted []*pgStagedVisit
+	if fn == nil {
+		retained := make([]*pgStagedVisit, 0, len(batch))
+		for _, visit := range batch {
+			visit.attempts += 1
+			if visit.attempts >= pgBatchAttempts {
+				exhausted = append(exhausted, visit)
+			} else {
+				retained = append(retained, visit)
+			}
+		}
+		c.batch = append(retained, c.batch...)
+	}
+	c.batchMu.Unlock()
+
+	if fn == nil {
+		for _, visit := range exhausted {
+			if err := c.insertVisit(ctx, visit); err != nil {
+				log.WithError(err).WithField("pid", visit.peerID.ShortString()).Warnln("Dropping visit that couldn't be inserted")
+			}
+		}
+		return
+	}
+
+	visits := make([]*VisitArgs, len(batch))
+	for i, visit := range batch {
+		visits[i] = visit.args
+	}
+
+	fn(ctx, visits)
+}
+
+// insertVisitsBatch inserts the given visits and returns the database IDs of
+// the visited peers keyed by their multi hash.
+func (c *PostgresClient) insertVisitsBatch(ctx context.Context, batch []*pgStagedVisit) (map[string]int, error) {
+	txn, err := c.dbh.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin txn: %w", err)
+	}
+	defer Rollback(txn)
+
+	stmt, err := txn.PrepareContext(ctx, pq.CopyIn("visits_staging",
+		"seq",
+		"crawl_id",
+		"peer_multi_hash",
+		"multi_addresses",
+		"agent_version_id",
+		"protocols_set_id",
+		"dial_duration",
+		"connect_duration",
+		"crawl_duration",
+		"visit_started_at",
+		"visit_ended_at",
+		"type",
+		"connect_error",
+		"crawl_error",
+		"peer_properties",
+		"retry_pass",
+	))
+	if err != nil {
+		return nil, fmt.Errorf("prepare copy: %w", err)
+	}
+
+	for i, v := range batch {
+		_, err = stmt.ExecContext(ctx,
+			i,
+			v.crawlID,
+			v.peerID.String(),
+			v.maddrs,
+			v.agentVersionID,
+			v.protocolsSetID,
+			v.dialDuration,
+			v.connectDuration,
+			v.crawlDuration,
+			v.visitStartedAt,
+			v.visitEndedAt,
+			v.visitType,
+			v.connectError,
+			v.crawlError,
+			v.properties,
+			v.retryPass,
+		)
+		if err != nil {
+			_ = stmt.Close()
+			return nil, fmt.Errorf("copy visit: %w", err)
+		}
+	}
+
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return nil, fmt.Errorf("finish copy: %w", err)
+	}
+
+	if err = stmt.Close(); err != nil {
+		return nil, fmt.Errorf("close copy statement: %w", err)
+	}
+
+	rows, err := txn.QueryContext(ctx, "SELECT peer_multi_hash, peer_id FROM insert_visits_batch()")
+	if err != nil {
+		return nil, fmt.Errorf("insert visits batch: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	peerIDs := make(map[string]int, len(batch))
+	for rows.Next() {
+		var (
+			multiHash string
+			id        int
+		)
+		if err = rows.Scan(&multiHash, &id); err != nil {
+			return nil, fmt.Errorf("scan peer id: %w", err)
+		}
+		peerIDs[multiHash] = id
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read peer ids: %w", err)
+	}
+
+	if err = txn.Commit(); err != nil {
+		return nil, fmt.Errorf("commit txn: %w", err)
+	}
+
+	return peerIDs, nil
+}
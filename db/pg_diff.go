@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+	"github.com/volatiletech/null/v8"
+)
+
+var _ CrawlLoader = (*PostgresClient)(nil)
+
+// LoadCrawl returns the peers that were visited during the crawl with the
+// given ID. The time range of the crawl restricts the query to the
+// partitions of the visits table that can contain its visits.
+func (c *PostgresClient) LoadCrawl(ctx context.Context, crawlID string) (*CrawlSnapshot, error) {
+	id, err := strconv.Atoi(crawlID)
+	if err != nil {
+		return nil, fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	startedAt, until, err := c.crawlTimeRange(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.dbh.QueryContext(ctx, `
+		SELECT p.multi_hash,
+		       v.connect_error IS NULL,
+		       coalesce(av.agent_version, ''),
+		       coalesce((SELECT array_agg(pr.protocol) FROM protocols pr WHERE pr.id = ANY (ps.protocol_ids)), '{}'),
+		       coalesce((SELECT array_agg(ma.maddr) FROM multi_addresses ma WHERE ma.id = ANY (v.multi_address_ids)), '{}')
+		FROM visits v
+		    INNER JOIN peers p ON p.id = v.peer_id
+		    LEFT JOIN agent_versions av ON av.id = v.agent_version_id
+		    LEFT JOIN protocols_sets ps ON ps.id = v.protocols_set_id
+		WHERE v.crawl_id = $1
+		  AND v.visit_started_at BETWEEN $2 AND $3`,
+		id, startedAt, until)
+	if err != nil {
+		return nil, fmt.Errorf("query visits: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	snapshot := newCrawlSnapshot(crawlID)
+	for rows.Next() {
+		var (
+			multiHash string
+			p         CrawlSnapshotPeer
+		)
+		if err = rows.Scan(&multiHash, &p.Dialable, &p.AgentVersion, (*pq.StringArray)(&p.Protocols), (*pq.StringArray)(&p.Maddrs)); err != nil {
+			return nil, fmt.Errorf("scan visit: %w", err)
+		}
+
+		peerID, err := peer.Decode(multiHash)
+		if err != nil {
+			return nil, fmt.Errorf("decode peer id %s: %w", multiHash, err)
+		}
+
+		snapshot.add(peerID, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read visits: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// crawlTimeRange returns the time range in which the visits of the given crawl
+// started. The range ends now if the crawl is still running or was
+// interrupted.
+func (c *PostgresClient) crawlTimeRange(ctx context.Context, crawlID int) (time.Time, time.Time, error) {
+	var (
+		startedAt  time.Time
+		finishedAt null.Time
+	)
+	err := c.dbh.QueryRowContext(ctx, "SELECT started_at, finished_at FROM crawls WHERE id = $1", crawlID).Scan(&startedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, time.Time{}, fmt.Errorf("crawl %d not found", crawlID)
+	} else if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("query crawl: %w", err)
+	}
+
+	if !finishedAt.Valid {
+		return startedAt, time.Now(), nil
+	}
+
+	return startedAt, finishedAt.Time, nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/volatiletech/null/v8"
+)
+
+var _ GraphLoader = (*PostgresClient)(nil)
+
+// LoadNeighborGraph returns the directed graph of the routing tables that
+// were fetched during the crawl with the given ID. The country and ASN of a
+// peer are taken from the first of its addresses that was resolved.
+// Discovery prefixes are missing for peers that were only stored before
+// Postgres kept track of them.
+func (c *PostgresClient) LoadNeighborGraph(ctx context.Context, crawlID string) (*NeighborGraph, error) {
+	id, err := strconv.Atoi(crawlID)
+	if err != nil {
+		return nil, fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	snapshot, err := c.LoadCrawl(ctx, crawlID)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newNeighborGraph(crawlID)
+	g.addVisits(snapshot)
+
+	startedAt, until, err := c.crawlTimeRange(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.dbh.QueryContext(ctx, `
+		SELECT p.multi_hash, p.discovery_id_prefix, ma.country, ma.asn
+		FROM visits v
+		    INNER JOIN peers p ON p.id = v.peer_id
+		    LEFT JOIN LATERAL (
+		        SELECT country, asn
+		        FROM multi_addresses
+		        WHERE id = ANY (v.multi_address_ids)
+		          AND (country IS NOT NULL OR asn IS NOT NULL)
+		        ORDER BY id
+		        LIMIT 1
+		    ) ma ON TRUE
+		WHERE v.crawl_id = $1
+		  AND v.visit_started_at BETWEEN $2 AND $3`,
+		id, startedAt, until)
+	if err != nil {
+		return nil, fmt.Errorf("query peer attributes: %w", err)
+	}
+
+	peerIDs := peerIDDecoder{}
+	for rows.Next() {
+		var (
+			multiHash string
+			prefix    null.Int64
+			country   null.String
+			asn       null.Int
+		)
+		if err = rows.Scan(&multiHash, &prefix, &country, &asn); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("scan peer attributes: %w", err)
+		}
+
+		peerID, err := peerIDs.decode(multiHash)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+
+		n := g.node(peerID)
+		n.Country = country.String
+		n.ASN = asn.Int
+		n.DiscoveryPrefix = pgDiscoveryPrefix(prefix)
+	}
+	_ = rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read peer attributes: %w", err)
+	}
+
+	rows, err = c.dbh.QueryContext(ctx, `
+		SELECT p.multi_hash, np.multi_hash, np.discovery_id_prefix
+		FROM neighbors n
+		    INNER JOIN peers p ON p.id = n.peer_id
+		    CROSS JOIN unnest(n.neighbor_ids) neighbor_id
+		    INNER JOIN peers np ON np.id = neighbor_id
+		WHERE n.crawl_id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query neighbors: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			peerMultiHash     string
+			neighborMultiHash string
+			neighborPrefix    null.Int64
+		)
+		if err = rows.Scan(&peerMultiHash, &neighborMultiHash, &neighborPrefix); err != nil {
+			return nil, fmt.Errorf("scan neighbor: %w", err)
+		}
+
+		from, err := peerIDs.decode(peerMultiHash)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := peerIDs.decode(neighborMultiHash)
+		if err != nil {
+			return nil, err
+		}
+
+		g.addEdge(from, to)
+
+		if n := g.Nodes[to]; n.DiscoveryPrefix == nil {
+			n.DiscoveryPrefix = pgDiscoveryPrefix(neighborPrefix)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read neighbors: %w", err)
+	}
+
+	return g, nil
+}
+
+// pgDiscoveryPrefix converts a discovery prefix that was stored as a signed
+// integer back to its unsigned representation.
+func pgDiscoveryPrefix(prefix null.Int64) *uint64 {
+	if !prefix.Valid {
+		return nil
+	}
+	p := uint64(prefix.Int64)
+	return &p
+}
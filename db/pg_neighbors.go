@@ -0,0 +1,207 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// pgRoutingTable is the routing table of a crawled peer that waits to be
+// inserted into the neighbors table.
+type pgRoutingTable struct {
+	peerID    peer.ID
+	neighbors []peer.ID
+	errorBits uint16
+
+	// the peer IDs of the routing table whose database IDs are not known yet
+	unresolved map[peer.ID]struct{}
+
+	// the position in the list of pending routing tables
+	elem *list.Element
+}
+
+// pgNeighbors buffers the routing tables of crawled peers until the database
+// IDs of the peer and all its neighbors are known. Most neighbors are crawled
+// shortly after they were discovered, so that the majority of routing tables
+// can be inserted with the database IDs alone and don't need to be kept in
+// memory until the end of the crawl. If more than limit routing tables are
+// waiting, the oldest ones are inserted right away. The database function
+// then looks up the missing peers by their multi hashes.
+type pgNeighbors struct {
+	limit int
+
+	mu sync.Mutex
+
+	// the routing tables with unresolved peer IDs in insertion order
+	pending *list.List
+
+	// the routing tables that wait for the database ID of the given peer
+	waiting map[peer.ID]map[*pgRoutingTable]struct{}
+}
+
+func newPGNeighbors(limit int) *pgNeighbors {
+	return &pgNeighbors{
+		limit:   limit,
+		pending: list.New(),
+		waiting: map[peer.ID]map[*pgRoutingTable]struct{}{},
+	}
+}
+
+// add adds the routing table of the given peer. The resolved function reports
+// whether the database ID of a peer is known. It returns the routing tables
+// that can be inserted now. These are either the given one, if all its peer
+// IDs are resolved, or the oldest pending ones that exceed the limit.
+func (n *pgNeighbors) add(peerID peer.ID, neighbors []peer.ID, errorBits uint16, resolved func(peer.ID) bool) []*pgRoutingTable {
+	rt := &pgRoutingTable{
+		peerID:     peerID,
+		neighbors:  neighbors,
+		errorBits:  errorBits,
+		unresolved: map[peer.ID]struct{}{},
+	}
+
+	for _, p := range append([]peer.ID{peerID}, neighbors...) {
+		if !resolved(p) {
+			rt.unresolved[p] = struct{}{}
+		}
+	}
+
+	if len(rt.unresolved) == 0 {
+		return []*pgRoutingTable{rt}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	rt.elem = n.pending.PushBack(rt)
+	for p := range rt.unresolved {
+		if _, found := n.waiting[p]; !found {
+			n.waiting[p] = map[*pgRoutingTable]struct{}{}
+		}
+		n.waiting[p][rt] = struct{}{}
+	}
+
+	var evicted []*pgRoutingTable
+	for n.pending.Len() > n.limit {
+		evicted = append(evicted, n.remove(n.pending.Front().Value.(*pgRoutingTable)))
+	}
+
+	return evicted
+}
+
+// resolve marks the given peers as resolved and returns all routing tables
+// that don't have unresolved peer IDs anymore.
+func (n *pgNeighbors) resolve(peerIDs []peer.ID) []*pgRoutingTable {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var ready []*pgRoutingTable
+	for _, p := range peerIDs {
+		for rt := range n.waiting[p] {
+			delete(rt.unresolved, p)
+			if len(rt.unresolved) == 0 {
+				ready = append(ready, n.remove(rt))
+			}
+		}
+		delete(n.waiting, p)
+	}
+
+	return ready
+}
+
+// drain removes and returns all pending routing tables.
+func (n *pgNeighbors) drain() []*pgRoutingTable {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	rts := make([]*pgRoutingTable, 0, n.pending.Len())
+	for n.pending.Len() > 0 {
+		rts = append(rts, n.remove(n.pending.Front().Value.(*pgRoutingTable)))
+	}
+
+	return rts
+}
+
+// len returns the number of pending routing tables.
+func (n *pgNeighbors) len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.pending.Len()
+}
+
+// remove removes the given routing table from the pending list and the
+// waiting sets of its unresolved peers. The caller must hold the lock.
+func (n *pgNeighbors) remove(rt *pgRoutingTable) *pgRoutingTable {
+	n.pending.Remove(rt.elem)
+	for p := range rt.unresolved {
+		delete(n.waiting[p], rt)
+		if len(n.waiting[p]) == 0 {
+			delete(n.waiting, p)
+		}
+	}
+
+	return rt
+}
+
+// stageNeighbors buffers the routing table of the given peer and inserts all
+// routing tables that became ready.
+func (c *PostgresClient) stageNeighbors(ctx context.Context, peerID peer.ID, neighbors []peer.ID, errorBits uint16) error {
+	c.peerMappingsMu.RLock()
+	rts := c.neighbors.add(peerID, neighbors, errorBits, func(p peer.ID) bool {
+		_, found := c.peerMappings[p]
+		return found
+	})
+	c.peerMappingsMu.RUnlock()
+
+	return c.insertRoutingTables(ctx, rts)
+}
+
+// resolveNeighbors must be called after the database IDs of the given peers
+// were added to the peer mappings. It inserts all routing tables that became
+// ready.
+func (c *PostgresClient) resolveNeighbors(ctx context.Context, peerIDs ...peer.ID) error {
+	if !c.cfg.PersistNeighbors {
+		return nil
+	}
+
+	return c.insertRoutingTables(ctx, c.neighbors.resolve(peerIDs))
+}
+
+// insertRoutingTables inserts the given routing tables one after the other.
+func (c *PostgresClient) insertRoutingTables(ctx context.Context, rts []*pgRoutingTable) error {
+	for _, rt := range rts {
+		if err := c.InsertNeighbors(ctx, rt.peerID, rt.neighbors, rt.errorBits); err != nil {
+			return fmt.Errorf("insert neighbors of %s: %w", rt.peerID, err)
+		}
+	}
+
+	return nil
+}
+
+// flushNeighbors inserts all routing tables that still wait for the database
+// IDs of some of their peers.
+func (c *PostgresClient) flushNeighbors(ctx context.Context) error {
+	rts := c.neighbors.drain()
+	if len(rts) == 0 {
+		return nil
+	}
+
+	log.WithField("peers", len(rts)).Infoln("Storing remaining neighbor information...")
+
+	start := time.Now()
+	if err := c.insertRoutingTables(ctx, rts); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"duration": time.Since(start).String(),
+		"peers":    len(rts),
+	}).Infoln("Finished storing remaining neighbor information")
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGNeighbors(t *testing.T) {
+	p := jsonTestPeers(t)
+
+	known := map[peer.ID]bool{p("a"): true}
+	resolved := func(id peer.ID) bool { return known[id] }
+
+	neighbors := newPGNeighbors(2)
+
+	// all peers are known, so the routing table can be inserted right away
+	rts := neighbors.add(p("a"), []peer.ID{p("a")}, 0, resolved)
+	require.Len(t, rts, 1)
+	assert.Equal(t, p("a"), rts[0].peerID)
+	assert.Zero(t, neighbors.len())
+
+	// b waits for itself and c, c waits for itself
+	assert.Empty(t, neighbors.add(p("b"), []peer.ID{p("a"), p("c")}, 0, resolved))
+	assert.Empty(t, neighbors.add(p("c"), []peer.ID{p("a")}, 0, resolved))
+	assert.Equal(t, 2, neighbors.len())
+
+	rts = neighbors.resolve([]peer.ID{p("c")})
+	require.Len(t, rts, 1)
+	assert.Equal(t, p("c"), rts[0].peerID)
+
+	rts = neighbors.resolve([]peer.ID{p("b")})
+	require.Len(t, rts, 1)
+	assert.Equal(t, p("b"), rts[0].peerID)
+	assert.Zero(t, neighbors.len())
+	assert.Empty(t, neighbors.waiting)
+
+	// the oldest routing tables are evicted if the buffer is full
+	assert.Empty(t, neighbors.add(p("d"), []peer.ID{p("x")}, 0, resolved))
+	assert.Empty(t, neighbors.add(p("e"), []peer.ID{p("x")}, 0, resolved))
+	rts = neighbors.add(p("f"), []peer.ID{p("x")}, 0, resolved)
+	require.Len(t, rts, 1)
+	assert.Equal(t, p("d"), rts[0].peerID)
+	assert.NotContains(t, neighbors.waiting, p("d"))
+
+	rts = neighbors.drain()
+	require.Len(t, rts, 2)
+	assert.Equal(t, p("e"), rts[0].peerID)
+	assert.Equal(t, p("f"), rts[1].peerID)
+	assert.Zero(t, neighbors.len())
+	assert.Empty(t, neighbors.waiting)
+}
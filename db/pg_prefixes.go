@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// discoveryPrefixes returns the discovery prefixes of the visited peer and,
+// if routing tables are persisted, of its neighbors that weren't stored yet.
+// Neighbors are only considered in that case because they would otherwise
+// be added to the peers table without ever being visited. A zero prefix
+// means that it's unknown, e.g., for visits of the monitor.
+func (c *PostgresClient) discoveryPrefixes(args *VisitArgs) map[peer.ID]uint64 {
+	prefixes := map[peer.ID]uint64{}
+	add := func(peerID peer.ID, prefix uint64) {
+		if prefix == 0 {
+			return
+		}
+
+		if !c.storedPrefixes.Contains(peerID) {
+			prefixes[peerID] = prefix
+		}
+	}
+
+	add(args.PeerID, args.DiscoveryPrefix)
+
+	if c.cfg.PersistNeighbors && len(args.NeighborPrefixes) == len(args.Neighbors) {
+		for i, neighbor := range args.Neighbors {
+			add(neighbor, args.NeighborPrefixes[i])
+		}
+	}
+
+	return prefixes
+}
+
+// upsertDiscoveryPrefixes stores the given discovery prefixes for all peers
+// whose prefix isn't known yet.
+func (c *PostgresClient) upsertDiscoveryPrefixes(ctx context.Context, prefixes map[peer.ID]uint64) error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	multiHashes := make(types.StringArray, 0, len(prefixes))
+	dbPrefixes := make(types.Int64Array, 0, len(prefixes))
+	for peerID, prefix := range prefixes {
+		multiHashes = append(multiHashes, peerID.String())
+
+		// postgres does not support unsigned integers. So we interpret the uint64 as an int64
+		dbPrefixes = append(dbPrefixes, int64(prefix))
+	}
+
+	if _, err := c.dbh.ExecContext(ctx, "SELECT upsert_discovery_id_prefixes($1, $2)", multiHashes, dbPrefixes); err != nil {
+		return fmt.Errorf("upsert discovery prefixes: %w", err)
+	}
+
+	for peerID := range prefixes {
+		c.storedPrefixes.Add(peerID, struct{}{})
+	}
+
+	return nil
+}
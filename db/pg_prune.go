@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+var _ Pruner = (*PostgresClient)(nil)
+
+// Prune drops all monthly partitions of the visits, sessions_closed, and
+// peer_logs tables that only contain data older than the respective
+// retention. Rows that exceed the retention in the partition of the cutoff
+// date are deleted individually. The same applies to the neighbors table,
+// which is partitioned by crawl IDs.
+func (c *PostgresClient) Prune(ctx context.Context, cfg *PruneConfig) ([]*PruneResult, error) {
+	now := time.Now()
+
+	tables := []struct {
+		name      string
+		column    string
+		retention time.Duration
+	}{
+		{name: pgmodels.TableNames.Visits, column: pgmodels.VisitColumns.VisitStartedAt, retention: cfg.VisitsRetention},
+		{name: pgmodels.TableNames.SessionsClosed, column: pgmodels.SessionsClosedColumns.LastVisitedAt, retention: cfg.SessionsRetention},
+		{name: pgmodels.TableNames.PeerLogs, column: pgmodels.PeerLogColumns.CreatedAt, retention: cfg.PeerLogsRetention},
+	}
+
+	var results []*PruneResult
+	for _, t := range tables {
+		if t.retention == 0 {
+			continue
+		}
+
+		cutoff := now.Add(-t.retention)
+
+		partitions, err := c.partitions(ctx, t.name)
+		if err != nil {
+			return results, fmt.Errorf("list %s partitions: %w", t.name, err)
+		}
+
+		for _, partition := range partitions {
+			// partitions are named after the month they cover, e.g., visits_2024_01
+			start, err := time.Parse("2006_01", strings.TrimPrefix(partition, t.name+"_"))
+			if err != nil {
+				log.WithField("partition", partition).Warnln("Skipping partition with unexpected name")
+				continue
+			}
+
+			var result *PruneResult
+			if !start.AddDate(0, 1, 0).After(cutoff) {
+				result, err = c.dropPartition(ctx, cfg, t.name, partition)
+			} else if start.Before(cutoff) {
+				result, err = c.deleteRows(ctx, cfg, t.name, partition, t.column+" < $1", cutoff)
+			} else {
+				continue
+			}
+			if err != nil {
+				return results, fmt.Errorf("prune %s: %w", partition, err)
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	if cfg.NeighborsCrawls == 0 {
+		return results, nil
+	}
+
+	neighborResults, err := c.pruneNeighbors(ctx, cfg)
+	results = append(results, neighborResults...)
+	if err != nil {
+		return results, fmt.Errorf("prune neighbors: %w", err)
+	}
+
+	return results, nil
+}
+
+// pruneNeighbors removes the neighbors of all crawls but the configured
+// number of most recent ones.
+func (c *PostgresClient) pruneNeighbors(ctx context.Context, cfg *PruneConfig) ([]*PruneResult, error) {
+	var cutoff sql.NullInt64
+	query := "SELECT min(id) FROM (SELECT id FROM crawls ORDER BY id DESC LIMIT $1) recent_crawls"
+	if err := c.dbh.QueryRowContext(ctx, query, cfg.NeighborsCrawls).Scan(&cutoff); err != nil {
+		return nil, fmt.Errorf("query oldest crawl to keep: %w", err)
+	} else if !cutoff.Valid {
+		return nil, nil
+	}
+
+	partitions, err := c.partitions(ctx, pgmodels.TableNames.Neighbors)
+	if err != nil {
+		return nil, fmt.Errorf("list partitions: %w", err)
+	}
+
+	var results []*PruneResult
+	for _, partition := range partitions {
+		// partitions are named after the crawl IDs they cover, e.g., neighbors_0_1000
+		bounds := strings.Split(strings.TrimPrefix(partition, pgmodels.TableNames.Neighbors+"_"), "_")
+		if len(bounds) != 2 {
+			log.WithField("partition", partition).Warnln("Skipping partition with unexpected name")
+			continue
+		}
+
+		lower, lowerErr := strconv.ParseInt(bounds[0], 10, 64)
+		upper, upperErr := strconv.ParseInt(bounds[1], 10, 64)
+		if lowerErr != nil || upperErr != nil {
+			log.WithField("partition", partition).Warnln("Skipping partition with unexpected name")
+			continue
+		}
+
+		var result *PruneResult
+		if upper <= cutoff.Int64 {
+			result, err = c.dropPartition(ctx, cfg, pgmodels.TableNames.Neighbors, partition)
+		} else if lower < cutoff.Int64 {
+			result, err = c.deleteRows(ctx, cfg, pgmodels.TableNames.Neighbors, partition, pgmodels.NeighborColumns.CrawlID+" < $1", cutoff.Int64)
+		} else {
+			continue
+		}
+		if err != nil {
+			return results, fmt.Errorf("prune %s: %w", partition, err)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// partitions returns the names of all partitions of the given table.
+func (c *PostgresClient) partitions(ctx context.Context, table string) ([]string, error) {
+	rows, err := c.dbh.QueryContext(ctx, `
+		SELECT child.relname
+		FROM pg_inherits
+		    INNER JOIN pg_class parent ON pg_inherits.inhparent = parent.oid
+		    INNER JOIN pg_class child ON pg_inherits.inhrelid = child.oid
+		WHERE parent.relname = $1
+		ORDER BY child.relname`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	var partitions []string
+	for rows.Next() {
+		var partition string
+		if err = rows.Scan(&partition); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, partition)
+	}
+
+	return partitions, rows.Err()
+}
+
+// dropPartition exports the given partition if configured and then drops it.
+func (c *PostgresClient) dropPartition(ctx context.Context, cfg *PruneConfig, table string, partition string) (*PruneResult, error) {
+	result := &PruneResult{
+		Table:     table,
+		Partition: partition,
+		Rows:      -1,
+	}
+
+	logEntry := log.WithField("partition", partition)
+	if cfg.DryRun {
+		logEntry.Infoln("Would drop partition")
+		return result, nil
+	}
+
+	if cfg.ExportDir != "" {
+		if err := c.exportRows(ctx, c.dbh, cfg.ExportDir, partition, partition, result, "TRUE"); err != nil {
+			return nil, err
+		}
+	}
+
+	logEntry.Infoln("Dropping partition")
+	if _, err := c.dbh.ExecContext(ctx, "DROP TABLE "+pq.QuoteIdentifier(partition)); err != nil {
+		return nil, fmt.Errorf("drop partition: %w", err)
+	}
+
+	return result, nil
+}
+
+// deleteRows exports the rows of the given partition that match the
+// condition if configured and then deletes them. Export and deletion happen
+// in the same transaction.
+func (c *PostgresClient) deleteRows(ctx context.Context, cfg *PruneConfig, table string, partition string, cond string, args ...any) (*PruneResult, error) {
+	result := &PruneResult{
+		Table: table,
+		Rows:  -1,
+	}
+
+	logEntry := log.WithField("partition", partition)
+	if cfg.DryRun {
+		query := fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", pq.QuoteIdentifier(partition), cond)
+		if err := c.dbh.QueryRowContext(ctx, query, args...).Scan(&result.Rows); err != nil {
+			return nil, fmt.Errorf("count rows: %w", err)
+		}
+		logEntry.WithField("rows", result.Rows).Infoln("Would delete rows")
+		return result, nil
+	}
+
+	txn, err := c.dbh.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin txn: %w", err)
+	}
+	defer Rollback(txn)
+
+	if cfg.ExportDir != "" {
+		name := fmt.Sprintf("%s_%s", partition, time.Now().UTC().Format("20060102T150405"))
+		if err = c.exportRows(ctx, txn, cfg.ExportDir, name, partition, result, cond, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	logEntry.Infoln("Deleting rows")
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", pq.QuoteIdentifier(partition), cond)
+	res, err := txn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("delete rows: %w", err)
+	}
+
+	if result.Rows, err = res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("get affected rows: %w", err)
+	}
+
+	if err = txn.Commit(); err != nil {
+		return nil, fmt.Errorf("commit txn: %w", err)
+	}
+
+	return result, nil
+}
+
+// exportRows writes all rows of the given relation that match the condition
+// to an export file with the given name and records it in the result.
+func (c *PostgresClient) exportRows(ctx context.Context, exec boil.ContextExecutor, dir string, name string, relation string, result *PruneResult, cond string, args ...any) error {
+	export, err := newPruneExport(dir, name)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("file", export.path).Infoln("Exporting rows")
+
+	query := fmt.Sprintf("SELECT row_to_json(t)::TEXT FROM %s t WHERE %s", pq.QuoteIdentifier(relation), cond)
+	rows, err := exec.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.Join(fmt.Errorf("query rows: %w", err), export.Close())
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	for rows.Next() {
+		var row string
+		if err = rows.Scan(&row); err != nil {
+			return errors.Join(fmt.Errorf("scan row: %w", err), export.Close())
+		}
+
+		if err = export.Write(row); err != nil {
+			return errors.Join(fmt.Errorf("write row: %w", err), export.Close())
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return errors.Join(fmt.Errorf("read rows: %w", err), export.Close())
+	}
+
+	if err = export.Close(); err != nil {
+		return fmt.Errorf("close export file: %w", err)
+	}
+
+	result.Rows = export.rows
+	result.ExportFile = export.path
+
+	return nil
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	tnoop "go.opentelemetry.io/otel/trace/noop"
 
 	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+	"github.com/libp2p/go-libp2p/core/peer"
 	lp2ptest "github.com/libp2p/go-libp2p/core/test"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/assert"
@@ -51,7 +53,7 @@ func clearDatabase(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-func setup(t *testing.T) (context.Context, *PostgresClient, func(t *testing.T)) {
+func setup(t *testing.T, opts ...func(cfg *PostgresClientConfig)) (context.Context, *PostgresClient, func(t *testing.T)) {
 	ctx := context.Background()
 
 	c := PostgresClientConfig{
@@ -69,6 +71,10 @@ func setup(t *testing.T) (context.Context, *PostgresClient, func(t *testing.T))
 		TracerProvider:         tnoop.NewTracerProvider(),
 	}
 
+	for _, opt := range opts {
+		opt(&c)
+	}
+
 	client, err := NewPostgresClient(ctx, &c)
 	require.NoError(t, err)
 
@@ -228,6 +234,14 @@ func TestClient_PersistCrawlProperties(t *testing.T) {
 		}
 	}
 	assert.Equal(t, 6, len(cps))
+
+	// persisting the properties again (e.g., after resuming) replaces them
+	err = client.InsertCrawlProperties(ctx, props)
+	require.NoError(t, err)
+
+	count, err := pgmodels.CrawlProperties(pgmodels.CrawlPropertyWhere.CrawlID.EQ(client.crawl.ID)).Count(ctx, client.dbh)
+	require.NoError(t, err)
+	assert.EqualValues(t, 6, count)
 }
 
 func TestClient_QueryBootstrapPeers(t *testing.T) {
@@ -281,6 +295,255 @@ func TestClient_PersistCrawlVisit(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestClient_InsertVisit_batch(t *testing.T) {
+	ctx, client, teardown := setup(t, func(cfg *PostgresClientConfig) {
+		cfg.BatchSize = 10
+		cfg.BatchTimeout = time.Hour
+	})
+	defer teardown(t)
+
+	err := client.InitCrawl(ctx, "test")
+	require.NoError(t, err)
+
+	peerIDs := make([]peer.ID, 3)
+	for i := range peerIDs {
+		peerIDs[i], err = lp2ptest.RandPeerID()
+		require.NoError(t, err)
+	}
+
+	ma1, err := multiaddr.NewMultiaddr("/ip4/100.0.0.1/tcp/2000")
+	require.NoError(t, err)
+
+	visitStart := time.Now().Add(-time.Minute)
+	visit := func(peerID peer.ID, at time.Time, connectErr string) *VisitArgs {
+		return &VisitArgs{
+			PeerID:          peerID,
+			DialMaddrs:      []multiaddr.Multiaddr{ma1},
+			AgentVersion:    "agent-1",
+			Protocols:       []string{"protocol-1"},
+			VisitStartedAt:  at,
+			VisitEndedAt:    at.Add(time.Second),
+			ConnectErrorStr: connectErr,
+			VisitType:       VisitTypeCrawl,
+			Properties:      json.RawMessage(marshalProperties(t, "is_exposed", true)),
+		}
+	}
+
+	// the first peer goes offline within the same batch, the second peer
+	// stays online, and the third peer was never online.
+	require.NoError(t, client.InsertVisit(ctx, visit(peerIDs[0], visitStart, "")))
+	require.NoError(t, client.InsertVisit(ctx, visit(peerIDs[1], visitStart, "")))
+	require.NoError(t, client.InsertVisit(ctx, visit(peerIDs[2], visitStart, pgmodels.NetErrorIoTimeout)))
+	require.NoError(t, client.InsertVisit(ctx, visit(peerIDs[0], visitStart.Add(time.Minute), pgmodels.NetErrorIoTimeout)))
+
+	// nothing was written yet
+	count, err := pgmodels.Visits().Count(ctx, client.Handle())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	require.NoError(t, client.Flush(ctx))
+
+	count, err = pgmodels.Visits().Count(ctx, client.Handle())
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, count)
+
+	var staged int
+	err = client.Handle().QueryRowContext(ctx, "SELECT count(*) FROM visits_staging").Scan(&staged)
+	require.NoError(t, err)
+	assert.Zero(t, staged)
+
+	for _, peerID := range peerIDs {
+		assert.Contains(t, client.peerMappings, peerID)
+	}
+
+	dbPeer := fetchPeerByMultihash(t, ctx, client.Handle(), peerIDs[0].String())
+	assert.Equal(t, "agent-1", dbPeer.R.AgentVersion.AgentVersion)
+	assert.Len(t, dbPeer.R.MultiAddresses, 1)
+	assert.True(t, unmarshalProperties(t, dbPeer.Properties.JSON)["is_exposed"].(bool))
+	assert.Nil(t, dbPeer.R.SessionsOpen)
+
+	closed, err := pgmodels.Sessions(pgmodels.SessionWhere.PeerID.EQ(dbPeer.ID)).One(ctx, client.Handle())
+	require.NoError(t, err)
+	assert.Equal(t, pgmodels.SessionStateClosed, closed.State)
+	assert.Equal(t, 1, closed.SuccessfulVisitsCount)
+	assert.Equal(t, pgmodels.NetErrorIoTimeout, closed.FinishReason.String)
+
+	dbPeer = fetchPeerByMultihash(t, ctx, client.Handle(), peerIDs[1].String())
+	require.NotNil(t, dbPeer.R.SessionsOpen)
+	assert.Equal(t, pgmodels.SessionStateOpen, dbPeer.R.SessionsOpen.State)
+
+	dbPeer = fetchPeerByMultihash(t, ctx, client.Handle(), peerIDs[2].String())
+	assert.Nil(t, dbPeer.R.SessionsOpen)
+}
+
+func TestClient_retainBatch(t *testing.T) {
+	ctx := context.Background()
+
+	visit := func(id string) *pgStagedVisit {
+		return &pgStagedVisit{peerID: peer.ID(id), args: &VisitArgs{PeerID: peer.ID(id)}}
+	}
+
+	client := &PostgresClient{batch: []*pgStagedVisit{visit("c")}}
+
+	// without a handler, the failed batch is retried first with the next one
+	client.retainBatch(ctx, []*pgStagedVisit{visit("a"), visit("b")})
+	require.Len(t, client.batch, 3)
+	for i, id := range []string{"a", "b", "c"} {
+		assert.Equal(t, peer.ID(id), client.batch[i].peerID)
+	}
+
+	// with a handler, the failed batch is handed over
+	var failed []*VisitArgs
+	client.OnFailedVisits(func(ctx context.Context, visits []*VisitArgs) {
+		failed = append(failed, visits...)
+	})
+	client.retainBatch(ctx, []*pgStagedVisit{visit("d")})
+	assert.Len(t, client.batch, 3)
+	require.Len(t, failed, 1)
+	assert.Equal(t, peer.ID("d"), failed[0].PeerID)
+}
+
+func TestClient_retainBatch_attempts(t *testing.T) {
+	ctx := context.Background()
+
+	// the database isn't reachable, so that inserting single visits fails
+	dbh, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	require.NoError(t, err)
+	defer dbh.Close()
+
+	telemetry, err := newPGTelemetry(tnoop.NewTracerProvider(), mnoop.NewMeterProvider())
+	require.NoError(t, err)
+
+	client := &PostgresClient{dbh: dbh, telemetry: telemetry}
+
+	visit := func(id string, attempts int) *pgStagedVisit {
+		return &pgStagedVisit{peerID: peer.ID(id), args: &VisitArgs{PeerID: peer.ID(id)}, attempts: attempts}
+	}
+
+	// the visit that was part of too many failed batches is inserted on its
+	// own. This fails as well, so it's dropped.
+	client.retainBatch(ctx, []*pgStagedVisit{visit("bad", pgBatchAttempts-1), visit("new", 0)})
+	require.Len(t, client.batch, 1)
+	assert.Equal(t, peer.ID("new"), client.batch[0].peerID)
+	assert.Equal(t, 1, client.batch[0].attempts)
+}
+
+func TestClient_InsertVisit_discoveryPrefix(t *testing.T) {
+	for _, batchSize := range []int{0, 10} {
+		t.Run(fmt.Sprintf("batch_size_%d", batchSize), func(t *testing.T) {
+			ctx, client, teardown := setup(t, func(cfg *PostgresClientConfig) {
+				cfg.PersistNeighbors = true
+				cfg.BatchSize = batchSize
+				cfg.BatchTimeout = time.Hour
+			})
+			defer teardown(t)
+
+			err := client.InitCrawl(ctx, "test")
+			require.NoError(t, err)
+
+			peerIDs := make([]peer.ID, 3)
+			for i := range peerIDs {
+				peerIDs[i], err = lp2ptest.RandPeerID()
+				require.NoError(t, err)
+			}
+
+			ma1, err := multiaddr.NewMultiaddr("/ip4/100.0.0.1/tcp/2000")
+			require.NoError(t, err)
+
+			// the prefix of the first peer exceeds the range of a signed BIGINT
+			visit := func(peerID peer.ID, prefix uint64, neighbors []peer.ID, neighborPrefixes []uint64) *VisitArgs {
+				return &VisitArgs{
+					PeerID:           peerID,
+					DiscoveryPrefix:  prefix,
+					DialMaddrs:       []multiaddr.Multiaddr{ma1},
+					VisitStartedAt:   time.Now().Add(-time.Second),
+					VisitEndedAt:     time.Now(),
+					VisitType:        VisitTypeCrawl,
+					Neighbors:        neighbors,
+					NeighborPrefixes: neighborPrefixes,
+				}
+			}
+
+			require.NoError(t, client.InsertVisit(ctx, visit(peerIDs[0], 0xfedcba9876543210, peerIDs[1:], []uint64{1, 2})))
+
+			// a later visit with a different prefix doesn't overwrite it
+			require.NoError(t, client.InsertVisit(ctx, visit(peerIDs[1], 3, nil, nil)))
+			require.NoError(t, client.Flush(ctx))
+
+			want := map[peer.ID]uint64{
+				peerIDs[0]: 0xfedcba9876543210,
+				peerIDs[1]: 1,
+				peerIDs[2]: 2,
+			}
+			for peerID, prefix := range want {
+				dbPeer := fetchPeerByMultihash(t, ctx, client.Handle(), peerID.String())
+				require.True(t, dbPeer.DiscoveryIDPrefix.Valid)
+				assert.Equal(t, prefix, uint64(dbPeer.DiscoveryIDPrefix.Int64))
+			}
+		})
+	}
+}
+
+func TestClient_Prune(t *testing.T) {
+	ctx, client, teardown := setup(t)
+	defer teardown(t)
+
+	// create a partition for visits from two years ago
+	old := time.Now().AddDate(-2, 0, 0)
+	lower := time.Date(old.Year(), old.Month(), 1, 0, 0, 0, 0, time.UTC)
+	_, err := client.Handle().ExecContext(ctx, partitionQuery(pgmodels.TableNames.Visits, lower, lower.AddDate(0, 1, 0)))
+	require.NoError(t, err)
+
+	for _, visitStart := range []time.Time{lower.Add(time.Hour), time.Now().Add(-time.Minute)} {
+		peerID, err := lp2ptest.RandPeerID()
+		require.NoError(t, err)
+
+		err = client.InsertVisit(ctx, &VisitArgs{
+			PeerID:          peerID,
+			VisitStartedAt:  visitStart,
+			VisitEndedAt:    visitStart.Add(time.Second),
+			ConnectErrorStr: pgmodels.NetErrorIoTimeout,
+			VisitType:       VisitTypeCrawl,
+		})
+		require.NoError(t, err)
+	}
+
+	cfg := &PruneConfig{
+		VisitsRetention: 365 * 24 * time.Hour,
+		ExportDir:       t.TempDir(),
+		DryRun:          true,
+	}
+
+	results, err := client.Prune(ctx, cfg)
+	require.NoError(t, err)
+
+	partition := fmt.Sprintf("%s_%s", pgmodels.TableNames.Visits, lower.Format("2006_01"))
+	require.Len(t, results, 1)
+	assert.Equal(t, partition, results[0].Partition)
+	assert.Empty(t, results[0].ExportFile)
+
+	count, err := pgmodels.Visits().Count(ctx, client.Handle())
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	cfg.DryRun = false
+	results, err = client.Prune(ctx, cfg)
+	require.NoError(t, err)
+
+	require.Len(t, results, 1)
+	assert.Equal(t, partition, results[0].Partition)
+	assert.EqualValues(t, 1, results[0].Rows)
+	assert.FileExists(t, results[0].ExportFile)
+
+	count, err = pgmodels.Visits().Count(ctx, client.Handle())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	partitions, err := client.partitions(ctx, pgmodels.TableNames.Visits)
+	require.NoError(t, err)
+	assert.NotContains(t, partitions, partition)
+}
+
 func TestClient_SessionScenario_1(t *testing.T) {
 	ctx, client, teardown := setup(t)
 	defer teardown(t)
@@ -708,3 +971,10 @@ func unmarshalProperties(t testing.TB, data []byte) map[string]any {
 	require.NoError(t, err)
 	return properties
 }
+
+func TestPostgresClient_Fork_network(t *testing.T) {
+	client := &PostgresClient{cfg: &PostgresClientConfig{NetworkID: "IPFS"}}
+
+	_, err := client.Fork("FILECOIN")
+	assert.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PruneConfig holds the retention policies for the data of past crawls and
+// monitoring runs. A zero retention disables pruning of the respective data.
+type PruneConfig struct {
+	// how long to keep visits
+	VisitsRetention time.Duration
+
+	// how long to keep sessions after they were closed
+	SessionsRetention time.Duration
+
+	// how long to keep the peer logs (Postgres only)
+	PeerLogsRetention time.Duration
+
+	// keep the neighbors of this many most recent crawls
+	NeighborsCrawls int
+
+	// if set, the data is written to gzip compressed newline delimited JSON
+	// files in this directory before it is deleted
+	ExportDir string
+
+	// only report what would be deleted
+	DryRun bool
+}
+
+// Validate validates the configuration and returns an error if it's invalid.
+func (cfg *PruneConfig) Validate() error {
+	if cfg.VisitsRetention < 0 || cfg.SessionsRetention < 0 || cfg.PeerLogsRetention < 0 {
+		return fmt.Errorf("retention must not be negative")
+	}
+
+	if cfg.NeighborsCrawls < 0 {
+		return fmt.Errorf("number of crawls to keep neighbors for must not be negative")
+	}
+
+	if cfg.VisitsRetention == 0 && cfg.SessionsRetention == 0 && cfg.PeerLogsRetention == 0 && cfg.NeighborsCrawls == 0 {
+		return fmt.Errorf("no retention policy configured")
+	}
+
+	return nil
+}
+
+// Pruner is implemented by database clients that support deleting data that
+// exceeds the configured retention policies.
+type Pruner interface {
+	// Prune deletes all data that exceeds the retention policies of the given
+	// configuration. Whole partitions are dropped where possible.
+	Prune(ctx context.Context, cfg *PruneConfig) ([]*PruneResult, error)
+}
+
+// PruneResult describes data that was removed from a table.
+type PruneResult struct {
+	// the table from which the data was removed
+	Table string
+
+	// the partition that was dropped. Empty if individual rows were deleted.
+	Partition string
+
+	// the number of removed rows. -1 if unknown.
+	Rows int64
+
+	// the file to which the data was exported. Empty if it wasn't exported.
+	ExportFile string
+}
+
+// pruneExport writes the rows of a table to a gzip compressed newline
+// delimited JSON file.
+type pruneExport struct {
+	path string
+	file *os.File
+	gw   *gzip.Writer
+	rows int64
+}
+
+// newPruneExport creates the export file with the given name in the given
+// directory. It doesn't overwrite existing files.
+func newPruneExport(dir string, name string) (*pruneExport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create export directory: %w", err)
+	}
+
+	p := filepath.Join(dir, name+".ndjson.gz")
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create export file: %w", err)
+	}
+
+	return &pruneExport{
+		path: p,
+		file: f,
+		gw:   gzip.NewWriter(f),
+	}, nil
+}
+
+// Write writes a single JSON encoded row to the export file.
+func (e *pruneExport) Write(row string) error {
+	if _, err := e.gw.Write([]byte(row)); err != nil {
+		return err
+	}
+
+	if len(row) == 0 || row[len(row)-1] != '\n' {
+		if _, err := e.gw.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+
+	e.rows += 1
+
+	return nil
+}
+
+// Close flushes the compressor and closes the export file.
+func (e *pruneExport) Close() error {
+	return errors.Join(e.gw.Close(), e.file.Close())
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *PruneConfig
+		wantErr bool
+	}{
+		{name: "empty", cfg: &PruneConfig{}, wantErr: true},
+		{name: "visits", cfg: &PruneConfig{VisitsRetention: time.Hour}},
+		{name: "neighbors", cfg: &PruneConfig{NeighborsCrawls: 10}},
+		{name: "negative retention", cfg: &PruneConfig{VisitsRetention: time.Hour, SessionsRetention: -time.Hour}, wantErr: true},
+		{name: "negative crawls", cfg: &PruneConfig{NeighborsCrawls: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPruneExport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "export")
+
+	export, err := newPruneExport(dir, "visits_2024_01")
+	require.NoError(t, err)
+
+	require.NoError(t, export.Write(`{"id":1}`))
+	require.NoError(t, export.Write("{\"id\":2}\n"))
+	require.NoError(t, export.Close())
+	assert.EqualValues(t, 2, export.rows)
+
+	f, err := os.Open(filepath.Join(dir, "visits_2024_01.ndjson.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	var lines []string
+	scanner := bufio.NewScanner(gr)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`}, lines)
+
+	// existing exports are never overwritten
+	_, err = newPruneExport(dir, "visits_2024_01")
+	assert.ErrorIs(t, err, os.ErrExist)
+}
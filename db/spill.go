@@ -0,0 +1,595 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/metric"
+	mnoop "go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+const (
+	spillSegmentPrefix = "spill-"
+	spillSegmentSuffix = ".ndjson"
+)
+
+// SpillConfig configures the [SpillClient].
+type SpillConfig struct {
+	// the directory in which the segment files with the visits that couldn't
+	// be inserted are stored.
+	Dir string
+
+	// how often a failed insert is retried before the visit is spilled to
+	// disk.
+	MaxRetries int
+
+	// the initial wait time between two retries. It doubles with every retry
+	// up to MaxBackoff.
+	Backoff time.Duration
+
+	// the maximum wait time between two retries.
+	MaxBackoff time.Duration
+
+	// how often the client tries to replay spilled visits.
+	ReplayInterval time.Duration
+
+	// MeterProvider is the meter provider to use when initialising metric instruments.
+	MeterProvider metric.MeterProvider
+}
+
+// DefaultSpillConfig returns the default spill configuration. The spill
+// directory must still be set.
+func DefaultSpillConfig() *SpillConfig {
+	return &SpillConfig{
+		MaxRetries:     3,
+		Backoff:        500 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		ReplayInterval: 30 * time.Second,
+		MeterProvider:  mnoop.NewMeterProvider(),
+	}
+}
+
+// Validate verifies the spill configuration's invariants.
+func (cfg *SpillConfig) Validate() error {
+	if cfg.Dir == "" {
+		return fmt.Errorf("spill directory must not be empty")
+	}
+
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("max retries must not be negative")
+	}
+
+	if cfg.Backoff <= 0 {
+		return fmt.Errorf("backoff must not be zero or negative")
+	}
+
+	if cfg.MaxBackoff < cfg.Backoff {
+		return fmt.Errorf("max backoff must not be smaller than backoff")
+	}
+
+	if cfg.ReplayInterval <= 0 {
+		return fmt.Errorf("replay interval must not be zero or negative")
+	}
+
+	return nil
+}
+
+// SpillClient wraps another [Client] and makes sure that visits aren't lost
+// if the database is unavailable. Failed visit inserts are retried with an
+// exponential backoff. If all retries fail, the visit is appended to a
+// segment file in the spill directory and all subsequent visits are spilled
+// right away until the database has recovered. The client periodically
+// replays the spilled visits. Segment files that were left over from a
+// previous run are replayed as well. All other methods are passed through to
+// the wrapped client.
+//
+// Clients that insert visits asynchronously in batches must implement
+// [BatchInserter], so that the visits of failed batches are spilled as well.
+// Use [Spillable] to check if a client can be wrapped.
+type SpillClient struct {
+	Client
+
+	cfg       *SpillConfig
+	telemetry *spillTelemetry
+
+	// mu guards the fields below
+	mu       sync.Mutex
+	segment  *os.File
+	encoder  *json.Encoder
+	degraded bool
+
+	// replayMu makes sure that only one replay runs at a time
+	replayMu sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ Client = (*SpillClient)(nil)
+
+// NewSpillClient wraps the given client and starts replaying spilled visits
+// in the background.
+func NewSpillClient(client Client, cfg *SpillConfig) (*SpillClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("make spill directory: %w", err)
+	}
+
+	telemetry, err := newSpillTelemetry(cfg.MeterProvider)
+	if err != nil {
+		return nil, fmt.Errorf("new telemetry: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &SpillClient{
+		Client:    client,
+		cfg:       cfg,
+		telemetry: telemetry,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	if b, ok := client.(BatchInserter); ok {
+		b.OnFailedVisits(s.spillBatch)
+	}
+
+	go s.replayLoop(ctx)
+
+	return s, nil
+}
+
+// Spillable reports whether the [SpillClient] can make sure that the visits
+// of the given client aren't lost. This is the case if the client returns
+// insert errors from [Client.InsertVisit] or hands over the visits of failed
+// batches as a [BatchInserter]. The ClickHouse and Parquet clients buffer
+// visits and only notice errors when they write them, which the [SpillClient]
+// wouldn't see. A [FanOutClient] would write replayed visits to the backends
+// that had succeeded again.
+func Spillable(client Client) bool {
+	switch client.(type) {
+	case BatchInserter, *SQLiteClient, *JSONClient:
+		return true
+	default:
+		return false
+	}
+}
+
+// InsertVisit inserts the visit with the wrapped client. If that fails, the
+// visit is spilled to disk. An error is only returned if the visit could
+// neither be inserted nor spilled.
+func (s *SpillClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
+	s.mu.Lock()
+	degraded := s.degraded
+	s.mu.Unlock()
+
+	// the database has failed us before. Don't put more pressure on it and
+	// wait for the replay to succeed.
+	if degraded {
+		return s.spill(ctx, args)
+	}
+
+	err := s.insertWithRetry(ctx, args)
+	if err == nil {
+		return nil
+	}
+
+	log.WithError(err).WithField("peerID", args.PeerID.ShortString()).Warnln("Failed inserting visit, spilling it to disk")
+
+	s.mu.Lock()
+	s.degraded = true
+	s.mu.Unlock()
+
+	return s.spill(ctx, args)
+}
+
+// spillBatch spills the visits of a batch that the wrapped client couldn't
+// insert. The batch was accepted earlier, so the insert can't be retried
+// with the caller's visit. Instead, the visits are spilled right away, and
+// all subsequent visits are spilled until the database has recovered.
+func (s *SpillClient) spillBatch(ctx context.Context, visits []*VisitArgs) {
+	log.WithField("visits", len(visits)).Warnln("Failed inserting visits batch, spilling it to disk")
+
+	s.mu.Lock()
+	s.degraded = true
+	s.mu.Unlock()
+
+	for _, args := range visits {
+		if err := s.spill(ctx, args); err != nil {
+			log.WithError(err).WithField("peerID", args.PeerID.ShortString()).Warnln("Failed spilling visit")
+		}
+	}
+}
+
+// insertWithRetry inserts the visit with the wrapped client and retries it
+// with an exponential backoff.
+func (s *SpillClient) insertWithRetry(ctx context.Context, args *VisitArgs) error {
+	backoff := s.cfg.Backoff
+	for retry := 0; ; retry++ {
+		err := s.Client.InsertVisit(ctx, args)
+		if err == nil || retry >= s.cfg.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, s.cfg.MaxBackoff)
+	}
+}
+
+// spill appends the visit to the current segment file.
+func (s *SpillClient) spill(ctx context.Context, args *VisitArgs) error {
+	crawlID := args.CrawlID
+	if crawlID == "" {
+		crawlID = s.Client.CrawlID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.segment == nil {
+		name := fmt.Sprintf("%s%019d%s", spillSegmentPrefix, time.Now().UnixNano(), spillSegmentSuffix)
+		f, err := os.OpenFile(filepath.Join(s.cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open spill segment: %w", err)
+		}
+		s.segment = f
+		s.encoder = json.NewEncoder(f)
+	}
+
+	if err := s.encoder.Encode(newSpillRecord(crawlID, args)); err != nil {
+		return fmt.Errorf("encode spilled visit: %w", err)
+	}
+
+	s.telemetry.spilledCounter.Add(ctx, 1)
+
+	return nil
+}
+
+// replayLoop replays the spilled visits right away, so that segments of a
+// previous run are picked up, and then in the configured interval.
+func (s *SpillClient) replayLoop(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.ReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Replay(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warnln("Failed replaying spilled visits")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Replay inserts all spilled visits with the wrapped client. Segment files
+// are replayed from oldest to newest and deleted after all of their visits
+// were inserted. If an insert fails, the remaining visits stay on disk and
+// are replayed the next time.
+func (s *SpillClient) Replay(ctx context.Context) error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	// rotate the current segment, so that we don't replay a file that's
+	// still being written to.
+	s.mu.Lock()
+	err := s.closeSegment()
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("close spill segment: %w", err)
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return fmt.Errorf("list spill segments: %w", err)
+	}
+
+	for _, segment := range segments {
+		if err := s.replaySegment(ctx, segment); err != nil {
+			return fmt.Errorf("replay %s: %w", filepath.Base(segment), err)
+		}
+	}
+
+	// if no visit was spilled in the meantime, the database has recovered.
+	s.mu.Lock()
+	if s.degraded && s.segment == nil {
+		log.Infoln("Replayed all spilled visits")
+		s.degraded = false
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// replaySegment inserts all visits of the given segment file and deletes it
+// afterward. If an insert fails, the segment file is rewritten with the
+// visits that weren't inserted.
+func (s *SpillClient) replaySegment(ctx context.Context, segment string) error {
+	records, err := readSpillRecords(segment)
+	if err != nil {
+		return fmt.Errorf("read records: %w", err)
+	}
+
+	for i, record := range records {
+		args, err := record.visitArgs()
+		if err != nil {
+			log.WithError(err).WithField("segment", filepath.Base(segment)).Warnln("Dropping invalid spilled visit")
+			continue
+		}
+
+		if err := s.Client.InsertVisit(ctx, args); err != nil {
+			if rerr := writeSpillRecords(segment, records[i:]); rerr != nil {
+				return fmt.Errorf("rewrite segment: %w", rerr)
+			}
+			return fmt.Errorf("insert visit: %w", err)
+		}
+
+		s.telemetry.replayedCounter.Add(ctx, 1)
+	}
+
+	if err := os.Remove(segment); err != nil {
+		return fmt.Errorf("remove segment: %w", err)
+	}
+
+	return nil
+}
+
+// segments returns the paths of all segment files in the spill directory
+// from oldest to newest.
+func (s *SpillClient) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, spillSegmentPrefix) || !strings.HasSuffix(name, spillSegmentSuffix) {
+			continue
+		}
+		segments = append(segments, filepath.Join(s.cfg.Dir, name))
+	}
+
+	// the names contain zero-padded timestamps, so they sort chronologically
+	sort.Strings(segments)
+
+	return segments, nil
+}
+
+// closeSegment closes the current segment file. The next spilled visit
+// opens a new one. s.mu must be held.
+func (s *SpillClient) closeSegment() error {
+	if s.segment == nil {
+		return nil
+	}
+
+	err := s.segment.Close()
+	s.segment = nil
+	s.encoder = nil
+
+	return err
+}
+
+// Flush tries to replay all spilled visits and then flushes the wrapped
+// client. Visits that can't be replayed stay on disk and are replayed
+// during the next run.
+func (s *SpillClient) Flush(ctx context.Context) error {
+	if err := s.Replay(ctx); err != nil {
+		log.WithError(err).WithField("dir", s.cfg.Dir).Warnln("Not all spilled visits could be replayed")
+	}
+
+	return s.Client.Flush(ctx)
+}
+
+// Close stops replaying spilled visits and closes the current segment file.
+// It doesn't close the wrapped client because the [SpillClient] doesn't own
+// it.
+func (s *SpillClient) Close() error {
+	s.cancel()
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeSegment()
+}
+
+// spillRecord is the JSON-serializable representation of [VisitArgs] that
+// is stored in the segment files.
+type spillRecord struct {
+	CrawlID          string
+	PeerID           []byte
+	DiscoveryPrefix  uint64
+	AgentVersion     string
+	Protocols        []string
+	DialMaddrs       []string
+	FilteredMaddrs   []string
+	ExtraMaddrs      []string
+	ListenMaddrs     []string
+	DialErrors       []string
+	ConnectMaddr     string
+	DialDuration     time.Duration
+	ConnectDuration  time.Duration
+	CrawlDuration    time.Duration
+	VisitStartedAt   time.Time
+	VisitEndedAt     time.Time
+	ConnectErrorStr  string
+	CrawlErrorStr    string
+	VisitType        VisitType
+	Neighbors        [][]byte
+	NeighborPrefixes []uint64
+	ErrorBits        uint16
+	Properties       json.RawMessage
+	RetryPass        int
+}
+
+func newSpillRecord(crawlID string, args *VisitArgs) *spillRecord {
+	record := &spillRecord{
+		CrawlID:          crawlID,
+		PeerID:           []byte(args.PeerID),
+		DiscoveryPrefix:  args.DiscoveryPrefix,
+		AgentVersion:     args.AgentVersion,
+		Protocols:        args.Protocols,
+		DialMaddrs:       utils.MaddrsToAddrs(args.DialMaddrs),
+		FilteredMaddrs:   utils.MaddrsToAddrs(args.FilteredMaddrs),
+		ExtraMaddrs:      utils.MaddrsToAddrs(args.ExtraMaddrs),
+		ListenMaddrs:     utils.MaddrsToAddrs(args.ListenMaddrs),
+		DialErrors:       args.DialErrors,
+		DialDuration:     args.DialDuration,
+		ConnectDuration:  args.ConnectDuration,
+		CrawlDuration:    args.CrawlDuration,
+		VisitStartedAt:   args.VisitStartedAt,
+		VisitEndedAt:     args.VisitEndedAt,
+		ConnectErrorStr:  args.ConnectErrorStr,
+		CrawlErrorStr:    args.CrawlErrorStr,
+		VisitType:        args.VisitType,
+		Neighbors:        make([][]byte, len(args.Neighbors)),
+		NeighborPrefixes: args.NeighborPrefixes,
+		ErrorBits:        args.ErrorBits,
+		Properties:       args.Properties,
+		RetryPass:        args.RetryPass,
+	}
+
+	if args.ConnectMaddr != nil {
+		record.ConnectMaddr = args.ConnectMaddr.String()
+	}
+
+	for i, n := range args.Neighbors {
+		record.Neighbors[i] = []byte(n)
+	}
+
+	return record
+}
+
+func (r *spillRecord) visitArgs() (*VisitArgs, error) {
+	args := &VisitArgs{
+		CrawlID:          r.CrawlID,
+		PeerID:           peer.ID(r.PeerID),
+		DiscoveryPrefix:  r.DiscoveryPrefix,
+		AgentVersion:     r.AgentVersion,
+		Protocols:        r.Protocols,
+		DialErrors:       r.DialErrors,
+		DialDuration:     r.DialDuration,
+		ConnectDuration:  r.ConnectDuration,
+		CrawlDuration:    r.CrawlDuration,
+		VisitStartedAt:   r.VisitStartedAt,
+		VisitEndedAt:     r.VisitEndedAt,
+		ConnectErrorStr:  r.ConnectErrorStr,
+		CrawlErrorStr:    r.CrawlErrorStr,
+		VisitType:        r.VisitType,
+		Neighbors:        make([]peer.ID, len(r.Neighbors)),
+		NeighborPrefixes: r.NeighborPrefixes,
+		ErrorBits:        r.ErrorBits,
+		Properties:       r.Properties,
+		RetryPass:        r.RetryPass,
+	}
+
+	if err := args.PeerID.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid peer id: %w", err)
+	}
+
+	var err error
+	if args.DialMaddrs, err = utils.AddrsToMaddrs(r.DialMaddrs); err != nil {
+		return nil, fmt.Errorf("parse dial maddrs: %w", err)
+	}
+
+	if args.FilteredMaddrs, err = utils.AddrsToMaddrs(r.FilteredMaddrs); err != nil {
+		return nil, fmt.Errorf("parse filtered maddrs: %w", err)
+	}
+
+	if args.ExtraMaddrs, err = utils.AddrsToMaddrs(r.ExtraMaddrs); err != nil {
+		return nil, fmt.Errorf("parse extra maddrs: %w", err)
+	}
+
+	if args.ListenMaddrs, err = utils.AddrsToMaddrs(r.ListenMaddrs); err != nil {
+		return nil, fmt.Errorf("parse listen maddrs: %w", err)
+	}
+
+	if r.ConnectMaddr != "" {
+		if args.ConnectMaddr, err = ma.NewMultiaddr(r.ConnectMaddr); err != nil {
+			return nil, fmt.Errorf("parse connect maddr: %w", err)
+		}
+	}
+
+	for i, n := range r.Neighbors {
+		args.Neighbors[i] = peer.ID(n)
+	}
+
+	return args, nil
+}
+
+// readSpillRecords reads all records of the given segment file. A partially
+// written last line, e.g., because the process crashed, is ignored.
+func readSpillRecords(segment string) ([]*spillRecord, error) {
+	f, err := os.Open(segment)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*spillRecord
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record := &spillRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			log.WithError(err).WithField("segment", filepath.Base(segment)).Warnln("Skipping corrupt spilled visit")
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// writeSpillRecords atomically replaces the given segment file with one that
+// contains the given records.
+func writeSpillRecords(segment string, records []*spillRecord) error {
+	tmp := segment + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, segment)
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyClient is a [Client] whose visit inserts fail until told otherwise.
+type flakyClient struct {
+	NoopClient
+
+	mu      sync.Mutex
+	failing bool
+	crawlID string
+	visits  []*VisitArgs
+}
+
+func (c *flakyClient) CrawlID() string {
+	return c.crawlID
+}
+
+func (c *flakyClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failing {
+		return fmt.Errorf("database unavailable")
+	}
+
+	c.visits = append(c.visits, args)
+
+	return nil
+}
+
+func (c *flakyClient) setFailing(failing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failing = failing
+}
+
+func (c *flakyClient) inserted() []*VisitArgs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*VisitArgs{}, c.visits...)
+}
+
+func testSpillConfig(t *testing.T) *SpillConfig {
+	cfg := DefaultSpillConfig()
+	cfg.Dir = t.TempDir()
+	cfg.MaxRetries = 1
+	cfg.Backoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+	cfg.ReplayInterval = time.Hour // replay manually
+	return cfg
+}
+
+func testVisitArgs(t *testing.T, id string) *VisitArgs {
+	maddr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/4001")
+	require.NoError(t, err)
+
+	return &VisitArgs{
+		PeerID:           peer.ID(id),
+		AgentVersion:     "agent",
+		Protocols:        []string{"/ipfs/kad/1.0.0"},
+		DialMaddrs:       []ma.Multiaddr{maddr},
+		ConnectMaddr:     maddr,
+		VisitStartedAt:   time.Unix(1_700_000_000, 0).UTC(),
+		VisitEndedAt:     time.Unix(1_700_000_001, 0).UTC(),
+		VisitType:        VisitTypeCrawl,
+		Neighbors:        []peer.ID{peer.ID("neighbor")},
+		NeighborPrefixes: []uint64{42},
+	}
+}
+
+func TestSpillConfig_Validate(t *testing.T) {
+	cfg := DefaultSpillConfig()
+	assert.Error(t, cfg.Validate(), "missing directory")
+
+	cfg.Dir = t.TempDir()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.MaxBackoff = cfg.Backoff - 1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestSpillClient(t *testing.T) {
+	ctx := context.Background()
+
+	inner := &flakyClient{crawlID: "1", failing: true}
+	cfg := testSpillConfig(t)
+
+	client, err := NewSpillClient(inner, cfg)
+	require.NoError(t, err)
+
+	// the first visit fails after retrying and is spilled. The second one is
+	// spilled right away.
+	require.NoError(t, client.InsertVisit(ctx, testVisitArgs(t, "peer-1")))
+	require.NoError(t, client.InsertVisit(ctx, testVisitArgs(t, "peer-2")))
+	assert.Empty(t, inner.inserted())
+
+	// the database is still unavailable, the visits stay on disk
+	assert.Error(t, client.Replay(ctx))
+	segments, err := client.segments()
+	require.NoError(t, err)
+	assert.Len(t, segments, 1)
+
+	inner.setFailing(false)
+	require.NoError(t, client.Replay(ctx))
+
+	segments, err = client.segments()
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+
+	visits := inner.inserted()
+	require.Len(t, visits, 2)
+	assert.Equal(t, "1", visits[0].CrawlID)
+	assert.Equal(t, testVisitArgs(t, "peer-1").PeerID, visits[0].PeerID)
+	assert.Equal(t, testVisitArgs(t, "peer-2").PeerID, visits[1].PeerID)
+	assert.Equal(t, "/ip4/127.0.0.1/tcp/4001", visits[1].ConnectMaddr.String())
+	assert.Equal(t, []uint64{42}, visits[1].NeighborPrefixes)
+
+	// the client has recovered and inserts directly again
+	require.NoError(t, client.InsertVisit(ctx, testVisitArgs(t, "peer-3")))
+	assert.Len(t, inner.inserted(), 3)
+	assert.Empty(t, inner.inserted()[2].CrawlID)
+
+	require.NoError(t, client.Close())
+}
+
+func TestSpillClient_replay_previous_run(t *testing.T) {
+	ctx := context.Background()
+	cfg := testSpillConfig(t)
+
+	inner := &flakyClient{crawlID: "1", failing: true}
+	client, err := NewSpillClient(inner, cfg)
+	require.NoError(t, err)
+	require.NoError(t, client.InsertVisit(ctx, testVisitArgs(t, "peer-1")))
+	require.NoError(t, client.Close())
+
+	// a corrupt line from a crash must not prevent the replay
+	segments, err := client.segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	f, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"CrawlID":"1","Pee`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// the next run replays the spilled visit when it starts
+	next := &flakyClient{crawlID: "2"}
+	client, err = NewSpillClient(next, cfg)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(next.inserted()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, client.Close())
+
+	visits := next.inserted()
+	require.Len(t, visits, 1)
+	assert.Equal(t, "1", visits[0].CrawlID, "must keep the crawl of the previous run")
+}
+
+// batchClient is a [BatchInserter] that accepts all visits and reports them
+// as failed when it's flushed.
+type batchClient struct {
+	flakyClient
+
+	staged   []*VisitArgs
+	onFailed func(ctx context.Context, visits []*VisitArgs)
+}
+
+func (c *batchClient) OnFailedVisits(fn func(ctx context.Context, visits []*VisitArgs)) {
+	c.onFailed = fn
+}
+
+func (c *batchClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
+	c.staged = append(c.staged, args)
+	return nil
+}
+
+func (c *batchClient) Flush(ctx context.Context) error {
+	c.onFailed(ctx, c.staged)
+	c.staged = nil
+	return nil
+}
+
+func TestSpillClient_failed_batch(t *testing.T) {
+	ctx := context.Background()
+
+	inner := &batchClient{flakyClient: flakyClient{crawlID: "1"}}
+	client, err := NewSpillClient(inner, testSpillConfig(t))
+	require.NoError(t, err)
+	require.NotNil(t, inner.onFailed)
+
+	require.NoError(t, client.InsertVisit(ctx, testVisitArgs(t, "peer-1")))
+	require.NoError(t, client.InsertVisit(ctx, testVisitArgs(t, "peer-2")))
+	require.NoError(t, inner.Flush(ctx))
+
+	segments, err := client.segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	records, err := readSpillRecords(segments[0])
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "1", records[0].CrawlID)
+
+	// subsequent visits are spilled right away
+	require.NoError(t, client.InsertVisit(ctx, testVisitArgs(t, "peer-3")))
+	assert.Empty(t, inner.staged)
+
+	require.NoError(t, client.Close())
+}
+
+func TestSpillable(t *testing.T) {
+	assert.True(t, Spillable(&PostgresClient{}))
+	assert.True(t, Spillable(&SQLiteClient{}))
+	assert.True(t, Spillable(&JSONClient{}))
+	assert.False(t, Spillable(&ClickHouseClient{}))
+	assert.False(t, Spillable(&ParquetClient{}))
+	assert.False(t, Spillable(&FanOutClient{}))
+}
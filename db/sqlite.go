@@ -0,0 +1,754 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	msqlite "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+//go:embed migrations/sqlite
+var sqliteMigrations embed.FS
+
+// SQLiteClientConfig holds the configuration for the SQLite client.
+type SQLiteClientConfig struct {
+	// the path to the SQLite database file. The file is created if it
+	// doesn't exist.
+	DatabasePath string
+
+	// Whether to apply migrations on startup
+	ApplyMigrations bool
+
+	// The network identifier that we are collecting data for
+	NetworkID string
+
+	// Whether to persist the routing tables to disk
+	PersistNeighbors bool
+}
+
+// DatabaseSourceName returns the data source name string to be put into the
+// sql.Open method. Writers wait for each other instead of failing, and the
+// write-ahead log allows reading while a crawl is running.
+func (cfg *SQLiteClientConfig) DatabaseSourceName() string {
+	params := url.Values{}
+	params.Set("_busy_timeout", "10000")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_foreign_keys", "on")
+	params.Set("_txlock", "immediate")
+	return "file:" + cfg.DatabasePath + "?" + params.Encode()
+}
+
+// SQLiteClient is a client for a local SQLite database file. It implements
+// the database [Client] interface and doesn't require a database server,
+// which makes it useful for crawls on a laptop. Like the Postgres client, it
+// tracks the sessions of all peers, so that it can back `nebula monitor`.
+type SQLiteClient struct {
+	// the client configuration object
+	cfg *SQLiteClientConfig
+
+	// Database handler
+	dbh *sql.DB
+
+	// the number of clients that share the above database handler. See
+	// [SQLiteClient.Fork].
+	refs *atomic.Int32
+
+	// database client implementations must track the crawl object internally.
+	// For more details see the [Client] documentation.
+	crawlMu sync.Mutex
+	crawlID int64
+}
+
+// NewSQLiteClient opens the SQLite database file at the configured path and
+// applies the migrations if configured.
+func NewSQLiteClient(ctx context.Context, cfg *SQLiteClientConfig) (*SQLiteClient, error) {
+	log.WithField("path", cfg.DatabasePath).Infoln("Initializing database client")
+
+	if cfg.DatabasePath == "" {
+		return nil, fmt.Errorf("no database path given")
+	}
+
+	dbh, err := sql.Open("sqlite3", cfg.DatabaseSourceName())
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	// SQLite only supports a single writer at a time. Serializing all
+	// queries on a single connection avoids lock contention between the
+	// writers.
+	dbh.SetMaxOpenConns(1)
+
+	if err = dbh.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("pinging database: %w", err)
+	}
+
+	refs := &atomic.Int32{}
+	refs.Store(1)
+
+	client := &SQLiteClient{
+		cfg:  cfg,
+		dbh:  dbh,
+		refs: refs,
+	}
+
+	if cfg.ApplyMigrations {
+		if err = client.applyMigrations(dbh); err != nil {
+			return nil, fmt.Errorf("apply migrations: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+func (c *SQLiteClient) applyMigrations(dbh *sql.DB) error {
+	driver, err := msqlite.WithInstance(dbh, &msqlite.Config{})
+	if err != nil {
+		return fmt.Errorf("create driver instance: %w", err)
+	}
+
+	m, err := newMigrate(sqliteMigrations, "migrations/sqlite", "sqlite3", driver)
+	if err != nil {
+		return err
+	}
+
+	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migrate database: %w", err)
+	}
+
+	return nil
+}
+
+// Handle returns the underlying database handler.
+func (c *SQLiteClient) Handle() *sql.DB {
+	return c.dbh
+}
+
+// Fork returns a new client that shares the database handler with this
+// client but tracks its own crawl of the network with the given identifier.
+func (c *SQLiteClient) Fork(networkID string) (Client, error) {
+	c.refs.Add(1)
+
+	cfg := *c.cfg
+	cfg.NetworkID = networkID
+
+	return &SQLiteClient{
+		cfg:  &cfg,
+		dbh:  c.dbh,
+		refs: c.refs,
+	}, nil
+}
+
+func (c *SQLiteClient) Close() error {
+	// only close the database handler if no other client uses it anymore
+	if c.refs.Add(-1) > 0 {
+		return nil
+	}
+
+	return c.dbh.Close()
+}
+
+// InitCrawl inserts a crawl instance into the database in the state `started`.
+func (c *SQLiteClient) InitCrawl(ctx context.Context, version string) error {
+	return c.InitCrawlAt(ctx, version, time.Now())
+}
+
+// InitCrawlAt inserts a crawl instance with the given start time.
+func (c *SQLiteClient) InitCrawlAt(ctx context.Context, version string, startedAt time.Time) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawlID != 0 {
+		return fmt.Errorf("crawl already initialized")
+	}
+
+	now := time.Now().UTC()
+	res, err := c.dbh.ExecContext(ctx,
+		"INSERT INTO crawls (network_id, state, started_at, version, updated_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		c.cfg.NetworkID, string(CrawlStateStarted), startedAt.UTC(), version, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("insert crawl: %w", err)
+	}
+
+	c.crawlID, err = res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get crawl id: %w", err)
+	}
+
+	log.WithField("id", c.crawlID).Infoln("Initialized crawl")
+
+	return nil
+}
+
+// ResumeCrawl puts the crawl with the given ID back into the state `started`.
+func (c *SQLiteClient) ResumeCrawl(ctx context.Context, crawlID string) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawlID != 0 {
+		return fmt.Errorf("crawl already initialized")
+	}
+
+	id, err := strconv.ParseInt(crawlID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	res, err := c.dbh.ExecContext(ctx,
+		"UPDATE crawls SET state = ?, finished_at = NULL, updated_at = ? WHERE id = ?",
+		string(CrawlStateStarted), time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("update crawl: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("get affected rows: %w", err)
+	} else if n == 0 {
+		return fmt.Errorf("crawl %d not found", id)
+	}
+
+	c.crawlID = id
+
+	log.WithField("id", c.crawlID).Infoln("Resumed crawl")
+
+	return nil
+}
+
+// CrawlID returns the database ID of the crawl as a string.
+func (c *SQLiteClient) CrawlID() string {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawlID == 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(c.crawlID, 10)
+}
+
+// SealCrawl marks the crawl as done.
+func (c *SQLiteClient) SealCrawl(ctx context.Context, args *SealCrawlArgs) error {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+
+	if c.crawlID == 0 {
+		return fmt.Errorf("crawl not initialized")
+	}
+
+	var networkSize, networkSizeLower, networkSizeUpper sql.NullFloat64
+	if args.NetworkSize != 0 {
+		networkSize = sql.NullFloat64{Float64: args.NetworkSize, Valid: true}
+		networkSizeLower = sql.NullFloat64{Float64: args.NetworkSizeLower, Valid: true}
+		networkSizeUpper = sql.NullFloat64{Float64: args.NetworkSizeUpper, Valid: true}
+	}
+
+	now := time.Now().UTC()
+	_, err := c.dbh.ExecContext(ctx, `
+		UPDATE crawls
+		SET state = ?, finished_at = ?, updated_at = ?,
+		    crawled_peers = ?, dialable_peers = ?, undialable_peers = ?, remaining_peers = ?,
+		    network_size = ?, network_size_lower = ?, network_size_upper = ?,
+		    retried_peers = ?, retry_succeeded_peers = ?
+		WHERE id = ?`,
+		string(args.State), args.finishedAt(now).UTC(), now,
+		args.Crawled, args.Dialable, args.Undialable, args.Remaining,
+		networkSize, networkSizeLower, networkSizeUpper,
+		args.Retried, args.RetrySucceeded,
+		c.crawlID,
+	)
+	if err != nil {
+		return fmt.Errorf("update crawl: %w", err)
+	}
+
+	return nil
+}
+
+// InsertVisit inserts the visit, updates the peer and its session, and
+// stores the peer's routing table in a single transaction.
+func (c *SQLiteClient) InsertVisit(ctx context.Context, args *VisitArgs) error {
+	var crawlID *int64
+	if args.CrawlID != "" {
+		id, err := strconv.ParseInt(args.CrawlID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse crawl id %q: %w", args.CrawlID, err)
+		}
+		crawlID = &id
+	} else if id := c.currentCrawlID(); id != 0 {
+		crawlID = &id
+	}
+
+	txn, err := c.dbh.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer Rollback(txn)
+
+	maddrs := slices.Concat(args.DialMaddrs, args.FilteredMaddrs, args.ExtraMaddrs)
+
+	peerID, err := c.upsertPeer(ctx, txn, args.PeerID, args.AgentVersion, args.Protocols, maddrs, args.Properties, args.VisitEndedAt)
+	if err != nil {
+		return fmt.Errorf("upsert peer: %w", err)
+	}
+
+	sessionID, err := c.upsertSession(ctx, txn, peerID, args.VisitStartedAt.UTC(), args.VisitEndedAt.UTC(), args.ConnectErrorStr)
+	if err != nil {
+		return fmt.Errorf("upsert session: %w", err)
+	}
+
+	var connectMaddr *string
+	if args.ConnectMaddr != nil {
+		s := args.ConnectMaddr.String()
+		connectMaddr = &s
+	}
+
+	_, err = txn.ExecContext(ctx, `
+		INSERT INTO visits (peer_id, crawl_id, session_id, type, agent_version, protocols,
+		                    dial_maddrs, filtered_maddrs, extra_maddrs, listen_maddrs, connect_maddr, dial_errors,
+		                    dial_duration, connect_duration, crawl_duration, visit_started_at, visit_ended_at,
+		                    connect_error, crawl_error, peer_properties, retry_pass, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		peerID,
+		crawlID,
+		sessionID,
+		string(args.VisitType),
+		nullString(args.AgentVersion),
+		jsonArray(args.Protocols),
+		jsonArray(utils.MaddrsToAddrs(args.DialMaddrs)),
+		jsonArray(utils.MaddrsToAddrs(args.FilteredMaddrs)),
+		jsonArray(utils.MaddrsToAddrs(args.ExtraMaddrs)),
+		jsonArray(utils.MaddrsToAddrs(args.ListenMaddrs)),
+		connectMaddr,
+		jsonArray(args.DialErrors),
+		durationToSeconds(args.DialDuration),
+		durationToSeconds(args.ConnectDuration),
+		durationToSeconds(args.CrawlDuration),
+		args.VisitStartedAt.UTC(),
+		args.VisitEndedAt.UTC(),
+		nullString(args.ConnectErrorStr),
+		nullString(args.CrawlErrorStr),
+		jsonObject(args.Properties),
+		args.RetryPass,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("insert visit: %w", err)
+	}
+
+	// routing tables are only persisted for the crawl that the client
+	// tracks, see [VisitArgs.CrawlID].
+	ownCrawl := crawlID != nil && *crawlID == c.currentCrawlID()
+	if c.cfg.PersistNeighbors && ownCrawl && (len(args.Neighbors) > 0 || args.ErrorBits != 0) {
+		if err := c.insertNeighbors(ctx, txn, *crawlID, peerID, args.Neighbors, args.ErrorBits, args.VisitEndedAt); err != nil {
+			return fmt.Errorf("insert neighbors: %w", err)
+		}
+	}
+
+	return txn.Commit()
+}
+
+func (c *SQLiteClient) currentCrawlID() int64 {
+	c.crawlMu.Lock()
+	defer c.crawlMu.Unlock()
+	return c.crawlID
+}
+
+// upsertPeer inserts the peer if it doesn't exist yet and otherwise updates
+// all non-empty fields. It returns the database ID of the peer.
+func (c *SQLiteClient) upsertPeer(ctx context.Context, txn *sql.Tx, pid peer.ID, agentVersion string, protocols []string, maddrs []ma.Multiaddr, properties json.RawMessage, updatedAt time.Time) (int64, error) {
+	var addrs any
+	if len(maddrs) > 0 {
+		addrs = jsonArray(utils.MaddrsToAddrs(maddrs))
+	}
+
+	var id int64
+	err := txn.QueryRowContext(ctx, `
+		INSERT INTO peers (multi_hash, agent_version, protocols, multi_addresses, properties, updated_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (multi_hash) DO UPDATE
+		SET agent_version   = coalesce(excluded.agent_version, agent_version),
+		    protocols       = coalesce(excluded.protocols, protocols),
+		    multi_addresses = coalesce(excluded.multi_addresses, multi_addresses),
+		    properties      = coalesce(excluded.properties, properties),
+		    updated_at      = excluded.updated_at
+		RETURNING id`,
+		pid.String(),
+		nullString(agentVersion),
+		jsonArray(protocols),
+		addrs,
+		jsonObject(properties),
+		updatedAt.UTC(),
+		updatedAt.UTC(),
+	).Scan(&id)
+
+	return id, err
+}
+
+// sqliteSession is an open or pending session of a peer.
+type sqliteSession struct {
+	ID                int64
+	State             string
+	FirstSuccessful   time.Time
+	LastSuccessful    time.Time
+	FirstFailed       sql.NullTime
+	FailedVisitsCount int
+	FinishReason      sql.NullString
+	SuccessfulVisits  int
+	RecoveredCount    int
+}
+
+// upsertSession updates the session of the given peer based on the result
+// of a visit. It follows the same rules as the `upsert_session` function in
+// the Postgres schema: A successful visit opens a new session or keeps an
+// existing one open. A failed visit of an open session puts it into the
+// pending state if the peer was online long enough. Otherwise, or if the
+// peer keeps failing, the session is closed. It returns the ID of the
+// affected session or nil if the peer doesn't have a session.
+func (c *SQLiteClient) upsertSession(ctx context.Context, txn *sql.Tx, peerID int64, visitStartedAt time.Time, visitEndedAt time.Time, connectErr string) (*int64, error) {
+	s := &sqliteSession{}
+	err := txn.QueryRowContext(ctx, `
+		SELECT id, state, first_successful_visit, last_successful_visit, first_failed_visit, failed_visits_count,
+		       finish_reason, successful_visits_count, recovered_count
+		FROM sessions
+		WHERE peer_id = ? AND state != 'closed'`,
+		peerID,
+	).Scan(&s.ID, &s.State, &s.FirstSuccessful, &s.LastSuccessful, &s.FirstFailed, &s.FailedVisitsCount,
+		&s.FinishReason, &s.SuccessfulVisits, &s.RecoveredCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		s = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("select session: %w", err)
+	}
+
+	now := time.Now().UTC()
+
+	switch {
+	case s == nil && connectErr == "":
+		// the peer is online and didn't have a session -> open a new one
+		res, err := txn.ExecContext(ctx, `
+			INSERT INTO sessions (peer_id, state, first_successful_visit, last_successful_visit, next_visit_due_at,
+			                      last_visited_at, successful_visits_count, recovered_count, failed_visits_count,
+			                      updated_at, created_at)
+			VALUES (?, 'open', ?, ?, ?, ?, 1, 0, 0, ?, ?)`,
+			peerID, visitStartedAt, visitEndedAt, nextVisit(visitEndedAt, time.Time{}), visitEndedAt, now, now,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("insert session: %w", err)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("get session id: %w", err)
+		}
+
+		return &id, nil
+
+	case s == nil:
+		// the peer is offline and didn't have a session -> nothing to do
+		return nil, nil
+
+	case connectErr == "":
+		// the peer is still or again online
+		recovered := s.RecoveredCount
+		if s.State == "pending" {
+			recovered += 1
+		}
+
+		_, err = txn.ExecContext(ctx, `
+			UPDATE sessions
+			SET state = 'open', last_successful_visit = ?, last_visited_at = ?, successful_visits_count = ?,
+			    first_failed_visit = NULL, last_failed_visit = NULL, failed_visits_count = 0, finish_reason = NULL,
+			    recovered_count = ?, next_visit_due_at = ?, updated_at = ?
+			WHERE id = ?`,
+			visitEndedAt, visitEndedAt, s.SuccessfulVisits+1,
+			recovered, nextVisit(visitEndedAt, s.LastSuccessful), now,
+			s.ID,
+		)
+
+	case s.State == "open" && maxFailedVisits(s.FirstSuccessful, s.LastSuccessful, connectErr) > 0:
+		// the peer went offline for the first time -> give it a few chances
+		maxVisits := maxFailedVisits(s.FirstSuccessful, s.LastSuccessful, connectErr)
+		_, err = txn.ExecContext(ctx, `
+			UPDATE sessions
+			SET state = 'pending', first_failed_visit = ?, last_failed_visit = ?, last_visited_at = ?,
+			    failed_visits_count = ?, finish_reason = ?, next_visit_due_at = ?, updated_at = ?
+			WHERE id = ?`,
+			visitStartedAt, visitEndedAt, visitEndedAt,
+			s.FailedVisitsCount+1, connectErr, visitEndedAt.Add(time.Duration(maxVisits)*time.Minute), now,
+			s.ID,
+		)
+
+	case s.State == "pending" && s.FailedVisitsCount < maxFailedVisits(s.FirstSuccessful, s.LastSuccessful, connectErr):
+		// the peer is still offline but has chances left
+		_, err = txn.ExecContext(ctx, `
+			UPDATE sessions
+			SET last_failed_visit = ?, last_visited_at = ?, failed_visits_count = ?, next_visit_due_at = ?, updated_at = ?
+			WHERE id = ?`,
+			visitEndedAt, visitEndedAt, s.FailedVisitsCount+1, nextVisit(visitEndedAt, s.LastSuccessful), now,
+			s.ID,
+		)
+
+	default:
+		// the peer is gone -> close the session
+		firstFailed := visitStartedAt
+		if s.FirstFailed.Valid {
+			firstFailed = s.FirstFailed.Time
+		}
+
+		finishReason := connectErr
+		if s.FinishReason.Valid {
+			finishReason = s.FinishReason.String
+		}
+
+		_, err = txn.ExecContext(ctx, `
+			UPDATE sessions
+			SET state = 'closed', first_failed_visit = ?, last_failed_visit = ?, last_visited_at = ?,
+			    failed_visits_count = ?, finish_reason = ?, next_visit_due_at = NULL, updated_at = ?
+			WHERE id = ?`,
+			firstFailed, visitEndedAt, visitEndedAt,
+			s.FailedVisitsCount+1, finishReason, now,
+			s.ID,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update session: %w", err)
+	}
+
+	return &s.ID, nil
+}
+
+// nextVisit returns when a peer should be visited again. The more stable a
+// peer is, the longer we wait, but at least one and at most 15 minutes.
+func nextVisit(visitedAt time.Time, lastSuccessful time.Time) time.Time {
+	interval := time.Minute
+	if !lastSuccessful.IsZero() {
+		interval = time.Duration(1.2 * float64(visitedAt.Sub(lastSuccessful)))
+	}
+	return visitedAt.Add(min(15*time.Minute, max(time.Minute, interval)))
+}
+
+// maxFailedVisits returns how often a peer may fail to be visited before we
+// close its session. Peers that were online for a long time get more
+// chances. Errors that indicate that the peer is gone for good don't get any.
+func maxFailedVisits(firstSuccessful time.Time, lastSuccessful time.Time, connectErr string) int {
+	switch connectErr {
+	case pgmodels.NetErrorNoGoodAddresses, pgmodels.NetErrorNoIPAddress, pgmodels.NetErrorNoRouteToHost, pgmodels.NetErrorPeerIDMismatch:
+		return 0
+	}
+
+	switch uptime := lastSuccessful.Sub(firstSuccessful); {
+	case uptime < time.Hour:
+		return 0
+	case uptime < 6*time.Hour:
+		return 1
+	case uptime < 24*time.Hour:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// insertNeighbors stores the routing table of the given peer. Neighbors that
+// aren't in the database yet are inserted without any further information.
+func (c *SQLiteClient) insertNeighbors(ctx context.Context, txn *sql.Tx, crawlID int64, peerID int64, neighbors []peer.ID, errorBits uint16, createdAt time.Time) error {
+	neighborIDs := make([]int64, len(neighbors))
+	for i, n := range neighbors {
+		err := txn.QueryRowContext(ctx, `
+			INSERT INTO peers (multi_hash, updated_at, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (multi_hash) DO UPDATE SET multi_hash = excluded.multi_hash
+			RETURNING id`,
+			n.String(), createdAt.UTC(), createdAt.UTC(),
+		).Scan(&neighborIDs[i])
+		if err != nil {
+			return fmt.Errorf("upsert neighbor: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(neighborIDs)
+	if err != nil {
+		return fmt.Errorf("marshal neighbor ids: %w", err)
+	}
+
+	_, err = txn.ExecContext(ctx,
+		"INSERT OR REPLACE INTO neighbors (crawl_id, peer_id, neighbor_ids, error_bits) VALUES (?, ?, ?, ?)",
+		crawlID, peerID, string(data), errorBits,
+	)
+
+	return err
+}
+
+func (c *SQLiteClient) InsertCrawlProperties(ctx context.Context, properties map[string]map[string]int) error {
+	crawlID := c.currentCrawlID()
+	if crawlID == 0 {
+		return fmt.Errorf("crawl not initialized")
+	}
+
+	txn, err := c.dbh.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer Rollback(txn)
+
+	// a resumed crawl persists the properties again, and these already
+	// include the counts of the previous runs. Replace the stored ones.
+	if _, err := txn.ExecContext(ctx, "DELETE FROM crawl_properties WHERE crawl_id = ?", crawlID); err != nil {
+		return fmt.Errorf("delete crawl properties: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for property, valuesMap := range properties {
+		for value, count := range valuesMap {
+			if value == "" {
+				continue
+			}
+
+			_, err := txn.ExecContext(ctx,
+				"INSERT INTO crawl_properties (crawl_id, property, value, count, created_at) VALUES (?, ?, ?, ?, ?)",
+				crawlID, property, value, count, now,
+			)
+			if err != nil {
+				return fmt.Errorf("insert crawl property: %w", err)
+			}
+		}
+	}
+
+	return txn.Commit()
+}
+
+// QueryBootstrapPeers returns the peers with open sessions that were visited
+// the longest time ago.
+func (c *SQLiteClient) QueryBootstrapPeers(ctx context.Context, limit int) ([]peer.AddrInfo, error) {
+	return c.queryAddrInfos(ctx, `
+		SELECT p.multi_hash, p.multi_addresses
+		FROM peers p INNER JOIN sessions s ON s.peer_id = p.id
+		WHERE s.state = 'open'
+		ORDER BY s.last_visited_at
+		LIMIT ?`,
+		limit,
+	)
+}
+
+// SelectPeersToProbe fetches all peers with open or pending sessions that
+// are due to be dialed/probed.
+func (c *SQLiteClient) SelectPeersToProbe(ctx context.Context) ([]peer.AddrInfo, error) {
+	return c.queryAddrInfos(ctx, `
+		SELECT p.multi_hash, p.multi_addresses
+		FROM peers p INNER JOIN sessions s ON s.peer_id = p.id
+		WHERE s.state != 'closed' AND s.next_visit_due_at < ?`,
+		time.Now().UTC(),
+	)
+}
+
+// queryAddrInfos runs the given query that must return the multi hash and
+// the JSON array of multi addresses of peers.
+func (c *SQLiteClient) queryAddrInfos(ctx context.Context, query string, args ...any) ([]peer.AddrInfo, error) {
+	rows, err := c.dbh.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	var addrInfos []peer.AddrInfo
+	for rows.Next() {
+		var mh string
+		var maddrsStr sql.NullString
+		if err := rows.Scan(&mh, &maddrsStr); err != nil {
+			return nil, fmt.Errorf("scan peer: %w", err)
+		}
+
+		peerID, err := peer.Decode(mh)
+		if err != nil {
+			log.WithError(err).Warnln("Could not decode multi hash ", mh)
+			continue
+		}
+
+		var addrs []string
+		if maddrsStr.Valid {
+			if err := json.Unmarshal([]byte(maddrsStr.String), &addrs); err != nil {
+				log.WithError(err).Warnln("Could not decode multi addresses of ", mh)
+			}
+		}
+
+		maddrs := make([]ma.Multiaddr, 0, len(addrs))
+		for _, addr := range addrs {
+			maddr, err := ma.NewMultiaddr(addr)
+			if err != nil {
+				log.WithError(err).Warnln("Could not decode multi addr ", addr)
+				continue
+			}
+			maddrs = append(maddrs, maddr)
+		}
+
+		addrInfos = append(addrInfos, peer.AddrInfo{ID: peerID, Addrs: maddrs})
+	}
+
+	return addrInfos, rows.Err()
+}
+
+// Flush is a no-op because all data is written immediately.
+func (c *SQLiteClient) Flush(ctx context.Context) error {
+	return nil
+}
+
+// nullString returns nil for empty strings, so that they are stored as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// jsonArray encodes the given list as a JSON array or returns nil if the
+// list is empty, so that it's stored as NULL.
+func jsonArray(list []string) *string {
+	if len(list) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil // can't happen for a list of strings
+	}
+
+	s := string(data)
+	return &s
+}
+
+// jsonObject returns the given JSON object as a string or nil if it's empty.
+func jsonObject(data json.RawMessage) *string {
+	if len(data) == 0 {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
+
+// durationToSeconds returns the duration in seconds or nil if it's zero.
+func durationToSeconds(dur time.Duration) *float64 {
+	if dur == 0 {
+		return nil
+	}
+	s := dur.Seconds()
+	return &s
+}
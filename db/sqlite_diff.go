@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ CrawlLoader = (*SQLiteClient)(nil)
+
+// LoadCrawl returns the peers that were visited during the crawl with the
+// given ID.
+func (c *SQLiteClient) LoadCrawl(ctx context.Context, crawlID string) (*CrawlSnapshot, error) {
+	id, err := strconv.ParseInt(crawlID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	var count int
+	if err = c.dbh.QueryRowContext(ctx, "SELECT count(*) FROM crawls WHERE id = ?", id).Scan(&count); err != nil {
+		return nil, fmt.Errorf("query crawl: %w", err)
+	} else if count == 0 {
+		return nil, fmt.Errorf("crawl %d not found", id)
+	}
+
+	rows, err := c.dbh.QueryContext(ctx, `
+		SELECT p.multi_hash, v.connect_error IS NULL, v.agent_version, v.protocols, v.dial_maddrs, v.filtered_maddrs, v.extra_maddrs
+		FROM visits v
+		    INNER JOIN peers p ON p.id = v.peer_id
+		WHERE v.crawl_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query visits: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	snapshot := newCrawlSnapshot(crawlID)
+	for rows.Next() {
+		var (
+			multiHash      string
+			dialable       bool
+			agentVersion   sql.NullString
+			protocols      sql.NullString
+			dialMaddrs     sql.NullString
+			filteredMaddrs sql.NullString
+			extraMaddrs    sql.NullString
+		)
+		if err = rows.Scan(&multiHash, &dialable, &agentVersion, &protocols, &dialMaddrs, &filteredMaddrs, &extraMaddrs); err != nil {
+			return nil, fmt.Errorf("scan visit: %w", err)
+		}
+
+		peerID, err := peer.Decode(multiHash)
+		if err != nil {
+			return nil, fmt.Errorf("decode peer id %s: %w", multiHash, err)
+		}
+
+		p := &CrawlSnapshotPeer{
+			Dialable:     dialable,
+			AgentVersion: agentVersion.String,
+		}
+
+		if p.Protocols, err = parseJSONArray(protocols); err != nil {
+			return nil, fmt.Errorf("parse protocols of %s: %w", multiHash, err)
+		}
+
+		for _, maddrs := range []sql.NullString{dialMaddrs, filteredMaddrs, extraMaddrs} {
+			list, err := parseJSONArray(maddrs)
+			if err != nil {
+				return nil, fmt.Errorf("parse maddrs of %s: %w", multiHash, err)
+			}
+			p.Maddrs = append(p.Maddrs, list...)
+		}
+
+		snapshot.add(peerID, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read visits: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// parseJSONArray decodes a list that was stored with [jsonArray].
+func parseJSONArray(s sql.NullString) ([]string, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+
+	var list []string
+	if err := json.Unmarshal([]byte(s.String), &list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var _ GraphLoader = (*SQLiteClient)(nil)
+
+// LoadNeighborGraph returns the directed graph of the routing tables that
+// were fetched during the crawl with the given ID.
+func (c *SQLiteClient) LoadNeighborGraph(ctx context.Context, crawlID string) (*NeighborGraph, error) {
+	id, err := strconv.ParseInt(crawlID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse crawl id %q: %w", crawlID, err)
+	}
+
+	snapshot, err := c.LoadCrawl(ctx, crawlID)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newNeighborGraph(crawlID)
+	g.addVisits(snapshot)
+
+	rows, err := c.dbh.QueryContext(ctx, `
+		SELECT p.multi_hash, np.multi_hash
+		FROM neighbors n, json_each(n.neighbor_ids) neighbor_id
+		    INNER JOIN peers p ON p.id = n.peer_id
+		    INNER JOIN peers np ON np.id = neighbor_id.value
+		WHERE n.crawl_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query neighbors: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.WithError(err).Warnln("Could not close rows")
+		}
+	}()
+
+	peerIDs := peerIDDecoder{}
+	for rows.Next() {
+		var peerMultiHash, neighborMultiHash string
+		if err = rows.Scan(&peerMultiHash, &neighborMultiHash); err != nil {
+			return nil, fmt.Errorf("scan neighbor: %w", err)
+		}
+
+		from, err := peerIDs.decode(peerMultiHash)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := peerIDs.decode(neighborMultiHash)
+		if err != nil {
+			return nil, err
+		}
+
+		g.addEdge(from, to)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("read neighbors: %w", err)
+	}
+
+	return g, nil
+}
@@ -0,0 +1,421 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	lp2ptest "github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+func setupSQLite(t *testing.T) (context.Context, *SQLiteClient) {
+	ctx := context.Background()
+
+	cfg := &SQLiteClientConfig{
+		DatabasePath:     filepath.Join(t.TempDir(), "nebula.db"),
+		ApplyMigrations:  true,
+		NetworkID:        "IPFS",
+		PersistNeighbors: true,
+	}
+
+	client, err := NewSQLiteClient(ctx, cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		assert.NoError(t, client.Close())
+	})
+
+	return ctx, client
+}
+
+// sqliteSessionState returns the state and counters of the latest session of the given peer.
+func sqliteSessionState(t *testing.T, client *SQLiteClient, peerID peer.ID) (state string, successful int, failed int, recovered int) {
+	t.Helper()
+
+	err := client.dbh.QueryRow(`
+		SELECT s.state, s.successful_visits_count, s.failed_visits_count, s.recovered_count
+		FROM sessions s INNER JOIN peers p ON s.peer_id = p.id
+		WHERE p.multi_hash = ?
+		ORDER BY s.id DESC
+		LIMIT 1`, peerID.String()).Scan(&state, &successful, &failed, &recovered)
+	require.NoError(t, err)
+
+	return state, successful, failed, recovered
+}
+
+func TestSQLiteClient_InitCrawl(t *testing.T) {
+	ctx, client := setupSQLite(t)
+
+	assert.Empty(t, client.CrawlID())
+	assert.Error(t, client.SealCrawl(ctx, &SealCrawlArgs{State: CrawlStateSucceeded}))
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+	assert.Equal(t, "1", client.CrawlID())
+	assert.Error(t, client.InitCrawl(ctx, "test"))
+
+	err := client.SealCrawl(ctx, &SealCrawlArgs{
+		Crawled:          10,
+		Dialable:         7,
+		Undialable:       3,
+		State:            CrawlStateSucceeded,
+		NetworkSize:      100,
+		NetworkSizeLower: 90,
+		NetworkSizeUpper: 110,
+	})
+	require.NoError(t, err)
+
+	var (
+		networkID   string
+		state       string
+		crawled     int
+		networkSize float64
+		finishedAt  time.Time
+	)
+	err = client.dbh.QueryRow("SELECT network_id, state, crawled_peers, network_size, finished_at FROM crawls WHERE id = 1").
+		Scan(&networkID, &state, &crawled, &networkSize, &finishedAt)
+	require.NoError(t, err)
+
+	assert.Equal(t, "IPFS", networkID)
+	assert.Equal(t, string(CrawlStateSucceeded), state)
+	assert.Equal(t, 10, crawled)
+	assert.Equal(t, 100.0, networkSize)
+	assert.NotZero(t, finishedAt)
+
+	// a new client picks up the existing crawl
+	client.crawlID = 0
+	require.NoError(t, client.ResumeCrawl(ctx, "1"))
+	assert.Equal(t, "1", client.CrawlID())
+
+	client.crawlID = 0
+	assert.Error(t, client.ResumeCrawl(ctx, "2"))
+}
+
+func TestSQLiteClient_InsertCrawlProperties(t *testing.T) {
+	ctx, client := setupSQLite(t)
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+
+	props := map[string]map[string]int{
+		"agent_version": {"agent-1": 1, "agent-2": 2},
+		"error":         {"io_timeout": 3},
+	}
+	require.NoError(t, client.InsertCrawlProperties(ctx, props))
+
+	// persisting the properties again (e.g., after resuming) replaces them
+	props["agent_version"]["agent-1"] = 4
+	require.NoError(t, client.InsertCrawlProperties(ctx, props))
+
+	var rows, total int
+	err := client.dbh.QueryRow("SELECT COUNT(*), SUM(count) FROM crawl_properties WHERE crawl_id = 1").Scan(&rows, &total)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rows)
+	assert.Equal(t, 9, total)
+}
+
+func TestSQLiteClient_InsertVisit(t *testing.T) {
+	ctx, client := setupSQLite(t)
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+
+	peerID, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	neighbor1, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	neighbor2, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	ma1, err := multiaddr.NewMultiaddr("/ip4/100.0.0.1/tcp/2000")
+	require.NoError(t, err)
+
+	args := &VisitArgs{
+		PeerID:          peerID,
+		DialMaddrs:      []multiaddr.Multiaddr{ma1},
+		ConnectMaddr:    ma1,
+		Protocols:       []string{"protocol-1", "protocol-2"},
+		AgentVersion:    "agent-1",
+		ConnectDuration: time.Second,
+		CrawlDuration:   time.Second,
+		VisitStartedAt:  time.Now().Add(-time.Second),
+		VisitEndedAt:    time.Now(),
+		VisitType:       VisitTypeCrawl,
+		Neighbors:       []peer.ID{neighbor1, neighbor2},
+		Properties:      json.RawMessage(`{"is_exposed":true}`),
+	}
+	require.NoError(t, client.InsertVisit(ctx, args))
+
+	var (
+		agentVersion string
+		protocols    string
+		maddrs       string
+	)
+	err = client.dbh.QueryRow("SELECT agent_version, protocols, multi_addresses FROM peers WHERE multi_hash = ?", peerID.String()).
+		Scan(&agentVersion, &protocols, &maddrs)
+	require.NoError(t, err)
+
+	assert.Equal(t, "agent-1", agentVersion)
+	assert.JSONEq(t, `["protocol-1","protocol-2"]`, protocols)
+	assert.JSONEq(t, `["/ip4/100.0.0.1/tcp/2000"]`, maddrs)
+
+	var (
+		crawlID      int64
+		connectMaddr string
+		duration     float64
+	)
+	err = client.dbh.QueryRow("SELECT crawl_id, connect_maddr, connect_duration FROM visits").Scan(&crawlID, &connectMaddr, &duration)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1, crawlID)
+	assert.Equal(t, ma1.String(), connectMaddr)
+	assert.Equal(t, 1.0, duration)
+
+	var neighborIDs string
+	err = client.dbh.QueryRow("SELECT neighbor_ids FROM neighbors WHERE crawl_id = 1").Scan(&neighborIDs)
+	require.NoError(t, err)
+
+	var ids []int64
+	require.NoError(t, json.Unmarshal([]byte(neighborIDs), &ids))
+	assert.Len(t, ids, 2)
+
+	// a visit without any information doesn't override the peer's data
+	args.AgentVersion = ""
+	args.Protocols = nil
+	args.DialMaddrs = nil
+	args.ConnectMaddr = nil
+	require.NoError(t, client.InsertVisit(ctx, args))
+
+	err = client.dbh.QueryRow("SELECT agent_version, protocols, multi_addresses FROM peers WHERE multi_hash = ?", peerID.String()).
+		Scan(&agentVersion, &protocols, &maddrs)
+	require.NoError(t, err)
+
+	assert.Equal(t, "agent-1", agentVersion)
+	assert.JSONEq(t, `["protocol-1","protocol-2"]`, protocols)
+	assert.JSONEq(t, `["/ip4/100.0.0.1/tcp/2000"]`, maddrs)
+
+	var peerCount int
+	require.NoError(t, client.dbh.QueryRow("SELECT count(*) FROM peers").Scan(&peerCount))
+	assert.Equal(t, 3, peerCount)
+
+	// visits for another crawl don't store neighbors
+	other, err := client.Fork("IPFS")
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, other.Close()) }()
+
+	require.NoError(t, other.InitCrawl(ctx, "test"))
+
+	args.CrawlID = other.CrawlID()
+	require.NoError(t, client.InsertVisit(ctx, args))
+
+	var neighborsCount int
+	require.NoError(t, client.dbh.QueryRow("SELECT count(*) FROM neighbors").Scan(&neighborsCount))
+	assert.Equal(t, 1, neighborsCount)
+}
+
+func TestSQLiteClient_Sessions(t *testing.T) {
+	ctx, client := setupSQLite(t)
+
+	peerID, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	ma1, err := multiaddr.NewMultiaddr("/ip4/100.0.0.1/tcp/2000")
+	require.NoError(t, err)
+
+	start := time.Now().Add(-3 * time.Hour)
+	visit := func(at time.Time, connectErr string) {
+		args := &VisitArgs{
+			PeerID:          peerID,
+			DialMaddrs:      []multiaddr.Multiaddr{ma1},
+			VisitStartedAt:  at.Add(-time.Second),
+			VisitEndedAt:    at,
+			ConnectErrorStr: connectErr,
+			VisitType:       VisitTypeDial,
+		}
+		require.NoError(t, client.InsertVisit(ctx, args))
+	}
+
+	// an offline peer without a session doesn't get one
+	visit(start, pgmodels.NetErrorIoTimeout)
+
+	var count int
+	require.NoError(t, client.dbh.QueryRow("SELECT count(*) FROM sessions").Scan(&count))
+	assert.Zero(t, count)
+
+	visit(start, "")
+	state, successful, failed, recovered := sqliteSessionState(t, client, peerID)
+	assert.Equal(t, "open", state)
+	assert.Equal(t, 1, successful)
+
+	// the peer is due to be probed and can be used for bootstrapping
+	toProbe, err := client.SelectPeersToProbe(ctx)
+	require.NoError(t, err)
+	require.Len(t, toProbe, 1)
+	assert.Equal(t, peerID, toProbe[0].ID)
+	assert.Equal(t, []multiaddr.Multiaddr{ma1}, toProbe[0].Addrs)
+
+	bootstrapPeers, err := client.QueryBootstrapPeers(ctx, 10)
+	require.NoError(t, err)
+	assert.Len(t, bootstrapPeers, 1)
+
+	// the peer was online for two hours, so it gets another chance
+	visit(start.Add(2*time.Hour), "")
+	visit(start.Add(2*time.Hour+time.Minute), pgmodels.NetErrorIoTimeout)
+	state, successful, failed, _ = sqliteSessionState(t, client, peerID)
+	assert.Equal(t, "pending", state)
+	assert.Equal(t, 2, successful)
+	assert.Equal(t, 1, failed)
+
+	// pending sessions aren't used for bootstrapping
+	bootstrapPeers, err = client.QueryBootstrapPeers(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, bootstrapPeers)
+
+	visit(start.Add(2*time.Hour+2*time.Minute), "")
+	state, successful, failed, recovered = sqliteSessionState(t, client, peerID)
+	assert.Equal(t, "open", state)
+	assert.Equal(t, 3, successful)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, 1, recovered)
+
+	visit(start.Add(2*time.Hour+3*time.Minute), pgmodels.NetErrorIoTimeout)
+	visit(start.Add(2*time.Hour+4*time.Minute), pgmodels.NetErrorIoTimeout)
+	state, _, failed, _ = sqliteSessionState(t, client, peerID)
+	assert.Equal(t, "closed", state)
+	assert.Equal(t, 2, failed)
+
+	toProbe, err = client.SelectPeersToProbe(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, toProbe)
+
+	// the peer comes back online, which opens a new session
+	visit(start.Add(2*time.Hour+5*time.Minute), "")
+	state, successful, _, _ = sqliteSessionState(t, client, peerID)
+	assert.Equal(t, "open", state)
+	assert.Equal(t, 1, successful)
+
+	// errors that indicate that the peer is gone close the session immediately
+	visit(start.Add(2*time.Hour+6*time.Minute), pgmodels.NetErrorNoGoodAddresses)
+	state, _, _, _ = sqliteSessionState(t, client, peerID)
+	assert.Equal(t, "closed", state)
+
+	require.NoError(t, client.dbh.QueryRow("SELECT count(*) FROM sessions").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestSQLiteClient_Fork(t *testing.T) {
+	ctx, client := setupSQLite(t)
+
+	forked, err := client.Fork("FILECOIN")
+	require.NoError(t, err)
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+	require.NoError(t, forked.InitCrawl(ctx, "test"))
+	assert.NotEqual(t, client.CrawlID(), forked.CrawlID())
+
+	var networkID string
+	err = client.dbh.QueryRow("SELECT network_id FROM crawls WHERE id = ?", forked.CrawlID()).Scan(&networkID)
+	require.NoError(t, err)
+	assert.Equal(t, "FILECOIN", networkID)
+
+	// closing the forked client keeps the shared database handler open
+	require.NoError(t, forked.Close())
+	require.NoError(t, client.dbh.PingContext(ctx))
+}
+
+func TestSQLiteClient_LoadCrawl(t *testing.T) {
+	ctx, client := setupSQLite(t)
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+
+	dialable, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	undialable, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	ma1, err := multiaddr.NewMultiaddr("/ip4/100.0.0.1/tcp/2000")
+	require.NoError(t, err)
+
+	ma2, err := multiaddr.NewMultiaddr("/ip4/100.0.0.2/tcp/2000")
+	require.NoError(t, err)
+
+	require.NoError(t, client.InsertVisit(ctx, &VisitArgs{
+		PeerID:         dialable,
+		DialMaddrs:     []multiaddr.Multiaddr{ma2},
+		FilteredMaddrs: []multiaddr.Multiaddr{ma1},
+		ConnectMaddr:   ma2,
+		Protocols:      []string{"protocol-2", "protocol-1"},
+		AgentVersion:   "agent-1",
+		VisitStartedAt: time.Now().Add(-time.Second),
+		VisitEndedAt:   time.Now(),
+		VisitType:      VisitTypeCrawl,
+	}))
+
+	require.NoError(t, client.InsertVisit(ctx, &VisitArgs{
+		PeerID:          undialable,
+		DialMaddrs:      []multiaddr.Multiaddr{ma1},
+		VisitStartedAt:  time.Now().Add(-time.Second),
+		VisitEndedAt:    time.Now(),
+		VisitType:       VisitTypeCrawl,
+		ConnectErrorStr: "connection_refused",
+	}))
+
+	snapshot, err := client.LoadCrawl(ctx, client.CrawlID())
+	require.NoError(t, err)
+
+	assert.Equal(t, client.CrawlID(), snapshot.CrawlID)
+	require.Len(t, snapshot.Peers, 2)
+	assert.Equal(t, &CrawlSnapshotPeer{
+		Dialable:     true,
+		AgentVersion: "agent-1",
+		Protocols:    []string{"protocol-1", "protocol-2"},
+		Maddrs:       []string{"/ip4/100.0.0.1/tcp/2000", "/ip4/100.0.0.2/tcp/2000"},
+	}, snapshot.Peers[dialable])
+	assert.Equal(t, &CrawlSnapshotPeer{
+		Dialable: false,
+		Maddrs:   []string{"/ip4/100.0.0.1/tcp/2000"},
+	}, snapshot.Peers[undialable])
+
+	_, err = client.LoadCrawl(ctx, "2")
+	assert.Error(t, err)
+}
+
+func TestSQLiteClient_LoadNeighborGraph(t *testing.T) {
+	ctx, client := setupSQLite(t)
+
+	require.NoError(t, client.InitCrawl(ctx, "test"))
+
+	peerID, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	neighbor1, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	neighbor2, err := lp2ptest.RandPeerID()
+	require.NoError(t, err)
+
+	require.NoError(t, client.InsertVisit(ctx, &VisitArgs{
+		PeerID:         peerID,
+		AgentVersion:   "agent-1",
+		VisitStartedAt: time.Now().Add(-time.Second),
+		VisitEndedAt:   time.Now(),
+		VisitType:      VisitTypeCrawl,
+		Neighbors:      []peer.ID{neighbor1, neighbor2},
+	}))
+
+	g, err := client.LoadNeighborGraph(ctx, client.CrawlID())
+	require.NoError(t, err)
+
+	require.Len(t, g.Nodes, 3)
+	assert.Equal(t, &GraphNode{Visited: true, Dialable: true, AgentVersion: "agent-1"}, g.Nodes[peerID])
+	assert.Equal(t, &GraphNode{}, g.Nodes[neighbor1])
+	assert.ElementsMatch(t, []GraphEdge{{From: peerID, To: neighbor1}, {From: peerID, To: neighbor2}}, g.Edges)
+}
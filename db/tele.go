@@ -62,3 +62,29 @@ func newCHTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) (*chTeleme
 		insertLatencyHistogram: insertHistogram,
 	}, nil
 }
+
+// spillTelemetry holds the relevant items to manage the [SpillClient]'s
+// telemetry
+type spillTelemetry struct {
+	spilledCounter  metric.Int64Counter
+	replayedCounter metric.Int64Counter
+}
+
+func newSpillTelemetry(mp metric.MeterProvider) (*spillTelemetry, error) {
+	meter := mp.Meter(tele.MeterName)
+
+	spilledCounter, err := meter.Int64Counter("spilled_visits", metric.WithDescription("Number of visits that were written to the spill directory because they couldn't be inserted into the database"), metric.WithUnit("1"))
+	if err != nil {
+		return nil, fmt.Errorf("spilled_visits counter: %w", err)
+	}
+
+	replayedCounter, err := meter.Int64Counter("replayed_visits", metric.WithDescription("Number of spilled visits that were inserted into the database"), metric.WithUnit("1"))
+	if err != nil {
+		return nil, fmt.Errorf("replayed_visits counter: %w", err)
+	}
+
+	return &spillTelemetry{
+		spilledCounter:  spilledCounter,
+		replayedCounter: replayedCounter,
+	}, nil
+}
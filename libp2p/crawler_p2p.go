@@ -235,7 +235,9 @@ func (c *Crawler) connect(ctx context.Context, pi peer.AddrInfo) (network.Conn,
 		// save addresses into the peer store temporarily
 		c.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
 
-		timeoutCtx, cancel := context.WithTimeout(ctx, c.cfg.DialTimeout)
+		// the swarm applies its own dial timeout which may be shorter than
+		// ours, e.g., for retried peers.
+		timeoutCtx, cancel := context.WithTimeout(network.WithDialPeerTimeout(ctx, c.cfg.DialTimeout), c.cfg.DialTimeout)
 		conn, err := c.host.Network().DialPeer(timeoutCtx, pi.ID)
 		cancel()
 
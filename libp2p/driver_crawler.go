@@ -72,18 +72,19 @@ func (p PeerInfo) DeduplicationKey() string {
 }
 
 type CrawlDriverConfig struct {
-	Version        string
-	WorkerCount    int
-	Network        config.Network
-	Protocols      []string
-	DialTimeout    time.Duration
-	CheckExposed   bool
-	BootstrapPeers []peer.AddrInfo
-	AddrDialType   config.AddrType
-	MeterProvider  metric.MeterProvider
-	TracerProvider trace.TracerProvider
-	GossipSubPX    bool
-	LogErrors      bool
+	Version          string
+	WorkerCount      int
+	Network          config.Network
+	Protocols        []string
+	DialTimeout      time.Duration
+	RetryDialTimeout time.Duration // 0 means DialTimeout
+	CheckExposed     bool
+	BootstrapPeers   []peer.AddrInfo
+	AddrDialType     config.AddrType
+	MeterProvider    metric.MeterProvider
+	TracerProvider   trace.TracerProvider
+	GossipSubPX      bool
+	LogErrors        bool
 }
 
 func (cfg *CrawlDriverConfig) CrawlerConfig() *CrawlerConfig {
@@ -128,7 +129,7 @@ type CrawlDriver struct {
 	writerCount     int
 }
 
-var _ core.Driver[PeerInfo, core.CrawlResult[PeerInfo]] = (*CrawlDriver)(nil)
+var _ core.RetryDriver[PeerInfo, core.CrawlResult[PeerInfo]] = (*CrawlDriver)(nil)
 
 func NewCrawlDriver(dbc db.Client, cfg *CrawlDriverConfig) (*CrawlDriver, error) {
 	// The Avail light clients verify the agent version:
@@ -181,6 +182,19 @@ func NewCrawlDriver(dbc db.Client, cfg *CrawlDriverConfig) (*CrawlDriver, error)
 }
 
 func (d *CrawlDriver) NewWorker() (core.Worker[PeerInfo, core.CrawlResult[PeerInfo]], error) {
+	return d.newCrawler(d.cfg.DialTimeout)
+}
+
+// NewRetryWorker returns a crawler for the retry pass of the engine. It
+// gives peers more time to respond if a retry dial timeout is configured.
+func (d *CrawlDriver) NewRetryWorker() (core.Worker[PeerInfo, core.CrawlResult[PeerInfo]], error) {
+	if d.cfg.RetryDialTimeout > 0 {
+		return d.newCrawler(d.cfg.RetryDialTimeout)
+	}
+	return d.newCrawler(d.cfg.DialTimeout)
+}
+
+func (d *CrawlDriver) newCrawler(dialTimeout time.Duration) (*Crawler, error) {
 	hostsList := make([]string, 0, len(d.hosts))
 	for _, h := range d.hosts {
 		hostsList = append(hostsList, string(h.ID()))
@@ -191,7 +205,7 @@ func (d *CrawlDriver) NewWorker() (core.Worker[PeerInfo, core.CrawlResult[PeerIn
 	ms := &msgSender{
 		h:         d.hosts[hostID].Host,
 		protocols: protocol.ConvertFromStrings(d.cfg.Protocols),
-		timeout:   d.cfg.DialTimeout,
+		timeout:   dialTimeout,
 	}
 
 	pm, err := pb.NewProtocolMessenger(ms)
@@ -199,12 +213,15 @@ func (d *CrawlDriver) NewWorker() (core.Worker[PeerInfo, core.CrawlResult[PeerIn
 		return nil, fmt.Errorf("new protocol messenger: %w", err)
 	}
 
+	crawlerCfg := d.cfg.CrawlerConfig()
+	crawlerCfg.DialTimeout = dialTimeout
+
 	c := &Crawler{
 		id:        fmt.Sprintf("crawler-%02d", d.crawlerCount),
 		host:      d.hosts[hostID],
 		pm:        pm,
 		psTopics:  make(map[string]struct{}),
-		cfg:       d.cfg.CrawlerConfig(),
+		cfg:       crawlerCfg,
 		client:    kubo.NewClient(),
 		stateChan: d.workerStateChan,
 	}
@@ -58,6 +58,22 @@ func NewClient(asnDB string, countryDB string) (*Client, error) {
 	}, nil
 }
 
+// NewASNClient initializes a new maxmind database client that only resolves
+// autonomous system numbers. Only [Client.AddrAS] can be used with it.
+func NewASNClient(asnDB string) (*Client, error) {
+	asnData, err := os.ReadFile(asnDB)
+	if err != nil {
+		return nil, fmt.Errorf("read asn file %s: %w", asnDB, err)
+	}
+
+	asnReader, err := geoip2.FromBytes(asnData)
+	if err != nil {
+		return nil, fmt.Errorf("asn geoip from bytes: %w", err)
+	}
+
+	return &Client{asnReader: asnReader}, nil
+}
+
 type AddrInfo struct {
 	Country   string
 	Continent string
@@ -119,6 +135,9 @@ func (c *Client) AddrAS(addr string) (uint, string, error) {
 }
 
 func (c *Client) Close() error {
+	if c.countryReader == nil {
+		return c.asnReader.Close()
+	}
 	return c.countryReader.Close()
 }
 
@@ -0,0 +1,317 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+)
+
+// CoordinatorConfig configures the [Coordinator].
+type CoordinatorConfig struct {
+	// the address the coordinator listens on for worker requests.
+	ListenAddr string
+
+	// the number of workers that take part in the crawl.
+	Count int
+
+	// the database identifier of the crawl that all workers write to.
+	CrawlID string
+
+	// how often the coordinator polls the workers' status to detect when
+	// the crawl has finished.
+	PollInterval time.Duration
+
+	// how long a worker may not respond to status requests or, after the
+	// crawl has finished, not report its summary before the coordinator
+	// considers it crashed and fails the crawl.
+	WorkerTimeout time.Duration
+}
+
+// Validate verifies the coordinator configuration's invariants.
+func (cfg *CoordinatorConfig) Validate() error {
+	if cfg.Count <= 0 {
+		return fmt.Errorf("worker count must not be zero or negative")
+	}
+
+	if cfg.PollInterval <= 0 {
+		return fmt.Errorf("poll interval must not be zero or negative")
+	}
+
+	if cfg.WorkerTimeout <= 0 {
+		return fmt.Errorf("worker timeout must not be zero or negative")
+	}
+
+	return nil
+}
+
+// Coordinator orchestrates a distributed crawl. It doesn't crawl peers
+// itself.
+type Coordinator struct {
+	cfg      *CoordinatorConfig
+	listener net.Listener
+	server   *http.Server
+	client   *http.Client
+
+	mu         sync.Mutex
+	urls       []string
+	summaries  map[int]*SummaryRequest
+	registered chan struct{}
+	reported   chan struct{}
+}
+
+// NewCoordinator initializes a new [Coordinator] and starts listening on
+// the configured address.
+func NewCoordinator(cfg *CoordinatorConfig) (*Coordinator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", cfg.ListenAddr, err)
+	}
+
+	c := &Coordinator{
+		cfg:        cfg,
+		listener:   listener,
+		client:     &http.Client{Timeout: RequestTimeout},
+		urls:       make([]string, 0, cfg.Count),
+		summaries:  make(map[int]*SummaryRequest, cfg.Count),
+		registered: make(chan struct{}),
+		reported:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathRegister, c.handleRegister)
+	mux.HandleFunc(pathSummary, c.handleSummary)
+	c.server = &http.Server{Handler: mux}
+
+	return c, nil
+}
+
+// Addr returns the address the coordinator listens on.
+func (c *Coordinator) Addr() net.Addr {
+	return c.listener.Addr()
+}
+
+// Run blocks until all workers have registered, crawled their slice of the
+// keyspace, and reported their summaries. It returns the merged summary of
+// all workers. If the context is cancelled, the coordinator asks all
+// registered workers to stop. If a worker doesn't respond for longer than
+// the configured worker timeout, the crawl fails.
+func (c *Coordinator) Run(ctx context.Context) (*core.Summary, error) {
+	go func() {
+		if err := c.server.Serve(c.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Warnln("Coordinator server stopped")
+		}
+	}()
+	defer func() {
+		if err := c.server.Close(); err != nil {
+			log.WithError(err).Warnln("Failed closing coordinator server")
+		}
+	}()
+
+	log.WithField("addr", c.Addr()).Infof("Waiting for %d workers to register", c.cfg.Count)
+	select {
+	case <-ctx.Done():
+		c.finish()
+		return nil, ctx.Err()
+	case <-c.registered:
+	}
+	log.Infoln("All workers registered")
+
+	if err := c.awaitTermination(ctx); err != nil {
+		c.finish()
+		return nil, err
+	}
+
+	log.Infoln("All workers ran out of work")
+	c.finish()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.reported:
+	case <-time.After(c.cfg.WorkerTimeout):
+		log.Warnln("Not all workers reported their summaries")
+	}
+
+	return c.summary()
+}
+
+// awaitTermination polls the status of all workers until all of them have
+// run out of work. It returns an error if a worker didn't respond for longer
+// than the configured worker timeout.
+func (c *Coordinator) awaitTermination(ctx context.Context) error {
+	ticker := time.NewTicker(c.cfg.PollInterval)
+	defer ticker.Stop()
+
+	lastSeen := make([]time.Time, len(c.urls))
+	for i := range lastSeen {
+		lastSeen[i] = time.Now()
+	}
+
+	var prev []core.ShardStatus
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		cur := make([]core.ShardStatus, len(c.urls))
+		failed := false
+		for i, u := range c.urls {
+			if err := getJSON(ctx, c.client, u+pathStatus, &cur[i]); err != nil {
+				log.WithError(err).WithField("worker", i).Warnln("Failed querying worker status")
+				if time.Since(lastSeen[i]) > c.cfg.WorkerTimeout {
+					return fmt.Errorf("worker %d didn't respond for %s", i, c.cfg.WorkerTimeout)
+				}
+				failed = true
+				continue
+			}
+			lastSeen[i] = time.Now()
+		}
+
+		if failed {
+			cur = nil
+		}
+
+		if core.ShardsTerminated(prev, cur) {
+			return nil
+		}
+		prev = cur
+	}
+}
+
+// finish asks all registered workers to stop. This is a best-effort
+// operation.
+func (c *Coordinator) finish() {
+	c.mu.Lock()
+	urls := make([]string, len(c.urls))
+	copy(urls, c.urls)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+
+	for i, u := range urls {
+		if err := postJSON(ctx, c.client, u+pathFinish, struct{}{}, nil); err != nil {
+			log.WithError(err).WithField("worker", i).Warnln("Failed finishing worker")
+		}
+	}
+}
+
+// summary merges the summaries of all workers.
+func (c *Coordinator) summary() (*core.Summary, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	merged := &core.Summary{}
+	remaining := 0
+	var errs []string
+	for i := 0; i < c.cfg.Count; i++ {
+		req, found := c.summaries[i]
+		if !found {
+			errs = append(errs, fmt.Sprintf("worker %d: no summary reported", i))
+			continue
+		}
+
+		if req.Error != "" {
+			errs = append(errs, fmt.Sprintf("worker %d: %s", i, req.Error))
+		}
+
+		if req.Summary == nil {
+			continue
+		}
+
+		merged = merged.Merge(req.Summary)
+		remaining += req.Summary.PeersRemaining
+	}
+	merged.PeersRemaining = remaining
+
+	if len(errs) > 0 {
+		return merged, fmt.Errorf("distributed crawl failed: %s", strings.Join(errs, ", "))
+	}
+
+	return merged, nil
+}
+
+func (c *Coordinator) handleRegister(rw http.ResponseWriter, req *http.Request) {
+	regReq := &RegisterRequest{}
+	if !readJSON(rw, req, regReq) {
+		return
+	}
+
+	c.mu.Lock()
+	if len(c.urls) == c.cfg.Count {
+		c.mu.Unlock()
+		http.Error(rw, "all workers have already registered", http.StatusConflict)
+		return
+	}
+
+	index := len(c.urls)
+	c.urls = append(c.urls, strings.TrimSuffix(regReq.URL, "/"))
+	if len(c.urls) == c.cfg.Count {
+		close(c.registered)
+	}
+	c.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"index": index,
+		"url":   regReq.URL,
+	}).Infoln("Worker registered")
+
+	// only respond after all workers have registered, so that each worker
+	// knows where to forward peers to.
+	select {
+	case <-req.Context().Done():
+		return
+	case <-c.registered:
+	}
+
+	c.mu.Lock()
+	urls := make([]string, len(c.urls))
+	copy(urls, c.urls)
+	c.mu.Unlock()
+
+	writeJSON(rw, &Assignment{
+		Index:   index,
+		Count:   c.cfg.Count,
+		CrawlID: c.cfg.CrawlID,
+		URLs:    urls,
+	})
+}
+
+func (c *Coordinator) handleSummary(rw http.ResponseWriter, req *http.Request) {
+	sumReq := &SummaryRequest{}
+	if !readJSON(rw, req, sumReq) {
+		return
+	}
+
+	if sumReq.Index < 0 || sumReq.Index >= c.cfg.Count {
+		http.Error(rw, fmt.Sprintf("invalid worker index %d", sumReq.Index), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	_, found := c.summaries[sumReq.Index]
+	c.summaries[sumReq.Index] = sumReq
+	if !found && len(c.summaries) == c.cfg.Count {
+		close(c.reported)
+	}
+	c.mu.Unlock()
+
+	log.WithField("index", sumReq.Index).Infoln("Worker reported summary")
+
+	writeJSON(rw, struct{}{})
+}
@@ -0,0 +1,175 @@
+// Package shard implements distributed crawling across multiple Nebula
+// processes. A [Coordinator] creates the crawl, hands out slices of the
+// keyspace to the participating workers, detects when all workers have run
+// out of work, and collects their summaries. Each worker uses a [Transport]
+// to forward peers that are outside its slice of the keyspace to the worker
+// that owns them. All participants communicate via HTTP and JSON.
+package shard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+)
+
+const (
+	// RequestTimeout is the timeout for a single HTTP request between the
+	// coordinator and the workers.
+	RequestTimeout = 10 * time.Second
+
+	pathRegister = "/register"
+	pathSummary  = "/summary"
+	pathPeers    = "/peers"
+	pathStatus   = "/status"
+	pathFinish   = "/finish"
+)
+
+// RegisterRequest is sent from a worker to the coordinator to take part in
+// the distributed crawl.
+type RegisterRequest struct {
+	// URL is the base URL under which the other participants can reach the
+	// worker.
+	URL string
+}
+
+// Assignment is the coordinator's response to a [RegisterRequest]. The
+// coordinator only responds after all workers have registered.
+type Assignment struct {
+	// Index is the slice of the keyspace that the worker is responsible for.
+	Index int
+
+	// Count is the total number of workers.
+	Count int
+
+	// CrawlID is the database identifier of the crawl that all workers
+	// write their results to.
+	CrawlID string
+
+	// URLs contains the base URLs of all workers ordered by their index.
+	URLs []string
+}
+
+// PeersRequest is sent from one worker to another to forward a batch of
+// peers. A batch is sent again until the receiver acknowledges it, so the
+// receiver may see the same batch multiple times, e.g., if only the response
+// got lost. The sender and batch number allow the receiver to ignore such
+// duplicates.
+type PeersRequest struct {
+	// Sender is the index of the worker that forwards the peers.
+	Sender int
+
+	// Batch is the sequence number of the batch. The batches from one
+	// worker to another are numbered consecutively starting at one and are
+	// sent in order.
+	Batch uint64
+
+	Peers []core.CheckpointPeer
+}
+
+// PeersResponse acknowledges the receipt of a [PeersRequest].
+type PeersResponse struct {
+	// Batch is the sequence number of the acknowledged batch.
+	Batch uint64
+}
+
+// SummaryRequest is sent from a worker to the coordinator after it has
+// finished crawling.
+type SummaryRequest struct {
+	// Index is the slice of the keyspace that the worker was responsible for.
+	Index int
+
+	// Summary contains the aggregate information of the worker's crawl.
+	Summary *core.Summary
+
+	// Error is a non-empty string if the worker's crawl failed.
+	Error string
+}
+
+// postJSON sends the given request body as JSON to the given URL and
+// decodes the response into resp if it's not nil.
+func postJSON(ctx context.Context, client *http.Client, url string, req any, resp any) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("post %s: %w", url, err)
+	}
+
+	return decodeResponse(httpResp, resp)
+}
+
+// getJSON requests the given URL and decodes the JSON response into resp.
+func getJSON(ctx context.Context, client *http.Client, url string, resp any) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", url, err)
+	}
+
+	return decodeResponse(httpResp, resp)
+}
+
+func decodeResponse(httpResp *http.Response, resp any) error {
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("read response body: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", httpResp.StatusCode, bytes.TrimSpace(data))
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	return nil
+}
+
+// writeJSON encodes the given value as the JSON response.
+func writeJSON(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readJSON decodes the JSON request body into v.
+func readJSON(rw http.ResponseWriter, req *http.Request, v any) bool {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
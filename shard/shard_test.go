@@ -0,0 +1,249 @@
+package shard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+type testPeerInfo struct {
+	peerID peer.ID
+	addrs  []ma.Multiaddr
+}
+
+var _ core.ShardTransport = (*Transport[testPeerInfo])(nil)
+
+func (p testPeerInfo) ID() peer.ID                           { return p.peerID }
+func (p testPeerInfo) Addrs() []ma.Multiaddr                 { return p.addrs }
+func (p testPeerInfo) Merge(other testPeerInfo) testPeerInfo { return p }
+func (p testPeerInfo) DeduplicationKey() string              { return string(p.peerID) }
+func (p testPeerInfo) DiscoveryPrefix() uint64               { return 0 }
+
+func decodeTestPeerInfo(p core.CheckpointPeer) (testPeerInfo, error) {
+	ai := p.AddrInfo()
+	return testPeerInfo{peerID: ai.ID, addrs: ai.Addrs}, nil
+}
+
+func TestCoordinator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coordinator, err := NewCoordinator(&CoordinatorConfig{
+		ListenAddr:    "127.0.0.1:0",
+		Count:         2,
+		CrawlID:       "1234",
+		PollInterval:  20 * time.Millisecond,
+		WorkerTimeout: time.Second,
+	})
+	require.NoError(t, err)
+
+	type coordinatorResult struct {
+		summary *core.Summary
+		err     error
+	}
+	resultChan := make(chan coordinatorResult, 1)
+	go func() {
+		summary, err := coordinator.Run(ctx)
+		resultChan <- coordinatorResult{summary: summary, err: err}
+	}()
+
+	transports := make([]*Transport[testPeerInfo], 2)
+	assignments := make([]*Assignment, 2)
+
+	var wg sync.WaitGroup
+	for i := range transports {
+		transport, err := NewTransport[testPeerInfo](&TransportConfig{
+			ListenAddr:     "127.0.0.1:0",
+			CoordinatorURL: fmt.Sprintf("http://%s", coordinator.Addr()),
+			FlushInterval:  10 * time.Millisecond,
+		}, decodeTestPeerInfo)
+		require.NoError(t, err)
+		transports[i] = transport
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assignment, err := transports[i].Start(ctx)
+			require.NoError(t, err)
+			assignments[i] = assignment
+		}(i)
+	}
+	wg.Wait()
+
+	// map transports to their assigned index
+	byIndex := make([]*Transport[testPeerInfo], 2)
+	for i, assignment := range assignments {
+		assert.Equal(t, 2, assignment.Count)
+		assert.Equal(t, "1234", assignment.CrawlID)
+		byIndex[assignment.Index] = transports[i]
+	}
+
+	forwarded := testPeerInfo{
+		peerID: peer.ID("forwarded"),
+		addrs:  []ma.Multiaddr{utils.MustMultiaddr(t, "/ip4/1.2.3.4/tcp/4001")},
+	}
+	byIndex[0].Forward(1, forwarded)
+	byIndex[0].SetIdle(true)
+
+	select {
+	case task := <-byIndex[1].Tasks():
+		received, ok := task.(testPeerInfo)
+		require.True(t, ok)
+		assert.Equal(t, forwarded.peerID, received.peerID)
+		assert.Equal(t, forwarded.addrs[0].String(), received.addrs[0].String())
+	case <-ctx.Done():
+		t.Fatal("forwarded peer wasn't received")
+	}
+	byIndex[1].SetIdle(true)
+
+	// both transports should close their tasks channels after the
+	// coordinator has detected that all workers are idle.
+	for i, transport := range byIndex {
+		select {
+		case _, more := <-transport.Tasks():
+			assert.False(t, more)
+		case <-ctx.Done():
+			t.Fatal("tasks channel wasn't closed")
+		}
+
+		summary := &core.Summary{PeersCrawled: i + 1, PeersRemaining: 1}
+		require.NoError(t, transport.ReportSummary(ctx, summary, nil))
+		require.NoError(t, transport.Close())
+	}
+
+	result := <-resultChan
+	require.NoError(t, result.err)
+	assert.Equal(t, 3, result.summary.PeersCrawled)
+	assert.Equal(t, 2, result.summary.PeersRemaining)
+}
+
+func TestCoordinator_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	coordinator, err := NewCoordinator(&CoordinatorConfig{
+		ListenAddr:    "127.0.0.1:0",
+		Count:         2,
+		PollInterval:  time.Second,
+		WorkerTimeout: time.Second,
+	})
+	require.NoError(t, err)
+
+	cancel()
+	_, err = coordinator.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCoordinator_workerTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coordinator, err := NewCoordinator(&CoordinatorConfig{
+		ListenAddr:    "127.0.0.1:0",
+		Count:         2,
+		PollInterval:  20 * time.Millisecond,
+		WorkerTimeout: 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := coordinator.Run(ctx)
+		errChan <- err
+	}()
+
+	transports := make([]*Transport[testPeerInfo], 2)
+	var wg sync.WaitGroup
+	for i := range transports {
+		transport, err := NewTransport[testPeerInfo](&TransportConfig{
+			ListenAddr:     "127.0.0.1:0",
+			CoordinatorURL: fmt.Sprintf("http://%s", coordinator.Addr()),
+			FlushInterval:  10 * time.Millisecond,
+		}, decodeTestPeerInfo)
+		require.NoError(t, err)
+		transports[i] = transport
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := transports[i].Start(ctx)
+			require.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	defer func() { assert.NoError(t, transports[1].Close()) }()
+
+	// the first worker crashes while the other one is still busy
+	require.NoError(t, transports[0].Close())
+
+	select {
+	case err := <-errChan:
+		assert.ErrorContains(t, err, "didn't respond")
+	case <-ctx.Done():
+		t.Fatal("coordinator didn't fail the crawl")
+	}
+}
+
+func TestCoordinator_summary_missing(t *testing.T) {
+	coordinator := &Coordinator{
+		cfg: &CoordinatorConfig{Count: 2},
+		summaries: map[int]*SummaryRequest{
+			0: {Index: 0, Summary: &core.Summary{PeersCrawled: 1}},
+		},
+	}
+
+	// the crawl fails if a worker didn't report its summary in time
+	summary, err := coordinator.summary()
+	assert.ErrorContains(t, err, "worker 1: no summary reported")
+	assert.Equal(t, 1, summary.PeersCrawled)
+}
+
+func TestTransport_handlePeers_duplicate(t *testing.T) {
+	transport, err := NewTransport[testPeerInfo](&TransportConfig{
+		ListenAddr:     "127.0.0.1:0",
+		CoordinatorURL: "http://127.0.0.1:0",
+		FlushInterval:  time.Second,
+	}, decodeTestPeerInfo)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, transport.listener.Close()) }()
+
+	post := func(batch uint64, id string) *PeersResponse {
+		data, err := json.Marshal(&PeersRequest{
+			Sender: 1,
+			Batch:  batch,
+			Peers:  []core.CheckpointPeer{{ID: []byte(id)}},
+		})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		transport.handlePeers(rec, httptest.NewRequest(http.MethodPost, pathPeers, bytes.NewReader(data)))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		resp := &PeersResponse{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), resp))
+		return resp
+	}
+
+	assert.Equal(t, uint64(1), post(1, "peer-1").Batch)
+
+	// the sender didn't receive the acknowledgement and sends the batch again
+	assert.Equal(t, uint64(1), post(1, "peer-1").Batch)
+	assert.Equal(t, uint64(2), post(2, "peer-2").Batch)
+
+	require.Len(t, transport.inbox, 2)
+	assert.Equal(t, peer.ID("peer-1"), transport.inbox[0].peerID)
+	assert.Equal(t, peer.ID("peer-2"), transport.inbox[1].peerID)
+}
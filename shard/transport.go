@@ -0,0 +1,409 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+	"github.com/dennis-tra/nebula-crawler/utils"
+)
+
+// TransportConfig configures the [Transport].
+type TransportConfig struct {
+	// the address the worker listens on for requests from the coordinator
+	// and other workers.
+	ListenAddr string
+
+	// the base URL under which the other participants can reach this
+	// worker. If empty, it's derived from the listen address.
+	AdvertiseURL string
+
+	// the base URL of the coordinator.
+	CoordinatorURL string
+
+	// how often forwarded peers are sent to the other workers.
+	FlushInterval time.Duration
+}
+
+// Validate verifies the transport configuration's invariants.
+func (cfg *TransportConfig) Validate() error {
+	if cfg.CoordinatorURL == "" {
+		return fmt.Errorf("coordinator url must not be empty")
+	}
+
+	if cfg.FlushInterval <= 0 {
+		return fmt.Errorf("flush interval must not be zero or negative")
+	}
+
+	return nil
+}
+
+// Transport is the [core.ShardTransport] of a worker process. It forwards
+// peers to other workers via HTTP. Because peers are sent over the wire, the
+// transport needs to know how to construct the concrete [core.PeerInfo]
+// from its serialized representation.
+type Transport[I core.PeerInfo[I]] struct {
+	cfg      *TransportConfig
+	listener net.Listener
+	server   *http.Server
+	client   *http.Client
+
+	// constructs the concrete peer info from its serialized representation
+	decode func(core.CheckpointPeer) (I, error)
+
+	// the assignment we have received from the coordinator
+	assignment *Assignment
+
+	mu     sync.Mutex
+	idle   bool
+	outbox map[int][]core.CheckpointPeer
+	inbox  []I
+
+	// the batches per receiving shard that weren't acknowledged yet and the
+	// sequence number of the last batch per receiving shard.
+	unacked map[int][]*PeersRequest
+	batches map[int]uint64
+
+	// the sequence number of the last batch that was received per sending
+	// shard. Batches with lower or equal numbers are duplicates.
+	lastBatch map[int]uint64
+
+	sent     atomic.Uint64
+	received atomic.Uint64
+
+	tasks      chan any
+	notify     chan struct{}
+	finished   chan struct{}
+	finishOnce sync.Once
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewTransport initializes a new [Transport] and starts listening on the
+// configured address.
+func NewTransport[I core.PeerInfo[I]](cfg *TransportConfig, decode func(core.CheckpointPeer) (I, error)) (*Transport[I], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", cfg.ListenAddr, err)
+	}
+
+	t := &Transport[I]{
+		cfg:       cfg,
+		listener:  listener,
+		client:    &http.Client{Timeout: RequestTimeout},
+		decode:    decode,
+		outbox:    map[int][]core.CheckpointPeer{},
+		unacked:   map[int][]*PeersRequest{},
+		batches:   map[int]uint64{},
+		lastBatch: map[int]uint64{},
+		tasks:     make(chan any),
+		notify:    make(chan struct{}, 1),
+		finished:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathPeers, t.handlePeers)
+	mux.HandleFunc(pathStatus, t.handleStatus)
+	mux.HandleFunc(pathFinish, t.handleFinish)
+	t.server = &http.Server{Handler: mux}
+
+	return t, nil
+}
+
+// Addr returns the address the transport listens on.
+func (t *Transport[I]) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Start starts serving requests from the coordinator and other workers and
+// registers this worker with the coordinator. It blocks until all workers
+// have registered and returns the assignment of this worker.
+func (t *Transport[I]) Start(ctx context.Context) (*Assignment, error) {
+	t.wg.Add(3)
+	go func() {
+		defer t.wg.Done()
+		if err := t.server.Serve(t.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Warnln("Shard transport server stopped")
+		}
+	}()
+	go func() {
+		defer t.wg.Done()
+		t.deliver()
+	}()
+	go func() {
+		defer t.wg.Done()
+		t.send()
+	}()
+
+	advertiseURL := t.cfg.AdvertiseURL
+	if advertiseURL == "" {
+		advertiseURL = "http://" + t.Addr().String()
+	}
+
+	// the coordinator only responds after all workers have registered, so
+	// don't apply the default request timeout.
+	client := &http.Client{}
+	assignment := &Assignment{}
+	coordinatorURL := strings.TrimSuffix(t.cfg.CoordinatorURL, "/")
+	if err := postJSON(ctx, client, coordinatorURL+pathRegister, &RegisterRequest{URL: advertiseURL}, assignment); err != nil {
+		return nil, fmt.Errorf("register with coordinator: %w", err)
+	}
+
+	if assignment.Index < 0 || assignment.Index >= assignment.Count || len(assignment.URLs) != assignment.Count {
+		return nil, fmt.Errorf("invalid assignment from coordinator")
+	}
+	t.assignment = assignment
+
+	start, end := core.ShardRange(assignment.Index, assignment.Count)
+	log.WithFields(log.Fields{
+		"index":   assignment.Index,
+		"count":   assignment.Count,
+		"crawlID": assignment.CrawlID,
+		"start":   fmt.Sprintf("%016x", start),
+		"end":     fmt.Sprintf("%016x", end),
+	}).Infoln("Received shard assignment")
+
+	return assignment, nil
+}
+
+// ReportSummary sends the summary of this worker's crawl to the coordinator.
+func (t *Transport[I]) ReportSummary(ctx context.Context, summary *core.Summary, runErr error) error {
+	req := &SummaryRequest{
+		Index:   t.assignment.Index,
+		Summary: summary,
+	}
+	if runErr != nil {
+		req.Error = runErr.Error()
+	}
+
+	coordinatorURL := strings.TrimSuffix(t.cfg.CoordinatorURL, "/")
+	return postJSON(ctx, t.client, coordinatorURL+pathSummary, req, nil)
+}
+
+// Close stops serving requests and stops all internal go routines.
+func (t *Transport[I]) Close() error {
+	close(t.done)
+	err := t.server.Close()
+	t.wg.Wait()
+	return err
+}
+
+func (t *Transport[I]) Forward(shard int, task any) {
+	pi, ok := task.(I)
+	if !ok {
+		log.Warnf("Cannot forward unexpected peer type %T", task)
+		return
+	}
+
+	t.sent.Add(1)
+
+	t.mu.Lock()
+	t.outbox[shard] = append(t.outbox[shard], core.CheckpointPeer{
+		ID:    []byte(pi.ID()),
+		Addrs: utils.MaddrsToAddrs(pi.Addrs()),
+	})
+	t.mu.Unlock()
+}
+
+func (t *Transport[I]) Tasks() <-chan any {
+	return t.tasks
+}
+
+func (t *Transport[I]) SetIdle(idle bool) {
+	t.mu.Lock()
+	t.idle = idle
+	t.mu.Unlock()
+}
+
+// send periodically sends the forwarded peers to their owning workers. The
+// peers of each flush become a new batch per receiving worker. A batch is
+// kept until the receiver has acknowledged it and sent again with the next
+// flush if that fails. The batches to a worker are sent in order, so that the
+// receiver can detect duplicates by their sequence number. As long as batches
+// aren't acknowledged, the sent and received counters don't add up and the
+// coordinator won't consider the crawl finished.
+func (t *Transport[I]) send() {
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-t.finished:
+			return
+		case <-ticker.C:
+		}
+
+		t.mu.Lock()
+		for shard, peers := range t.outbox {
+			t.batches[shard] += 1
+			t.unacked[shard] = append(t.unacked[shard], &PeersRequest{
+				Sender: t.assignment.Index,
+				Batch:  t.batches[shard],
+				Peers:  peers,
+			})
+		}
+		t.outbox = map[int][]core.CheckpointPeer{}
+
+		unacked := make(map[int][]*PeersRequest, len(t.unacked))
+		for shard, batches := range t.unacked {
+			unacked[shard] = slices.Clone(batches)
+		}
+		t.mu.Unlock()
+
+		for shard, batches := range unacked {
+			for _, batch := range batches {
+				if err := t.sendBatch(shard, batch); err != nil {
+					// try again with the next flush. Don't send the later
+					// batches, so that they arrive in order.
+					log.WithError(err).WithField("shard", shard).Warnln("Failed forwarding peers")
+					break
+				}
+
+				t.mu.Lock()
+				t.unacked[shard] = t.unacked[shard][1:]
+				if len(t.unacked[shard]) == 0 {
+					delete(t.unacked, shard)
+				}
+				t.mu.Unlock()
+			}
+		}
+	}
+}
+
+// sendBatch sends the given batch to the given shard and verifies that the
+// shard has acknowledged it.
+func (t *Transport[I]) sendBatch(shard int, batch *PeersRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+
+	resp := &PeersResponse{}
+	if err := postJSON(ctx, t.client, t.assignment.URLs[shard]+pathPeers, batch, resp); err != nil {
+		return err
+	} else if resp.Batch != batch.Batch {
+		return fmt.Errorf("batch %d not acknowledged (got %d)", batch.Batch, resp.Batch)
+	}
+
+	return nil
+}
+
+// deliver hands received peers to the engine until the coordinator has
+// signalled that the crawl has finished.
+func (t *Transport[I]) deliver() {
+	defer close(t.tasks)
+
+	for {
+		t.mu.Lock()
+		var (
+			task  I
+			found bool
+		)
+		if len(t.inbox) > 0 {
+			task, found = t.inbox[0], true
+			t.inbox = t.inbox[1:]
+		}
+		t.mu.Unlock()
+
+		if !found {
+			select {
+			case <-t.notify:
+				continue
+			case <-t.finished:
+				return
+			case <-t.done:
+				return
+			}
+		}
+
+		select {
+		case t.tasks <- task:
+			t.received.Add(1)
+		case <-t.finished:
+			return
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *Transport[I]) handlePeers(rw http.ResponseWriter, req *http.Request) {
+	peersReq := &PeersRequest{}
+	if !readJSON(rw, req, peersReq) {
+		return
+	}
+
+	t.mu.Lock()
+	duplicate := peersReq.Batch <= t.lastBatch[peersReq.Sender]
+	if !duplicate {
+		t.lastBatch[peersReq.Sender] = peersReq.Batch
+	}
+	t.mu.Unlock()
+
+	// we have already received this batch, but the sender didn't get our
+	// acknowledgement.
+	if duplicate {
+		writeJSON(rw, &PeersResponse{Batch: peersReq.Batch})
+		return
+	}
+
+	infos := make([]I, 0, len(peersReq.Peers))
+	for _, p := range peersReq.Peers {
+		info, err := t.decode(p)
+		if err != nil {
+			log.WithError(err).Warnln("Failed decoding forwarded peer")
+			// count it as received, so that the sent and received counters
+			// still add up.
+			t.received.Add(1)
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	t.mu.Lock()
+	t.inbox = append(t.inbox, infos...)
+	t.mu.Unlock()
+
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+
+	writeJSON(rw, &PeersResponse{Batch: peersReq.Batch})
+}
+
+func (t *Transport[I]) handleStatus(rw http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	status := core.ShardStatus{
+		Idle:     t.idle,
+		Sent:     t.sent.Load(),
+		Received: t.received.Load(),
+	}
+	t.mu.Unlock()
+
+	writeJSON(rw, status)
+}
+
+func (t *Transport[I]) handleFinish(rw http.ResponseWriter, req *http.Request) {
+	t.finishOnce.Do(func() {
+		log.Infoln("Coordinator signalled the end of the crawl")
+		close(t.finished)
+	})
+
+	writeJSON(rw, struct{}{})
+}
@@ -0,0 +1,164 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+	"github.com/dennis-tra/nebula-crawler/db"
+)
+
+// Protocols are the protocols that all simulated peers support.
+var Protocols = []string{"/ipfs/id/1.0.0", "/ipfs/kad/1.0.0", "/ipfs/ping/1.0.0"}
+
+type CrawlerConfig struct {
+	Network     *Network
+	RealTime    bool
+	DialTimeout time.Duration
+	LogErrors   bool
+
+	// whether the crawler retries peers, which lets transient failures
+	// succeed, see [NetworkConfig.TransientFailure].
+	Retry bool
+}
+
+// Crawler crawls peers of a simulated [Network].
+type Crawler struct {
+	id           string
+	cfg          *CrawlerConfig
+	crawledPeers int
+}
+
+var _ core.Worker[PeerInfo, core.CrawlResult[PeerInfo]] = (*Crawler)(nil)
+
+func (c *Crawler) Work(ctx context.Context, task PeerInfo) (core.CrawlResult[PeerInfo], error) {
+	logEntry := log.WithFields(log.Fields{
+		"crawlerID":  c.id,
+		"remoteID":   task.ID().ShortString(),
+		"crawlCount": c.crawledPeers,
+	})
+	defer logEntry.Debugln("Crawled peer")
+
+	p := task.peer
+	start := time.Now()
+	cr := core.CrawlResult[PeerInfo]{
+		CrawlerID:        c.id,
+		Info:             task,
+		DialMaddrs:       p.Addrs,
+		CrawlStartTime:   start,
+		ConnectStartTime: start,
+		LogErrors:        c.cfg.LogErrors,
+	}
+
+	// connecting takes two round trips: one for the transport and one for
+	// the security and multiplexer negotiation.
+	var connectDur time.Duration
+	switch p.Reachability {
+	case Churned:
+		connectDur = p.Latency
+		cr.ConnectError = fmt.Errorf("dial %s: connection refused", p.Addrs[0])
+	case Unreachable:
+		connectDur = c.cfg.DialTimeout
+		cr.ConnectError = fmt.Errorf("dial %s: i/o timeout", p.Addrs[0])
+	default:
+		if p.Transient && !c.cfg.Retry {
+			connectDur = c.cfg.DialTimeout
+			cr.ConnectError = fmt.Errorf("dial %s: i/o timeout", p.Addrs[0])
+			break
+		}
+
+		connectDur = 2 * p.Latency
+	}
+
+	if err := c.wait(ctx, connectDur); err != nil {
+		cr.ConnectError = err
+	}
+	cr.ConnectEndTime = cr.ConnectStartTime.Add(connectDur)
+
+	if cr.ConnectError != nil {
+		cr.ConnectErrorStr = db.NetError(cr.ConnectError)
+		cr.DialErrors = make([]string, len(p.Addrs))
+		for i := range cr.DialErrors {
+			cr.DialErrors[i] = cr.ConnectErrorStr
+		}
+		cr.CrawlEndTime = cr.ConnectEndTime
+		c.crawledPeers++
+		return cr, nil
+	}
+
+	cr.ConnectMaddr = p.Addrs[0]
+	cr.ListenMaddrs = p.Addrs
+	cr.ExtraMaddrs = []ma.Multiaddr{}
+	cr.Agent = p.AgentVersion
+	cr.Protocols = Protocols
+
+	// all buckets are queried concurrently, which takes one round trip.
+	crawlDur := connectDur + p.Latency
+	if err := c.wait(ctx, p.Latency); err != nil {
+		cr.CrawlError = err
+		cr.CrawlErrorStr = db.NetError(err)
+		cr.CrawlEndTime = cr.CrawlStartTime.Add(crawlDur)
+		c.crawledPeers++
+		return cr, nil
+	}
+
+	cr.RoutingTable = c.routingTable(p)
+	cr.CrawlEndTime = cr.CrawlStartTime.Add(crawlDur)
+
+	// like the libp2p crawler, only consider the crawl failed if we didn't
+	// get any neighbors.
+	if cr.RoutingTable.Error != nil && len(cr.RoutingTable.Neighbors) == 0 {
+		cr.CrawlError = cr.RoutingTable.Error
+		cr.CrawlErrorStr = db.NetError(cr.RoutingTable.Error)
+	}
+
+	c.crawledPeers++
+
+	return cr, nil
+}
+
+// routingTable returns the neighbors of the given peer from all buckets that
+// don't fail when queried.
+func (c *Crawler) routingTable(p *Peer) *core.RoutingTable[PeerInfo] {
+	rt := &core.RoutingTable[PeerInfo]{
+		PeerID:    p.ID,
+		Neighbors: []PeerInfo{},
+		ErrorBits: p.FailedBuckets,
+	}
+
+	for b, bucket := range c.cfg.Network.RoutingTable(p) {
+		if p.FailedBuckets&(1<<b) != 0 {
+			if rt.Error == nil {
+				rt.Error = fmt.Errorf("query bucket %d: RPC timeout", b)
+			}
+			continue
+		}
+
+		for _, n := range bucket {
+			rt.Neighbors = append(rt.Neighbors, PeerInfo{peer: n})
+		}
+	}
+
+	return rt
+}
+
+// wait blocks for the given duration if the crawler operates in real time.
+func (c *Crawler) wait(ctx context.Context, d time.Duration) error {
+	if !c.cfg.RealTime {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
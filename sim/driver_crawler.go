@@ -0,0 +1,180 @@
+package sim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+	"github.com/dennis-tra/nebula-crawler/db"
+)
+
+// PeerInfo is the [core.PeerInfo] of a simulated peer.
+type PeerInfo struct {
+	peer *Peer
+}
+
+var _ core.PeerInfo[PeerInfo] = (*PeerInfo)(nil)
+
+func (p PeerInfo) ID() peer.ID {
+	return p.peer.ID
+}
+
+func (p PeerInfo) Addrs() []ma.Multiaddr {
+	return p.peer.Addrs
+}
+
+// Merge returns the callee because simulated peers never change their
+// addresses.
+func (p PeerInfo) Merge(other PeerInfo) PeerInfo {
+	if p.peer.ID != other.peer.ID {
+		panic("merge peer ID mismatch")
+	}
+
+	return p
+}
+
+func (p PeerInfo) DeduplicationKey() string {
+	return string(p.peer.ID)
+}
+
+func (p PeerInfo) DiscoveryPrefix() uint64 {
+	return binary.BigEndian.Uint64(p.peer.Key[:8])
+}
+
+// Peer returns the simulated peer.
+func (p PeerInfo) Peer() *Peer {
+	return p.peer
+}
+
+type CrawlDriverConfig struct {
+	// the simulated network that should be crawled
+	Network *Network
+
+	// if true, the crawlers sleep for the simulated connection and query
+	// durations. Otherwise, peers are crawled instantly and only the
+	// reported timestamps reflect the simulated durations.
+	RealTime bool
+
+	// the time after which connecting to an unreachable peer fails
+	DialTimeout time.Duration
+
+	LogErrors bool
+}
+
+// DefaultCrawlDriverConfig returns the default configuration for crawling
+// the given network.
+func DefaultCrawlDriverConfig(network *Network) *CrawlDriverConfig {
+	return &CrawlDriverConfig{
+		Network:     network,
+		RealTime:    false,
+		DialTimeout: 15 * time.Second,
+	}
+}
+
+// Validate verifies the driver configuration's invariants.
+func (cfg *CrawlDriverConfig) Validate() error {
+	if cfg.Network == nil {
+		return fmt.Errorf("network must not be nil")
+	}
+
+	if cfg.DialTimeout <= 0 {
+		return fmt.Errorf("dial timeout must not be zero or negative")
+	}
+
+	return nil
+}
+
+func (cfg *CrawlDriverConfig) CrawlerConfig() *CrawlerConfig {
+	return &CrawlerConfig{
+		Network:     cfg.Network,
+		RealTime:    cfg.RealTime,
+		DialTimeout: cfg.DialTimeout,
+		LogErrors:   cfg.LogErrors,
+	}
+}
+
+func (cfg *CrawlDriverConfig) WriterConfig() *core.CrawlWriterConfig {
+	return &core.CrawlWriterConfig{}
+}
+
+// CrawlDriver is the [core.Driver] that crawls a simulated [Network].
+type CrawlDriver struct {
+	cfg          *CrawlDriverConfig
+	dbc          db.Client
+	tasksChan    chan PeerInfo
+	crawlerCount int
+	retryCount   int
+	writerCount  int
+}
+
+var _ core.RetryDriver[PeerInfo, core.CrawlResult[PeerInfo]] = (*CrawlDriver)(nil)
+
+// NewCrawlDriver initializes a new [CrawlDriver] that starts the crawl with
+// the bootstrap peers of the configured network.
+func NewCrawlDriver(dbc db.Client, cfg *CrawlDriverConfig) (*CrawlDriver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	bootstrapPeers := cfg.Network.BootstrapPeers()
+
+	tasksChan := make(chan PeerInfo, len(bootstrapPeers))
+	for _, p := range bootstrapPeers {
+		tasksChan <- PeerInfo{peer: p}
+	}
+	close(tasksChan)
+
+	return &CrawlDriver{
+		cfg:       cfg,
+		dbc:       dbc,
+		tasksChan: tasksChan,
+	}, nil
+}
+
+func (d *CrawlDriver) NewWorker() (core.Worker[PeerInfo, core.CrawlResult[PeerInfo]], error) {
+	c := &Crawler{
+		id:  fmt.Sprintf("crawler-%02d", d.crawlerCount),
+		cfg: d.cfg.CrawlerConfig(),
+	}
+
+	d.crawlerCount += 1
+
+	log.Debugln("Started crawler worker", c.id)
+
+	return c, nil
+}
+
+// NewRetryWorker returns a crawler that can reach peers with transient
+// failures, see [NetworkConfig.TransientFailure].
+func (d *CrawlDriver) NewRetryWorker() (core.Worker[PeerInfo, core.CrawlResult[PeerInfo]], error) {
+	cfg := d.cfg.CrawlerConfig()
+	cfg.Retry = true
+
+	c := &Crawler{
+		id:  fmt.Sprintf("retry-crawler-%02d", d.retryCount),
+		cfg: cfg,
+	}
+
+	d.retryCount += 1
+
+	log.Debugln("Started retry crawler worker", c.id)
+
+	return c, nil
+}
+
+func (d *CrawlDriver) NewWriter() (core.Worker[core.CrawlResult[PeerInfo], core.WriteResult], error) {
+	w := core.NewCrawlWriter[PeerInfo](fmt.Sprintf("writer-%02d", d.writerCount), d.dbc, d.cfg.WriterConfig())
+	d.writerCount += 1
+	return w, nil
+}
+
+func (d *CrawlDriver) Tasks() <-chan PeerInfo {
+	return d.tasksChan
+}
+
+func (d *CrawlDriver) Close() {}
@@ -0,0 +1,380 @@
+// Package sim implements a simulated Kademlia network that lives entirely in
+// memory. Its [CrawlDriver] can be plugged into the [core.Engine] like any
+// other driver, which allows us to test the engine's scheduling, limits,
+// summaries, and writer behaviour with large networks without opening a
+// single connection. The network and all crawl results are derived from a
+// seed, so a crawl of the same network always produces the same results
+// regardless of the number of workers or the order in which peers are
+// processed.
+package sim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// NetworkConfig configures the simulated [Network].
+type NetworkConfig struct {
+	// the number of peers in the network
+	Size int
+
+	// the seed from which the network and all crawl results are derived
+	Seed uint64
+
+	// the maximum number of peers per routing table bucket (k)
+	BucketSize int
+
+	// the number of routing table buckets per peer. The last bucket
+	// contains the peers that share at least Buckets-1 bits with the peer.
+	// Can't be larger than 16 because crawl errors are tracked per bucket in
+	// a uint16.
+	Buckets int
+
+	// the fraction of peers that have left the network but are still part
+	// of other peers' routing tables. Connecting to them is refused.
+	Churn float64
+
+	// the fraction of peers that are online but can't be reached, e.g.,
+	// because they are behind a NAT. Connecting to them times out.
+	Unreachable float64
+
+	// the probability that querying a single routing table bucket of a
+	// reachable peer fails.
+	BucketFailure float64
+
+	// the fraction of reachable peers that time out when they are dialed by
+	// regular crawlers but can be reached on retry, see
+	// [CrawlDriver.NewRetryWorker].
+	TransientFailure float64
+
+	// the median round-trip time to a peer.
+	Latency time.Duration
+
+	// the standard deviation of the logarithm of the round-trip times. 0
+	// means all peers have the same latency.
+	LatencyJitter float64
+
+	// the number of reachable peers that are used to bootstrap the crawl
+	BootstrapPeers int
+
+	// the agent versions that are assigned to the peers
+	AgentVersions []string
+}
+
+// DefaultNetworkConfig returns a network configuration that resembles a
+// small public DHT.
+func DefaultNetworkConfig() *NetworkConfig {
+	return &NetworkConfig{
+		Size:           1000,
+		Seed:           1,
+		BucketSize:     20,
+		Buckets:        16,
+		Churn:          0.2,
+		Unreachable:    0.1,
+		BucketFailure:  0.01,
+		Latency:        100 * time.Millisecond,
+		LatencyJitter:  0.5,
+		BootstrapPeers: 4,
+		AgentVersions:  []string{"sim/1.0.0", "sim/1.1.0", "sim/2.0.0"},
+	}
+}
+
+// Validate verifies the network configuration's invariants.
+func (cfg *NetworkConfig) Validate() error {
+	if cfg.Size <= 0 {
+		return fmt.Errorf("network size must not be zero or negative")
+	}
+
+	if cfg.BucketSize <= 0 {
+		return fmt.Errorf("bucket size must not be zero or negative")
+	}
+
+	if cfg.Buckets <= 0 || cfg.Buckets > 16 {
+		return fmt.Errorf("number of buckets must be between 1 and 16")
+	}
+
+	if cfg.Churn < 0 || cfg.Unreachable < 0 || cfg.Churn+cfg.Unreachable >= 1 {
+		return fmt.Errorf("churn and unreachable fractions must not be negative and must leave reachable peers")
+	}
+
+	if cfg.BucketFailure < 0 || cfg.BucketFailure > 1 {
+		return fmt.Errorf("bucket failure probability must be between 0 and 1")
+	}
+
+	if cfg.TransientFailure < 0 || cfg.TransientFailure > 1 {
+		return fmt.Errorf("transient failure fraction must be between 0 and 1")
+	}
+
+	if cfg.Latency < 0 || cfg.LatencyJitter < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+
+	if cfg.BootstrapPeers <= 0 {
+		return fmt.Errorf("number of bootstrap peers must not be zero or negative")
+	}
+
+	if len(cfg.AgentVersions) == 0 {
+		return fmt.Errorf("at least one agent version must be configured")
+	}
+
+	return nil
+}
+
+// Reachability describes whether a simulated peer can be crawled.
+type Reachability int
+
+const (
+	// Reachable peers can be connected to and return their routing tables.
+	Reachable Reachability = iota
+
+	// Churned peers have left the network. Connecting to them is refused.
+	Churned
+
+	// Unreachable peers are online but connecting to them times out.
+	Unreachable
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case Reachable:
+		return "reachable"
+	case Churned:
+		return "churned"
+	case Unreachable:
+		return "unreachable"
+	default:
+		return fmt.Sprintf("Reachability(%d)", int(r))
+	}
+}
+
+// Peer is a single participant of the simulated network.
+type Peer struct {
+	// the position of the peer in the network
+	Index int
+
+	// the libp2p peer ID
+	ID peer.ID
+
+	// the Kademlia key of the peer (the SHA256 of its ID)
+	Key [32]byte
+
+	// the addresses the peer listens on
+	Addrs []ma.Multiaddr
+
+	// whether the peer can be crawled
+	Reachability Reachability
+
+	// the round-trip time to the peer
+	Latency time.Duration
+
+	// the agent version that the peer reports
+	AgentVersion string
+
+	// the routing table buckets that fail when queried. Little endian
+	// representation, see [core.RoutingTable].
+	FailedBuckets uint16
+
+	// whether dialing the peer only succeeds on retry
+	Transient bool
+}
+
+// Network is a simulated Kademlia network. It is safe for concurrent use
+// because it's never modified after it was created.
+type Network struct {
+	cfg   *NetworkConfig
+	peers []*Peer
+
+	// peer indices sorted by their Kademlia keys
+	sorted []int
+}
+
+// NewNetwork generates the simulated network.
+func NewNetwork(cfg *NetworkConfig) (*Network, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	n := &Network{
+		cfg:    cfg,
+		peers:  make([]*Peer, cfg.Size),
+		sorted: make([]int, cfg.Size),
+	}
+
+	for i := range n.peers {
+		n.peers[i] = n.newPeer(i)
+		n.sorted[i] = i
+	}
+
+	sort.Slice(n.sorted, func(i, j int) bool {
+		return bytes.Compare(n.peers[n.sorted[i]].Key[:], n.peers[n.sorted[j]].Key[:]) < 0
+	})
+
+	return n, nil
+}
+
+// newPeer derives the peer at the given index from the network's seed.
+func (n *Network) newPeer(index int) *Peer {
+	rng := n.rand(index, 0)
+
+	// construct a SHA256 multihash, like a peer ID of an RSA key
+	var seed [16]byte
+	binary.BigEndian.PutUint64(seed[:8], n.cfg.Seed)
+	binary.BigEndian.PutUint64(seed[8:], uint64(index))
+	digest := sha256.Sum256(seed[:])
+	id := peer.ID(append([]byte{0x12, 0x20}, digest[:]...))
+
+	p := &Peer{
+		Index:        index,
+		ID:           id,
+		Key:          sha256.Sum256([]byte(id)),
+		Addrs:        []ma.Multiaddr{peerMaddr(index)},
+		Reachability: Reachable,
+		Latency:      time.Duration(float64(n.cfg.Latency) * math.Exp(rng.NormFloat64()*n.cfg.LatencyJitter)),
+		AgentVersion: n.cfg.AgentVersions[rng.IntN(len(n.cfg.AgentVersions))],
+	}
+
+	switch r := rng.Float64(); {
+	case r < n.cfg.Churn:
+		p.Reachability = Churned
+	case r < n.cfg.Churn+n.cfg.Unreachable:
+		p.Reachability = Unreachable
+	}
+
+	for b := 0; b < n.cfg.Buckets; b++ {
+		if rng.Float64() < n.cfg.BucketFailure {
+			p.FailedBuckets |= 1 << b
+		}
+	}
+
+	p.Transient = p.Reachability == Reachable && rng.Float64() < n.cfg.TransientFailure
+
+	return p
+}
+
+// rand returns a random number generator that is derived from the network's
+// seed, the given peer index, and a purpose, so that all derived values are
+// independent of each other.
+func (n *Network) rand(index int, purpose uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(n.cfg.Seed, uint64(index)<<8|purpose))
+}
+
+// peerMaddr returns a public IPv4 TCP address for the peer at the given
+// index. Consecutive peers share the same /24 prefix.
+func peerMaddr(index int) ma.Multiaddr {
+	ip := netip.AddrFrom4([4]byte{11 + byte(index>>24), byte(index >> 16), byte(index >> 8), byte(index)})
+	maddr, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/%s/tcp/4001", ip))
+	if err != nil {
+		panic(err) // the address is always valid
+	}
+	return maddr
+}
+
+// Size returns the number of peers in the network.
+func (n *Network) Size() int {
+	return len(n.peers)
+}
+
+// Peer returns the peer at the given index.
+func (n *Network) Peer(index int) *Peer {
+	return n.peers[index]
+}
+
+// BootstrapPeers returns the reachable peers with the lowest indices.
+func (n *Network) BootstrapPeers() []*Peer {
+	var peers []*Peer
+	for _, p := range n.peers {
+		if len(peers) == n.cfg.BootstrapPeers {
+			break
+		} else if p.Reachability == Reachable {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// RoutingTable returns the buckets of the given peer's routing table. The
+// bucket at index i contains up to BucketSize peers that share exactly i
+// bits with the peer's key. The last bucket contains peers that share at
+// least that many bits. Buckets that fail when queried are still returned.
+// The routing table is derived on demand, so that large networks don't need
+// to keep all routing tables in memory.
+func (n *Network) RoutingTable(p *Peer) [][]*Peer {
+	rng := n.rand(p.Index, 1)
+
+	buckets := make([][]*Peer, n.cfg.Buckets)
+	for b := range buckets {
+		lo, hi := n.bucketRange(p, b, b == n.cfg.Buckets-1)
+
+		var candidates []int
+		if hi-lo <= 2*n.cfg.BucketSize {
+			// few candidates: shuffle all of them and take the first k
+			for _, idx := range n.sorted[lo:hi] {
+				if idx != p.Index {
+					candidates = append(candidates, idx)
+				}
+			}
+			rng.Shuffle(len(candidates), func(i, j int) {
+				candidates[i], candidates[j] = candidates[j], candidates[i]
+			})
+			candidates = candidates[:min(len(candidates), n.cfg.BucketSize)]
+		} else {
+			// many candidates: draw k distinct ones. This is much cheaper
+			// than shuffling, e.g., half of the network for bucket 0.
+			seen := make(map[int]struct{}, n.cfg.BucketSize)
+			for len(candidates) < n.cfg.BucketSize {
+				idx := n.sorted[lo+rng.IntN(hi-lo)]
+				if _, found := seen[idx]; found || idx == p.Index {
+					continue
+				}
+				seen[idx] = struct{}{}
+				candidates = append(candidates, idx)
+			}
+		}
+		sort.Ints(candidates)
+
+		buckets[b] = make([]*Peer, len(candidates))
+		for i, idx := range candidates {
+			buckets[b][i] = n.peers[idx]
+		}
+	}
+
+	return buckets
+}
+
+// bucketRange returns the range in the sorted peers slice of the peers that
+// share exactly cpl bits with the given peer's key. If orCloser is true, the
+// range also includes the peers that share more bits.
+func (n *Network) bucketRange(p *Peer, cpl int, orCloser bool) (int, int) {
+	lo, hi := p.Key, p.Key
+
+	// the bit at position cpl must differ, all following bits are free
+	if !orCloser {
+		lo[cpl/8] ^= 0x80 >> (cpl % 8)
+		hi[cpl/8] ^= 0x80 >> (cpl % 8)
+		cpl++
+	}
+
+	for bit := cpl; bit < 256; bit++ {
+		lo[bit/8] &^= 0x80 >> (bit % 8)
+		hi[bit/8] |= 0x80 >> (bit % 8)
+	}
+
+	start := sort.Search(len(n.sorted), func(i int) bool {
+		return bytes.Compare(n.peers[n.sorted[i]].Key[:], lo[:]) >= 0
+	})
+	end := sort.Search(len(n.sorted), func(i int) bool {
+		return bytes.Compare(n.peers[n.sorted[i]].Key[:], hi[:]) > 0
+	})
+
+	return start, end
+}
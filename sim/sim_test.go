@@ -0,0 +1,376 @@
+package sim
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+	"github.com/dennis-tra/nebula-crawler/db"
+	pgmodels "github.com/dennis-tra/nebula-crawler/db/models/pg"
+)
+
+// recordingClient is a database client that records all visits.
+type recordingClient struct {
+	db.NoopClient
+
+	mu      sync.Mutex
+	visits  map[peer.ID]int
+	retries int
+}
+
+func (c *recordingClient) InsertVisit(ctx context.Context, args *db.VisitArgs) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.visits == nil {
+		c.visits = map[peer.ID]int{}
+	}
+	c.visits[args.PeerID] += 1
+
+	if args.RetryPass > 0 {
+		c.retries += 1
+	}
+
+	return nil
+}
+
+// expectedCrawl traverses the network like a crawler would and returns all
+// peers that should be visited.
+func expectedCrawl(n *Network) map[int]*Peer {
+	visited := map[int]*Peer{}
+
+	var queue []*Peer
+	for _, p := range n.BootstrapPeers() {
+		visited[p.Index] = p
+		queue = append(queue, p)
+	}
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		if p.Reachability != Reachable {
+			continue
+		}
+
+		for b, bucket := range n.RoutingTable(p) {
+			if p.FailedBuckets&(1<<b) != 0 {
+				continue
+			}
+
+			for _, neighbor := range bucket {
+				if _, found := visited[neighbor.Index]; !found {
+					visited[neighbor.Index] = neighbor
+					queue = append(queue, neighbor)
+				}
+			}
+		}
+	}
+
+	return visited
+}
+
+func crawl(t *testing.T, n *Network, dbc db.Client, handlerCfg *core.CrawlHandlerConfig, engineCfg *core.EngineConfig) *core.Summary {
+	t.Helper()
+
+	driver, err := NewCrawlDriver(dbc, DefaultCrawlDriverConfig(n))
+	require.NoError(t, err)
+
+	handler := core.NewCrawlHandler[PeerInfo](handlerCfg)
+
+	eng, err := core.NewEngine[PeerInfo, core.CrawlResult[PeerInfo]](driver, handler, engineCfg)
+	require.NoError(t, err)
+
+	summary, err := eng.Run(context.Background())
+	require.NoError(t, err)
+
+	return summary
+}
+
+func TestNetworkConfig_Validate(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Buckets = 17
+	assert.Error(t, cfg.Validate())
+
+	cfg = DefaultNetworkConfig()
+	cfg.Churn = 0.5
+	cfg.Unreachable = 0.5
+	assert.Error(t, cfg.Validate())
+}
+
+func TestNewNetwork(t *testing.T) {
+	cfg := DefaultNetworkConfig()
+
+	n1, err := NewNetwork(cfg)
+	require.NoError(t, err)
+
+	n2, err := NewNetwork(cfg)
+	require.NoError(t, err)
+
+	require.Equal(t, cfg.Size, n1.Size())
+	for i := 0; i < n1.Size(); i++ {
+		assert.Equal(t, n1.Peer(i).ID, n2.Peer(i).ID)
+		assert.NoError(t, n1.Peer(i).ID.Validate())
+	}
+
+	p := n1.Peer(42)
+	assert.Equal(t, n1.RoutingTable(p), n2.RoutingTable(p), "routing tables must be deterministic")
+
+	for b, bucket := range n1.RoutingTable(p) {
+		assert.LessOrEqual(t, len(bucket), cfg.BucketSize)
+		for _, neighbor := range bucket {
+			cpl := commonPrefixLen(p.Key, neighbor.Key)
+			if b == cfg.Buckets-1 {
+				assert.GreaterOrEqual(t, cpl, b)
+			} else {
+				assert.Equal(t, b, cpl)
+			}
+		}
+	}
+
+	cfg.Seed = 2
+	n3, err := NewNetwork(cfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, n1.Peer(0).ID, n3.Peer(0).ID)
+}
+
+func commonPrefixLen(a, b [32]byte) int {
+	for i := 0; i < 256; i++ {
+		mask := byte(0x80 >> (i % 8))
+		if a[i/8]&mask != b[i/8]&mask {
+			return i
+		}
+	}
+	return 256
+}
+
+func TestCrawlDriver(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	n, err := NewNetwork(DefaultNetworkConfig())
+	require.NoError(t, err)
+
+	expected := expectedCrawl(n)
+	expectedErrs := map[string]int{}
+	for _, p := range expected {
+		switch p.Reachability {
+		case Churned:
+			expectedErrs[pgmodels.NetErrorConnectionRefused] += 1
+		case Unreachable:
+			expectedErrs[pgmodels.NetErrorIoTimeout] += 1
+		}
+	}
+
+	var summaries []*core.Summary
+	for _, workers := range []int{1, 10, 100} {
+		dbc := &recordingClient{}
+
+		engineCfg := core.DefaultEngineConfig()
+		engineCfg.WorkerCount = workers
+		engineCfg.WriterCount = 5
+
+		summary := crawl(t, n, dbc, &core.CrawlHandlerConfig{}, engineCfg)
+		summaries = append(summaries, summary)
+
+		assert.Equal(t, len(expected), summary.PeersCrawled)
+		assert.Equal(t, expectedErrs, summary.ConnErrs)
+		assert.Zero(t, summary.PeersRemaining)
+
+		// every crawled peer was written exactly once
+		assert.Len(t, dbc.visits, len(expected))
+		for _, count := range dbc.visits {
+			assert.Equal(t, 1, count)
+		}
+	}
+
+	// the results don't depend on the number of workers. The network size
+	// sums depend on the order in which the floats were added.
+	expectedSize, _, _ := summaries[0].NetworkSize.Estimate()
+	for _, summary := range summaries {
+		size, _, _ := summary.NetworkSize.Estimate()
+		assert.InEpsilon(t, expectedSize, size, 1e-9)
+		summary.NetworkSize = core.NetworkSize{}
+	}
+	for _, summary := range summaries[1:] {
+		assert.Equal(t, summaries[0], summary)
+	}
+}
+
+func TestCrawlDriver_limit(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	n, err := NewNetwork(DefaultNetworkConfig())
+	require.NoError(t, err)
+
+	driver, err := NewCrawlDriver(&recordingClient{}, DefaultCrawlDriverConfig(n))
+	require.NoError(t, err)
+
+	handler := core.NewCrawlHandler[PeerInfo](&core.CrawlHandlerConfig{})
+
+	engineCfg := core.DefaultEngineConfig()
+	engineCfg.Limit = 100
+
+	eng, err := core.NewEngine[PeerInfo, core.CrawlResult[PeerInfo]](driver, handler, engineCfg)
+	require.NoError(t, err)
+
+	// the engine stops by cancelling its context when the limit is reached
+	// and drops the results that workers deliver afterward.
+	summary, err := eng.Run(context.Background())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 100, summary.PeersCrawled)
+	assert.Positive(t, summary.PeersRemaining)
+
+	// the network size can be estimated from a partial crawl
+	estimate, lower, upper := summary.NetworkSize.Estimate()
+	assert.InDelta(t, n.Size(), estimate, 0.2*float64(n.Size()))
+	assert.Less(t, lower, estimate)
+	assert.Greater(t, upper, estimate)
+}
+
+func TestCrawlDriver_networkSize(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	for _, size := range []int{500, 5000} {
+		netCfg := DefaultNetworkConfig()
+		netCfg.Size = size
+		n, err := NewNetwork(netCfg)
+		require.NoError(t, err)
+
+		summary := crawl(t, n, &recordingClient{}, &core.CrawlHandlerConfig{}, core.DefaultEngineConfig())
+
+		// the estimate includes the peers that we couldn't crawl
+		estimate, lower, upper := summary.NetworkSize.Estimate()
+		assert.InDelta(t, size, estimate, 0.1*float64(size))
+		assert.Less(t, lower, estimate)
+		assert.Greater(t, upper, estimate)
+	}
+}
+
+func TestCrawlDriver_retry(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	netCfg := DefaultNetworkConfig()
+	netCfg.TransientFailure = 0.2
+	n, err := NewNetwork(netCfg)
+	require.NoError(t, err)
+
+	// the retry passes reach the transient peers and their neighbors, so we
+	// end up crawling the same peers as if there were no transient failures.
+	expected := expectedCrawl(n)
+	expectedErrs := map[string]int{}
+	transient := 0
+	for _, p := range expected {
+		switch {
+		case p.Reachability == Churned:
+			expectedErrs[pgmodels.NetErrorConnectionRefused] += 1
+		case p.Reachability == Unreachable:
+			expectedErrs[pgmodels.NetErrorIoTimeout] += 1
+		case p.Transient:
+			transient += 1
+		}
+	}
+	require.Positive(t, transient)
+
+	handlerCfg := &core.CrawlHandlerConfig{RetryErrors: core.DefaultRetryErrors}
+
+	t.Run("no retry", func(t *testing.T) {
+		// the routing tables are redundant enough to find all peers, but
+		// the transient peers are undialable.
+		summary := crawl(t, n, &recordingClient{}, handlerCfg, core.DefaultEngineConfig())
+		assert.Equal(t, len(expected), summary.PeersCrawled)
+		assert.Equal(t, expectedErrs[pgmodels.NetErrorIoTimeout]+transient, summary.ConnErrs[pgmodels.NetErrorIoTimeout])
+		assert.Zero(t, summary.PeersRetried)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		engineCfg := core.DefaultEngineConfig()
+		engineCfg.RetryPasses = 2
+
+		dbc := &recordingClient{}
+		summary := crawl(t, n, dbc, handlerCfg, engineCfg)
+
+		// the retried results replace the previous ones in the summary
+		assert.Equal(t, len(expected), summary.PeersCrawled)
+		assert.Equal(t, expectedErrs, summary.ConnErrs)
+		assert.Equal(t, summary.PeersCrawled, summary.PeersDialable+summary.PeersUndialable)
+
+		// transient peers that were found during a retry pass are crawled by
+		// the retry workers right away and are therefore not retried.
+		assert.Positive(t, summary.PeersRetrySucceeded)
+		assert.LessOrEqual(t, summary.PeersRetrySucceeded, transient)
+		assert.Greater(t, summary.PeersRetried, summary.PeersRetrySucceeded)
+
+		// every retry was written to the database as well
+		total := 0
+		for _, count := range dbc.visits {
+			total += count
+		}
+		assert.Len(t, dbc.visits, len(expected))
+		assert.Equal(t, len(expected)+summary.PeersRetried, total)
+		assert.Equal(t, summary.PeersRetried, dbc.retries, "retried visits must be marked")
+		for _, p := range expected {
+			if p.Reachability == Reachable && !p.Transient {
+				assert.Equal(t, 1, dbc.visits[p.ID])
+			}
+		}
+	})
+}
+
+func TestCrawlDriver_realTime(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	netCfg := DefaultNetworkConfig()
+	netCfg.Size = 200
+	netCfg.Latency = time.Millisecond
+	n, err := NewNetwork(netCfg)
+	require.NoError(t, err)
+
+	driverCfg := DefaultCrawlDriverConfig(n)
+	driverCfg.RealTime = true
+	driverCfg.DialTimeout = 5 * time.Millisecond
+
+	driver, err := NewCrawlDriver(&recordingClient{}, driverCfg)
+	require.NoError(t, err)
+
+	handler := core.NewCrawlHandler[PeerInfo](&core.CrawlHandlerConfig{})
+	eng, err := core.NewEngine[PeerInfo, core.CrawlResult[PeerInfo]](driver, handler, core.DefaultEngineConfig())
+	require.NoError(t, err)
+
+	summary, err := eng.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, len(expectedCrawl(n)), summary.PeersCrawled)
+}
+
+func TestCrawlDriver_large(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large simulated crawl in short mode")
+	}
+
+	logrus.SetLevel(logrus.PanicLevel)
+
+	netCfg := DefaultNetworkConfig()
+	netCfg.Size = 100_000
+	n, err := NewNetwork(netCfg)
+	require.NoError(t, err)
+
+	engineCfg := core.DefaultEngineConfig()
+	engineCfg.WorkerCount = 1000
+	engineCfg.MaxWriteQueue = 1000
+
+	dbc := &recordingClient{}
+	summary := crawl(t, n, dbc, &core.CrawlHandlerConfig{}, engineCfg)
+
+	expected := expectedCrawl(n)
+	assert.Equal(t, len(expected), summary.PeersCrawled)
+	assert.Equal(t, summary.PeersCrawled, summary.PeersDialable+summary.PeersUndialable)
+	assert.Len(t, dbc.visits, len(expected))
+}
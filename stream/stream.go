@@ -0,0 +1,241 @@
+// Package stream serves the peer processing results of a running crawl or
+// monitoring process as a Server-Sent Events (SSE) feed. Each event carries
+// a JSON-encoded [core.ResultEvent]. Clients can subscribe with any SSE
+// client, e.g.:
+//
+//	curl -N http://localhost:6667/events?type=crawl
+//
+// If multiple networks are crawled in the same process, the events can be
+// filtered by network as well, e.g.:
+//
+//	curl -N http://localhost:6667/events?network=IPFS
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+)
+
+// Config configures the [Server].
+type Config struct {
+	// the address the server listens on for subscribers
+	ListenAddr string
+
+	// the number of events that are buffered per subscriber. If a subscriber
+	// can't keep up, events are dropped for that subscriber.
+	BufferSize int
+
+	// how often a keep-alive comment is sent to idle subscribers
+	KeepAlive time.Duration
+}
+
+// DefaultConfig returns the default stream server configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr: "localhost:6667",
+		BufferSize: 1024,
+		KeepAlive:  15 * time.Second,
+	}
+}
+
+// Validate verifies the stream server configuration's invariants.
+func (cfg *Config) Validate() error {
+	if cfg.BufferSize <= 0 {
+		return fmt.Errorf("buffer size must not be zero or negative")
+	}
+
+	if cfg.KeepAlive <= 0 {
+		return fmt.Errorf("keep-alive interval must not be zero or negative")
+	}
+
+	return nil
+}
+
+// Server is a [core.Observer] that forwards all peer processing results to
+// its subscribers.
+type Server struct {
+	cfg      *Config
+	listener net.Listener
+	server   *http.Server
+
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+var _ core.Observer = (*Server)(nil)
+
+// subscriber is a single client of the event stream.
+type subscriber struct {
+	// only forward events of this type. Empty means all events.
+	eventType string
+
+	// only forward events of this network. Empty means all events.
+	network string
+
+	// encoded events that still need to be sent to the client
+	events chan []byte
+
+	// the number of events that were dropped because the client couldn't
+	// keep up.
+	dropped atomic.Uint64
+}
+
+// NewServer initializes a new [Server] and starts listening on the
+// configured address.
+func NewServer(cfg *Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("validate config: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", cfg.ListenAddr, err)
+	}
+
+	s := &Server{
+		cfg:         cfg,
+		listener:    listener,
+		subscribers: map[*subscriber]struct{}{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.handleEvents)
+	s.server = &http.Server{Handler: mux}
+
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// ListenAndServe serves subscribers until [Server.Close] is called.
+func (s *Server) ListenAndServe() {
+	log.WithField("addr", s.Addr()).Infoln("Serving result stream")
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithError(err).Warnln("Result stream server stopped")
+	}
+}
+
+// Close disconnects all subscribers and stops the server.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// ObservePeerResult encodes the given event and hands it to all subscribers.
+// It never blocks. If a subscriber's buffer is full, the event is dropped
+// for that subscriber.
+func (s *Server) ObservePeerResult(ctx context.Context, event *core.ResultEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.subscribers) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Warnln("Failed encoding result event")
+		return
+	}
+
+	for sub := range s.subscribers {
+		if sub.eventType != "" && sub.eventType != event.Type {
+			continue
+		}
+
+		if sub.network != "" && sub.network != event.Network {
+			continue
+		}
+
+		select {
+		case sub.events <- data:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func (s *Server) subscribe(eventType string, network string) *subscriber {
+	sub := &subscriber{
+		eventType: eventType,
+		network:   network,
+		events:    make(chan []byte, s.cfg.BufferSize),
+	}
+
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+
+	return sub
+}
+
+func (s *Server) unsubscribe(sub *subscriber) uint64 {
+	s.mu.Lock()
+	delete(s.subscribers, sub)
+	s.mu.Unlock()
+
+	return sub.dropped.Load()
+}
+
+func (s *Server) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	eventType := req.URL.Query().Get("type")
+	switch eventType {
+	case "", core.ResultEventCrawl, core.ResultEventDial:
+	default:
+		http.Error(rw, fmt.Sprintf("unknown event type %q", eventType), http.StatusBadRequest)
+		return
+	}
+
+	sub := s.subscribe(eventType, req.URL.Query().Get("network"))
+	logEntry := log.WithField("remote", req.RemoteAddr)
+	logEntry.Infoln("Result stream subscriber connected")
+	defer func() {
+		dropped := s.unsubscribe(sub)
+		logEntry.WithField("dropped", dropped).Infoln("Result stream subscriber disconnected")
+	}()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(s.cfg.KeepAlive)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			_, err = fmt.Fprint(rw, ": keep-alive\n\n")
+		case data := <-sub.events:
+			_, err = fmt.Fprintf(rw, "data: %s\n\n", data)
+		}
+
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
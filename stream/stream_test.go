@@ -0,0 +1,144 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dennis-tra/nebula-crawler/core"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.BufferSize = 2
+
+	srv, err := NewServer(cfg)
+	require.NoError(t, err)
+	go srv.ListenAndServe()
+
+	t.Cleanup(func() { assert.NoError(t, srv.Close()) })
+
+	return srv
+}
+
+// subscribe connects to the event stream and waits until the server has
+// registered the subscriber.
+func subscribe(t *testing.T, srv *Server, query string) *bufio.Scanner {
+	t.Helper()
+
+	url := fmt.Sprintf("http://%s/events%s", srv.Addr(), query)
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return bufio.NewScanner(resp.Body)
+}
+
+func nextEvent(t *testing.T, scanner *bufio.Scanner) *core.ResultEvent {
+	t.Helper()
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		event := &core.ResultEvent{}
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), event))
+		return event
+	}
+
+	t.Fatal("stream ended unexpectedly")
+	return nil
+}
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.BufferSize = 0
+	assert.Error(t, cfg.Validate())
+
+	cfg = DefaultConfig()
+	cfg.KeepAlive = 0
+	assert.Error(t, cfg.Validate())
+}
+
+func TestServer_ObservePeerResult(t *testing.T) {
+	srv := newTestServer(t)
+
+	all := subscribe(t, srv, "")
+	dials := subscribe(t, srv, "?type=dial")
+
+	ctx := context.Background()
+	srv.ObservePeerResult(ctx, &core.ResultEvent{Type: core.ResultEventCrawl, PeerID: "crawled"})
+	srv.ObservePeerResult(ctx, &core.ResultEvent{Type: core.ResultEventDial, PeerID: "dialed"})
+
+	assert.Equal(t, "crawled", nextEvent(t, all).PeerID)
+	assert.Equal(t, "dialed", nextEvent(t, all).PeerID)
+	assert.Equal(t, "dialed", nextEvent(t, dials).PeerID)
+}
+
+func TestServer_ObservePeerResult_network(t *testing.T) {
+	srv := newTestServer(t)
+
+	ipfs := subscribe(t, srv, "?network=IPFS&type=crawl")
+
+	ctx := context.Background()
+	srv.ObservePeerResult(ctx, &core.ResultEvent{Type: core.ResultEventCrawl, Network: "FILECOIN", PeerID: "filecoin"})
+	srv.ObservePeerResult(ctx, &core.ResultEvent{Type: core.ResultEventCrawl, Network: "IPFS", PeerID: "ipfs"})
+
+	event := nextEvent(t, ipfs)
+	assert.Equal(t, "ipfs", event.PeerID)
+	assert.Equal(t, "IPFS", event.Network)
+}
+
+func TestServer_ObservePeerResult_drops(t *testing.T) {
+	srv := newTestServer(t)
+
+	sub := srv.subscribe("", "")
+	for i := 0; i < 5; i++ {
+		srv.ObservePeerResult(context.Background(), &core.ResultEvent{Type: core.ResultEventDial})
+	}
+
+	assert.Len(t, sub.events, 2)
+	assert.EqualValues(t, 3, srv.unsubscribe(sub))
+}
+
+func TestServer_handleEvents_unknown_type(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/events?type=unknown", srv.Addr()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServer_keep_alive(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.KeepAlive = 10 * time.Millisecond
+
+	srv, err := NewServer(cfg)
+	require.NoError(t, err)
+	go srv.ListenAndServe()
+	defer srv.Close()
+
+	scanner := subscribe(t, srv, "")
+	require.True(t, scanner.Scan())
+	assert.Equal(t, ": keep-alive", scanner.Text())
+}
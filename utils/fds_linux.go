@@ -0,0 +1,25 @@
+//go:build linux
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// OpenFDs returns the number of file descriptors that this process has
+// currently opened and the soft limit of open file descriptors.
+func OpenFDs() (int, uint64, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, fmt.Errorf("read fd directory: %w", err)
+	}
+
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, 0, fmt.Errorf("get rlimit: %w", err)
+	}
+
+	return len(entries), rlimit.Cur, nil
+}
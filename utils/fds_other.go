@@ -0,0 +1,14 @@
+//go:build !linux
+
+package utils
+
+import (
+	"fmt"
+)
+
+// OpenFDs returns the number of file descriptors that this process has
+// currently opened and the soft limit of open file descriptors. This is
+// only supported on linux.
+func OpenFDs() (int, uint64, error) {
+	return 0, 0, fmt.Errorf("open file descriptors not supported on this platform")
+}